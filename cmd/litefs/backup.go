@@ -0,0 +1,471 @@
+// go:build linux
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/superfly/litefs"
+	"github.com/superfly/ltx"
+)
+
+// Backup modes.
+const (
+	BackupModeSnapshot    = "snapshot"
+	BackupModeIncremental = "incremental"
+)
+
+// Default backup settings.
+const (
+	DefaultBackupInterval         = 1 * time.Minute
+	DefaultBackupSnapshotInterval = 24 * time.Hour
+)
+
+// BackupManifestKey is the name of the manifest object within a database's
+// backup directory.
+const BackupManifestKey = "manifest.json"
+
+// BackupConfig represents the configuration for periodic database backups.
+type BackupConfig struct {
+	Mode             string        `yaml:"mode"`
+	Path             string        `yaml:"path"`
+	Interval         time.Duration `yaml:"interval"`
+	SnapshotInterval time.Duration `yaml:"snapshot-interval"`
+}
+
+// BackupStore represents the object storage that backups are written to.
+type BackupStore interface {
+	WriteObject(ctx context.Context, key string, r io.Reader) (int64, error)
+	OpenObject(ctx context.Context, key string) (io.ReadCloser, error)
+	DeleteObject(ctx context.Context, key string) error
+}
+
+var _ BackupStore = (*FileBackupStore)(nil)
+
+// FileBackupStore stores backup objects as files under a directory, such as a
+// locally mounted bucket.
+type FileBackupStore struct {
+	Path string
+}
+
+// NewFileBackupStore returns a new instance of FileBackupStore.
+func NewFileBackupStore(path string) *FileBackupStore {
+	return &FileBackupStore{Path: path}
+}
+
+// WriteObject atomically writes r to the object at key.
+func (s *FileBackupStore) WriteObject(ctx context.Context, key string, r io.Reader) (int64, error) {
+	filename := filepath.Join(s.Path, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(filename), 0o777); err != nil {
+		return 0, err
+	}
+
+	f, err := os.Create(filename + ".tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = f.Close() }()
+
+	n, err := io.Copy(f, r)
+	if err != nil {
+		return n, err
+	} else if err := f.Sync(); err != nil {
+		return n, err
+	} else if err := f.Close(); err != nil {
+		return n, err
+	}
+	return n, os.Rename(filename+".tmp", filename)
+}
+
+// OpenObject returns a reader for the object at key.
+func (s *FileBackupStore) OpenObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.Path, filepath.FromSlash(key)))
+}
+
+// DeleteObject removes the object at key. Missing objects are ignored.
+func (s *FileBackupStore) DeleteObject(ctx context.Context, key string) error {
+	if err := os.Remove(filepath.Join(s.Path, filepath.FromSlash(key))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// BackupManifest references the base snapshot of a database & the chain of
+// LTX files committed after it. Restoring applies the snapshot and then each
+// file in order.
+type BackupManifest struct {
+	TXID       uint64               `json:"txid"`
+	Snapshot   BackupManifestFile   `json:"snapshot"`
+	SnapshotAt time.Time            `json:"snapshotAt"`
+	Files      []BackupManifestFile `json:"files"`
+	UpdatedAt  time.Time            `json:"updatedAt"`
+}
+
+// BackupManifestFile references a single LTX object in the backup.
+type BackupManifestFile struct {
+	Key     string `json:"key"`
+	MinTXID uint64 `json:"minTXID"`
+	MaxTXID uint64 `json:"maxTXID"`
+}
+
+// ReadBackupManifest reads the manifest for a database. Returns nil if no
+// backup exists for the database.
+func ReadBackupManifest(ctx context.Context, store BackupStore, name string) (*BackupManifest, error) {
+	rc, err := store.OpenObject(ctx, path.Join(name, BackupManifestKey))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rc.Close() }()
+
+	var manifest BackupManifest
+	if err := json.NewDecoder(rc).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// WriteBackupManifest writes the manifest for a database.
+func WriteBackupManifest(ctx context.Context, store BackupStore, name string, manifest *BackupManifest) error {
+	buf, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = store.WriteObject(ctx, path.Join(name, BackupManifestKey), bytes.NewReader(buf))
+	return err
+}
+
+// monitorBackup periodically backs up all databases while this node is
+// primary. Replicas hold the same data so only the primary uploads.
+func (m *Main) monitorBackup(ctx context.Context) {
+	store := NewFileBackupStore(m.Config.Backup.Path)
+
+	ticker := time.NewTicker(m.Config.Backup.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !m.Store.IsPrimary() {
+			continue
+		}
+
+		for _, db := range m.Store.DBs() {
+			if db.LocalOnly() {
+				continue
+			}
+
+			if err := m.BackupDB(ctx, store, db); err != nil {
+				log.Printf("cannot back up database %q: %s", db.Name(), err)
+				backupErrorCountMetric.Inc()
+			}
+		}
+	}
+}
+
+// BackupDB uploads the LTX files committed since the last backup & updates
+// the manifest. A new base snapshot is uploaded instead if there is no backup,
+// if the snapshot interval has elapsed, if mode is "snapshot", or if the LTX
+// files needed to extend the chain were already removed by retention.
+func (m *Main) BackupDB(ctx context.Context, store BackupStore, db *litefs.DB) error {
+	config := m.Config.Backup
+
+	manifest, err := ReadBackupManifest(ctx, store, db.Name())
+	if err != nil {
+		return fmt.Errorf("read manifest: %w", err)
+	}
+
+	txID := db.TXID()
+	if txID == 0 {
+		return nil // no data
+	} else if manifest != nil && manifest.TXID == txID {
+		m.updateBackupMetrics(db, manifest)
+		return nil // no changes
+	}
+
+	needSnapshot := manifest == nil ||
+		config.Mode == BackupModeSnapshot ||
+		manifest.TXID > txID ||
+		(config.SnapshotInterval > 0 && time.Since(manifest.SnapshotAt) >= config.SnapshotInterval)
+
+	if !needSnapshot {
+		files, err := m.backupLTXFiles(ctx, store, db, manifest.TXID, txID)
+		if err == errBackupLTXNotFound {
+			log.Printf("ltx files for %q no longer available, uploading new backup snapshot", db.Name())
+			needSnapshot = true
+		} else if err != nil {
+			return err
+		} else {
+			manifest.Files = append(manifest.Files, files...)
+			manifest.TXID = files[len(files)-1].MaxTXID
+		}
+	}
+
+	var prev *BackupManifest
+	if needSnapshot {
+		snapshot, err := m.backupSnapshot(ctx, store, db)
+		if err != nil {
+			return err
+		}
+		prev, manifest = manifest, &BackupManifest{
+			TXID:       snapshot.MaxTXID,
+			Snapshot:   snapshot,
+			SnapshotAt: time.Now(),
+		}
+	}
+
+	manifest.UpdatedAt = time.Now()
+	if err := WriteBackupManifest(ctx, store, db.Name(), manifest); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	m.updateBackupMetrics(db, manifest)
+
+	// Remove objects from the previous chain once the manifest no longer
+	// references them.
+	if prev != nil {
+		for _, file := range append([]BackupManifestFile{prev.Snapshot}, prev.Files...) {
+			if file.Key == manifest.Snapshot.Key {
+				continue
+			}
+			if err := store.DeleteObject(ctx, file.Key); err != nil {
+				log.Printf("cannot remove previous backup object %q: %s", file.Key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// errBackupLTXNotFound is returned when an LTX file needed for an incremental
+// backup does not exist locally.
+var errBackupLTXNotFound = fmt.Errorf("backup ltx file not found")
+
+// backupLTXFiles uploads each LTX file after minTXID through maxTXID. The
+// files are checked first so a partial chain is never uploaded.
+func (m *Main) backupLTXFiles(ctx context.Context, store BackupStore, db *litefs.DB, minTXID, maxTXID uint64) ([]BackupManifestFile, error) {
+	for txID := minTXID + 1; txID <= maxTXID; txID++ {
+		if _, err := os.Stat(db.LTXPath(txID, txID)); os.IsNotExist(err) {
+			return nil, errBackupLTXNotFound
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	var files []BackupManifestFile
+	for txID := minTXID + 1; txID <= maxTXID; txID++ {
+		f, err := db.OpenLTXFile(txID)
+		if err != nil {
+			return nil, err
+		}
+
+		key := path.Join(db.Name(), ltx.FormatFilename(txID, txID))
+		n, err := store.WriteObject(ctx, key, f)
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("upload ltx file %s: %w", ltx.FormatTXID(txID), err)
+		}
+		backupBytesMetricVec.WithLabelValues(db.Name()).Add(float64(n))
+
+		files = append(files, BackupManifestFile{Key: key, MinTXID: txID, MaxTXID: txID})
+	}
+	return files, nil
+}
+
+// backupSnapshot uploads a snapshot of the current database state. The
+// snapshot is staged in a temp file so database locks are not held while
+// uploading.
+func (m *Main) backupSnapshot(ctx context.Context, store BackupStore, db *litefs.DB) (BackupManifestFile, error) {
+	f, err := os.CreateTemp(m.Store.TmpDir, "litefs-backup-*.ltx")
+	if err != nil {
+		return BackupManifestFile{}, err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	defer func() { _ = f.Close() }()
+
+	hdr, _, err := db.WriteSnapshotTo(ctx, f)
+	if err != nil {
+		return BackupManifestFile{}, fmt.Errorf("write snapshot: %w", err)
+	} else if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return BackupManifestFile{}, err
+	}
+
+	key := path.Join(db.Name(), "snapshot-"+ltx.FormatFilename(hdr.MinTXID, hdr.MaxTXID))
+	n, err := store.WriteObject(ctx, key, f)
+	if err != nil {
+		return BackupManifestFile{}, fmt.Errorf("upload snapshot: %w", err)
+	}
+	backupBytesMetricVec.WithLabelValues(db.Name()).Add(float64(n))
+
+	return BackupManifestFile{Key: key, MinTXID: hdr.MinTXID, MaxTXID: hdr.MaxTXID}, nil
+}
+
+func (m *Main) updateBackupMetrics(db *litefs.DB, manifest *BackupManifest) {
+	backupTXIDMetricVec.WithLabelValues(db.Name()).Set(float64(manifest.TXID))
+
+	var lag uint64
+	if txID := db.TXID(); txID > manifest.TXID {
+		lag = txID - manifest.TXID
+	}
+	backupLagMetricVec.WithLabelValues(db.Name()).Set(float64(lag))
+}
+
+// BackupRestoreCommand represents a command to restore a database from a
+// backup. It operates directly on the backup so no running node is required.
+type BackupRestoreCommand struct {
+	// Path to the backup directory.
+	Path string
+
+	// Name of the database to restore.
+	Name string
+
+	// Path to write the restored database file to.
+	OutputPath string
+
+	Stdout io.Writer
+}
+
+// NewBackupRestoreCommand returns a new instance of BackupRestoreCommand.
+func NewBackupRestoreCommand() *BackupRestoreCommand {
+	return &BackupRestoreCommand{
+		Stdout: os.Stdout,
+	}
+}
+
+// ParseFlags parses the command line flags for the "backup restore" command.
+func (c *BackupRestoreCommand) ParseFlags(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("litefs-backup-restore", flag.ContinueOnError)
+	fs.StringVar(&c.Path, "path", "", "backup directory")
+	fs.StringVar(&c.OutputPath, "o", "", "output database path")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: litefs backup restore -path PATH -o OUTPUT DB")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() == 0 {
+		return fmt.Errorf("database name required")
+	} else if fs.NArg() > 1 {
+		return fmt.Errorf("too many arguments")
+	} else if c.Path == "" {
+		return fmt.Errorf("backup path required")
+	} else if c.OutputPath == "" {
+		return fmt.Errorf("output path required")
+	}
+	c.Name = fs.Arg(0)
+	return nil
+}
+
+// Run reads the manifest and applies the snapshot & each LTX file in the
+// chain to a new database file.
+func (c *BackupRestoreCommand) Run(ctx context.Context) (err error) {
+	store := NewFileBackupStore(c.Path)
+
+	manifest, err := ReadBackupManifest(ctx, store, c.Name)
+	if err != nil {
+		return fmt.Errorf("read manifest: %w", err)
+	} else if manifest == nil {
+		return fmt.Errorf("no backup found for database %q", c.Name)
+	}
+
+	f, err := os.OpenFile(c.OutputPath+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o666)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(c.OutputPath + ".tmp") }()
+	defer func() { _ = f.Close() }()
+
+	var txID uint64
+	for _, file := range append([]BackupManifestFile{manifest.Snapshot}, manifest.Files...) {
+		if txID != 0 && file.MinTXID != txID+1 {
+			return fmt.Errorf("backup chain has a gap: %s follows %s", ltx.FormatTXID(file.MinTXID), ltx.FormatTXID(txID))
+		}
+		if err := c.applyLTX(ctx, store, f, file.Key); err != nil {
+			return fmt.Errorf("apply %s: %w", file.Key, err)
+		}
+		txID = file.MaxTXID
+	}
+
+	if err := f.Sync(); err != nil {
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	} else if err := os.Rename(c.OutputPath+".tmp", c.OutputPath); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.Stdout, "restored %q to %s at txid %s (%d files)\n", c.Name, c.OutputPath, ltx.FormatTXID(txID), len(manifest.Files)+1)
+	return nil
+}
+
+// applyLTX writes the pages of an LTX object to the database file.
+func (c *BackupRestoreCommand) applyLTX(ctx context.Context, store BackupStore, f *os.File, key string) error {
+	rc, err := store.OpenObject(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rc.Close() }()
+
+	dec := ltx.NewDecoder(rc)
+	if err := dec.DecodeHeader(); err != nil {
+		return fmt.Errorf("decode header: %w", err)
+	}
+
+	hdr := dec.Header()
+	data := make([]byte, hdr.PageSize)
+	for {
+		var phdr ltx.PageHeader
+		if err := dec.DecodePage(&phdr, data); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("decode page: %w", err)
+		}
+
+		if _, err := f.WriteAt(data, int64(phdr.Pgno-1)*int64(hdr.PageSize)); err != nil {
+			return fmt.Errorf("write page %d: %w", phdr.Pgno, err)
+		}
+	}
+
+	// Close verifies the file checksum.
+	if err := dec.Close(); err != nil {
+		return err
+	}
+	return f.Truncate(int64(hdr.Commit) * int64(hdr.PageSize))
+}
+
+// Backup metrics.
+var (
+	backupTXIDMetricVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "litefs_backup_txid",
+		Help: "Last transaction ID included in the backup.",
+	}, []string{"db"})
+
+	backupLagMetricVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "litefs_backup_lag_txn_count",
+		Help: "Number of committed transactions not yet included in the backup.",
+	}, []string{"db"})
+
+	backupBytesMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_backup_bytes",
+		Help: "Number of bytes uploaded to the backup.",
+	}, []string{"db"})
+
+	backupErrorCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_backup_error_count",
+		Help: "Number of failed database backups.",
+	})
+)
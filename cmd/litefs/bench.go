@@ -0,0 +1,152 @@
+// go:build linux
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/superfly/litefs/http"
+	"github.com/superfly/ltx"
+)
+
+// BenchCommand represents a command to measure replication throughput
+// between this node and a target LiteFS node.
+type BenchCommand struct {
+	// Base URL of the target LiteFS node.
+	Target string
+
+	// Number of round trips to perform.
+	N int
+
+	// Number of pages in each synthetic LTX payload.
+	PageN int
+
+	// Page size for each synthetic LTX payload.
+	PageSize int
+
+	Client *http.Client
+	Stdout io.Writer
+}
+
+// NewBenchCommand returns a new instance of BenchCommand.
+func NewBenchCommand() *BenchCommand {
+	return &BenchCommand{
+		N:        10,
+		PageN:    1024,
+		PageSize: 4096,
+		Client:   http.NewClient(),
+		Stdout:   os.Stdout,
+	}
+}
+
+// ParseFlags parses the command line flags for the bench command.
+func (c *BenchCommand) ParseFlags(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("litefs-bench", flag.ContinueOnError)
+	fs.StringVar(&c.Target, "target", "", "URL of target LiteFS node")
+	fs.IntVar(&c.N, "n", c.N, "number of round trips")
+	fs.IntVar(&c.PageN, "pages", c.PageN, "number of pages per payload")
+	fs.IntVar(&c.PageSize, "page-size", c.PageSize, "page size of payload")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() > 0 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	if c.Target == "" {
+		return fmt.Errorf("target URL required")
+	} else if c.N < 1 {
+		return fmt.Errorf("number of round trips must be at least 1")
+	} else if c.PageN < 1 {
+		return fmt.Errorf("number of pages must be at least 1")
+	} else if !ltx.IsValidPageSize(uint32(c.PageSize)) {
+		return fmt.Errorf("invalid page size: %d", c.PageSize)
+	} else if int64(c.PageN)*int64(c.PageSize) > http.MaxBenchPayloadSize {
+		return fmt.Errorf("payload cannot exceed %d bytes", http.MaxBenchPayloadSize)
+	}
+	return nil
+}
+
+// Run sends synthetic LTX payloads to the target and reports throughput and
+// latency percentiles. Payloads are sent over the same HTTP/2 transport that
+// is used for replication.
+func (c *BenchCommand) Run(ctx context.Context) error {
+	payload, err := c.payload()
+	if err != nil {
+		return fmt.Errorf("cannot generate payload: %w", err)
+	}
+	fmt.Fprintf(c.Stdout, "benchmarking %s: n=%d payload=%d bytes\n", c.Target, c.N, len(payload))
+
+	latencies := make([]time.Duration, 0, c.N)
+	var total int64
+	start := time.Now()
+	for i := 0; i < c.N; i++ {
+		t := time.Now()
+		n, err := c.Client.Bench(ctx, c.Target, bytes.NewReader(payload))
+		if err != nil {
+			return fmt.Errorf("round trip %d: %w", i, err)
+		} else if n != int64(len(payload)) {
+			return fmt.Errorf("round trip %d: short payload: %d bytes, expected %d", i, n, len(payload))
+		}
+		latencies = append(latencies, time.Since(t))
+
+		// Payloads are transferred in both directions.
+		total += int64(len(payload)) + n
+	}
+	elapsed := time.Since(start)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	fmt.Fprintf(c.Stdout, "throughput: %.2f MB/s\n", float64(total)/elapsed.Seconds()/1e6)
+	fmt.Fprintf(c.Stdout, "latency: min=%s p50=%s p90=%s p99=%s max=%s\n",
+		latencies[0],
+		percentile(latencies, 0.50),
+		percentile(latencies, 0.90),
+		percentile(latencies, 0.99),
+		latencies[len(latencies)-1],
+	)
+	return nil
+}
+
+// payload returns an encoded LTX snapshot containing random page data.
+func (c *BenchCommand) payload() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := ltx.NewEncoder(&buf)
+	if err := enc.EncodeHeader(ltx.Header{
+		Version:   ltx.Version,
+		PageSize:  uint32(c.PageSize),
+		Commit:    uint32(c.PageN),
+		MinTXID:   1,
+		MaxTXID:   1,
+		Timestamp: uint64(time.Now().UnixMilli()),
+	}); err != nil {
+		return nil, fmt.Errorf("encode ltx header: %w", err)
+	}
+
+	data := make([]byte, c.PageSize)
+	var chksum uint64
+	for pgno := uint32(1); pgno <= uint32(c.PageN); pgno++ {
+		_, _ = rand.Read(data)
+		if err := enc.EncodePage(ltx.PageHeader{Pgno: pgno}, data); err != nil {
+			return nil, fmt.Errorf("encode page frame: %w", err)
+		}
+		chksum ^= ltx.ChecksumPage(pgno, data)
+	}
+
+	enc.SetPostApplyChecksum(ltx.ChecksumFlag | chksum)
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("close ltx encoder: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// percentile returns the value at p in a sorted list of durations.
+func percentile(a []time.Duration, p float64) time.Duration {
+	i := int(float64(len(a)-1) * p)
+	return a[i]
+}
@@ -0,0 +1,101 @@
+// go:build linux
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/superfly/litefs/internal"
+)
+
+// Default circuit breaker settings for outbound integrations.
+const (
+	DefaultCircuitBreakerThreshold = 5
+	DefaultCircuitBreakerCooldown  = 30 * time.Second
+)
+
+// Outbound integrations protected by a circuit breaker.
+const (
+	IntegrationSink          = "sink"
+	IntegrationPostApplyHook = "post-apply-hook"
+)
+
+// CircuitBreakerConfig represents the configuration for the circuit breaker
+// around an outbound integration. Disabled if the threshold is zero.
+type CircuitBreakerConfig struct {
+	Threshold int           `yaml:"threshold"`
+	Cooldown  time.Duration `yaml:"cooldown"`
+}
+
+// validate returns an error if the configuration is invalid.
+func (c *CircuitBreakerConfig) validate(integration string) error {
+	if c.Threshold < 0 {
+		return fmt.Errorf("%s circuit-breaker threshold cannot be negative", integration)
+	} else if c.Cooldown < 0 {
+		return fmt.Errorf("%s circuit-breaker cooldown cannot be negative", integration)
+	}
+	return nil
+}
+
+// integrationBreaker wraps a circuit breaker to report its state via metrics
+// & log when it opens or closes.
+type integrationBreaker struct {
+	breaker     *internal.CircuitBreaker
+	integration string
+}
+
+func newIntegrationBreaker(integration string, config CircuitBreakerConfig) *integrationBreaker {
+	circuitBreakerStateMetricVec.WithLabelValues(integration).Set(float64(internal.CircuitClosed))
+	return &integrationBreaker{
+		breaker:     internal.NewCircuitBreaker(config.Threshold, config.Cooldown),
+		integration: integration,
+	}
+}
+
+// allow returns true if a call may be made. Rejected calls are counted.
+func (b *integrationBreaker) allow() bool {
+	ok := b.breaker.Allow()
+	if !ok {
+		circuitBreakerSkipCountMetricVec.WithLabelValues(b.integration).Inc()
+	}
+	b.updateState()
+	return ok
+}
+
+func (b *integrationBreaker) success() {
+	prev := b.breaker.State()
+	b.breaker.Success()
+	if prev != internal.CircuitClosed {
+		log.Printf("circuit breaker closed: integration=%s", b.integration)
+	}
+	b.updateState()
+}
+
+func (b *integrationBreaker) failure() {
+	prev := b.breaker.State()
+	b.breaker.Failure()
+	if state := b.breaker.State(); state == internal.CircuitOpen && prev != internal.CircuitOpen {
+		log.Printf("circuit breaker open, skipping calls: integration=%s cooldown=%s", b.integration, b.breaker.Cooldown)
+	}
+	b.updateState()
+}
+
+func (b *integrationBreaker) updateState() {
+	circuitBreakerStateMetricVec.WithLabelValues(b.integration).Set(float64(b.breaker.State()))
+}
+
+// Circuit breaker metrics.
+var (
+	circuitBreakerStateMetricVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "litefs_circuit_breaker_state",
+		Help: "State of the integration circuit breaker: 0 closed, 1 open, 2 half-open.",
+	}, []string{"integration"})
+
+	circuitBreakerSkipCountMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_circuit_breaker_skip_count",
+		Help: "Number of integration calls skipped while the circuit breaker was open.",
+	}, []string{"integration"})
+)
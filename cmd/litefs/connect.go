@@ -0,0 +1,55 @@
+// go:build linux
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// ExitCodeConnectTimeout is the process exit code when the node cannot join
+// the cluster within the connect timeout.
+const ExitCodeConnectTimeout = 3
+
+// ConnectProgressInterval is the frequency that progress is logged while
+// waiting to connect to the cluster.
+const ConnectProgressInterval = 10 * time.Second
+
+// ErrConnectTimeout is returned by Run if the node does not become primary
+// or connect to the primary within the connect timeout.
+var ErrConnectTimeout = errors.New("timed out connecting to cluster")
+
+// WaitConnected waits until the store either becomes primary or connects to
+// the primary. Returns ErrConnectTimeout if the connect timeout elapses first.
+func (m *Main) WaitConnected(ctx context.Context) error {
+	log.Printf("waiting to connect to cluster")
+	start := time.Now()
+
+	var timeoutCh <-chan time.Time
+	if m.Config.ConnectTimeout > 0 {
+		timer := time.NewTimer(m.Config.ConnectTimeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	ticker := time.NewTicker(ConnectProgressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-m.Store.ReadyCh():
+			log.Printf("connected to cluster, ready")
+			return nil
+		case <-ticker.C:
+			n, lastErr := m.Store.LeaseAttempts()
+			log.Printf("still waiting to connect to cluster: attempts=%d elapsed=%s last-error=%q", n, time.Since(start).Round(time.Second), lastErr)
+		case <-timeoutCh:
+			n, lastErr := m.Store.LeaseAttempts()
+			log.Printf("cannot connect to cluster within connect-timeout (%s): attempts=%d last-error=%q", m.Config.ConnectTimeout, n, lastErr)
+			return ErrConnectTimeout
+		}
+	}
+}
@@ -0,0 +1,205 @@
+// go:build linux
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	litefshttp "github.com/superfly/litefs/http"
+)
+
+// DiffCommand represents a command to compare a database's pages across two
+// nodes or across two transactions on the same node.
+type DiffCommand struct {
+	// Name of the database to compare.
+	Name string
+
+	// Base URLs of the nodes' API servers. B is unused when comparing TXIDs.
+	A string
+	B string
+
+	// TXID range to compare on node A. If set, B must be empty.
+	From string
+	To   string
+
+	// If true, prints each differing page instead of only a summary.
+	Verbose bool
+
+	Stdout io.Writer
+}
+
+// NewDiffCommand returns a new instance of DiffCommand.
+func NewDiffCommand() *DiffCommand {
+	return &DiffCommand{
+		A:      "http://localhost:20202",
+		Stdout: os.Stdout,
+	}
+}
+
+// ParseFlags parses the command line flags for the "diff" command.
+func (c *DiffCommand) ParseFlags(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("litefs-diff", flag.ContinueOnError)
+	fs.StringVar(&c.Name, "name", c.Name, "database name")
+	fs.StringVar(&c.A, "a", c.A, "LiteFS API URL of the first node")
+	fs.StringVar(&c.B, "b", c.B, "LiteFS API URL of the second node")
+	fs.StringVar(&c.From, "from", c.From, "compare changes on node a after this TXID")
+	fs.StringVar(&c.To, "to", c.To, "compare changes on node a up to this TXID")
+	fs.BoolVar(&c.Verbose, "v", c.Verbose, "list each differing page")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), `usage:
+  litefs diff -name NAME -a URL -b URL [-v]
+  litefs diff -name NAME -a URL -from TXID [-to TXID] [-v]`)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() > 0 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	if c.Name == "" {
+		return fmt.Errorf("database name required")
+	} else if c.A == "" {
+		return fmt.Errorf("node url required")
+	}
+
+	if c.From != "" || c.To != "" {
+		if c.B != "" {
+			return fmt.Errorf("cannot specify -b when comparing TXIDs")
+		} else if c.From == "" {
+			return fmt.Errorf("-from TXID required when comparing TXIDs")
+		}
+	} else if c.B == "" {
+		return fmt.Errorf("second node url required")
+	}
+	return nil
+}
+
+// Run executes the command.
+func (c *DiffCommand) Run(ctx context.Context) error {
+	if c.B == "" {
+		return c.runTXIDs(ctx)
+	}
+	return c.runNodes(ctx)
+}
+
+// runNodes compares the page checksums of the database on two nodes.
+func (c *DiffCommand) runNodes(ctx context.Context) error {
+	var a, b litefshttp.DBPagesJSON
+	if err := c.get(ctx, c.A, "pages", nil, &a); err != nil {
+		return fmt.Errorf("node a: %w", err)
+	} else if err := c.get(ctx, c.B, "pages", nil, &b); err != nil {
+		return fmt.Errorf("node b: %w", err)
+	}
+
+	fmt.Fprintf(c.Stdout, "a: txid=%s checksum=%s pages=%d\n", a.TXID, a.Checksum, len(a.Pages))
+	fmt.Fprintf(c.Stdout, "b: txid=%s checksum=%s pages=%d\n", b.TXID, b.Checksum, len(b.Pages))
+	if a.TXID != b.TXID {
+		fmt.Fprintln(c.Stdout, "WARNING: nodes are at different positions, differences may be from replication lag")
+	}
+	if a.PageSize != b.PageSize {
+		fmt.Fprintf(c.Stdout, "page size differs: a=%d b=%d\n", a.PageSize, b.PageSize)
+	}
+
+	n := len(a.Pages)
+	if len(b.Pages) > n {
+		n = len(b.Pages)
+	}
+
+	var diffs int
+	for i := 0; i < n; i++ {
+		var x, y string
+		if i < len(a.Pages) {
+			x = a.Pages[i]
+		}
+		if i < len(b.Pages) {
+			y = b.Pages[i]
+		}
+		if x == y {
+			continue
+		}
+
+		diffs++
+		if c.Verbose {
+			fmt.Fprintf(c.Stdout, "page %d: a=%s b=%s\n", i+1, formatPageChecksum(x), formatPageChecksum(y))
+		}
+	}
+
+	if diffs == 0 {
+		fmt.Fprintln(c.Stdout, "no differing pages")
+	} else {
+		fmt.Fprintf(c.Stdout, "%d differing pages\n", diffs)
+	}
+	return nil
+}
+
+// runTXIDs summarizes the pages changed by each transaction on node A.
+func (c *DiffCommand) runTXIDs(ctx context.Context) error {
+	q := url.Values{"from": {c.From}}
+	if c.To != "" {
+		q.Set("to", c.To)
+	}
+
+	var resp litefshttp.DBChangesJSON
+	if err := c.get(ctx, c.A, "changes", q, &resp); err != nil {
+		return err
+	}
+
+	pgnos := make(map[uint32]struct{})
+	for _, tx := range resp.TXs {
+		for _, pgno := range tx.Pgnos {
+			pgnos[pgno] = struct{}{}
+		}
+
+		if c.Verbose {
+			fmt.Fprintf(c.Stdout, "%s-%s: commit=%d pages=%v\n", tx.MinTXID, tx.MaxTXID, tx.Commit, tx.Pgnos)
+		}
+	}
+
+	fmt.Fprintf(c.Stdout, "%d transactions, %d distinct pages changed\n", len(resp.TXs), len(pgnos))
+	return nil
+}
+
+// get fetches a database action from a node and decodes the JSON response into v.
+func (c *DiffCommand) get(ctx context.Context, baseURL, action string, q url.Values, v any) error {
+	u := strings.TrimSuffix(baseURL, "/") + "/db/" + url.PathEscape(c.Name) + "/" + action
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s failed (%d): %s", action, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// formatPageChecksum returns a placeholder for a page missing from one side.
+func formatPageChecksum(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
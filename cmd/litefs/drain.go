@@ -0,0 +1,88 @@
+// go:build linux
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// DrainCommand represents a command to drain a running node before shutdown.
+type DrainCommand struct {
+	// Base URL of the node's API server.
+	URL string
+
+	// Maximum time to wait for the node to hand off the primary lease.
+	Timeout time.Duration
+
+	Stdout io.Writer
+}
+
+// NewDrainCommand returns a new instance of DrainCommand.
+func NewDrainCommand() *DrainCommand {
+	return &DrainCommand{
+		URL:     "http://localhost:20202",
+		Timeout: 30 * time.Second,
+		Stdout:  os.Stdout,
+	}
+}
+
+// ParseFlags parses the command line flags for the "drain" command.
+func (c *DrainCommand) ParseFlags(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("litefs-drain", flag.ContinueOnError)
+	fs.StringVar(&c.URL, "url", c.URL, "LiteFS API URL")
+	fs.DurationVar(&c.Timeout, "timeout", c.Timeout, "time to wait for lease handoff")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: litefs drain [-url URL] [-timeout DURATION]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() > 0 {
+		return fmt.Errorf("too many arguments")
+	}
+	return nil
+}
+
+// Run drains the node. The node stops accepting writes & becoming primary.
+// If it is primary, it releases its lease once in-flight transactions finish.
+func (c *DrainCommand) Run(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(c.URL, "/")+"/drain", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("drain failed (%d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		IsPrimary bool `json:"isPrimary"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+
+	if result.IsPrimary {
+		fmt.Fprintln(c.Stdout, "node drained, still primary (static lease)")
+	} else {
+		fmt.Fprintln(c.Stdout, "node drained")
+	}
+	return nil
+}
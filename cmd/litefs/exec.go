@@ -0,0 +1,152 @@
+// go:build linux
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/superfly/litefs"
+)
+
+// DefaultExecDebounce is the time the node's role must remain unchanged
+// before a primary-only subprocess is started or stopped.
+const DefaultExecDebounce = 5 * time.Second
+
+// DefaultExecShutdownTimeout is the time a subprocess has to exit after it is
+// signaled to stop before it is killed.
+const DefaultExecShutdownTimeout = 10 * time.Second
+
+// PrimaryExec runs a subprocess only while the node is primary. The process
+// is started on promotion & stopped on demotion. Role changes are debounced
+// so that a flapping lease does not repeatedly restart the process.
+type PrimaryExec struct {
+	store           *litefs.Store
+	args            []string
+	debounce        time.Duration
+	shutdownTimeout time.Duration
+
+	cmd    *exec.Cmd
+	doneCh chan error // receives the exit error of cmd
+	exited bool       // if true, cmd exited on its own while primary
+}
+
+// NewPrimaryExec returns a new instance of PrimaryExec.
+func NewPrimaryExec(store *litefs.Store, args []string, debounce, shutdownTimeout time.Duration) *PrimaryExec {
+	return &PrimaryExec{
+		store:           store,
+		args:            args,
+		debounce:        debounce,
+		shutdownTimeout: shutdownTimeout,
+	}
+}
+
+// Run monitors the node's role until ctx is canceled. The subprocess is
+// stopped before returning.
+func (e *PrimaryExec) Run(ctx context.Context) {
+	sub := e.store.SubscribeEvents(0)
+	defer func() { _ = sub.Close() }()
+
+	timer := time.NewTimer(e.debounce)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			e.stop()
+			return
+
+		case <-sub.NotifyCh():
+			// Restart the debounce period on any role change. Dropped events
+			// may have included a role change so treat them the same way.
+			if events, dropped := sub.Events(); dropped > 0 || hasRoleEvent(events) {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(e.debounce)
+			}
+
+		case err := <-e.doneCh:
+			// Do not restart a process that exits on its own, such as a
+			// migration, until the node is promoted again.
+			log.Printf("primary-only subprocess exited: %v", err)
+			e.cmd, e.doneCh, e.exited = nil, nil, true
+
+		case <-timer.C:
+			e.reconcile()
+		}
+	}
+}
+
+// reconcile starts or stops the subprocess to match the node's current role.
+func (e *PrimaryExec) reconcile() {
+	if !e.store.IsPrimary() {
+		e.exited = false
+		if e.cmd != nil {
+			log.Printf("node is no longer primary, stopping primary-only subprocess: %s", e.args[0])
+			e.stop()
+		}
+		return
+	}
+
+	if e.cmd != nil || e.exited {
+		return
+	}
+
+	log.Printf("node is primary, starting primary-only subprocess: %s %v", e.args[0], e.args[1:])
+	cmd := exec.Command(e.args[0], e.args[1:]...)
+	cmd.Env = os.Environ()
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		log.Printf("cannot start primary-only subprocess: %s", err)
+		return
+	}
+
+	doneCh := make(chan error, 1)
+	go func() { doneCh <- cmd.Wait() }()
+	e.cmd, e.doneCh = cmd, doneCh
+}
+
+// stop signals the subprocess to exit and waits for it. The process is killed
+// if it does not exit within the shutdown timeout.
+func (e *PrimaryExec) stop() {
+	if e.cmd == nil {
+		return
+	}
+	defer func() { e.cmd, e.doneCh = nil, nil }()
+
+	if err := e.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		log.Printf("cannot signal primary-only subprocess: %s", err)
+	}
+
+	var timeoutCh <-chan time.Time
+	if e.shutdownTimeout > 0 {
+		timeoutCh = time.After(e.shutdownTimeout)
+	}
+
+	select {
+	case <-e.doneCh:
+	case <-timeoutCh:
+		log.Printf("primary-only subprocess did not exit within %s, sending SIGKILL", e.shutdownTimeout)
+		_ = e.cmd.Process.Kill()
+		<-e.doneCh
+	}
+}
+
+// hasRoleEvent returns true if events includes a change of primary status.
+func hasRoleEvent(events []litefs.Event) bool {
+	for _, event := range events {
+		switch event.Type {
+		case litefs.EventTypePrimaryAcquire, litefs.EventTypePrimaryLost:
+			return true
+		}
+	}
+	return false
+}
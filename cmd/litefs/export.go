@@ -0,0 +1,171 @@
+// go:build linux
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/superfly/ltx"
+)
+
+// ExportCommand represents a command to export a database from a node as a
+// SQLite database file.
+type ExportCommand struct {
+	// Name of the database to export.
+	Name string
+
+	// Base URL of the node's API server.
+	URL string
+
+	// Output path. The database is streamed to Stdout if "-".
+	Path string
+
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// NewExportCommand returns a new instance of ExportCommand.
+func NewExportCommand() *ExportCommand {
+	return &ExportCommand{
+		URL:    "http://localhost:20202",
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+}
+
+// ParseFlags parses the command line flags for the "export" command.
+func (c *ExportCommand) ParseFlags(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("litefs-export", flag.ContinueOnError)
+	fs.StringVar(&c.Name, "name", c.Name, "database name")
+	fs.StringVar(&c.URL, "url", c.URL, "LiteFS API URL")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), `usage:
+  litefs export -name NAME [-url URL] PATH
+
+Writes the database to PATH, or streams it to stdout if PATH is "-".`)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() == 0 {
+		return fmt.Errorf("output path required")
+	} else if fs.NArg() > 1 {
+		return fmt.Errorf("too many arguments")
+	}
+	c.Path = fs.Arg(0)
+
+	if c.Name == "" {
+		return fmt.Errorf("database name required")
+	} else if c.URL == "" {
+		return fmt.Errorf("node url required")
+	}
+	return nil
+}
+
+// Run executes the command.
+func (c *ExportCommand) Run(ctx context.Context) (err error) {
+	u := strings.TrimSuffix(c.URL, "/") + "/db/" + url.PathEscape(c.Name) + "/export"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("export failed (%d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	// Report the position on stderr so that stdout only holds the database.
+	var header ltx.Header
+	var pageN uint32
+	dst := "stdout"
+	if c.Path == "-" {
+		header, pageN, err = decodeSnapshotTo(c.Stdout, resp.Body)
+	} else {
+		dst = filepath.Clean(c.Path)
+		header, pageN, err = c.exportFile(resp.Body)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.Stderr, "exported %q to %s: txid=%s pages=%d\n", c.Name, dst, ltx.FormatTXID(header.MaxTXID), pageN)
+	return nil
+}
+
+// exportFile writes the snapshot read from r to a temporary file which is
+// renamed to the output path once complete.
+func (c *ExportCommand) exportFile(r io.Reader) (header ltx.Header, pageN uint32, err error) {
+	tmpPath := c.Path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return header, 0, err
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(tmpPath)
+	}()
+
+	if header, pageN, err = decodeSnapshotTo(f, r); err != nil {
+		return header, pageN, err
+	} else if err := f.Sync(); err != nil {
+		return header, pageN, err
+	} else if err := f.Close(); err != nil {
+		return header, pageN, err
+	} else if err := os.Rename(tmpPath, c.Path); err != nil {
+		return header, pageN, err
+	}
+	return header, pageN, nil
+}
+
+// decodeSnapshotTo writes the pages of an LTX snapshot read from r to w as a
+// SQLite database file. Pages are written as they are decoded so the database
+// is never held in memory. The snapshot checksum is verified at the end so a
+// truncated or corrupted stream returns an error.
+func decodeSnapshotTo(w io.Writer, r io.Reader) (header ltx.Header, pageN uint32, err error) {
+	dec := ltx.NewDecoder(r)
+	if err := dec.DecodeHeader(); err != nil {
+		return header, 0, fmt.Errorf("decode snapshot header: %w", err)
+	}
+	header = dec.Header()
+	if !header.IsSnapshot() {
+		return header, 0, fmt.Errorf("expected snapshot, received txid range %s-%s", ltx.FormatTXID(header.MinTXID), ltx.FormatTXID(header.MaxTXID))
+	}
+
+	data := make([]byte, header.PageSize)
+	for {
+		var phdr ltx.PageHeader
+		if err := dec.DecodePage(&phdr, data); err == io.EOF {
+			break
+		} else if err != nil {
+			return header, pageN, fmt.Errorf("decode page: %w", err)
+		} else if phdr.Pgno != pageN+1 {
+			return header, pageN, fmt.Errorf("unexpected page %d, expected %d", phdr.Pgno, pageN+1)
+		}
+
+		if _, err := w.Write(data); err != nil {
+			return header, pageN, fmt.Errorf("write page: %w", err)
+		}
+		pageN++
+	}
+
+	if err := dec.Close(); err != nil {
+		return header, pageN, fmt.Errorf("verify snapshot: %w", err)
+	} else if pageN != header.Commit {
+		return header, pageN, fmt.Errorf("snapshot has %d pages, expected %d", pageN, header.Commit)
+	}
+	return header, pageN, nil
+}
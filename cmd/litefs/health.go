@@ -0,0 +1,38 @@
+// go:build linux
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// DefaultHealthBusyTimeout is the time the deep health check waits for the
+// health database write lock.
+const DefaultHealthBusyTimeout = 1 * time.Second
+
+// writeHealthMarker writes a marker row to the health database. The write
+// goes through the mount so it is replicated like any other transaction.
+func (m *Main) writeHealthMarker(ctx context.Context, name string) error {
+	sqldb, err := sql.Open("sqlite3", fmt.Sprintf("%s?_busy_timeout=%d", m.mountedDBPath(name), DefaultHealthBusyTimeout.Milliseconds()))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = sqldb.Close() }()
+
+	// Refuse to write into a user database that happens to share the name.
+	var n int
+	if err := sqldb.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE name != 'litefs_health'`).Scan(&n); err != nil {
+		return fmt.Errorf("read schema: %w", err)
+	} else if n > 0 {
+		return fmt.Errorf("database %q contains other tables, set http.health.db to an unused name", name)
+	}
+
+	if _, err := sqldb.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS litefs_health (id INTEGER PRIMARY KEY, node TEXT NOT NULL, written_at INTEGER NOT NULL)`); err != nil {
+		return fmt.Errorf("create table: %w", err)
+	} else if _, err := sqldb.ExecContext(ctx, `REPLACE INTO litefs_health (id, node, written_at) VALUES (1, ?, ?)`, m.Store.NodeName, time.Now().UnixMilli()); err != nil {
+		return fmt.Errorf("write marker: %w", err)
+	}
+	return nil
+}
@@ -2,16 +2,25 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
 	"os/user"
+	"path"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
@@ -24,6 +33,8 @@ import (
 	"github.com/superfly/litefs/consul"
 	"github.com/superfly/litefs/fuse"
 	"github.com/superfly/litefs/http"
+	"github.com/superfly/litefs/internal"
+	"github.com/superfly/ltx"
 	"gopkg.in/yaml.v3"
 )
 
@@ -48,6 +59,125 @@ func main() {
 		_ = os.Setenv("HOSTNAME", hostname)
 	}
 
+	// Run the bench command, if specified, instead of starting the server.
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		c := NewBenchCommand()
+		if err := c.ParseFlags(ctx, os.Args[2:]); err == flag.ErrHelp {
+			os.Exit(2)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(2)
+		}
+
+		if err := c.Run(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Run the LTX dump command, if specified, against a file on disk.
+	if len(os.Args) > 2 && os.Args[1] == "ltx" && os.Args[2] == "dump" {
+		c := NewLTXDumpCommand()
+		if err := c.ParseFlags(ctx, os.Args[3:]); err == flag.ErrHelp {
+			os.Exit(2)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(2)
+		}
+
+		if err := c.Run(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Restore a database from a backup, if specified.
+	if len(os.Args) > 2 && os.Args[1] == "backup" && os.Args[2] == "restore" {
+		c := NewBackupRestoreCommand()
+		if err := c.ParseFlags(ctx, os.Args[3:]); err == flag.ErrHelp {
+			os.Exit(2)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(2)
+		}
+
+		if err := c.Run(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// List LiteFS mounts and optionally clean up stale ones left by a crash.
+	if len(os.Args) > 1 && os.Args[1] == "mounts" {
+		c := NewMountsCommand()
+		if err := c.ParseFlags(ctx, os.Args[2:]); err == flag.ErrHelp {
+			os.Exit(2)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(2)
+		}
+
+		if err := c.Run(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Export a database from a node to a file or stdout.
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		c := NewExportCommand()
+		if err := c.ParseFlags(ctx, os.Args[2:]); err == flag.ErrHelp {
+			os.Exit(2)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(2)
+		}
+
+		if err := c.Run(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Compare database pages across nodes or transactions.
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		c := NewDiffCommand()
+		if err := c.ParseFlags(ctx, os.Args[2:]); err == flag.ErrHelp {
+			os.Exit(2)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(2)
+		}
+
+		if err := c.Run(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Drain a running node before shutdown.
+	if len(os.Args) > 1 && os.Args[1] == "drain" {
+		c := NewDrainCommand()
+		if err := c.ParseFlags(ctx, os.Args[2:]); err == flag.ErrHelp {
+			os.Exit(2)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(2)
+		}
+
+		if err := c.Run(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Initialize binary and parse CLI flags & config.
 	m := NewMain()
 	if err := m.ParseFlags(ctx, os.Args[1:]); err == flag.ErrHelp {
@@ -63,9 +193,31 @@ func main() {
 		os.Exit(2)
 	}
 
+	// Print the merged & validated config for verification, if requested.
+	if m.PrintConfig {
+		buf, err := yaml.Marshal(RedactConfig(m.Config))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: cannot marshal config: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(string(buf))
+		return
+	}
+
+	// Identify log lines by node, if a name is configured.
+	if name := m.Config.NodeName; name != "" {
+		log.SetPrefix("[" + name + "] ")
+	}
+
 	if err := m.Run(ctx); err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
 
+		// A failed join always exits so an orchestrator can restart the node.
+		if errors.Is(err, ErrConnectTimeout) {
+			_ = m.Close()
+			os.Exit(ExitCodeConnectTimeout)
+		}
+
 		// Only exit the process if enabled in the config. A user want to
 		// continue running so that an ephemeral node can be debugged intsead
 		// of continually restarting on error.
@@ -91,10 +243,28 @@ func main() {
 				os.Exit(1)
 			}
 
+			// Escalate to SIGKILL if the process ignores the signal so that
+			// shutdown cannot hang indefinitely.
+			var timeoutCh <-chan time.Time
+			timeout := m.Config.Exec.alwaysOn().ShutdownTimeout
+			if timeout > 0 {
+				timeoutCh = time.After(timeout)
+			}
+
 			fmt.Println("waiting for exec process to close")
-			if err := <-m.execCh; err != nil && !strings.HasPrefix(err.Error(), "signal:") {
-				fmt.Fprintln(os.Stderr, "cannot wait for exec process:", err)
-				os.Exit(1)
+			select {
+			case err := <-m.execCh:
+				if err != nil && !strings.HasPrefix(err.Error(), "signal:") {
+					fmt.Fprintln(os.Stderr, "cannot wait for exec process:", err)
+					os.Exit(1)
+				}
+			case <-timeoutCh:
+				log.Printf("exec process did not exit within %s, sending SIGKILL", timeout)
+				if err := m.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+					fmt.Fprintln(os.Stderr, "cannot kill exec process:", err)
+					os.Exit(1)
+				}
+				<-m.execCh
 			}
 		}
 
@@ -112,16 +282,36 @@ func main() {
 
 // Main represents the command line program.
 type Main struct {
-	cmd    *exec.Cmd  // subcommand
-	execCh chan error // subcommand error channel
+	cmd     *exec.Cmd     // subcommand
+	cmdDone chan struct{} // closed when subcommand exits
+	execCh  chan error    // subcommand error channel
+
+	primaryExecWG sync.WaitGroup // primary-only subcommand supervisors
+
+	ctx    context.Context // canceled on close
+	cancel func()
+
+	configPath     string   // path the config was read from, if any
+	configOverlays []string // overlay paths merged over the config, in order
+
+	logWriter     *internal.DedupWriter // deduplicates log output, if enabled
+	prevLogOutput io.Writer             // restored on close
 
 	Config Config
 
 	Store      *litefs.Store
 	Leaser     litefs.Leaser
-	FileSystem *fuse.FileSystem
+	FileSystem litefs.FileSystem
 	HTTPServer *http.Server
 
+	// If true, inaccessible data & mount directories are chown'd to the
+	// process user at startup. Set by the -fix-permissions flag.
+	FixPermissions bool
+
+	// If true, the effective config is printed after validation & the
+	// process exits without mounting. Set by the -print-config flag.
+	PrintConfig bool
+
 	// Used for generating the advertise URL for testing.
 	AdvertiseURLFn func() string
 }
@@ -143,19 +333,23 @@ func (m *Main) ParseFlags(ctx context.Context, args []string) (err error) {
 	fs := flag.NewFlagSet("litefs", flag.ContinueOnError)
 	configPath := fs.String("config", "", "config file path")
 	noExpandEnv := fs.Bool("no-expand-env", false, "do not expand env vars in config")
+	var overlays stringSliceFlag
+	fs.Var(&overlays, "config-overlay", "config file merged over the config, may be repeated")
+	fs.BoolVar(&m.FixPermissions, "fix-permissions", false, "chown & chmod inaccessible data & mount directories")
+	fs.BoolVar(&m.PrintConfig, "print-config", false, "print the effective config, with secrets redacted, and exit")
 	if err := fs.Parse(args0); err != nil {
 		return err
 	} else if fs.NArg() > 0 {
 		return fmt.Errorf("too many arguments, specify a '--' to specify an exec command")
 	}
 
-	if err := m.parseConfig(ctx, *configPath, !*noExpandEnv); err != nil {
+	if err := m.parseConfig(ctx, *configPath, overlays, !*noExpandEnv); err != nil {
 		return err
 	}
 
 	// Override "exec" field if specified on the CLI.
 	if args1 != nil {
-		m.Config.Exec = strings.Join(args1, " ")
+		m.Config.Exec = ExecConfigSlice{NewExecConfig(strings.Join(args1, " "))}
 	}
 
 	return nil
@@ -163,11 +357,15 @@ func (m *Main) ParseFlags(ctx context.Context, args []string) (err error) {
 
 // parseConfig parses the configuration file from configPath, if specified.
 // Otherwise searches the standard list of search paths. Returns an error if
-// no configuration files could be found.
-func (m *Main) parseConfig(ctx context.Context, configPath string, expandEnv bool) (err error) {
+// no configuration files could be found. Overlays are merged over the file
+// that is read.
+func (m *Main) parseConfig(ctx context.Context, configPath string, overlays []string, expandEnv bool) (err error) {
+	m.configOverlays = overlays
+
 	// Only read from explicit path, if specified. Report any error.
 	if configPath != "" {
-		return ReadConfigFile(&m.Config, configPath, expandEnv)
+		m.configPath = configPath
+		return ReadConfigFiles(&m.Config, configPath, overlays, expandEnv)
 	}
 
 	// Otherwise attempt to read each config path until we succeed.
@@ -176,8 +374,11 @@ func (m *Main) parseConfig(ctx context.Context, configPath string, expandEnv boo
 			return err
 		}
 
-		if err := ReadConfigFile(&m.Config, path, expandEnv); err == nil {
-			fmt.Printf("config file read from %s\n", path)
+		if err := ReadConfigFiles(&m.Config, path, overlays, expandEnv); err == nil {
+			if !m.PrintConfig { // keep printed config parseable
+				fmt.Printf("config file read from %s\n", path)
+			}
+			m.configPath = path
 			return nil
 		} else if err != nil && !os.IsNotExist(err) {
 			return fmt.Errorf("cannot read config file at %s: %s", path, err)
@@ -196,6 +397,33 @@ func (m *Main) Validate(ctx context.Context) (err error) {
 		return fmt.Errorf("mount directory and data directory cannot be the same path")
 	}
 
+	if m.Config.Log.DedupWindow < 0 {
+		return fmt.Errorf("log dedup-window cannot be negative")
+	}
+
+	// The ready file cannot be in the mount as it is only writable when primary.
+	if v := m.Config.FUSE.ReadyFile; v != "" {
+		if rel, err := filepath.Rel(m.Config.MountDir, v); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("fuse ready-file cannot be inside the mount directory")
+		}
+	}
+
+	switch litefs.NonDBFileMode(m.Config.FUSE.NonDBFiles) {
+	case litefs.NonDBFilesReject, litefs.NonDBFilesLocal, litefs.NonDBFilesReplicate:
+	default:
+		return fmt.Errorf("invalid fuse non-db-files mode: %q", m.Config.FUSE.NonDBFiles)
+	}
+
+	if _, err := parseFileMode(m.Config.FUSE.FileMode); err != nil {
+		return fmt.Errorf("invalid fuse file-mode: %q", m.Config.FUSE.FileMode)
+	} else if _, err := parseFileMode(m.Config.FUSE.DirMode); err != nil {
+		return fmt.Errorf("invalid fuse dir-mode: %q", m.Config.FUSE.DirMode)
+	}
+
+	if m.Config.CandidatePriority < 0 || m.Config.CandidatePriority > litefs.MaxCandidatePriority {
+		return fmt.Errorf("candidate-priority must be between 0 and %d", litefs.MaxCandidatePriority)
+	}
+
 	// Enforce exactly one lease mode.
 	if m.Config.Consul != nil && m.Config.Static != nil {
 		return fmt.Errorf("cannot specify both 'consul' and 'static' lease modes")
@@ -203,261 +431,1767 @@ func (m *Main) Validate(ctx context.Context) (err error) {
 		return fmt.Errorf("must specify a lease mode ('consul', 'static')")
 	}
 
-	return nil
-}
+	if m.Config.Consul != nil {
+		switch m.Config.Consul.AdvertiseResolve {
+		case "", "ipv4", "ipv6":
+		default:
+			return fmt.Errorf("invalid consul advertise-resolve: %q", m.Config.Consul.AdvertiseResolve)
+		}
 
-// configSearchPaths returns paths to search for the config file. It starts with
-// the current directory, then home directory, if available. And finally it tries
-// to read from the /etc directory.
-func configSearchPaths() []string {
-	a := []string{"litefs.yml"}
-	if u, _ := user.Current(); u != nil && u.HomeDir != "" {
-		a = append(a, filepath.Join(u.HomeDir, "litefs.yml"))
+		if c := m.Config.Consul.ChurnDampening; c != nil && (c.Window < 0 || c.Threshold < 0 || c.MaxBackoff < 0) {
+			return fmt.Errorf("consul churn-dampening window, threshold & max-backoff must not be negative")
+		}
+
+		if c := m.Config.Consul.TLS; c != nil {
+			if !strings.HasPrefix(m.Config.Consul.URL, "https://") {
+				return fmt.Errorf("consul tls requires an https url")
+			} else if (c.CertFile == "") != (c.KeyFile == "") {
+				return fmt.Errorf("consul tls cert-file & key-file must be set together")
+			}
+		}
 	}
-	a = append(a, "/etc/litefs.yml")
-	return a
-}
 
-func (m *Main) Close() (err error) {
-	if m.HTTPServer != nil {
-		if e := m.HTTPServer.Close(); err == nil {
-			err = e
+	if m.Config.Static != nil {
+		switch m.Config.Static.OnConflict {
+		case "", StaticOnConflictWarn, StaticOnConflictDemote:
+		default:
+			return fmt.Errorf("invalid static on-conflict: %q", m.Config.Static.OnConflict)
+		}
+		if m.Config.Static.ConflictCheckInterval < 0 {
+			return fmt.Errorf("static conflict-check-interval cannot be negative")
 		}
 	}
 
-	if m.FileSystem != nil {
-		if e := m.FileSystem.Unmount(); err == nil {
-			err = e
+	// Ensure the advertise URL is either valid or can be derived.
+	if v := m.Config.HTTP.AdvertisePort; v < 0 || v > 65535 {
+		return fmt.Errorf("http advertise-port must be between 0 and 65535")
+	}
+	var advertiseURL string
+	if m.Config.Consul != nil {
+		advertiseURL = m.Config.Consul.AdvertiseURL
+	} else {
+		advertiseURL = m.Config.Static.AdvertiseURL
+	}
+	if advertiseURL != "" {
+		if u, err := url.Parse(advertiseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid advertise-url: %q", advertiseURL)
+		} else if (m.Config.Consul != nil || m.Config.Static.Primary) && (m.Config.HTTP.AdvertiseAddr != "" || m.Config.HTTP.AdvertisePort != 0) {
+			return fmt.Errorf("cannot specify advertise-url with http advertise-addr or advertise-port")
 		}
 	}
 
-	if m.Store != nil {
-		if e := m.Store.Close(); err == nil {
-			err = e
+	// A static replica connects to the primary's URL so it cannot be derived.
+	if m.Config.Static != nil && !m.Config.Static.Primary && m.Config.Static.AdvertiseURL == "" {
+		return fmt.Errorf("static advertise-url of the primary is required on replicas")
+	}
+	if m.Config.Consul != nil && m.Config.Consul.AdvertiseURL == "" && m.Config.Consul.Hostname == "" && m.Config.HTTP.AdvertiseAddr == "" {
+		if _, err := os.Hostname(); err != nil {
+			return fmt.Errorf("cannot derive consul advertise-url from hostname, set consul.advertise-url or http.advertise-addr: %w", err)
 		}
 	}
 
-	return err
-}
+	// Validate replication acknowledgement settings.
+	switch m.Config.Replication.AckMode {
+	case litefs.AckModeAsync:
+	case litefs.AckModeQuorum:
+		if m.Config.Replication.Quorum.MinReplicas < 1 {
+			return fmt.Errorf("quorum min-replicas must be at least 1")
+		}
+	default:
+		return fmt.Errorf("invalid replication ack-mode: %q", m.Config.Replication.AckMode)
+	}
 
-func (m *Main) Run(ctx context.Context) (err error) {
-	// Print version & commit information, if available.
-	if Version != "" {
-		log.Printf("LiteFS %s, commit=%s", Version, Commit)
-	} else if Commit != "" {
-		log.Printf("LiteFS commit=%s", Commit)
-	} else {
-		log.Printf("LiteFS development build")
+	switch m.Config.Replication.Quorum.OnTimeout {
+	case "fail", "async":
+	default:
+		return fmt.Errorf("invalid quorum on-timeout: %q", m.Config.Replication.Quorum.OnTimeout)
 	}
 
-	// Start listening on HTTP server first so we can determine the URL.
-	if err := m.initStore(ctx); err != nil {
-		return fmt.Errorf("cannot init store: %w", err)
-	} else if err := m.initHTTPServer(ctx); err != nil {
-		return fmt.Errorf("cannot init http server: %w", err)
+	switch m.Config.OnLeaseLoss {
+	case litefs.LeaseLossAbort, litefs.LeaseLossComplete:
+	default:
+		return fmt.Errorf("invalid on-lease-loss: %q", m.Config.OnLeaseLoss)
 	}
 
-	// Instantiate leaser.
-	if m.Config.Consul != nil {
-		log.Println("Using Consul to determine primary")
-		if err := m.initConsul(ctx); err != nil {
-			return fmt.Errorf("cannot init consul: %w", err)
-		}
-	} else { // static
-		log.Printf("Using static primary: is-primary=%v hostname=%s advertise-url=%s", m.Config.Static.Primary, m.Config.Static.Hostname, m.Config.Static.AdvertiseURL)
-		m.Leaser = litefs.NewStaticLeaser(m.Config.Static.Primary, m.Config.Static.Hostname, m.Config.Static.AdvertiseURL)
+	switch m.Config.StartupReport {
+	case StartupReportText, StartupReportJSON:
+	default:
+		return fmt.Errorf("invalid startup-report: %q", m.Config.StartupReport)
 	}
 
-	if err := m.openStore(ctx); err != nil {
-		return fmt.Errorf("cannot open store: %w", err)
+	switch m.Config.OnClusterMismatch {
+	case litefs.ClusterMismatchFail, litefs.ClusterMismatchReset:
+	default:
+		return fmt.Errorf("invalid on-cluster-mismatch: %q", m.Config.OnClusterMismatch)
 	}
 
-	if err := m.initFileSystem(ctx); err != nil {
-		return fmt.Errorf("cannot init file system: %w", err)
+	switch m.Config.Replica.LocalWrite {
+	case litefs.LocalWriteReject, litefs.LocalWriteShadow, litefs.LocalWriteAllow:
+	default:
+		return fmt.Errorf("invalid replica local-write: %q", m.Config.Replica.LocalWrite)
+	}
+	if m.Config.Replica.CatchupDeadline < 0 {
+		return fmt.Errorf("replica catchup-deadline cannot be negative")
+	} else if m.Config.Replica.MaxApplyRate < 0 {
+		return fmt.Errorf("replica max-apply-rate cannot be negative")
+	} else if m.Config.Replica.ApplyRateBypassLag < 0 {
+		return fmt.Errorf("replica apply-rate-bypass-lag cannot be negative")
+	} else if m.Config.Replica.ApplyConcurrency < 0 {
+		return fmt.Errorf("replica apply-concurrency cannot be negative")
 	}
-	log.Printf("LiteFS mounted to: %s", m.FileSystem.Path())
 
-	m.HTTPServer.Serve()
-	log.Printf("http server listening on: %s", m.HTTPServer.URL())
+	switch m.Config.Replica.ReadConsistency {
+	case litefs.ReadConsistencyLocal, litefs.ReadConsistencyLinearizable:
+	default:
+		return fmt.Errorf("invalid replica read-consistency: %q", m.Config.Replica.ReadConsistency)
+	}
+	if m.Config.Replica.ReadTimeout <= 0 {
+		return fmt.Errorf("replica read-timeout must be greater than zero")
+	}
 
-	// Wait until the store either becomes primary or connects to the primary.
-	log.Printf("waiting to connect to cluster")
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case <-m.Store.ReadyCh():
-		log.Printf("connected to cluster, ready")
+	switch m.Config.FileSystem.Backend {
+	case FileSystemBackendFUSE:
+	case FileSystemBackendNone:
+		if m.Config.Candidate {
+			return fmt.Errorf("candidate must be false when file system backend is %q", FileSystemBackendNone)
+		}
+	default:
+		return fmt.Errorf("invalid file system backend: %q", m.Config.FileSystem.Backend)
 	}
 
-	// Execute subcommand, if specified in config.
-	if err := m.execCmd(ctx); err != nil {
-		return fmt.Errorf("cannot exec: %w", err)
+	switch m.Config.ConfigSource.Source {
+	case ConfigSourceLocal, ConfigSourcePrimary:
+	default:
+		return fmt.Errorf("invalid config source: %q", m.Config.ConfigSource.Source)
 	}
 
-	return nil
-}
+	if m.Config.ConnectTimeout < 0 {
+		return fmt.Errorf("connect-timeout cannot be negative")
+	}
 
-func (m *Main) initConsul(ctx context.Context) (err error) {
-	// TEMP: Allow non-localhost addresses.
+	for _, pattern := range m.Config.Databases.Include {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid databases include pattern %q: %w", pattern, err)
+		}
+	}
+	for _, pattern := range m.Config.Databases.Exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid databases exclude pattern %q: %w", pattern, err)
+		}
+	}
 
-	// Use hostname from OS, if not specified.
-	hostname := m.Config.Consul.Hostname
-	if hostname == "" {
-		if hostname, err = os.Hostname(); err != nil {
-			return err
+	if v := m.Config.Maintenance.Vacuum; v.Interval < 0 {
+		return fmt.Errorf("vacuum interval cannot be negative")
+	} else if v.Interval > 0 && len(v.Databases) == 0 {
+		return fmt.Errorf("vacuum databases required when interval is set")
+	} else if v.Interval > 0 && !VacuumSupported {
+		return fmt.Errorf(`vacuum requires litefs to be built with the "vacuum" tag`)
+	} else if v.MinFreePages < 0 {
+		return fmt.Errorf("vacuum min-free-pages cannot be negative")
+	}
+
+	if err := m.Config.Hooks.CircuitBreaker.validate("hooks"); err != nil {
+		return err
+	} else if err := m.Config.Sink.validate(); err != nil {
+		return err
+	}
+
+	if v := m.Config.Snapshots; v.Path != "" {
+		switch v.RunOn {
+		case SnapshotRunOnReplica, SnapshotRunOnPrimary, SnapshotRunOnAny:
+		default:
+			return fmt.Errorf("invalid snapshots run-on: %q", v.RunOn)
+		}
+		if v.Interval <= 0 {
+			return fmt.Errorf("snapshots interval must be greater than zero")
+		} else if v.Retain <= 0 {
+			return fmt.Errorf("snapshots retain must be greater than zero")
 		}
 	}
 
-	// Determine the advertise URL for the LiteFS API.
-	// Default to use the hostname and HTTP port. Also allow injection for tests.
-	advertiseURL := m.Config.Consul.AdvertiseURL
-	if m.AdvertiseURLFn != nil {
-		advertiseURL = m.AdvertiseURLFn()
+	if v := m.Config.Metrics; v.DumpFile != "" {
+		if v.DumpInterval <= 0 {
+			return fmt.Errorf("metrics dump-interval must be greater than zero")
+		} else if rel, err := filepath.Rel(m.Config.MountDir, v.DumpFile); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("metrics dump-file cannot be inside the mount directory")
+		}
 	}
-	if advertiseURL == "" && hostname != "" {
-		advertiseURL = fmt.Sprintf("http://%s:%d", hostname, m.HTTPServer.Port())
+
+	switch v := m.Config.Backup; v.Mode {
+	case "":
+	case BackupModeSnapshot, BackupModeIncremental:
+		if v.Path == "" {
+			return fmt.Errorf("backup path required")
+		} else if v.Interval <= 0 {
+			return fmt.Errorf("backup interval must be greater than zero")
+		} else if v.SnapshotInterval < 0 {
+			return fmt.Errorf("backup snapshot-interval cannot be negative")
+		}
+	default:
+		return fmt.Errorf("invalid backup mode: %q", v.Mode)
 	}
 
-	leaser := consul.NewLeaser(m.Config.Consul.URL, hostname, advertiseURL)
-	if v := m.Config.Consul.Key; v != "" {
-		leaser.Key = v
+	if m.Config.Retention.MaxCount < 0 {
+		return fmt.Errorf("retention max-count cannot be negative")
+	} else if m.Config.Retention.MaxBytes < 0 {
+		return fmt.Errorf("retention max-bytes cannot be negative")
 	}
-	if v := m.Config.Consul.TTL; v > 0 {
-		leaser.TTL = v
+
+	if m.Config.SQLite.MaxWALSize < 0 {
+		return fmt.Errorf("sqlite max-wal-size cannot be negative")
+	} else if m.Config.Write.BusyTimeout < 0 {
+		return fmt.Errorf("write busy-timeout cannot be negative")
 	}
-	if v := m.Config.Consul.LockDelay; v > 0 {
-		leaser.LockDelay = v
+
+	if m.Config.Data.MinFreeBytes < 0 {
+		return fmt.Errorf("data min-free-bytes cannot be negative")
+	} else if algo := litefs.ChecksumAlgorithm(m.Config.Data.ChecksumAlgorithm); algo != litefs.ChecksumAlgorithmCRC64 {
+		return fmt.Errorf("unsupported data checksum-algorithm: %q", algo)
 	}
-	if err := leaser.Open(); err != nil {
-		return fmt.Errorf("cannot connect to consul: %w", err)
+
+	switch litefs.SyncMode(m.Config.Data.SyncMode) {
+	case litefs.SyncModeFull, litefs.SyncModeNormal, litefs.SyncModeOff:
+	default:
+		return fmt.Errorf("invalid data sync-mode: %q", m.Config.Data.SyncMode)
 	}
-	log.Printf("initializing consul: key=%s url=%s hostname=%s advertise-url=%s", m.Config.Consul.Key, m.Config.Consul.URL, hostname, advertiseURL)
 
-	m.Leaser = leaser
-	return nil
-}
+	switch litefs.CaseSensitivity(m.Config.Data.CaseSensitivity) {
+	case litefs.CaseSensitivityStrict, litefs.CaseSensitivityFold:
+	default:
+		return fmt.Errorf("invalid data case-sensitivity: %q", m.Config.Data.CaseSensitivity)
+	}
 
-func (m *Main) initStore(ctx context.Context) error {
-	m.Store = litefs.NewStore(m.Config.DataDir, m.Config.Candidate)
-	m.Store.Debug = m.Config.Debug
-	m.Store.StrictVerify = m.Config.StrictVerify
-	m.Store.RetentionDuration = m.Config.Retention.Duration
-	m.Store.RetentionMonitorInterval = m.Config.Retention.MonitorInterval
-	m.Store.Client = http.NewClient()
-	return nil
-}
+	if m.Config.HTTP.Replication.MaxReplicas < 0 {
+		return fmt.Errorf("http max-replicas cannot be negative")
+	} else if m.Config.HTTP.Replication.MaxAcceptRate < 0 {
+		return fmt.Errorf("http max-accept-rate cannot be negative")
+	} else if m.Config.HTTP.Replication.ReconnectWindow < time.Second {
+		return fmt.Errorf("http reconnect-window must be at least 1s")
+	} else if m.Config.HTTP.Replication.MaxConcurrentSnapshots < 0 {
+		return fmt.Errorf("http max-concurrent-snapshots cannot be negative")
+	} else if m.Config.HTTP.Events.BufferSize <= 0 {
+		return fmt.Errorf("http events buffer-size must be positive")
+	} else if m.Config.HTTP.Events.HistorySize < 0 {
+		return fmt.Errorf("http events history-size cannot be negative")
+	} else if m.Config.HTTP.Health.Timeout <= 0 {
+		return fmt.Errorf("http health timeout must be greater than zero")
+	} else if v := m.Config.HTTP.Health.DB; v != "" && strings.ContainsAny(v, "/*?[") {
+		return fmt.Errorf("invalid http health db: %q", v)
+	}
 
-func (m *Main) openStore(ctx context.Context) error {
-	m.Store.Leaser = m.Leaser
-	if err := m.Store.Open(); err != nil {
-		return err
+	// The deep health check writes its marker with SQLite through the mount.
+	if m.Config.HTTP.Health.DB != "" {
+		if m.Config.Observer {
+			return fmt.Errorf("http health db cannot be set on an observer")
+		} else if m.Config.FileSystem.Backend != FileSystemBackendFUSE {
+			return fmt.Errorf("http health db requires the fuse file system backend")
+		} else if !VacuumSupported {
+			return fmt.Errorf(`http health db requires litefs to be built with the "vacuum" tag`)
+		}
 	}
 
-	// Register expvar variable once so it doesn't panic during tests.
-	expvarOnce.Do(func() { expvar.Publish("store", (*litefs.StoreVar)(m.Store)) })
+	if addr := m.Config.HTTP.Client.SourceAddr; addr != "" {
+		if err := validateSourceAddr(addr); err != nil {
+			return err
+		}
+	}
 
-	return nil
-}
+	if m.Config.HTTP.DebugState && m.Config.HTTP.DebugStateToken == "" {
+		return fmt.Errorf("http debug-state-token required when debug-state is enabled")
+	} else if m.Config.HTTP.Pprof && m.Config.HTTP.DebugStateToken == "" {
+		return fmt.Errorf("http debug-state-token required when pprof is enabled")
+	}
 
-func (m *Main) initFileSystem(ctx context.Context) error {
-	// Build the file system to interact with the store.
-	fsys := fuse.NewFileSystem(m.Config.MountDir, m.Store)
-	if err := fsys.Mount(); err != nil {
-		return fmt.Errorf("cannot open file system: %s", err)
+	if subdir := m.Config.FUSE.Subdir; subdir != "" && !isValidSubdir(subdir) {
+		return fmt.Errorf("invalid fuse subdir: %q", subdir)
 	}
 
-	// Attach file system to store so it can invalidate the page cache.
-	m.Store.Invalidator = fsys
+	if m.Config.FUSE.AutoRemount {
+		if m.Config.FUSE.MaxRemountAttempts < 1 {
+			return fmt.Errorf("fuse max-remount-attempts must be at least 1")
+		} else if m.Config.FUSE.CheckInterval <= 0 {
+			return fmt.Errorf("fuse check-interval must be greater than zero")
+		}
+	}
+	if m.Config.FUSE.SlowOpThreshold < 0 {
+		return fmt.Errorf("fuse slow-op-threshold cannot be negative")
+	}
+
+	// Ensure the subcommands exist so a typo fails before anything is mounted.
+	var alwaysOnN int
+	for _, c := range m.Config.Exec {
+		args, err := shellwords.Parse(c.Cmd)
+		if err != nil {
+			return fmt.Errorf("cannot parse exec command: %w", err)
+		} else if len(args) == 0 {
+			return fmt.Errorf("exec command required")
+		} else if _, err := exec.LookPath(args[0]); err != nil {
+			return fmt.Errorf("exec command not found in PATH: %q", args[0])
+		} else if c.Debounce < 0 {
+			return fmt.Errorf("exec debounce cannot be negative")
+		} else if c.ShutdownTimeout < 0 {
+			return fmt.Errorf("exec shutdown-timeout cannot be negative")
+		}
+
+		if !c.PrimaryOnly {
+			alwaysOnN++
+		}
+	}
+	if alwaysOnN > 1 {
+		return fmt.Errorf("exec supports only one command without primary-only")
+	}
 
-	m.FileSystem = fsys
 	return nil
 }
 
-func (m *Main) initHTTPServer(ctx context.Context) error {
-	server := http.NewServer(m.Store, m.Config.HTTP.Addr)
-	if err := server.Listen(); err != nil {
-		return fmt.Errorf("cannot open http server: %w", err)
+// isValidSubdir returns true if s is a clean, relative slash-separated path
+// that stays within the mount directory.
+func isValidSubdir(s string) bool {
+	if path.IsAbs(s) || path.Clean(s) != s {
+		return false
 	}
-	m.HTTPServer = server
-	return nil
+	for _, part := range strings.Split(s, "/") {
+		if part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
 }
 
-func (m *Main) execCmd(ctx context.Context) error {
-	// Exit if no subcommand specified.
-	if m.Config.Exec == "" {
-		return nil
+// configSearchPaths returns paths to search for the config file. It starts with
+// the current directory, then home directory, if available. And finally it tries
+// to read from the /etc directory.
+func configSearchPaths() []string {
+	a := []string{"litefs.yml"}
+	if u, _ := user.Current(); u != nil && u.HomeDir != "" {
+		a = append(a, filepath.Join(u.HomeDir, "litefs.yml"))
 	}
+	a = append(a, "/etc/litefs.yml")
+	return a
+}
 
-	// Execute subcommand process.
-	args, err := shellwords.Parse(m.Config.Exec)
-	if err != nil {
-		return fmt.Errorf("cannot parse exec command: %w", err)
+// ShutdownStepTimeout is the maximum time allowed for each step of shutdown.
+const ShutdownStepTimeout = 10 * time.Second
+
+// Close shuts down the program. New writes are stopped & in-progress writes
+// are drained before the subprocess is stopped & the file system unmounted.
+// Each step is bounded by ShutdownStepTimeout so a stuck step does not hang
+// shutdown indefinitely.
+func (m *Main) Close() (err error) {
+	if m.cancel != nil {
+		m.cancel()
 	}
 
-	log.Printf("starting subprocess: %s %v", args[0], args[1:])
+	steps := []struct {
+		name string
+		fn   func(ctx context.Context) error
+	}{
+		{"remove ready file", func(ctx context.Context) error { return m.removeReadyFile() }},
+		{"stop writes", m.stopWrites},
+		{"stop subprocess", m.stopCmd},
+		{"unmount file system", func(ctx context.Context) error {
+			if m.FileSystem == nil {
+				return nil
+			}
+			return m.FileSystem.Unmount()
+		}},
+		{"close leaser", func(ctx context.Context) error {
+			if m.Leaser == nil {
+				return nil
+			}
+			return m.Leaser.Close()
+		}},
+		{"close store", func(ctx context.Context) error {
+			if m.Store == nil {
+				return nil
+			}
+			return m.Store.Close()
+		}},
+		{"close http server", func(ctx context.Context) error {
+			if m.HTTPServer == nil {
+				return nil
+			}
+			return m.HTTPServer.Close()
+		}},
+	}
 
-	m.cmd = exec.CommandContext(ctx, args[0], args[1:]...)
-	m.cmd.Env = os.Environ()
-	m.cmd.Stdout = os.Stdout
-	m.cmd.Stderr = os.Stderr
-	if err := m.cmd.Start(); err != nil {
-		return fmt.Errorf("cannot start exec command: %w", err)
+	for _, step := range steps {
+		if e := runShutdownStep(step.name, step.fn); err == nil {
+			err = e
+		}
 	}
-	go func() { m.execCh <- m.cmd.Wait() }()
 
-	return nil
+	// Write pending log summaries last so shutdown messages are included.
+	if m.logWriter != nil {
+		log.SetOutput(m.prevLogOutput)
+		_ = m.logWriter.Close()
+		m.logWriter = nil
+	}
+	return err
 }
 
-var expvarOnce sync.Once
+// runShutdownStep executes fn and returns an error if it does not complete
+// within ShutdownStepTimeout. The step continues in the background on timeout.
+func runShutdownStep(name string, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownStepTimeout)
+	defer cancel()
 
-// NOTE: Update etc/litefs.yml configuration file after changing the structure below.
+	t := time.Now()
+	ch := make(chan error, 1)
+	go func() { ch <- fn(ctx) }()
+
+	select {
+	case err := <-ch:
+		if err != nil {
+			log.Printf("shutdown: %s failed after %s: %s", name, time.Since(t), err)
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return nil
+	case <-ctx.Done():
+		log.Printf("shutdown: %s timed out after %s", name, ShutdownStepTimeout)
+		return fmt.Errorf("%s: timeout", name)
+	}
+}
+
+// stopWrites rejects new write transactions & waits for in-progress ones.
+func (m *Main) stopWrites(ctx context.Context) error {
+	if m.Store == nil {
+		return nil
+	}
+	m.Store.DisableWrites()
+	return m.Store.Drain(ctx)
+}
+
+// stopCmd signals the subprocesses to stop, if running, and waits for them to exit.
+func (m *Main) stopCmd(ctx context.Context) error {
+	// Primary-only subprocesses are stopped once the main context is canceled.
+	ch := make(chan struct{})
+	go func() { m.primaryExecWG.Wait(); close(ch) }()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-ch:
+	}
+
+	if m.cmd == nil {
+		return nil
+	}
+
+	select {
+	case <-m.cmdDone:
+		return nil // already exited
+	default:
+	}
+
+	if err := m.cmd.Process.Signal(syscall.SIGTERM); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+
+	var timeoutCh <-chan time.Time
+	timeout := m.Config.Exec.alwaysOn().ShutdownTimeout
+	if timeout > 0 {
+		timeoutCh = time.After(timeout)
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-m.cmdDone:
+		return nil
+	case <-timeoutCh:
+	}
+
+	log.Printf("exec process did not exit within %s, sending SIGKILL", timeout)
+	if err := m.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-m.cmdDone:
+		return nil
+	}
+}
+
+func (m *Main) Run(ctx context.Context) (err error) {
+	// Print version & commit information, if available.
+	log.Print(versionString())
+
+	// Background tasks are stopped when the program is closed.
+	m.ctx, m.cancel = context.WithCancel(ctx)
+
+	// Collapse repeated identical log messages, such as during an outage.
+	if d := m.Config.Log.DedupWindow; d > 0 {
+		m.prevLogOutput = log.Writer()
+		m.logWriter = internal.NewDedupWriter(m.prevLogOutput, d)
+		m.logWriter.Open()
+		log.SetOutput(m.logWriter)
+	}
+
+	// Remove a ready file left behind by a previous process that crashed.
+	if err := m.removeReadyFile(); err != nil {
+		return fmt.Errorf("cannot remove stale ready file: %w", err)
+	}
+
+	// Catch misconfigured directory ownership before it fails on first write.
+	if m.Config.CheckPermissions || m.FixPermissions {
+		if err := m.CheckDirPermissions(m.FixPermissions); err != nil {
+			return err
+		}
+	}
+
+	// Start listening on HTTP server first so we can determine the URL.
+	if err := m.initStore(ctx); err != nil {
+		return fmt.Errorf("cannot init store: %w", err)
+	} else if err := m.initHTTPServer(ctx); err != nil {
+		return fmt.Errorf("cannot init http server: %w", err)
+	}
+
+	// Instantiate leaser.
+	if m.Config.Consul != nil {
+		if err := m.initConsul(ctx); err != nil {
+			return fmt.Errorf("cannot init consul: %w", err)
+		}
+	} else { // static
+		// Derive the advertise URL from the advertise address or hostname, if
+		// not set. Replicas connect to the primary's URL which is always set.
+		advertiseURL := m.Config.Static.AdvertiseURL
+		if advertiseURL == "" && m.Config.Static.Primary {
+			if host := m.Config.HTTP.AdvertiseAddr; host != "" {
+				advertiseURL = advertiseHostURL(host, m.advertisePort())
+			} else if host := m.Config.Static.Hostname; host != "" {
+				advertiseURL = advertiseHostURL(host, m.advertisePort())
+			}
+		}
+		leaser := litefs.NewStaticLeaser(m.Config.Static.Primary, m.Config.Static.Hostname, advertiseURL)
+		m.Leaser = leaser
+
+		// Detect another node misconfigured as the static primary.
+		if m.Config.Static.Primary && len(m.Config.Static.Peers) > 0 {
+			go m.monitorStaticConflict(m.ctx, leaser)
+		}
+	}
+
+	if err := m.openStore(ctx); err != nil {
+		return fmt.Errorf("cannot open store: %w", err)
+	}
+
+	// Watch for runtime settings in Consul KV, if a prefix is specified.
+	if leaser, ok := m.Leaser.(*consul.Leaser); ok && m.Config.Consul.ConfigPrefix != "" {
+		go m.monitorConsulConfig(m.ctx, leaser)
+	}
+
+	if err := m.initFileSystem(ctx); err != nil {
+		return fmt.Errorf("cannot init file system: %w", err)
+	}
+	// Recover from a lost FUSE connection, if enabled.
+	if _, ok := m.FileSystem.(*fuse.FileSystem); ok && m.Config.FUSE.AutoRemount {
+		go m.monitorMount(m.ctx)
+	}
+
+	m.HTTPServer.Serve()
+
+	// Report the resolved settings once everything is listening & mounted.
+	m.logStartupReport()
+
+	// Periodically vacuum opted-in databases while primary, if enabled.
+	if m.Config.Maintenance.Vacuum.Interval > 0 {
+		go m.monitorVacuum(m.ctx)
+	}
+
+	// Run hook command after transactions are applied, if specified.
+	if m.Config.Hooks.PostApply != "" {
+		go m.monitorPostApplyHook(m.ctx, m.Store.Subscribe())
+	}
+
+	// Periodically back up databases while primary, if enabled.
+	if m.Config.Backup.Mode != "" {
+		go m.monitorBackup(m.ctx)
+	}
+
+	// Periodically write database snapshots, preferably on replicas, if enabled.
+	if m.Config.Snapshots.Path != "" {
+		go m.monitorSnapshots(m.ctx)
+	}
+	if m.Config.Metrics.DumpFile != "" {
+		go m.monitorMetricsDump(m.ctx)
+	}
+
+	// Ship committed transactions to an external sink, if configured.
+	if m.Config.Sink.Type != "" {
+		sink := NewSink(m.Store, m.Config.Sink)
+		sink.ExcludeDB = m.Config.HTTP.Health.DB
+		go sink.Run(m.ctx, m.Store.Subscribe())
+	}
+
+	// Wait until the store either becomes primary or connects to the primary.
+	if err := m.WaitConnected(ctx); err != nil {
+		return err
+	}
+
+	if err := m.writeReadyFile(); err != nil {
+		return fmt.Errorf("cannot write ready file: %w", err)
+	}
+
+	// Execute subcommand, if specified in config.
+	if err := m.execCmd(ctx); err != nil {
+		return fmt.Errorf("cannot exec: %w", err)
+	}
+
+	return nil
+}
+
+// writeReadyFile atomically creates the ready file, if configured, so that
+// watchers never observe a partially written file.
+func (m *Main) writeReadyFile() error {
+	path := m.Config.FUSE.ReadyFile
+	if path == "" {
+		return nil
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0o666); err != nil {
+		return err
+	} else if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	log.Printf("ready file written: %s", path)
+	return nil
+}
+
+// removeReadyFile removes the ready file, if configured & present.
+func (m *Main) removeReadyFile() error {
+	path := m.Config.FUSE.ReadyFile
+	if path == "" {
+		return nil
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (m *Main) initConsul(ctx context.Context) (err error) {
+	// TEMP: Allow non-localhost addresses.
+
+	// Use hostname from OS, if not specified.
+	hostname := m.Config.Consul.Hostname
+	if hostname == "" {
+		if hostname, err = os.Hostname(); err != nil {
+			return err
+		}
+	}
+
+	// Determine the advertise URL for the LiteFS API. Default to use the
+	// advertise address & port, which fall back to the hostname & bind port.
+	// Also allow injection for tests.
+	advertiseURL := m.Config.Consul.AdvertiseURL
+	if m.AdvertiseURLFn != nil {
+		advertiseURL = m.AdvertiseURLFn()
+	}
+	advertiseHost := hostname
+	if v := m.Config.HTTP.AdvertiseAddr; v != "" {
+		advertiseHost = v
+	}
+	var advertiseIP net.IP
+	if advertiseURL == "" && advertiseHost != "" {
+		advertiseURL = advertiseHostURL(advertiseHost, m.advertisePort())
+
+		// Advertise the resolved IP instead of the hostname, if enabled.
+		if m.Config.Consul.AdvertiseResolve != "" {
+			if advertiseIP, err = m.resolveAdvertiseIP(ctx, advertiseHost); err != nil {
+				return fmt.Errorf("cannot resolve advertise address: %w", err)
+			}
+			advertiseURL = advertiseIPURL(advertiseIP, m.advertisePort())
+		}
+	}
+
+	// Advertise the node name to other nodes unless a hostname is explicitly
+	// set. The hostname is still used to build the advertise URL.
+	name := hostname
+	if m.Config.Consul.Hostname == "" && m.Config.NodeName != "" {
+		name = m.Config.NodeName
+	}
+
+	leaser := consul.NewLeaser(m.Config.Consul.URL, name, advertiseURL)
+	if v := m.Config.Consul.Key; v != "" {
+		leaser.Key = v
+	}
+	leaser.KeyPrefix = m.Config.Consul.KeyPrefix
+	if v := m.Config.Consul.TTL; v > 0 {
+		leaser.TTL = v
+	}
+	if v := m.Config.Consul.LockDelay; v > 0 {
+		leaser.LockDelay = v
+	}
+	if v := m.Config.Consul.ReleaseOnShutdown; v != nil {
+		leaser.ReleaseOnShutdown = *v
+	}
+	leaser.ForceTakeover = m.Config.Consul.ForceTakeover
+	if c := m.Config.Consul.ChurnDampening; c != nil {
+		if c.Window > 0 {
+			leaser.ChurnWindow = c.Window
+		}
+		if c.Threshold > 0 {
+			leaser.ChurnThreshold = c.Threshold
+		}
+		leaser.ChurnMaxBackoff = consul.DefaultChurnMaxBackoff
+		if c.MaxBackoff > 0 {
+			leaser.ChurnMaxBackoff = c.MaxBackoff
+		}
+	}
+	if c := m.Config.Consul.TLS; c != nil {
+		if leaser.TLSConfig, err = c.TLSConfig(); err != nil {
+			return err
+		}
+	}
+	if err := leaser.Open(); err != nil {
+		return fmt.Errorf("cannot connect to consul: %w", err)
+	}
+
+	m.Leaser = leaser
+
+	// Periodically re-resolve the hostname in case the address changes.
+	if advertiseIP != nil && m.Config.Consul.AdvertiseResolveInterval > 0 {
+		go m.monitorAdvertiseIP(m.ctx, leaser, advertiseHost, advertiseIP)
+	}
+
+	return nil
+}
+
+// resolveAdvertiseIP resolves hostname to an IP address. Addresses from the
+// preferred family are used first. Loopback addresses are rejected unless
+// explicitly allowed as other nodes cannot reach them.
+func (m *Main) resolveAdvertiseIP(ctx context.Context, hostname string) (net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	var fallback net.IP
+	for _, addr := range addrs {
+		ip := addr.IP
+		if ip.IsUnspecified() || ip.IsMulticast() || ip.IsLinkLocalUnicast() {
+			continue
+		} else if ip.IsLoopback() && !m.Config.Consul.AdvertiseAllowLoopback {
+			continue
+		}
+
+		isIPv4 := ip.To4() != nil
+		if isIPv4 == (m.Config.Consul.AdvertiseResolve == "ipv4") {
+			return ip, nil
+		} else if fallback == nil {
+			fallback = ip
+		}
+	}
+
+	if fallback == nil {
+		return nil, fmt.Errorf("no routable address found for host %q", hostname)
+	}
+	return fallback, nil
+}
+
+// monitorAdvertiseIP periodically re-resolves hostname and updates the
+// advertise URL on the leaser if the address has changed.
+func (m *Main) monitorAdvertiseIP(ctx context.Context, leaser *consul.Leaser, hostname string, ip net.IP) {
+	ticker := time.NewTicker(m.Config.Consul.AdvertiseResolveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		newIP, err := m.resolveAdvertiseIP(ctx, hostname)
+		if err != nil {
+			log.Printf("cannot re-resolve advertise address, keeping %s: %s", ip, err)
+			continue
+		} else if newIP.Equal(ip) {
+			continue
+		}
+
+		advertiseURL := advertiseIPURL(newIP, m.advertisePort())
+		log.Printf("advertise address changed from %s to %s, advertising as %s", ip, newIP, advertiseURL)
+		leaser.SetAdvertiseURL(advertiseURL)
+		ip = newIP
+	}
+}
+
+// validateSourceAddr returns an error if addr is not an IP address assigned to
+// a local interface.
+func validateSourceAddr(addr string) error {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return fmt.Errorf("http client source-addr must be an ip address: %q", addr)
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return fmt.Errorf("cannot read interface addresses: %w", err)
+	}
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("http client source-addr is not assigned to a local interface: %s", addr)
+}
+
+// advertiseIPURL returns the URL for the LiteFS API on the given IP & port.
+func advertiseIPURL(ip net.IP, port int) string {
+	return advertiseHostURL(ip.String(), port)
+}
+
+// advertiseHostURL returns the API URL for a host name or IP address & port.
+func advertiseHostURL(host string, port int) string {
+	return fmt.Sprintf("http://%s", net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+// advertisePort returns the port other nodes should connect to. This differs
+// from the bind port when behind NAT, a proxy or a container port mapping.
+func (m *Main) advertisePort() int {
+	if v := m.Config.HTTP.AdvertisePort; v > 0 {
+		return v
+	}
+	return m.HTTPServer.Port()
+}
+
+func (m *Main) initStore(ctx context.Context) error {
+	m.Store = litefs.NewStore(m.Config.DataDir, m.Config.Candidate && !m.Config.Observer)
+	m.Store.Observer = m.Config.Observer
+
+	// Default the node name to the hostname.
+	if m.Store.NodeName = m.Config.NodeName; m.Store.NodeName == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("cannot determine node name: %w", err)
+		}
+		m.Store.NodeName = hostname
+	}
+	m.Store.CandidatePriority = m.Config.CandidatePriority
+	m.Store.SetDebug(m.Config.Debug)
+	m.Store.StrictVerify = m.Config.StrictVerify
+	m.Store.StrictJournalMode = m.Config.StrictJournalMode
+	m.Store.OnLeaseLoss = m.Config.OnLeaseLoss
+	m.Store.ExpectedClusterID = m.Config.ClusterID
+	m.Store.OnClusterMismatch = m.Config.OnClusterMismatch
+	m.Store.AdoptPrimaryConfig = m.Config.ConfigSource.Source == ConfigSourcePrimary
+	m.Store.TmpDir = m.Config.Data.TmpDir
+	if v := m.Config.Data.MinFreeBytes; v > 0 {
+		m.Store.MinFreeSpace = v
+		m.Store.EnforceMinFreeSpace = true
+	}
+	m.Store.SetRetentionDuration(m.Config.Retention.Duration)
+	m.Store.RetentionMonitorInterval = m.Config.Retention.MonitorInterval
+	m.Store.RetentionMaxCount = m.Config.Retention.MaxCount
+	m.Store.RetentionMaxBytes = m.Config.Retention.MaxBytes
+	m.Store.MaxWALSize = m.Config.SQLite.MaxWALSize
+	m.Store.WriteBusyTimeout = m.Config.Write.BusyTimeout
+	m.Store.AckMode = m.Config.Replication.AckMode
+	m.Store.QuorumMinReplicas = m.Config.Replication.Quorum.MinReplicas
+	m.Store.QuorumTimeout = m.Config.Replication.Quorum.Timeout
+	m.Store.QuorumFallback = m.Config.Replication.Quorum.OnTimeout == "async"
+	m.Store.ChecksumAlgorithm = litefs.ChecksumAlgorithm(m.Config.Data.ChecksumAlgorithm)
+	m.Store.SyncMode = litefs.SyncMode(m.Config.Data.SyncMode)
+	m.Store.CaseSensitivity = litefs.CaseSensitivity(m.Config.Data.CaseSensitivity)
+	m.Store.LocalWrite = m.Config.Replica.LocalWrite
+	m.Store.CatchupDeadline = m.Config.Replica.CatchupDeadline
+	m.Store.MaxApplyRate = m.Config.Replica.MaxApplyRate
+	m.Store.ReadConsistency = m.Config.Replica.ReadConsistency
+	m.Store.ReadTimeout = m.Config.Replica.ReadTimeout
+	m.Store.ApplyRateBypassLag = m.Config.Replica.ApplyRateBypassLag
+	m.Store.ApplyConcurrency = m.Config.Replica.ApplyConcurrency
+	m.Store.IncludeDBs = m.Config.Databases.Include
+	m.Store.EventHistorySize = m.Config.HTTP.Events.HistorySize
+	m.Store.NonDBFiles = litefs.NonDBFileMode(m.Config.FUSE.NonDBFiles)
+	m.Store.ExcludeDBs = m.Config.Databases.Exclude
+
+	client := http.NewClient()
+	client.ChecksumAlgorithm = m.Store.ChecksumAlgorithm
+	client.VerifyOnConnect = m.Config.Replication.VerifyOnConnect
+	if addr := m.Config.HTTP.Client.SourceAddr; addr != "" {
+		client.Dialer.LocalAddr = &net.TCPAddr{IP: net.ParseIP(addr)}
+	}
+	m.Store.Client = client
+	return nil
+}
+
+// Store open retry settings. The delay doubles after each failed attempt.
+const (
+	StoreOpenAttempts     = 5
+	StoreOpenInitialDelay = 500 * time.Millisecond
+)
+
+func (m *Main) openStore(ctx context.Context) error {
+	m.Store.Leaser = m.Leaser
+
+	// Retry transient failures such as a data volume that is attached slightly
+	// after the process starts. Retries stop if the context is canceled.
+	delay := StoreOpenInitialDelay
+	for attempt := 1; ; attempt++ {
+		err := m.Store.Open()
+		if err == nil {
+			break
+		} else if attempt >= StoreOpenAttempts {
+			return err
+		}
+		log.Printf("cannot open store, retrying in %s (attempt %d/%d): %s", delay, attempt, StoreOpenAttempts, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+
+	// Register expvar variable once so it doesn't panic during tests.
+	expvarOnce.Do(func() { expvar.Publish("store", (*litefs.StoreVar)(m.Store)) })
+
+	return nil
+}
+
+func (m *Main) initFileSystem(ctx context.Context) error {
+	// Observers only archive the replication stream so nothing is mounted.
+	if m.Config.Observer {
+		return nil
+	}
+
+	switch m.Config.FileSystem.Backend {
+	case FileSystemBackendFUSE:
+		return m.initFUSEFileSystem(ctx)
+	case FileSystemBackendNone:
+		return nil
+	default:
+		return fmt.Errorf("invalid file system backend: %q", m.Config.FileSystem.Backend)
+	}
+}
+
+func (m *Main) initFUSEFileSystem(ctx context.Context) error {
+	// Build the file system to interact with the store.
+	fsys := fuse.NewFileSystem(m.Config.MountDir, m.Store)
+	fsys.Subdir = m.Config.FUSE.Subdir
+	fsys.SlowOpThreshold = m.Config.FUSE.SlowOpThreshold
+	fsys.HideWAL = !m.Config.FUSE.ExposeWAL
+	fsys.FileMode, _ = parseFileMode(m.Config.FUSE.FileMode)
+	fsys.DirMode, _ = parseFileMode(m.Config.FUSE.DirMode)
+	if err := fsys.Mount(); err != nil {
+		return fmt.Errorf("cannot open file system: %s", err)
+	}
+
+	// Attach file system to store so it can invalidate the page cache.
+	m.Store.Invalidator = fsys
+
+	// Report open handle & inode stats via expvar & the HTTP server.
+	m.HTTPServer.MountVar = (*fuse.FileSystemVar)(fsys)
+	fuseExpvarOnce.Do(func() { expvar.Publish("fuse", (*fuse.FileSystemVar)(fsys)) })
+
+	m.FileSystem = fsys
+	return nil
+}
+
+func (m *Main) initHTTPServer(ctx context.Context) error {
+	server := http.NewServer(m.Store, m.Config.HTTP.Addr)
+	server.MaxReplicas = m.Config.HTTP.Replication.MaxReplicas
+	server.MaxAcceptRate = m.Config.HTTP.Replication.MaxAcceptRate
+	server.ReconnectWindow = m.Config.HTTP.Replication.ReconnectWindow
+	server.MaxConcurrentSnapshots = m.Config.HTTP.Replication.MaxConcurrentSnapshots
+	server.VerifyOnConnect = m.Config.Replication.VerifyOnConnect
+	server.Pprof = m.Config.HTTP.Pprof
+	server.Dashboard = m.Config.HTTP.Dashboard
+	server.ReadOnlyAPI = m.Config.HTTP.ReadOnlyAPI
+	server.DebugState = m.Config.HTTP.DebugState
+	server.DebugStateToken = m.Config.HTTP.DebugStateToken
+	server.EventBufferSize = m.Config.HTTP.Events.BufferSize
+	server.PrimaryHeaders = m.Config.HTTP.PrimaryHeaders
+	if m.Config.HTTP.Health.DB != "" {
+		server.HealthDB = m.Config.HTTP.Health.DB
+		server.HealthTimeout = m.Config.HTTP.Health.Timeout
+		server.HealthWriter = m.writeHealthMarker
+	}
+	if server.DebugState {
+		buf, err := yaml.Marshal(RedactConfig(m.Config))
+		if err != nil {
+			return fmt.Errorf("cannot marshal debug config: %w", err)
+		}
+		server.DebugConfig = string(buf)
+	}
+	if err := server.Listen(); err != nil {
+		return fmt.Errorf("cannot open http server: %w", err)
+	}
+	m.HTTPServer = server
+	return nil
+}
+
+func (m *Main) execCmd(ctx context.Context) error {
+	// Supervise commands which only run while primary.
+	for _, c := range m.Config.Exec {
+		if !c.PrimaryOnly {
+			continue
+		}
+
+		args, err := shellwords.Parse(c.Cmd)
+		if err != nil {
+			return fmt.Errorf("cannot parse exec command: %w", err)
+		}
+
+		e := NewPrimaryExec(m.Store, args, c.Debounce, c.ShutdownTimeout)
+		m.primaryExecWG.Add(1)
+		go func() { defer m.primaryExecWG.Done(); e.Run(m.ctx) }()
+	}
+
+	// Exit if no always-on subcommand specified.
+	c := m.Config.Exec.alwaysOn()
+	if c == nil {
+		return nil
+	}
+
+	// Execute subcommand process.
+	args, err := shellwords.Parse(c.Cmd)
+	if err != nil {
+		return fmt.Errorf("cannot parse exec command: %w", err)
+	}
+
+	log.Printf("starting subprocess: %s %v", args[0], args[1:])
+
+	m.cmd = exec.CommandContext(ctx, args[0], args[1:]...)
+	m.cmd.Env = os.Environ()
+	m.cmd.Stdout = os.Stdout
+	m.cmd.Stderr = os.Stderr
+	if err := m.cmd.Start(); err != nil {
+		return fmt.Errorf("cannot start exec command: %w", err)
+	}
+	m.cmdDone = make(chan struct{})
+	go func() {
+		err := m.cmd.Wait()
+		close(m.cmdDone)
+		m.execCh <- err
+	}()
+
+	return nil
+}
+
+// monitorMount periodically checks the mount point and remounts the file
+// system if the connection to the kernel has been lost. Gives up after the
+// configured number of attempts.
+func (m *Main) monitorMount(ctx context.Context) {
+	ticker := time.NewTicker(m.Config.FUSE.CheckInterval)
+	defer ticker.Stop()
+
+	for attempts := 0; ; {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := os.Stat(m.FileSystem.Path()); !errors.Is(err, syscall.ENOTCONN) {
+			continue
+		}
+
+		if attempts >= m.Config.FUSE.MaxRemountAttempts {
+			log.Printf("fuse transport disconnected, max remount attempts (%d) reached", attempts)
+			return
+		}
+		attempts++
+
+		log.Printf("fuse transport disconnected, remounting: attempt=%d", attempts)
+		fsys := m.FileSystem.(*fuse.FileSystem)
+		if err := fsys.Remount(); err != nil {
+			log.Printf("cannot remount file system: %s", err)
+			continue
+		}
+
+		// Reattach file system so store continues to invalidate the page cache.
+		m.Store.Invalidator = fsys
+		log.Printf("LiteFS remounted to: %s", m.FileSystem.Path())
+	}
+}
+
+// monitorConsulConfig watches the config prefix in Consul KV and applies
+// settings that are safe to change at runtime. Settings that are absent from
+// Consul fall back to the values from the local config.
+func (m *Main) monitorConsulConfig(ctx context.Context, leaser *consul.Leaser) {
+	var index uint64
+	for {
+		values, newIndex, err := leaser.ConfigValues(ctx, m.Config.Consul.ConfigPrefix, index)
+		if ctx.Err() != nil {
+			return
+		} else if err != nil {
+			log.Printf("cannot read consul config, retrying: %s", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(DefaultConsulConfigRetryInterval):
+			}
+			continue
+		}
+
+		// Avoid reapplying if the blocking query timed out without changes.
+		if index != 0 && newIndex == index {
+			continue
+		}
+		index = newIndex
+
+		m.applyConsulConfig(values)
+	}
+}
+
+// applyConsulConfig updates the store from runtime settings read from Consul.
+func (m *Main) applyConsulConfig(values map[string]string) {
+	retention := m.Config.Retention.Duration
+	if v, ok := values["retention"]; ok {
+		if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+			log.Printf("invalid consul config retention %q, using local config", v)
+		} else {
+			retention = d
+		}
+	}
+
+	debug := m.Config.Debug
+	if v, ok := values["log-level"]; ok {
+		switch v {
+		case "debug":
+			debug = true
+		case "info":
+			debug = false
+		default:
+			log.Printf("invalid consul config log-level %q, using local config", v)
+		}
+	}
+
+	if m.Store.RetentionDuration() != retention || m.Store.Debug() != debug {
+		log.Printf("applying consul config: retention=%s debug=%v", retention, debug)
+	}
+	m.Store.SetRetentionDuration(retention)
+	m.Store.SetDebug(debug)
+}
+
+// monitorPostApplyHook runs the post-apply hook after transactions are applied
+// on a replica. Changes are coalesced so the hook runs at most once per
+// interval for each changed database.
+func (m *Main) monitorPostApplyHook(ctx context.Context, sub *litefs.Subscriber) {
+	defer func() { _ = sub.Close() }()
+
+	breaker := newIntegrationBreaker(IntegrationPostApplyHook, m.Config.Hooks.CircuitBreaker)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sub.NotifyCh():
+		}
+
+		// Wait for the interval so that small commits are batched together.
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(m.Config.Hooks.PostApplyInterval):
+		}
+
+		// Only replicas apply transactions.
+		dirtySet := sub.DirtySet()
+		if m.Store.IsPrimary() {
+			continue
+		}
+
+		for name := range dirtySet {
+			db := m.Store.DB(name)
+			if db == nil || name == m.Config.HTTP.Health.DB {
+				continue // health check markers are not user transactions
+			}
+
+			if !breaker.allow() {
+				continue // hook failing repeatedly, skip until cooldown elapses
+			}
+
+			if err := m.runPostApplyHook(ctx, db); err != nil {
+				breaker.failure()
+				log.Printf("post-apply hook failed: db=%s err=%s", name, err)
+				continue
+			}
+			breaker.success()
+		}
+	}
+}
+
+// runPostApplyHook executes the post-apply hook command for a database. The
+// database name & TXID are passed via the LITEFS_DB & LITEFS_TXID env vars.
+func (m *Main) runPostApplyHook(ctx context.Context, db *litefs.DB) error {
+	args, err := shellwords.Parse(m.Config.Hooks.PostApply)
+	if err != nil {
+		return fmt.Errorf("cannot parse post-apply command: %w", err)
+	} else if len(args) == 0 {
+		return nil
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(),
+		"LITEFS_DB="+db.Name(),
+		"LITEFS_TXID="+ltx.FormatTXID(db.TXID()),
+	)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+var expvarOnce sync.Once
+var fuseExpvarOnce sync.Once
+
+// NOTE: Update etc/litefs.yml configuration file after changing the structure below.
 
 // Config represents a configuration for the binary process.
 type Config struct {
-	MountDir     string `yaml:"mount-dir"`
-	DataDir      string `yaml:"data-dir"`
-	Exec         string `yaml:"exec"`
-	Candidate    bool   `yaml:"candidate"`
-	Debug        bool   `yaml:"debug"`
-	ExitOnError  bool   `yaml:"exit-on-error"`
-	StrictVerify bool   `yaml:"-"`
-
-	Retention RetentionConfig `yaml:"retention"`
-	HTTP      HTTPConfig      `yaml:"http"`
-	Consul    *ConsulConfig   `yaml:"consul"`
-	Static    *StaticConfig   `yaml:"static"`
+	MountDir          string          `yaml:"mount-dir"`
+	DataDir           string          `yaml:"data-dir"`
+	Exec              ExecConfigSlice `yaml:"exec"`
+	NodeName          string          `yaml:"node-name"`
+	Candidate         bool            `yaml:"candidate"`
+	Observer          bool            `yaml:"observer"`
+	CandidatePriority int             `yaml:"candidate-priority"`
+	Debug             bool            `yaml:"debug"`
+	Log               LogConfig       `yaml:"log"`
+	ExitOnError       bool            `yaml:"exit-on-error"`
+	ConnectTimeout    time.Duration   `yaml:"connect-timeout"`
+	CheckPermissions  bool            `yaml:"check-permissions"`
+	StrictVerify      bool            `yaml:"-"`
+
+	StrictJournalMode bool                 `yaml:"strict-journal-mode"`
+	OnLeaseLoss       litefs.LeaseLossMode `yaml:"on-lease-loss"`
+	StartupReport     string               `yaml:"startup-report"`
+
+	ClusterID         string                     `yaml:"cluster-id"`
+	OnClusterMismatch litefs.ClusterMismatchMode `yaml:"on-cluster-mismatch"`
+
+	Data         DataConfig         `yaml:"data"`
+	Databases    DatabasesConfig    `yaml:"databases"`
+	FileSystem   FileSystemConfig   `yaml:"filesystem"`
+	ConfigSource ConfigSourceConfig `yaml:"config"`
+	Retention    RetentionConfig    `yaml:"retention"`
+	SQLite       SQLiteConfig       `yaml:"sqlite"`
+	Write        WriteConfig        `yaml:"write"`
+	Replication  ReplicationConfig  `yaml:"replication"`
+	Replica      ReplicaConfig      `yaml:"replica"`
+	Hooks        HooksConfig        `yaml:"hooks"`
+	Sink         SinkConfig         `yaml:"sink"`
+	Backup       BackupConfig       `yaml:"backup"`
+	Snapshots    SnapshotsConfig    `yaml:"snapshots"`
+	Metrics      MetricsConfig      `yaml:"metrics"`
+	Maintenance  MaintenanceConfig  `yaml:"maintenance"`
+	FUSE         FUSEConfig         `yaml:"fuse"`
+	HTTP         HTTPConfig         `yaml:"http"`
+	Consul       *ConsulConfig      `yaml:"consul"`
+	Static       *StaticConfig      `yaml:"static"`
+
+	// Values resolved from secret references while reading the config.
+	// Any occurrence is redacted when the config is reported.
+	secrets []string
 }
 
 // NewConfig returns a new instance of Config with defaults set.
 func NewConfig() Config {
 	var config Config
 	config.Candidate = true
+	config.CandidatePriority = litefs.DefaultCandidatePriority
 	config.ExitOnError = true
+	config.ConfigSource.Source = ConfigSourceLocal
+	config.FileSystem.Backend = FileSystemBackendFUSE
+	config.Data.ChecksumAlgorithm = string(litefs.ChecksumAlgorithmCRC64)
+	config.Data.SyncMode = string(litefs.SyncModeFull)
+	config.Data.CaseSensitivity = string(litefs.CaseSensitivityStrict)
 	config.Retention.Duration = litefs.DefaultRetentionDuration
 	config.Retention.MonitorInterval = litefs.DefaultRetentionMonitorInterval
+	config.Replication.AckMode = litefs.AckModeAsync
+	config.Replication.Quorum.MinReplicas = litefs.DefaultQuorumMinReplicas
+	config.Replication.Quorum.Timeout = litefs.DefaultQuorumTimeout
+	config.Replication.Quorum.OnTimeout = "fail"
+	config.Replica.LocalWrite = litefs.LocalWriteAllow
+	config.Replica.ReadConsistency = litefs.ReadConsistencyLocal
+	config.Replica.ReadTimeout = litefs.DefaultReadTimeout
+	config.Replica.ApplyConcurrency = 1
+	config.OnLeaseLoss = litefs.LeaseLossAbort
+	config.StartupReport = StartupReportText
+	config.OnClusterMismatch = litefs.ClusterMismatchFail
+	config.Hooks.PostApplyInterval = DefaultPostApplyInterval
+	config.Hooks.CircuitBreaker.Threshold = DefaultCircuitBreakerThreshold
+	config.Hooks.CircuitBreaker.Cooldown = DefaultCircuitBreakerCooldown
+	config.Sink.CircuitBreaker.Threshold = DefaultCircuitBreakerThreshold
+	config.Sink.CircuitBreaker.Cooldown = DefaultCircuitBreakerCooldown
+	config.Sink.BufferSize = DefaultSinkBufferSize
+	config.Sink.RetryInterval = DefaultSinkRetryInterval
+	config.Sink.MaxRetries = DefaultSinkMaxRetries
+	config.Sink.OnFailure = SinkOnFailureDrop
+	config.Backup.Interval = DefaultBackupInterval
+	config.Backup.SnapshotInterval = DefaultBackupSnapshotInterval
+	config.Snapshots.Interval = DefaultSnapshotInterval
+	config.Snapshots.Retain = DefaultSnapshotRetain
+	config.Snapshots.RunOn = SnapshotRunOnReplica
+	config.Metrics.DumpInterval = DefaultMetricsDumpInterval
+	config.Maintenance.Vacuum.MinFreePages = DefaultVacuumMinFreePages
+	config.FUSE.MaxRemountAttempts = DefaultMaxRemountAttempts
+	config.FUSE.CheckInterval = DefaultMountCheckInterval
+	config.FUSE.ExposeWAL = true
+	config.FUSE.NonDBFiles = string(litefs.NonDBFilesLocal)
+	config.FUSE.FileMode = fmt.Sprintf("%04o", fuse.DefaultFileMode)
+	config.FUSE.DirMode = fmt.Sprintf("%04o", fuse.DefaultDirMode)
+	config.Log.DedupWindow = DefaultLogDedupWindow
 	config.HTTP.Addr = http.DefaultAddr
+	config.HTTP.Replication.ReconnectWindow = http.DefaultReconnectWindow
+	config.HTTP.Events.BufferSize = litefs.DefaultEventBufferSize
+	config.HTTP.Events.HistorySize = litefs.DefaultEventHistorySize
+	config.HTTP.Health.Timeout = http.DefaultHealthTimeout
+	config.HTTP.PrimaryHeaders = true
 	return config
 }
 
+// MaintenanceConfig represents the configuration for background maintenance.
+type MaintenanceConfig struct {
+	Vacuum VacuumConfig `yaml:"vacuum"`
+}
+
+// VacuumConfig represents the configuration for scheduled vacuums.
+type VacuumConfig struct {
+	Interval     time.Duration `yaml:"interval"`
+	MinFreePages int64         `yaml:"min-free-pages"`
+	Incremental  bool          `yaml:"incremental"`
+	Databases    []string      `yaml:"databases"`
+}
+
+// File system backends.
+const (
+	FileSystemBackendFUSE = "fuse"
+	FileSystemBackendNone = "none"
+)
+
+// FileSystemConfig represents the backend used to present databases.
+type FileSystemConfig struct {
+	Backend string `yaml:"backend"`
+}
+
+// Config sources.
+const (
+	ConfigSourceLocal   = "local"
+	ConfigSourcePrimary = "primary"
+)
+
+// ConfigSourceConfig represents where replicas read cluster-wide settings from.
+// Only the retention duration is distributed by the primary.
+type ConfigSourceConfig struct {
+	Source string `yaml:"source"`
+}
+
+// DataConfig represents the configuration for the data directory.
+type DataConfig struct {
+	MinFreeBytes      int64  `yaml:"min-free-bytes"`
+	TmpDir            string `yaml:"tmp-dir"`
+	ChecksumAlgorithm string `yaml:"checksum-algorithm"`
+	SyncMode          string `yaml:"sync-mode"`
+	CaseSensitivity   string `yaml:"case-sensitivity"`
+}
+
+// DatabasesConfig represents the patterns selecting which databases in the
+// data directory are managed by LiteFS.
+type DatabasesConfig struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
 // RetentionConfig represents the configuration for LTX file retention.
 type RetentionConfig struct {
 	Duration        time.Duration `yaml:"duration"`
 	MonitorInterval time.Duration `yaml:"monitor-interval"`
+	MaxCount        int           `yaml:"max-count"`
+	MaxBytes        int64         `yaml:"max-bytes"`
+}
+
+// SQLiteConfig represents the configuration for how LiteFS manages SQLite files.
+type SQLiteConfig struct {
+	MaxWALSize int64 `yaml:"max-wal-size"`
+}
+
+// WriteConfig represents the configuration for writes to local databases.
+type WriteConfig struct {
+	BusyTimeout time.Duration `yaml:"busy-timeout"`
+}
+
+// ReplicationConfig represents the configuration for replica acknowledgement.
+type ReplicationConfig struct {
+	AckMode litefs.AckMode `yaml:"ack-mode"`
+	Quorum  QuorumConfig   `yaml:"quorum"`
+
+	VerifyOnConnect bool `yaml:"verify-on-connect"`
+}
+
+// ReplicaConfig represents the configuration for writes made on a replica.
+type ReplicaConfig struct {
+	LocalWrite      litefs.LocalWriteMode `yaml:"local-write"`
+	CatchupDeadline time.Duration         `yaml:"catchup-deadline"`
+
+	MaxApplyRate       int           `yaml:"max-apply-rate"`
+	ApplyRateBypassLag time.Duration `yaml:"apply-rate-bypass-lag"`
+	ApplyConcurrency   int           `yaml:"apply-concurrency"`
+
+	ReadConsistency litefs.ReadConsistency `yaml:"read-consistency"`
+	ReadTimeout     time.Duration          `yaml:"read-timeout"`
+}
+
+// QuorumConfig represents the configuration for the "quorum" ack mode.
+type QuorumConfig struct {
+	MinReplicas int           `yaml:"min-replicas"`
+	Timeout     time.Duration `yaml:"timeout"`
+	OnTimeout   string        `yaml:"on-timeout"`
+}
+
+// DefaultPostApplyInterval is the minimum time between post-apply hook runs.
+const DefaultPostApplyInterval = 1 * time.Second
+
+// HooksConfig represents the configuration for external hook commands.
+type HooksConfig struct {
+	PostApply         string        `yaml:"post-apply"`
+	PostApplyInterval time.Duration `yaml:"post-apply-interval"`
+
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit-breaker"`
+}
+
+// Default FUSE mount watchdog settings.
+const (
+	DefaultMaxRemountAttempts = 3
+	DefaultMountCheckInterval = 5 * time.Second
+)
+
+// FUSEConfig represents the configuration for the FUSE file system.
+type FUSEConfig struct {
+	Subdir             string        `yaml:"subdir"`
+	AutoRemount        bool          `yaml:"auto-remount"`
+	MaxRemountAttempts int           `yaml:"max-remount-attempts"`
+	CheckInterval      time.Duration `yaml:"check-interval"`
+	SlowOpThreshold    time.Duration `yaml:"slow-op-threshold"`
+	ExposeWAL          bool          `yaml:"expose-wal"`
+	ReadyFile          string        `yaml:"ready-file"`
+	NonDBFiles         string        `yaml:"non-db-files"`
+	FileMode           string        `yaml:"file-mode"`
+	DirMode            string        `yaml:"dir-mode"`
+}
+
+// parseFileMode parses an octal string of permission bits, such as "0640".
+func parseFileMode(s string) (os.FileMode, error) {
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, err
+	} else if v > 0777 {
+		return 0, fmt.Errorf("mode out of range")
+	}
+	return os.FileMode(v), nil
+}
+
+// LogConfig represents the configuration for log output.
+type LogConfig struct {
+	DedupWindow time.Duration `yaml:"dedup-window"`
 }
 
 // HTTPConfig represents the configuration for the HTTP server.
 type HTTPConfig struct {
-	Addr string `yaml:"addr"`
+	Addr           string                `yaml:"addr"`
+	Pprof          bool                  `yaml:"pprof"`
+	Dashboard      bool                  `yaml:"dashboard"`
+	ReadOnlyAPI    bool                  `yaml:"read-only-api"`
+	PrimaryHeaders bool                  `yaml:"primary-headers"`
+	Replication    HTTPReplicationConfig `yaml:"replication"`
+	Client         HTTPClientConfig      `yaml:"client"`
+	Events         HTTPEventsConfig      `yaml:"events"`
+	Health         HTTPHealthConfig      `yaml:"health"`
+
+	DebugState      bool   `yaml:"debug-state"`
+	DebugStateToken string `yaml:"debug-state-token"`
+
+	// Address & port advertised to other nodes, if different from the bind
+	// address. Used to derive the advertise URL when it is not set.
+	AdvertiseAddr string `yaml:"advertise-addr"`
+	AdvertisePort int    `yaml:"advertise-port"`
+}
+
+// redactedValue replaces credentials in the config reported by "/debug/state".
+const redactedValue = "REDACTED"
+
+// RedactConfig returns a copy of config with tokens & URL passwords replaced.
+// Any value resolved from a secret reference is also replaced wherever it
+// appears, such as within an exec or sink command.
+func RedactConfig(config Config) Config {
+	if len(config.secrets) > 0 {
+		config = redactSecrets(reflect.ValueOf(config), config.secrets).Interface().(Config)
+	}
+
+	if config.HTTP.DebugStateToken != "" {
+		config.HTTP.DebugStateToken = redactedValue
+	}
+	config.Sink.URL = redactURL(config.Sink.URL)
+
+	if config.Consul != nil {
+		other := *config.Consul
+		other.URL = redactURL(other.URL)
+		config.Consul = &other
+	}
+	return config
+}
+
+// redactSecrets returns a deep copy of v with every occurrence of a secret
+// within a string replaced by redactedValue.
+func redactSecrets(v reflect.Value, secrets []string) reflect.Value {
+	switch v.Kind() {
+	case reflect.String:
+		s := v.String()
+		for _, secret := range secrets {
+			s = strings.ReplaceAll(s, secret, redactedValue)
+		}
+		other := reflect.New(v.Type()).Elem()
+		other.SetString(s)
+		return other
+
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		other := reflect.New(v.Type().Elem())
+		other.Elem().Set(redactSecrets(v.Elem(), secrets))
+		return other
+
+	case reflect.Struct:
+		other := reflect.New(v.Type()).Elem()
+		other.Set(v) // copies unexported fields as-is
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				other.Field(i).Set(redactSecrets(v.Field(i), secrets))
+			}
+		}
+		return other
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		other := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			other.Index(i).Set(redactSecrets(v.Index(i), secrets))
+		}
+		return other
+
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		other := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			other.SetMapIndex(iter.Key(), redactSecrets(iter.Value(), secrets))
+		}
+		return other
+
+	default:
+		return v
+	}
+}
+
+// redactURL returns rawurl with any password replaced. Unparseable URLs are
+// redacted entirely as they may still contain credentials.
+func redactURL(rawurl string) string {
+	if rawurl == "" {
+		return ""
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return redactedValue
+	}
+	return u.Redacted()
+}
+
+// ExecConfig represents a subprocess run by LiteFS.
+type ExecConfig struct {
+	Cmd string `yaml:"cmd"`
+
+	// If true, the command only runs while this node is primary. It is
+	// started on promotion & stopped on demotion once the role has been
+	// stable for the debounce period.
+	PrimaryOnly bool          `yaml:"primary-only"`
+	Debounce    time.Duration `yaml:"debounce"`
+
+	// Time to wait for the command to exit after it is signaled to stop
+	// before it is killed. Waits indefinitely if zero.
+	ShutdownTimeout time.Duration `yaml:"shutdown-timeout"`
+}
+
+// NewExecConfig returns a new instance of ExecConfig with default settings.
+func NewExecConfig(cmd string) *ExecConfig {
+	return &ExecConfig{
+		Cmd:             cmd,
+		Debounce:        DefaultExecDebounce,
+		ShutdownTimeout: DefaultExecShutdownTimeout,
+	}
+}
+
+// UnmarshalYAML decodes either a command string or a mapping of options.
+func (c *ExecConfig) UnmarshalYAML(value *yaml.Node) error {
+	*c = *NewExecConfig("")
+	if value.Kind == yaml.ScalarNode {
+		c.Cmd = value.Value
+		return nil
+	}
+
+	type execConfig ExecConfig // avoid recursion
+	return value.Decode((*execConfig)(c))
+}
+
+// ExecConfigSlice represents the list of subprocesses run by LiteFS. For
+// compatibility, a single command string or mapping is also accepted.
+type ExecConfigSlice []*ExecConfig
+
+// UnmarshalYAML decodes a command string, a mapping or a list of either.
+func (a *ExecConfigSlice) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		if value.Value == "" {
+			*a = nil
+			return nil
+		}
+		*a = ExecConfigSlice{NewExecConfig(value.Value)}
+		return nil
+	case yaml.MappingNode:
+		var c ExecConfig
+		if err := value.Decode(&c); err != nil {
+			return err
+		}
+		*a = ExecConfigSlice{&c}
+		return nil
+	default:
+		var other []*ExecConfig
+		if err := value.Decode(&other); err != nil {
+			return err
+		}
+		*a = other
+		return nil
+	}
+}
+
+// alwaysOn returns the command that runs regardless of the node's role, if any.
+func (a ExecConfigSlice) alwaysOn() *ExecConfig {
+	for _, c := range a {
+		if !c.PrimaryOnly {
+			return c
+		}
+	}
+	return nil
 }
 
+// HTTPClientConfig represents the configuration for connections to the primary.
+type HTTPClientConfig struct {
+	SourceAddr string `yaml:"source-addr"`
+}
+
+// HTTPEventsConfig represents the configuration for the "/events" stream.
+type HTTPEventsConfig struct {
+	BufferSize  int `yaml:"buffer-size"`
+	HistorySize int `yaml:"history-size"`
+}
+
+// HTTPHealthConfig represents the configuration for the "/healthz/deep" check.
+type HTTPHealthConfig struct {
+	DB      string        `yaml:"db"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// HTTPReplicationConfig represents the configuration for replica streams.
+type HTTPReplicationConfig struct {
+	MaxReplicas            int           `yaml:"max-replicas"`
+	MaxAcceptRate          int           `yaml:"max-accept-rate"`
+	ReconnectWindow        time.Duration `yaml:"reconnect-window"`
+	MaxConcurrentSnapshots int           `yaml:"max-concurrent-snapshots"`
+}
+
+// DefaultLogDedupWindow is the period after a log message during which
+// identical messages are counted instead of written.
+const DefaultLogDedupWindow = 30 * time.Second
+
+// DefaultConsulConfigRetryInterval is the time to wait after failing to read
+// runtime settings from Consul.
+const DefaultConsulConfigRetryInterval = 5 * time.Second
+
 // ConsulConfig represents the configuration for a Consul leaser.
 type ConsulConfig struct {
 	URL          string        `yaml:"url"`
 	Hostname     string        `yaml:"hostname"`
 	AdvertiseURL string        `yaml:"advertise-url"`
 	Key          string        `yaml:"key"`
+	KeyPrefix    string        `yaml:"key-prefix"`
 	TTL          time.Duration `yaml:"ttl"`
 	LockDelay    time.Duration `yaml:"lock-delay"`
+	ConfigPrefix string        `yaml:"config-prefix"`
+
+	// If true, the session is destroyed on shutdown for fast failover.
+	// Otherwise, it expires via TTL which is better for rolling restarts.
+	// Defaults to true if unset.
+	ReleaseOnShutdown *bool `yaml:"release-on-shutdown"`
+
+	// If true, an incompatible value in the lease key is overwritten.
+	ForceTakeover bool `yaml:"force-takeover"`
+
+	// If set to "ipv4" or "ipv6", the hostname is resolved to an IP address
+	// of the preferred family which is advertised instead of the hostname.
+	AdvertiseResolve         string        `yaml:"advertise-resolve"`
+	AdvertiseResolveInterval time.Duration `yaml:"advertise-resolve-interval"`
+	AdvertiseAllowLoopback   bool          `yaml:"advertise-allow-loopback"`
+
+	// If set, the node backs off before acquiring the lease when it has
+	// acquired it repeatedly within a short window.
+	ChurnDampening *ConsulChurnDampeningConfig `yaml:"churn-dampening"`
+
+	// TLS settings for an "https" URL.
+	TLS *ConsulTLSConfig `yaml:"tls"`
+}
+
+// ConsulTLSConfig represents the TLS configuration for the Consul connection.
+type ConsulTLSConfig struct {
+	CAFile     string `yaml:"ca-file"`
+	CertFile   string `yaml:"cert-file"`
+	KeyFile    string `yaml:"key-file"`
+	ServerName string `yaml:"server-name"`
+}
+
+// TLSConfig loads the CA bundle & client certificate, if set, so that bad
+// files are reported at startup instead of as a failed handshake.
+func (c *ConsulTLSConfig) TLSConfig() (*tls.Config, error) {
+	config := &tls.Config{ServerName: c.ServerName}
+
+	if c.CAFile != "" {
+		buf, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read consul tls ca-file: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(buf) {
+			return nil, fmt.Errorf("consul tls ca-file contains no PEM certificates: %s", c.CAFile)
+		}
+	}
+
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load consul tls client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// ConsulChurnDampeningConfig represents the configuration for election churn
+// dampening. Unset fields use the consul package defaults.
+type ConsulChurnDampeningConfig struct {
+	Window     time.Duration `yaml:"window"`
+	Threshold  int           `yaml:"threshold"`
+	MaxBackoff time.Duration `yaml:"max-backoff"`
 }
 
 // StaticConfig represents the configuration for a static leaser.
@@ -465,57 +2199,367 @@ type StaticConfig struct {
 	Primary      bool   `yaml:"primary"`
 	Hostname     string `yaml:"hostname"`
 	AdvertiseURL string `yaml:"advertise-url"`
+
+	// API URLs of other nodes checked by the primary for conflicting primaries.
+	Peers                 []string      `yaml:"peers"`
+	OnConflict            string        `yaml:"on-conflict"`
+	ConflictCheckInterval time.Duration `yaml:"conflict-check-interval"`
+}
+
+// stringSliceFlag is a flag that can be specified multiple times.
+type stringSliceFlag []string
+
+func (f *stringSliceFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringSliceFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
 }
 
+// NoExpandEnvSentinel disables environment variable expansion when it is used
+// as the first line of the config file.
+const NoExpandEnvSentinel = "# litefs:no-expand-env"
+
 // ReadConfigFile unmarshals config from filename. If expandEnv is true then
 // environment variables are expanded in the config.
 func ReadConfigFile(config *Config, filename string, expandEnv bool) error {
-	// Read configuration.
-	buf, err := os.ReadFile(filename)
+	return ReadConfigFiles(config, filename, nil, expandEnv)
+}
+
+// ReadConfigFiles unmarshals config from filename with each overlay file
+// deep-merged on top, in order. Each file is expanded & migrated separately
+// before merging. Mappings are merged while lists & scalars are replaced.
+func ReadConfigFiles(config *Config, filename string, overlays []string, expandEnv bool) error {
+	doc, secrets, err := readConfigNode(filename, expandEnv)
 	if err != nil {
 		return err
 	}
 
-	// Expand environment variables, if enabled.
+	for _, overlay := range overlays {
+		other, otherSecrets, err := readConfigNode(overlay, expandEnv)
+		if err != nil {
+			return fmt.Errorf("cannot read config overlay %s: %w", overlay, err)
+		}
+		secrets = append(secrets, otherSecrets...)
+		if err := MergeConfigNode(doc, other); err != nil {
+			return fmt.Errorf("cannot merge config overlay %s: %w", overlay, err)
+		}
+	}
+
+	if doc.Kind == 0 {
+		return nil // empty files
+	} else if err := doc.Decode(config); err != nil {
+		return err
+	}
+	config.secrets = append(config.secrets, secrets...)
+	return nil
+}
+
+// readConfigNode reads filename into a YAML document with environment
+// variables expanded, deprecated keys migrated & secrets resolved. Returns
+// a zero node if the file is empty, along with the resolved secret values.
+func readConfigNode(filename string, expandEnv bool) (*yaml.Node, []string, error) {
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Expand environment variables, if enabled. Expansion can also be disabled
+	// from within the file by starting it with a sentinel comment line.
+	if bytes.HasPrefix(buf, []byte(NoExpandEnvSentinel+"\n")) {
+		expandEnv = false
+	}
 	if expandEnv {
 		buf = []byte(ExpandEnv(string(buf)))
 	}
 
-	if err := yaml.Unmarshal(buf, &config); err != nil {
-		return err
+	var node yaml.Node
+	if err := yaml.Unmarshal(buf, &node); err != nil {
+		return nil, nil, err
+	} else if node.Kind == 0 {
+		return &node, nil, nil // empty file
+	}
+
+	// Map deprecated keys to their replacements before decoding.
+	if err := MigrateConfig(&node, ConfigDeprecations); err != nil {
+		return nil, nil, err
+	}
+
+	// Resolve secrets after parsing so their contents are never parsed as YAML.
+	var secrets []string
+	if expandEnv {
+		if err := resolveSecrets(&node, DefaultSecretProvider, &secrets); err != nil {
+			return nil, nil, err
+		}
+	}
+	return &node, secrets, nil
+}
+
+// MergeConfigNode deep-merges the YAML document src into dst. Keys in a
+// mapping are merged recursively. Any other value in src, including lists,
+// replaces the value in dst.
+func MergeConfigNode(dst, src *yaml.Node) error {
+	if src.Kind == 0 {
+		return nil // empty overlay
+	} else if dst.Kind == 0 {
+		*dst = *src
+		return nil
+	}
+
+	if dst.Kind == yaml.DocumentNode && src.Kind == yaml.DocumentNode && len(dst.Content) > 0 && len(src.Content) > 0 {
+		if src.Content[0].Kind != yaml.MappingNode {
+			return fmt.Errorf("config overlay must be a mapping (line %d)", src.Content[0].Line)
+		}
+		mergeConfigMapping(dst.Content[0], src.Content[0])
+		return nil
+	}
+	return fmt.Errorf("invalid config document")
+}
+
+func mergeConfigMapping(dst, src *yaml.Node) {
+	if dst.Kind != yaml.MappingNode || src.Kind != yaml.MappingNode {
+		*dst = *src
+		return
+	}
+
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+
+		j := configMappingIndex(dst, key.Value)
+		if j < 0 {
+			dst.Content = append(dst.Content, key, value)
+			continue
+		}
+		mergeConfigMapping(dst.Content[j+1], value)
+	}
+}
+
+// configMappingIndex returns the index of key within a mapping node's
+// content. Returns -1 if not found.
+func configMappingIndex(node *yaml.Node, key string) int {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// ConfigDeprecation represents a config key that has been renamed or removed.
+// Keys are dot-separated paths such as "retention.duration".
+type ConfigDeprecation struct {
+	Key    string // deprecated key
+	NewKey string // replacement key, blank if the key has been removed
+	Reason string // instructions shown when a removed key is used
+}
+
+// ConfigDeprecations is the list of renamed & removed config keys. Renamed
+// keys log a warning and are moved to the new key. Removed keys are an error.
+var ConfigDeprecations []ConfigDeprecation
+
+// MigrateConfig rewrites deprecated keys in a parsed YAML document to their
+// replacement keys. Returns an error if a removed key is used or if both a
+// deprecated key and its replacement are specified.
+func MigrateConfig(doc *yaml.Node, deprecations []ConfigDeprecation) error {
+	root := doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for _, d := range deprecations {
+		keyNode, valueNode := removeYAMLKey(root, strings.Split(d.Key, "."))
+		if keyNode == nil {
+			continue
+		}
+
+		if d.NewKey == "" {
+			return fmt.Errorf("config key %q (line %d) has been removed: %s", d.Key, keyNode.Line, d.Reason)
+		}
+
+		if !setYAMLKey(root, strings.Split(d.NewKey, "."), valueNode) {
+			return fmt.Errorf("cannot specify both deprecated config key %q and its replacement %q", d.Key, d.NewKey)
+		}
+		log.Printf("WARNING: config key %q (line %d) is deprecated, use %q instead", d.Key, keyNode.Line, d.NewKey)
 	}
 	return nil
 }
 
+// removeYAMLKey removes the key at path from the mapping node and returns the
+// removed key & value nodes. Returns nil nodes if the key does not exist.
+func removeYAMLKey(node *yaml.Node, path []string) (keyNode, valueNode *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		k, v := node.Content[i], node.Content[i+1]
+		if k.Value != path[0] {
+			continue
+		}
+
+		if len(path) == 1 {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return k, v
+		} else if v.Kind == yaml.MappingNode {
+			return removeYAMLKey(v, path[1:])
+		}
+		return nil, nil
+	}
+	return nil, nil
+}
+
+// setYAMLKey sets value at path in the mapping node, creating intermediate
+// mappings as needed. Returns false if the key already exists.
+func setYAMLKey(node *yaml.Node, path []string, value *yaml.Node) bool {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		k, v := node.Content[i], node.Content[i+1]
+		if k.Value != path[0] {
+			continue
+		}
+
+		if len(path) == 1 || v.Kind != yaml.MappingNode {
+			return false
+		}
+		return setYAMLKey(v, path[1:], value)
+	}
+
+	// Key does not exist so create it along with any intermediate mappings.
+	for j := len(path) - 1; j > 0; j-- {
+		value = &yaml.Node{
+			Kind:    yaml.MappingNode,
+			Tag:     "!!map",
+			Content: []*yaml.Node{{Kind: yaml.ScalarNode, Tag: "!!str", Value: path[j]}, value},
+		}
+	}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: path[0]}, value)
+	return true
+}
+
 // ExpandEnv replaces environment variables just like os.ExpandEnv() but also
 // allows for equality/inequality binary expressions within the ${} form.
+// A double dollar sign ("$$") is replaced by a literal dollar sign. Secret
+// references such as "${file:/path}" are left in place.
 func ExpandEnv(s string) string {
 	return os.Expand(s, func(v string) string {
-		v = strings.TrimSpace(v)
+		if v == "$" {
+			return "$"
+		}
 
-		if a := expandExprSingleQuote.FindStringSubmatch(v); a != nil {
-			if a[2] == "==" {
-				return strconv.FormatBool(os.Getenv(a[1]) == a[3])
-			}
-			return strconv.FormatBool(os.Getenv(a[1]) != a[3])
+		// Secret references are resolved separately by ResolveSecrets().
+		if secretRefRegex.MatchString("${" + v + "}") {
+			return "${" + v + "}"
 		}
+		return expandEnvVar(strings.TrimSpace(v))
+	})
+}
 
-		if a := expandExprDoubleQuote.FindStringSubmatch(v); a != nil {
-			if a[2] == "==" {
-				return strconv.FormatBool(os.Getenv(a[1]) == a[3])
+// ResolveSecrets replaces secret references within scalar values of node.
+// "${file:/path}" is replaced by the contents of the file, without a trailing
+// newline, and "${secret:name}" is resolved by provider. Only values are
+// resolved so references within comments are ignored. Returns an error if a
+// reference cannot be resolved so a secret is never silently left empty.
+func ResolveSecrets(node *yaml.Node, provider SecretProvider) error {
+	return resolveSecrets(node, provider, nil)
+}
+
+// resolveSecrets resolves secret references within node. If secrets is
+// non-nil then each non-empty resolved value is appended to it.
+func resolveSecrets(node *yaml.Node, provider SecretProvider, secrets *[]string) error {
+	if node.Kind == yaml.ScalarNode && strings.Contains(node.Value, "${") {
+		var err error
+		value := secretRefRegex.ReplaceAllStringFunc(node.Value, func(ref string) string {
+			a := secretRefRegex.FindStringSubmatch(ref)
+			typ, name := a[1], strings.TrimSpace(a[2])
+
+			var v string
+			var e error
+			switch typ {
+			case "file":
+				v, e = FileSecretProvider{}.Secret(name)
+			default:
+				if provider == nil {
+					e = fmt.Errorf("no secret provider configured")
+				} else {
+					v, e = provider.Secret(name)
+				}
+			}
+			if e != nil && err == nil {
+				err = fmt.Errorf("cannot resolve ${%s:%s} (line %d): %s", typ, name, node.Line, e)
+			}
+			if secrets != nil && v != "" {
+				*secrets = append(*secrets, v)
 			}
-			return strconv.FormatBool(os.Getenv(a[1]) != a[3])
+			return v
+		})
+		if err != nil {
+			return err
+		} else if value != node.Value && node.Style == 0 {
+			node.Tag = "" // re-resolve type of plain scalars from the secret
 		}
+		node.Value = value
+	}
 
-		if a := expandExprVar.FindStringSubmatch(v); a != nil {
-			if a[2] == "==" {
-				return strconv.FormatBool(os.Getenv(a[1]) == os.Getenv(a[3]))
-			}
-			return strconv.FormatBool(os.Getenv(a[1]) != os.Getenv(a[3]))
+	for _, child := range node.Content {
+		if err := resolveSecrets(child, provider, secrets); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		return os.Getenv(v)
-	})
+var secretRefRegex = regexp.MustCompile(`\$\{\s*(file|secret):([^}]*)\}`)
+
+// expandEnvVar returns the value of an environment variable or the result of
+// an equality expression, as used within "${}".
+func expandEnvVar(v string) string {
+	if a := expandExprSingleQuote.FindStringSubmatch(v); a != nil {
+		if a[2] == "==" {
+			return strconv.FormatBool(os.Getenv(a[1]) == a[3])
+		}
+		return strconv.FormatBool(os.Getenv(a[1]) != a[3])
+	}
+
+	if a := expandExprDoubleQuote.FindStringSubmatch(v); a != nil {
+		if a[2] == "==" {
+			return strconv.FormatBool(os.Getenv(a[1]) == a[3])
+		}
+		return strconv.FormatBool(os.Getenv(a[1]) != a[3])
+	}
+
+	if a := expandExprVar.FindStringSubmatch(v); a != nil {
+		if a[2] == "==" {
+			return strconv.FormatBool(os.Getenv(a[1]) == os.Getenv(a[3]))
+		}
+		return strconv.FormatBool(os.Getenv(a[1]) != os.Getenv(a[3]))
+	}
+
+	return os.Getenv(v)
+}
+
+// SecretProvider resolves secrets referenced as "${secret:name}" in the config.
+type SecretProvider interface {
+	Secret(name string) (string, error)
+}
+
+// DefaultSecretProvider resolves "${secret:name}" references when reading the
+// config file. No provider is configured by default so such references fail.
+var DefaultSecretProvider SecretProvider
+
+// FileSecretProvider resolves secrets by reading the file at the given path.
+// A single trailing newline is removed as most editors add one.
+type FileSecretProvider struct{}
+
+// Secret returns the contents of the file at path.
+func (FileSecretProvider) Secret(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("secret file path required")
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	s := strings.TrimSuffix(string(buf), "\n")
+	return strings.TrimSuffix(s, "\r"), nil
 }
 
 var (
@@ -2,21 +2,42 @@
 package main_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"database/sql"
 	_ "embed"
+	"encoding/pem"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math/big"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/superfly/litefs"
 	main "github.com/superfly/litefs/cmd/litefs"
+	litefshttp "github.com/superfly/litefs/http"
 	"github.com/superfly/litefs/internal/testingutil"
+	"github.com/superfly/litefs/litefstest"
+	"github.com/superfly/litefs/mock"
+	"github.com/superfly/ltx"
 	"golang.org/x/sync/errgroup"
 	"gopkg.in/yaml.v3"
 )
@@ -889,11 +910,921 @@ func TestMain_Validate(t *testing.T) {
 			t.Fatalf("unexpected error: %s", err)
 		}
 	})
+	t.Run("StaticReplicaWithAdvertiseAddr", func(t *testing.T) {
+		m := main.NewMain()
+		m.Config.MountDir, m.Config.DataDir = t.TempDir(), t.TempDir()
+		m.Config.Static = &main.StaticConfig{Hostname: "node1", AdvertiseURL: "http://node1:20202"}
+		m.Config.HTTP.AdvertiseAddr = "10.0.0.2"
+		if err := m.Validate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("ErrHealthUnsupported", func(t *testing.T) {
+		if main.VacuumSupported {
+			t.Skip("built with vacuum tag")
+		}
+		m := main.NewMain()
+		m.Config.MountDir, m.Config.DataDir = t.TempDir(), t.TempDir()
+		m.Config.Static = &main.StaticConfig{Primary: true}
+		m.Config.HTTP.Health.DB = "litefs-health.db"
+		if err := m.Validate(context.Background()); err == nil || err.Error() != `http health db requires litefs to be built with the "vacuum" tag` {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+	t.Run("ErrVacuumUnsupported", func(t *testing.T) {
+		if main.VacuumSupported {
+			t.Skip("built with vacuum tag")
+		}
+		m := main.NewMain()
+		m.Config.MountDir, m.Config.DataDir = t.TempDir(), t.TempDir()
+		m.Config.Static = &main.StaticConfig{Primary: true}
+		m.Config.Maintenance.Vacuum.Interval = time.Hour
+		m.Config.Maintenance.Vacuum.Databases = []string{"db"}
+		if err := m.Validate(context.Background()); err == nil || err.Error() != `vacuum requires litefs to be built with the "vacuum" tag` {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+	t.Run("ErrInvalidFUSESubdir", func(t *testing.T) {
+		for _, subdir := range []string{"/abs", "../up", "a/../b", "a/", "."} {
+			m := main.NewMain()
+			m.Config.MountDir, m.Config.DataDir = t.TempDir(), t.TempDir()
+			m.Config.Static = &main.StaticConfig{Primary: true}
+			m.Config.FUSE.Subdir = subdir
+			if err := m.Validate(context.Background()); err == nil || err.Error() != fmt.Sprintf(`invalid fuse subdir: %q`, subdir) {
+				t.Fatalf("unexpected error for %q: %s", subdir, err)
+			}
+		}
+	})
+
+	for _, tt := range []struct {
+		name   string
+		config func(t *testing.T, m *main.Main)
+		err    string
+	}{
+		{
+			name:   "ErrInvalidAckMode",
+			config: func(t *testing.T, m *main.Main) { m.Config.Replication.AckMode = "sync" },
+			err:    `invalid replication ack-mode: "sync"`,
+		},
+		{
+			name:   "ErrInvalidCandidatePriority",
+			config: func(t *testing.T, m *main.Main) { m.Config.CandidatePriority = 101 },
+			err:    `candidate-priority must be between 0 and 100`,
+		},
+		{
+			name:   "ErrNegativeLogDedupWindow",
+			config: func(t *testing.T, m *main.Main) { m.Config.Log.DedupWindow = -1 },
+			err:    `log dedup-window cannot be negative`,
+		},
+		{
+			name: "ErrInvalidSnapshotsRunOn",
+			config: func(t *testing.T, m *main.Main) {
+				m.Config.Snapshots.Path = t.TempDir()
+				m.Config.Snapshots.RunOn = "sometimes"
+			},
+			err: `invalid snapshots run-on: "sometimes"`,
+		},
+		{
+			name:   "ErrNegativeCircuitBreakerThreshold",
+			config: func(t *testing.T, m *main.Main) { m.Config.Hooks.CircuitBreaker.Threshold = -1 },
+			err:    `hooks circuit-breaker threshold cannot be negative`,
+		},
+		{
+			name:   "ErrNegativeConnectTimeout",
+			config: func(t *testing.T, m *main.Main) { m.Config.ConnectTimeout = -1 },
+			err:    `connect-timeout cannot be negative`,
+		},
+		{
+			name:   "ErrInvalidDatabasesPattern",
+			config: func(t *testing.T, m *main.Main) { m.Config.Databases.Exclude = []string{"[scratch"} },
+			err:    `invalid databases exclude pattern "[scratch": syntax error in pattern`,
+		},
+		{
+			name: "ErrZeroMetricsDumpInterval",
+			config: func(t *testing.T, m *main.Main) {
+				m.Config.Metrics.DumpFile = filepath.Join(m.Config.DataDir, "metrics.json")
+				m.Config.Metrics.DumpInterval = 0
+			},
+			err: `metrics dump-interval must be greater than zero`,
+		},
+		{
+			name:   "ErrInvalidNonDBFiles",
+			config: func(t *testing.T, m *main.Main) { m.Config.FUSE.NonDBFiles = "passthrough" },
+			err:    `invalid fuse non-db-files mode: "passthrough"`,
+		},
+		{
+			name:   "ErrInvalidFileMode",
+			config: func(t *testing.T, m *main.Main) { m.Config.FUSE.FileMode = "0999" },
+			err:    `invalid fuse file-mode: "0999"`,
+		},
+		{
+			name:   "ErrInvalidDirMode",
+			config: func(t *testing.T, m *main.Main) { m.Config.FUSE.DirMode = "01777" },
+			err:    `invalid fuse dir-mode: "01777"`,
+		},
+		{
+			name:   "ErrReadyFileInMountDir",
+			config: func(t *testing.T, m *main.Main) { m.Config.FUSE.ReadyFile = filepath.Join(m.Config.MountDir, "ready") },
+			err:    `fuse ready-file cannot be inside the mount directory`,
+		},
+		{
+			name: "ErrInvalidAdvertiseResolve",
+			config: func(t *testing.T, m *main.Main) {
+				m.Config.Static = nil
+				m.Config.Consul = &main.ConsulConfig{AdvertiseResolve: "ipv5"}
+			},
+			err: `invalid consul advertise-resolve: "ipv5"`,
+		},
+		{
+			name: "ErrInvalidChurnDampening",
+			config: func(t *testing.T, m *main.Main) {
+				m.Config.Static = nil
+				m.Config.Consul = &main.ConsulConfig{ChurnDampening: &main.ConsulChurnDampeningConfig{Threshold: -1}}
+			},
+			err: `consul churn-dampening window, threshold & max-backoff must not be negative`,
+		},
+		{
+			name:   "ErrInvalidStaticOnConflict",
+			config: func(t *testing.T, m *main.Main) { m.Config.Static = &main.StaticConfig{OnConflict: "panic"} },
+			err:    `invalid static on-conflict: "panic"`,
+		},
+		{
+			name: "ErrConsulTLSRequiresHTTPS",
+			config: func(t *testing.T, m *main.Main) {
+				m.Config.Static = nil
+				m.Config.Consul = &main.ConsulConfig{URL: "http://localhost:8500", TLS: &main.ConsulTLSConfig{}}
+			},
+			err: `consul tls requires an https url`,
+		},
+		{
+			name:   "ErrInvalidAdvertisePort",
+			config: func(t *testing.T, m *main.Main) { m.Config.HTTP.AdvertisePort = 70000 },
+			err:    `http advertise-port must be between 0 and 65535`,
+		},
+		{
+			name: "ErrInvalidAdvertiseURL",
+			config: func(t *testing.T, m *main.Main) {
+				m.Config.Static = &main.StaticConfig{AdvertiseURL: "localhost:20202"}
+			},
+			err: `invalid advertise-url: "localhost:20202"`,
+		},
+		{
+			name: "ErrAdvertiseURLWithAdvertiseAddr",
+			config: func(t *testing.T, m *main.Main) {
+				m.Config.Static = nil
+				m.Config.Consul = &main.ConsulConfig{AdvertiseURL: "http://node1:20202"}
+				m.Config.HTTP.AdvertiseAddr = "10.0.0.1"
+			},
+			err: `cannot specify advertise-url with http advertise-addr or advertise-port`,
+		},
+		{
+			name: "ErrStaticReplicaNoAdvertiseURL",
+			config: func(t *testing.T, m *main.Main) {
+				m.Config.Static = &main.StaticConfig{Hostname: "node1"}
+				m.Config.HTTP.AdvertiseAddr = "10.0.0.2"
+			},
+			err: `static advertise-url of the primary is required on replicas`,
+		},
+		{
+			name:   "ErrInvalidReconnectWindow",
+			config: func(t *testing.T, m *main.Main) { m.Config.HTTP.Replication.ReconnectWindow = 0 },
+			err:    `http reconnect-window must be at least 1s`,
+		},
+		{
+			name:   "ErrNegativeMaxConcurrentSnapshots",
+			config: func(t *testing.T, m *main.Main) { m.Config.HTTP.Replication.MaxConcurrentSnapshots = -1 },
+			err:    `http max-concurrent-snapshots cannot be negative`,
+		},
+		{
+			name:   "ErrInvalidWriteBusyTimeout",
+			config: func(t *testing.T, m *main.Main) { m.Config.Write.BusyTimeout = -1 },
+			err:    `write busy-timeout cannot be negative`,
+		},
+		{
+			name:   "ErrNegativeEventHistorySize",
+			config: func(t *testing.T, m *main.Main) { m.Config.HTTP.Events.HistorySize = -1 },
+			err:    `http events history-size cannot be negative`,
+		},
+		{
+			name:   "ErrInvalidEventBufferSize",
+			config: func(t *testing.T, m *main.Main) { m.Config.HTTP.Events.BufferSize = 0 },
+			err:    `http events buffer-size must be positive`,
+		},
+		{
+			name:   "ErrInvalidHealthTimeout",
+			config: func(t *testing.T, m *main.Main) { m.Config.HTTP.Health.Timeout = 0 },
+			err:    `http health timeout must be greater than zero`,
+		},
+		{
+			name: "ErrHealthObserver",
+			config: func(t *testing.T, m *main.Main) {
+				m.Config.Observer = true
+				m.Config.HTTP.Health.DB = "litefs-health.db"
+			},
+			err: `http health db cannot be set on an observer`,
+		},
+		{
+			name: "ErrHealthFileSystemBackend",
+			config: func(t *testing.T, m *main.Main) {
+				m.Config.FileSystem.Backend = main.FileSystemBackendNone
+				m.Config.Candidate = false
+				m.Config.HTTP.Health.DB = "litefs-health.db"
+			},
+			err: `http health db requires the fuse file system backend`,
+		},
+		{
+			name: "ErrExecMultipleAlwaysOn",
+			config: func(t *testing.T, m *main.Main) {
+				m.Config.Exec = main.ExecConfigSlice{main.NewExecConfig("true"), main.NewExecConfig("true")}
+			},
+			err: `exec supports only one command without primary-only`,
+		},
+		{
+			name: "ErrExecDebounceNegative",
+			config: func(t *testing.T, m *main.Main) {
+				m.Config.Exec = main.ExecConfigSlice{{Cmd: "true", PrimaryOnly: true, Debounce: -1}}
+			},
+			err: `exec debounce cannot be negative`,
+		},
+		{
+			name: "ErrExecShutdownTimeoutNegative",
+			config: func(t *testing.T, m *main.Main) {
+				m.Config.Exec = main.ExecConfigSlice{{Cmd: "true", ShutdownTimeout: -1}}
+			},
+			err: `exec shutdown-timeout cannot be negative`,
+		},
+		{
+			name: "ErrExecCommandNotFound",
+			config: func(t *testing.T, m *main.Main) {
+				m.Config.Exec = main.ExecConfigSlice{main.NewExecConfig("litefs-no-such-command -flag 'quoted arg'")}
+			},
+			err: `exec command not found in PATH: "litefs-no-such-command"`,
+		},
+		{
+			name:   "ErrUnsupportedChecksumAlgorithm",
+			config: func(t *testing.T, m *main.Main) { m.Config.Data.ChecksumAlgorithm = "sha256" },
+			err:    `unsupported data checksum-algorithm: "sha256"`,
+		},
+		{
+			name:   "ErrInvalidSyncMode",
+			config: func(t *testing.T, m *main.Main) { m.Config.Data.SyncMode = "extra" },
+			err:    `invalid data sync-mode: "extra"`,
+		},
+		{
+			name:   "ErrInvalidCaseSensitivity",
+			config: func(t *testing.T, m *main.Main) { m.Config.Data.CaseSensitivity = "lower" },
+			err:    `invalid data case-sensitivity: "lower"`,
+		},
+		{
+			name:   "ErrNegativeMaxWALSize",
+			config: func(t *testing.T, m *main.Main) { m.Config.SQLite.MaxWALSize = -1 },
+			err:    `sqlite max-wal-size cannot be negative`,
+		},
+		{
+			name:   "ErrNegativeMaxApplyRate",
+			config: func(t *testing.T, m *main.Main) { m.Config.Replica.MaxApplyRate = -1 },
+			err:    `replica max-apply-rate cannot be negative`,
+		},
+		{
+			name:   "ErrInvalidReadConsistency",
+			config: func(t *testing.T, m *main.Main) { m.Config.Replica.ReadConsistency = "strong" },
+			err:    `invalid replica read-consistency: "strong"`,
+		},
+		{
+			name:   "ErrNegativeCatchupDeadline",
+			config: func(t *testing.T, m *main.Main) { m.Config.Replica.CatchupDeadline = -time.Second },
+			err:    `replica catchup-deadline cannot be negative`,
+		},
+		{
+			name:   "ErrInvalidOnClusterMismatch",
+			config: func(t *testing.T, m *main.Main) { m.Config.OnClusterMismatch = "ignore" },
+			err:    `invalid on-cluster-mismatch: "ignore"`,
+		},
+		{
+			name:   "ErrInvalidOnLeaseLoss",
+			config: func(t *testing.T, m *main.Main) { m.Config.OnLeaseLoss = "ignore" },
+			err:    `invalid on-lease-loss: "ignore"`,
+		},
+		{
+			name:   "ErrInvalidStartupReport",
+			config: func(t *testing.T, m *main.Main) { m.Config.StartupReport = "xml" },
+			err:    `invalid startup-report: "xml"`,
+		},
+		{
+			name:   "ErrInvalidReplicaLocalWrite",
+			config: func(t *testing.T, m *main.Main) { m.Config.Replica.LocalWrite = "ignore" },
+			err:    `invalid replica local-write: "ignore"`,
+		},
+		{
+			name:   "ErrInvalidFileSystemBackend",
+			config: func(t *testing.T, m *main.Main) { m.Config.FileSystem.Backend = "vfs" },
+			err:    `invalid file system backend: "vfs"`,
+		},
+		{
+			name:   "ErrCandidateWithoutFileSystem",
+			config: func(t *testing.T, m *main.Main) { m.Config.FileSystem.Backend = "none" },
+			err:    `candidate must be false when file system backend is "none"`,
+		},
+		{
+			name:   "ErrVacuumDatabasesRequired",
+			config: func(t *testing.T, m *main.Main) { m.Config.Maintenance.Vacuum.Interval = time.Hour },
+			err:    `vacuum databases required when interval is set`,
+		},
+		{
+			name:   "ErrInvalidSourceAddr",
+			config: func(t *testing.T, m *main.Main) { m.Config.HTTP.Client.SourceAddr = "localhost" },
+			err:    `http client source-addr must be an ip address: "localhost"`,
+		},
+		{
+			name:   "ErrSourceAddrNotLocal",
+			config: func(t *testing.T, m *main.Main) { m.Config.HTTP.Client.SourceAddr = "192.0.2.1" },
+			err:    `http client source-addr is not assigned to a local interface: 192.0.2.1`,
+		},
+		{
+			name:   "ErrDebugStateTokenRequired",
+			config: func(t *testing.T, m *main.Main) { m.Config.HTTP.DebugState = true },
+			err:    `http debug-state-token required when debug-state is enabled`,
+		},
+		{
+			name:   "ErrPprofTokenRequired",
+			config: func(t *testing.T, m *main.Main) { m.Config.HTTP.Pprof = true },
+			err:    `http debug-state-token required when pprof is enabled`,
+		},
+		{
+			name:   "ErrInvalidBackupMode",
+			config: func(t *testing.T, m *main.Main) { m.Config.Backup.Mode = "full" },
+			err:    `invalid backup mode: "full"`,
+		},
+		{
+			name:   "ErrBackupPathRequired",
+			config: func(t *testing.T, m *main.Main) { m.Config.Backup.Mode = "incremental" },
+			err:    `backup path required`,
+		},
+		{
+			name:   "ErrInvalidSinkType",
+			config: func(t *testing.T, m *main.Main) { m.Config.Sink.Type = "kafka" },
+			err:    `invalid sink type: "kafka"`,
+		},
+		{
+			name:   "ErrSinkURLRequired",
+			config: func(t *testing.T, m *main.Main) { m.Config.Sink.Type = "http" },
+			err:    `sink url required for "http" sink`,
+		},
+		{
+			name:   "ErrInvalidConfigSource",
+			config: func(t *testing.T, m *main.Main) { m.Config.ConfigSource.Source = "replica" },
+			err:    `invalid config source: "replica"`,
+		},
+		{
+			name: "ErrInvalidMaxRemountAttempts",
+			config: func(t *testing.T, m *main.Main) {
+				m.Config.FUSE.AutoRemount = true
+				m.Config.FUSE.MaxRemountAttempts = 0
+			},
+			err: `fuse max-remount-attempts must be at least 1`,
+		},
+		{
+			name:   "ErrNegativeSlowOpThreshold",
+			config: func(t *testing.T, m *main.Main) { m.Config.FUSE.SlowOpThreshold = -time.Second },
+			err:    `fuse slow-op-threshold cannot be negative`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m := main.NewMain()
+			m.Config.MountDir, m.Config.DataDir = t.TempDir(), t.TempDir()
+			m.Config.Static = &main.StaticConfig{Primary: true}
+			tt.config(t, m)
+			if err := m.Validate(context.Background()); err == nil || err.Error() != tt.err {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	}
 }
 
 //go:embed etc/litefs.yml
 var litefsConfig []byte
 
+// Ensure exec commands are resolved like the shell would before mounting.
+func TestMain_Validate_ExecLookPath(t *testing.T) {
+	newMain := func(tb testing.TB, cmd string) *main.Main {
+		m := main.NewMain()
+		m.Config.MountDir, m.Config.DataDir = tb.TempDir(), tb.TempDir()
+		m.Config.Static = &main.StaticConfig{Primary: true}
+		m.Config.Exec = main.ExecConfigSlice{main.NewExecConfig(cmd)}
+		return m
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "myapp"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	} else if err := os.WriteFile(filepath.Join(dir, "noexec"), []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	t.Run("PATH", func(t *testing.T) {
+		if err := newMain(t, `myapp -addr ":8080 x"`).Validate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("AbsolutePath", func(t *testing.T) {
+		if err := newMain(t, filepath.Join(dir, "myapp")+" serve").Validate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("QuotedExecutable", func(t *testing.T) {
+		if err := newMain(t, `'myapp' serve`).Validate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("ErrNotExecutable", func(t *testing.T) {
+		if err := newMain(t, "noexec").Validate(context.Background()); err == nil || err.Error() != `exec command not found in PATH: "noexec"` {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+	t.Run("ErrParse", func(t *testing.T) {
+		if err := newMain(t, `myapp "unterminated`).Validate(context.Background()); err == nil || !strings.HasPrefix(err.Error(), "cannot parse exec command: ") {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+}
+
+// Ensure a primary-only subprocess is started on promotion & stopped on demotion.
+func TestPrimaryExec_Run(t *testing.T) {
+	c := litefstest.NewCluster()
+	leaser := c.NewLeaser("node1", "http://node1:20202")
+	store := litefstest.NewStore(t, leaser, nil)
+
+	// The subprocess logs each start & stop so the transitions can be observed.
+	logPath := filepath.Join(t.TempDir(), "log")
+	script := `echo start >> ` + logPath + `; trap 'echo stop >> ` + logPath + `; exit 0' TERM; while true; do sleep 0.01; done`
+	e := main.NewPrimaryExec(store, []string{"sh", "-c", script}, 50*time.Millisecond, 5*time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { defer close(done); e.Run(ctx) }()
+
+	waitLog := func(tb testing.TB, want string) {
+		tb.Helper()
+		testingutil.RetryUntil(tb, 10*time.Millisecond, 5*time.Second, func() error {
+			buf, err := os.ReadFile(logPath)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			} else if got := strings.Join(strings.Fields(string(buf)), ","); got != want {
+				return fmt.Errorf("log=%q, want %q", got, want)
+			}
+			return nil
+		})
+	}
+
+	// Started while primary.
+	waitLog(t, "start")
+
+	// Stopped after the lease is handed to another node.
+	c.Handoff(c.NewLeaser("node2", "http://node2:20202"))
+	waitLog(t, "start,stop")
+
+	// Started again on promotion.
+	c.Handoff(leaser)
+	waitLog(t, "start,stop,start")
+
+	// Stopped on shutdown.
+	cancel()
+	<-done
+	waitLog(t, "start,stop,start,stop")
+}
+
+func TestMain_StartupReport(t *testing.T) {
+	t.Run("Static", func(t *testing.T) {
+		m := main.NewMain()
+		m.Config.MountDir, m.Config.DataDir = "/mnt", "/data"
+		m.Config.Static = &main.StaticConfig{Primary: true, Hostname: "node1", AdvertiseURL: "http://node1:20202"}
+		m.Leaser = litefs.NewStaticLeaser(true, "node1", "http://node1:20202")
+
+		r := m.StartupReport()
+		if got, want := r.Lease.Type, "static"; got != want {
+			t.Fatalf("Lease.Type=%q, want %q", got, want)
+		} else if got, want := r.Lease.AdvertiseURL, "http://node1:20202"; got != want {
+			t.Fatalf("Lease.AdvertiseURL=%q, want %q", got, want)
+		} else if got, want := len(r.Warnings), 1; got != want {
+			t.Fatalf("len(Warnings)=%d, want %d: %v", got, want, r.Warnings)
+		} else if !strings.Contains(r.Warnings[0], "without authentication") {
+			t.Fatalf("unexpected warning: %s", r.Warnings[0])
+		}
+
+		s := r.String()
+		for _, want := range []string{"mount-dir:   /mnt", "lease:       static primary=true", "WARNING: http: listening on all interfaces"} {
+			if !strings.Contains(s, want) {
+				t.Fatalf("report missing %q:\n%s", want, s)
+			}
+		}
+	})
+
+	t.Run("LocalhostReadOnly", func(t *testing.T) {
+		m := main.NewMain()
+		m.Config.HTTP.Addr = "127.0.0.1:20202"
+		m.Config.HTTP.Pprof = true
+		if r := m.StartupReport(); len(r.Warnings) != 0 {
+			t.Fatalf("unexpected warnings: %v", r.Warnings)
+		}
+	})
+}
+
+func TestMain_CheckDirPermissions(t *testing.T) {
+	// newMain returns a program with data & mount directories under a temp dir.
+	newMain := func(tb testing.TB) *main.Main {
+		dir := tb.TempDir()
+		m := main.NewMain()
+		m.Config.DataDir = filepath.Join(dir, "data")
+		m.Config.MountDir = filepath.Join(dir, "mnt")
+		return m
+	}
+
+	// skipIfRoot skips tests which rely on the kernel denying access.
+	skipIfRoot := func(tb testing.TB) {
+		if os.Geteuid() == 0 {
+			tb.Skip("permission checks always succeed as root")
+		}
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		m := newMain(t)
+		if err := os.Mkdir(m.Config.DataDir, 0700); err != nil {
+			t.Fatal(err)
+		} else if err := m.CheckDirPermissions(false); err != nil {
+			t.Fatal(err) // mount dir does not exist but can be created
+		}
+	})
+
+	t.Run("ErrNotDirectory", func(t *testing.T) {
+		m := newMain(t)
+		if err := os.WriteFile(m.Config.DataDir, nil, 0600); err != nil {
+			t.Fatal(err)
+		} else if err := m.CheckDirPermissions(false); err == nil || err.Error() != fmt.Sprintf(`data directory %q is not a directory`, m.Config.DataDir) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrNotCreatable", func(t *testing.T) {
+		skipIfRoot(t)
+
+		m := newMain(t)
+		parent := filepath.Dir(m.Config.DataDir)
+		if err := os.Chmod(parent, 0500); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = os.Chmod(parent, 0700) })
+
+		if err := m.CheckDirPermissions(true); err == nil || !strings.Contains(err.Error(), "does not exist and cannot be created") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrNotAccessible", func(t *testing.T) {
+		skipIfRoot(t)
+
+		m := newMain(t)
+		if err := os.Mkdir(m.Config.DataDir, 0500); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = os.Chmod(m.Config.DataDir, 0700) })
+
+		if err := m.CheckDirPermissions(false); err == nil || !strings.Contains(err.Error(), "restart with -fix-permissions") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	// Ensure -fix-permissions restores owner access to the directory.
+	t.Run("Fix", func(t *testing.T) {
+		skipIfRoot(t)
+
+		m := newMain(t)
+		if err := os.Mkdir(m.Config.DataDir, 0000); err != nil {
+			t.Fatal(err)
+		} else if err := os.Chmod(m.Config.DataDir, 0050); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := m.CheckDirPermissions(true); err != nil {
+			t.Fatal(err)
+		}
+		if fi, err := os.Stat(m.Config.DataDir); err != nil {
+			t.Fatal(err)
+		} else if got, want := fi.Mode().Perm(), os.FileMode(0750); got != want {
+			t.Fatalf("mode=%s, want %s", got, want)
+		} else if got, want := int(fi.Sys().(*syscall.Stat_t).Uid), os.Geteuid(); got != want {
+			t.Fatalf("uid=%d, want %d", got, want)
+		}
+	})
+}
+
+func TestConsulTLSConfig_TLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "client")
+	_, otherKeyFile := writeTestCert(t, dir, "other")
+
+	t.Run("OK", func(t *testing.T) {
+		c := main.ConsulTLSConfig{CAFile: certFile, CertFile: certFile, KeyFile: keyFile, ServerName: "consul"}
+		config, err := c.TLSConfig()
+		if err != nil {
+			t.Fatal(err)
+		} else if config.RootCAs == nil {
+			t.Fatal("expected root CAs")
+		} else if got, want := len(config.Certificates), 1; got != want {
+			t.Fatalf("len(Certificates)=%d, want %d", got, want)
+		} else if got, want := config.ServerName, "consul"; got != want {
+			t.Fatalf("ServerName=%q, want %q", got, want)
+		}
+	})
+
+	// Ensure the system roots are used if no CA bundle is set.
+	t.Run("NoCAFile", func(t *testing.T) {
+		c := main.ConsulTLSConfig{CertFile: certFile, KeyFile: keyFile}
+		if config, err := c.TLSConfig(); err != nil {
+			t.Fatal(err)
+		} else if config.RootCAs != nil {
+			t.Fatal("expected no root CAs")
+		}
+	})
+
+	t.Run("ErrCAFileNotFound", func(t *testing.T) {
+		c := main.ConsulTLSConfig{CAFile: filepath.Join(dir, "missing.pem")}
+		if _, err := c.TLSConfig(); err == nil || !strings.HasPrefix(err.Error(), "cannot read consul tls ca-file: ") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrCAFileNoPEM", func(t *testing.T) {
+		c := main.ConsulTLSConfig{CAFile: keyFile}
+		if _, err := c.TLSConfig(); err == nil || err.Error() != "consul tls ca-file contains no PEM certificates: "+keyFile {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrKeyFileNotFound", func(t *testing.T) {
+		c := main.ConsulTLSConfig{CertFile: certFile, KeyFile: filepath.Join(dir, "missing.key")}
+		if _, err := c.TLSConfig(); err == nil || !strings.HasPrefix(err.Error(), "cannot load consul tls client certificate: ") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrKeyMismatch", func(t *testing.T) {
+		c := main.ConsulTLSConfig{CertFile: certFile, KeyFile: otherKeyFile}
+		if _, err := c.TLSConfig(); err == nil || err.Error() != "cannot load consul tls client certificate: tls: private key does not match public key" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestMain_WaitConnected(t *testing.T) {
+	// newStore returns an opened replica store whose leaser never reports a primary.
+	newStore := func(tb testing.TB) *litefs.Store {
+		tb.Helper()
+
+		store := litefs.NewStore(tb.TempDir(), false)
+		store.Leaser = &mock.Leaser{
+			CloseFunc:        func() error { return nil },
+			AdvertiseURLFunc: func() string { return "http://localhost:20202" },
+			PrimaryInfoFunc: func(ctx context.Context) (litefs.PrimaryInfo, error) {
+				return litefs.PrimaryInfo{}, litefs.ErrNoPrimary
+			},
+		}
+		if err := store.Open(); err != nil {
+			tb.Fatal(err)
+		}
+		tb.Cleanup(func() { _ = store.Close() })
+		return store
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		m := main.NewMain()
+		m.Store = litefstest.NewStore(t, litefstest.NewLeaser(), nil)
+		m.Config.ConnectTimeout = time.Second
+		if err := m.WaitConnected(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ErrConnectTimeout", func(t *testing.T) {
+		m := main.NewMain()
+		m.Store = newStore(t)
+		m.Config.ConnectTimeout = 100 * time.Millisecond
+
+		start := time.Now()
+		if err := m.WaitConnected(context.Background()); err != main.ErrConnectTimeout {
+			t.Fatalf("unexpected error: %v", err)
+		} else if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Fatalf("timeout took too long: %s", elapsed)
+		}
+	})
+
+	// Ensure the wait is only aborted by cancellation if there is no timeout.
+	t.Run("Canceled", func(t *testing.T) {
+		m := main.NewMain()
+		m.Store = newStore(t)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if err := m.WaitConnected(ctx); err != context.DeadlineExceeded {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestMountsCommand_Run(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.Mkdir(filepath.Join(dir, "my db"), 0777); err != nil {
+			t.Fatal(err)
+		}
+
+		// Only FUSE mounts with the "litefs" fsname are listed.
+		mountsPath := filepath.Join(t.TempDir(), "mounts")
+		if err := os.WriteFile(mountsPath, []byte(strings.Join([]string{
+			"proc /proc proc rw,nosuid 0 0",
+			"litefs " + dir + " fuse.litefs rw,nosuid 0 0",
+			"litefs " + filepath.Join(dir, `my\040db`) + " fuse rw 0 0",
+			"other /mnt/other fuse.other rw 0 0",
+			"litefs /mnt/notfuse ext4 rw 0 0",
+			"",
+		}, "\n")), 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		var stdout strings.Builder
+		c := main.NewMountsCommand()
+		c.MountsPath, c.Stdout = mountsPath, &stdout
+		if err := c.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := stdout.String(), fmt.Sprintf("connected  %s\nconnected  %s\n", dir, filepath.Join(dir, "my db")); got != want {
+			t.Fatalf("stdout=%q, want %q", got, want)
+		}
+	})
+
+	t.Run("NoMounts", func(t *testing.T) {
+		mountsPath := filepath.Join(t.TempDir(), "mounts")
+		if err := os.WriteFile(mountsPath, []byte("proc /proc proc rw 0 0\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		var stdout strings.Builder
+		c := main.NewMountsCommand()
+		c.MountsPath, c.Stdout = mountsPath, &stdout
+		if err := c.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if got, want := stdout.String(), "no litefs mounts found\n"; got != want {
+			t.Fatalf("stdout=%q, want %q", got, want)
+		}
+	})
+
+	t.Run("ErrMountsNotFound", func(t *testing.T) {
+		c := main.NewMountsCommand()
+		c.MountsPath = filepath.Join(t.TempDir(), "mounts")
+		if err := c.Run(context.Background()); err == nil || !os.IsNotExist(errors.Unwrap(err)) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestBenchCommand_Run(t *testing.T) {
+	store := litefs.NewStore(t.TempDir(), true)
+	store.Leaser = litefs.NewStaticLeaser(true, "localhost", "http://localhost:20202")
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	server := litefshttp.NewServer(store, "localhost:0")
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	server.Serve()
+	t.Cleanup(func() { _ = server.Close() })
+
+	t.Run("OK", func(t *testing.T) {
+		var stdout strings.Builder
+		c := main.NewBenchCommand()
+		c.Stdout = &stdout
+		if err := c.ParseFlags(context.Background(), []string{"-target", server.URL(), "-n", "3", "-pages", "16"}); err != nil {
+			t.Fatal(err)
+		} else if err := c.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+		if got, want := len(lines), 3; got != want {
+			t.Fatalf("lines=%d, want %d: %q", got, want, stdout.String())
+		} else if want := fmt.Sprintf("benchmarking %s: n=3 payload=", server.URL()); !strings.HasPrefix(lines[0], want) {
+			t.Fatalf("line[0]=%q, want prefix %q", lines[0], want)
+		} else if !strings.HasPrefix(lines[1], "throughput: ") {
+			t.Fatalf("line[1]=%q", lines[1])
+		} else if !strings.HasPrefix(lines[2], "latency: min=") {
+			t.Fatalf("line[2]=%q", lines[2])
+		}
+	})
+
+	t.Run("ErrPayloadTooLarge", func(t *testing.T) {
+		c := main.NewBenchCommand()
+		if err := c.ParseFlags(context.Background(), []string{"-target", server.URL(), "-pages", "65536", "-page-size", "4096"}); err == nil || err.Error() != `payload cannot exceed 67108864 bytes` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestExportCommand_Run(t *testing.T) {
+	store := litefstest.NewStore(t, litefstest.NewLeaser(), nil)
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 3, 1))
+	litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 4, 2))
+
+	want, err := os.ReadFile(db.DatabasePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Serve the snapshot, optionally truncated to simulate a dropped connection.
+	var truncate int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/db/db/export" {
+			http.NotFound(w, r)
+			return
+		}
+		var buf bytes.Buffer
+		if _, _, err := db.WriteSnapshotTo(r.Context(), &buf); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(buf.Bytes()[:buf.Len()-truncate])
+	}))
+	defer server.Close()
+
+	newCommand := func(name, path string) (*main.ExportCommand, *strings.Builder) {
+		var stderr strings.Builder
+		c := main.NewExportCommand()
+		c.Name, c.URL, c.Path, c.Stderr = name, server.URL, path, &stderr
+		return c, &stderr
+	}
+
+	t.Run("File", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "db")
+		c, stderr := newCommand("db", path)
+		if err := c.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, err := os.ReadFile(path); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(got, want) {
+			t.Fatal("exported database does not match")
+		} else if got, want := stderr.String(), fmt.Sprintf("exported \"db\" to %s: txid=%s pages=4\n", path, ltx.FormatTXID(db.TXID())); got != want {
+			t.Fatalf("stderr=%q, want %q", got, want)
+		}
+	})
+
+	t.Run("Stdout", func(t *testing.T) {
+		var stdout bytes.Buffer
+		c, _ := newCommand("db", "-")
+		c.Stdout = &stdout
+		if err := c.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(stdout.Bytes(), want) {
+			t.Fatal("exported database does not match")
+		}
+	})
+
+	// Ensure a truncated stream fails verification & leaves no output file.
+	t.Run("ErrTruncated", func(t *testing.T) {
+		truncate = 100
+		defer func() { truncate = 0 }()
+
+		path := filepath.Join(t.TempDir(), "db")
+		c, _ := newCommand("db", path)
+		if err := c.Run(context.Background()); err == nil {
+			t.Fatal("expected error")
+		}
+
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("expected no output file, got %v", err)
+		} else if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+			t.Fatalf("expected no temporary file, got %v", err)
+		}
+	})
+
+	t.Run("ErrNotFound", func(t *testing.T) {
+		c, _ := newCommand("other", filepath.Join(t.TempDir(), "db"))
+		if err := c.Run(context.Background()); err == nil || !strings.HasPrefix(err.Error(), "export failed (404)") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
 func TestConfigExample(t *testing.T) {
 	config := main.NewConfig()
 	if err := yaml.Unmarshal(litefsConfig, &config); err != nil {
@@ -922,6 +1853,74 @@ func TestConfigExample(t *testing.T) {
 	}
 }
 
+func TestMigrateConfig(t *testing.T) {
+	t.Run("Renamed", func(t *testing.T) {
+		var node yaml.Node
+		if err := yaml.Unmarshal([]byte("retention-duration: 20s\nretention:\n  monitor-interval: 5s\n"), &node); err != nil {
+			t.Fatal(err)
+		}
+		if err := main.MigrateConfig(&node, []main.ConfigDeprecation{
+			{Key: "retention-duration", NewKey: "retention.duration"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		config := main.NewConfig()
+		if err := node.Decode(&config); err != nil {
+			t.Fatal(err)
+		} else if got, want := config.Retention.Duration, 20*time.Second; got != want {
+			t.Fatalf("Retention.Duration=%s, want %s", got, want)
+		} else if got, want := config.Retention.MonitorInterval, 5*time.Second; got != want {
+			t.Fatalf("Retention.MonitorInterval=%s, want %s", got, want)
+		}
+	})
+
+	t.Run("NewSection", func(t *testing.T) {
+		var node yaml.Node
+		if err := yaml.Unmarshal([]byte("max-replicas: 3\n"), &node); err != nil {
+			t.Fatal(err)
+		}
+		if err := main.MigrateConfig(&node, []main.ConfigDeprecation{
+			{Key: "max-replicas", NewKey: "http.replication.max-replicas"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		config := main.NewConfig()
+		if err := node.Decode(&config); err != nil {
+			t.Fatal(err)
+		} else if got, want := config.HTTP.Replication.MaxReplicas, 3; got != want {
+			t.Fatalf("MaxReplicas=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("ErrRemoved", func(t *testing.T) {
+		var node yaml.Node
+		if err := yaml.Unmarshal([]byte("retention:\n  max-files: 10\n"), &node); err != nil {
+			t.Fatal(err)
+		}
+		err := main.MigrateConfig(&node, []main.ConfigDeprecation{
+			{Key: "retention.max-files", Reason: "use retention.duration instead"},
+		})
+		if err == nil || err.Error() != `config key "retention.max-files" (line 2) has been removed: use retention.duration instead` {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+
+	t.Run("ErrBothKeys", func(t *testing.T) {
+		var node yaml.Node
+		if err := yaml.Unmarshal([]byte("retention-duration: 20s\nretention:\n  duration: 10s\n"), &node); err != nil {
+			t.Fatal(err)
+		}
+		err := main.MigrateConfig(&node, []main.ConfigDeprecation{
+			{Key: "retention-duration", NewKey: "retention.duration"},
+		})
+		if err == nil || err.Error() != `cannot specify both deprecated config key "retention-duration" and its replacement "retention.duration"` {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+}
+
 func TestExpandEnv(t *testing.T) {
 	_ = os.Setenv("LITEFS_FOO", "foo")
 	_ = os.Setenv("LITEFS_FOO2", "foo")
@@ -956,6 +1955,19 @@ func TestExpandEnv(t *testing.T) {
 			t.Fatalf("got %q, want %q", got, want)
 		}
 	})
+	t.Run("SecretRef", func(t *testing.T) {
+		if got, want := main.ExpandEnv("${file:/path/to/token} ${secret:name}"), `${file:/path/to/token} ${secret:name}`; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+	t.Run("EscapedDollarSign", func(t *testing.T) {
+		if got, want := main.ExpandEnv("echo $$LITEFS_FOO"), `echo $LITEFS_FOO`; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+		if got, want := main.ExpandEnv("$$$LITEFS_FOO"), `$foo`; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
 	t.Run("VarExpr", func(t *testing.T) {
 		if got, want := main.ExpandEnv("${ LITEFS_FOO == LITEFS_FOO2 }"), `true`; got != want {
 			t.Fatalf("got %q, want %q", got, want)
@@ -966,6 +1978,678 @@ func TestExpandEnv(t *testing.T) {
 	})
 }
 
+func TestReadConfigFile_Exec(t *testing.T) {
+	readConfig := func(tb testing.TB, s string) main.Config {
+		path := filepath.Join(tb.TempDir(), "litefs.yml")
+		if err := os.WriteFile(path, []byte(s), 0600); err != nil {
+			tb.Fatal(err)
+		}
+		config := main.NewConfig()
+		if err := main.ReadConfigFile(&config, path, false); err != nil {
+			tb.Fatal(err)
+		}
+		return config
+	}
+
+	t.Run("String", func(t *testing.T) {
+		config := readConfig(t, "exec: \"myapp -addr :8080\"\n")
+		if got, want := config.Exec, (main.ExecConfigSlice{main.NewExecConfig("myapp -addr :8080")}); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Exec=%#v, want %#v", got, want)
+		}
+	})
+	t.Run("Mapping", func(t *testing.T) {
+		config := readConfig(t, "exec:\n  cmd: \"migrate\"\n  primary-only: true\n")
+		if got, want := config.Exec, (main.ExecConfigSlice{{Cmd: "migrate", PrimaryOnly: true, Debounce: main.DefaultExecDebounce, ShutdownTimeout: main.DefaultExecShutdownTimeout}}); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Exec=%#v, want %#v", got, want)
+		}
+	})
+	t.Run("List", func(t *testing.T) {
+		config := readConfig(t, "exec:\n  - cmd: \"migrate\"\n    primary-only: true\n    debounce: \"1s\"\n    shutdown-timeout: \"0s\"\n  - \"myapp\"\n")
+		if got, want := config.Exec, (main.ExecConfigSlice{
+			{Cmd: "migrate", PrimaryOnly: true, Debounce: time.Second},
+			main.NewExecConfig("myapp"),
+		}); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Exec=%#v, want %#v", got, want)
+		}
+	})
+}
+
+func TestReadConfigFiles_Overlay(t *testing.T) {
+	writeConfig := func(tb testing.TB, s string) string {
+		f, err := os.CreateTemp(tb.TempDir(), "litefs-*.yml")
+		if err != nil {
+			tb.Fatal(err)
+		} else if _, err := f.WriteString(s); err != nil {
+			tb.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			tb.Fatal(err)
+		}
+		return f.Name()
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		t.Setenv("LITEFS_OVERLAY_ADDR", ":30303")
+
+		base := writeConfig(t, "mount-dir: \"/litefs\"\nhttp:\n  addr: \":20202\"\n  dashboard: true\nexec:\n  - \"migrate\"\n  - \"myapp\"\n")
+		overlay := writeConfig(t, "http:\n  addr: \"${LITEFS_OVERLAY_ADDR}\"\nexec: \"myapp -prod\"\n")
+
+		config := main.NewConfig()
+		if err := main.ReadConfigFiles(&config, base, []string{overlay}, true); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := config.MountDir, "/litefs"; got != want {
+			t.Fatalf("MountDir=%q, want %q", got, want)
+		} else if got, want := config.HTTP.Addr, ":30303"; got != want {
+			t.Fatalf("HTTP.Addr=%q, want %q", got, want)
+		} else if got, want := config.HTTP.Dashboard, true; got != want {
+			t.Fatalf("HTTP.Dashboard=%v, want %v", got, want)
+		} else if got, want := config.Exec, (main.ExecConfigSlice{main.NewExecConfig("myapp -prod")}); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Exec=%#v, want %#v", got, want)
+		}
+	})
+	t.Run("Order", func(t *testing.T) {
+		base := writeConfig(t, "http:\n  addr: \":20202\"\n")
+		a := writeConfig(t, "http:\n  addr: \":30303\"\n")
+		b := writeConfig(t, "http:\n  addr: \":40404\"\n")
+
+		config := main.NewConfig()
+		if err := main.ReadConfigFiles(&config, base, []string{a, b}, false); err != nil {
+			t.Fatal(err)
+		} else if got, want := config.HTTP.Addr, ":40404"; got != want {
+			t.Fatalf("HTTP.Addr=%q, want %q", got, want)
+		}
+	})
+	t.Run("EmptyBase", func(t *testing.T) {
+		base := writeConfig(t, "")
+		overlay := writeConfig(t, "http:\n  addr: \":30303\"\n")
+
+		config := main.NewConfig()
+		if err := main.ReadConfigFiles(&config, base, []string{overlay}, false); err != nil {
+			t.Fatal(err)
+		} else if got, want := config.HTTP.Addr, ":30303"; got != want {
+			t.Fatalf("HTTP.Addr=%q, want %q", got, want)
+		}
+	})
+	t.Run("ErrOverlayNotFound", func(t *testing.T) {
+		base := writeConfig(t, "http:\n  addr: \":20202\"\n")
+
+		config := main.NewConfig()
+		if err := main.ReadConfigFiles(&config, base, []string{"/does/not/exist.yml"}, false); err == nil || !strings.Contains(err.Error(), "cannot read config overlay /does/not/exist.yml") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	t.Run("ErrOverlayNotMapping", func(t *testing.T) {
+		base := writeConfig(t, "http:\n  addr: \":20202\"\n")
+		overlay := writeConfig(t, "- foo\n")
+
+		config := main.NewConfig()
+		if err := main.ReadConfigFiles(&config, base, []string{overlay}, false); err == nil || !strings.Contains(err.Error(), "config overlay must be a mapping (line 1)") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestReadConfigFile_Secrets(t *testing.T) {
+	writeConfig := func(tb testing.TB, s string) string {
+		path := filepath.Join(t.TempDir(), "litefs.yml")
+		if err := os.WriteFile(path, []byte(s), 0600); err != nil {
+			tb.Fatal(err)
+		}
+		return path
+	}
+
+	t.Run("File", func(t *testing.T) {
+		secretPath := filepath.Join(t.TempDir(), "token")
+		if err := os.WriteFile(secretPath, []byte("s3cr3t: x\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		config := main.NewConfig()
+		path := writeConfig(t, "# see ${file:/does/not/exist}\nhttp:\n  debug-state-token: ${file:"+secretPath+"}\n")
+		if err := main.ReadConfigFile(&config, path, true); err != nil {
+			t.Fatal(err)
+		} else if got, want := config.HTTP.DebugStateToken, `s3cr3t: x`; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+	t.Run("ErrFileNotFound", func(t *testing.T) {
+		config := main.NewConfig()
+		path := writeConfig(t, "http:\n  debug-state-token: \"${file:/does/not/exist}\"\n")
+		if err := main.ReadConfigFile(&config, path, true); err == nil || !strings.Contains(err.Error(), "cannot resolve ${file:/does/not/exist} (line 2)") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	t.Run("NoExpandEnv", func(t *testing.T) {
+		config := main.NewConfig()
+		path := writeConfig(t, "http:\n  debug-state-token: ${file:/does/not/exist}\n")
+		if err := main.ReadConfigFile(&config, path, false); err != nil {
+			t.Fatal(err)
+		} else if got, want := config.HTTP.DebugStateToken, `${file:/does/not/exist}`; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestRedactConfig(t *testing.T) {
+	secretPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(secretPath, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "litefs.yml")
+	if err := os.WriteFile(path, []byte(`
+exec:
+  - cmd: "myapp -token ${file:`+secretPath+`}"
+sink:
+  command: "curl -H 'Authorization: ${file:`+secretPath+`}' http://localhost"
+consul:
+  url: "http://localhost:8500?token=${file:`+secretPath+`}"
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := main.NewConfig()
+	if err := main.ReadConfigFile(&config, path, true); err != nil {
+		t.Fatal(err)
+	}
+
+	redacted := main.RedactConfig(config)
+	if got, want := redacted.Exec[0].Cmd, "myapp -token REDACTED"; got != want {
+		t.Fatalf("exec.cmd=%q, want %q", got, want)
+	} else if got, want := redacted.Sink.Command, "curl -H 'Authorization: REDACTED' http://localhost"; got != want {
+		t.Fatalf("sink.command=%q, want %q", got, want)
+	} else if got, want := redacted.Consul.URL, "http://localhost:8500?token=REDACTED"; got != want {
+		t.Fatalf("consul.url=%q, want %q", got, want)
+	}
+
+	// Ensure the original config is unchanged.
+	if got, want := config.Exec[0].Cmd, "myapp -token s3cr3t"; got != want {
+		t.Fatalf("original exec.cmd=%q, want %q", got, want)
+	} else if got, want := config.Consul.URL, "http://localhost:8500?token=s3cr3t"; got != want {
+		t.Fatalf("original consul.url=%q, want %q", got, want)
+	}
+
+	// Ensure the secret does not appear anywhere in the printed config.
+	buf, err := yaml.Marshal(redacted)
+	if err != nil {
+		t.Fatal(err)
+	} else if strings.Contains(string(buf), "s3cr3t") {
+		t.Fatalf("secret found in printed config:\n%s", buf)
+	}
+}
+
+func TestResolveSecrets(t *testing.T) {
+	provider := secretProviderFunc(func(name string) (string, error) {
+		if name != "consul-token" {
+			return "", fmt.Errorf("secret not found")
+		}
+		return "abc", nil
+	})
+
+	t.Run("Provider", func(t *testing.T) {
+		node := yaml.Node{Kind: yaml.ScalarNode, Value: "token=${ secret:consul-token }"}
+		if err := main.ResolveSecrets(&node, provider); err != nil {
+			t.Fatal(err)
+		} else if got, want := node.Value, `token=abc`; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+	t.Run("ErrNotFound", func(t *testing.T) {
+		node := yaml.Node{Kind: yaml.ScalarNode, Value: "${secret:other}", Line: 3}
+		if err := main.ResolveSecrets(&node, provider); err == nil || err.Error() != `cannot resolve ${secret:other} (line 3): secret not found` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	t.Run("ErrNoProvider", func(t *testing.T) {
+		node := yaml.Node{Kind: yaml.ScalarNode, Value: "${secret:consul-token}", Line: 1}
+		if err := main.ResolveSecrets(&node, nil); err == nil || err.Error() != `cannot resolve ${secret:consul-token} (line 1): no secret provider configured` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestBackup_RoundTrip(t *testing.T) {
+	m := main.NewMain()
+	m.Store = litefstest.NewStore(t, litefstest.NewLeaser(), nil)
+	m.Config.Backup = main.BackupConfig{
+		Mode:             main.BackupModeIncremental,
+		Path:             t.TempDir(),
+		SnapshotInterval: time.Hour,
+	}
+	backupStore := main.NewFileBackupStore(m.Config.Backup.Path)
+
+	db, f, err := m.Store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// backup backs up the database & returns its manifest.
+	backup := func(tb testing.TB) *main.BackupManifest {
+		tb.Helper()
+		if err := m.BackupDB(context.Background(), backupStore, db); err != nil {
+			tb.Fatal(err)
+		}
+		manifest, err := main.ReadBackupManifest(context.Background(), backupStore, "db")
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return manifest
+	}
+
+	// restore restores the backup & verifies its pages match the database.
+	restore := func(tb testing.TB) {
+		tb.Helper()
+
+		c := main.NewBackupRestoreCommand()
+		c.Path, c.Name, c.Stdout = m.Config.Backup.Path, "db", io.Discard
+		c.OutputPath = filepath.Join(tb.TempDir(), "db")
+		if err := c.Run(context.Background()); err != nil {
+			tb.Fatal(err)
+		}
+
+		data, err := os.ReadFile(c.OutputPath)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		var chksums []uint64
+		for pgno := uint32(1); int(pgno)*4096 <= len(data); pgno++ {
+			chksums = append(chksums, ltx.ChecksumPage(pgno, data[(pgno-1)*4096:pgno*4096]))
+		}
+
+		if _, want, err := db.PageChecksums(context.Background()); err != nil {
+			tb.Fatal(err)
+		} else if !reflect.DeepEqual(chksums, want) {
+			tb.Fatalf("page checksums=%x, want %x", chksums, want)
+		}
+	}
+
+	// The first backup uploads a base snapshot.
+	litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 2, 1))
+	manifest := backup(t)
+	if got, want := manifest.TXID, uint64(1); got != want {
+		t.Fatalf("TXID=%d, want %d", got, want)
+	} else if got, want := len(manifest.Files), 0; got != want {
+		t.Fatalf("len(Files)=%d, want %d", got, want)
+	}
+	restore(t)
+
+	// Later backups extend the chain with each LTX file.
+	snapshotKey := manifest.Snapshot.Key
+	litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 3, 2))
+	litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 4, 3))
+	manifest = backup(t)
+	if got, want := manifest.TXID, uint64(3); got != want {
+		t.Fatalf("TXID=%d, want %d", got, want)
+	} else if got, want := manifest.Snapshot.Key, snapshotKey; got != want {
+		t.Fatalf("Snapshot.Key=%s, want %s", got, want)
+	} else if got, want := len(manifest.Files), 2; got != want {
+		t.Fatalf("len(Files)=%d, want %d", got, want)
+	}
+	restore(t)
+
+	// Once the snapshot interval elapses, a new snapshot replaces the chain.
+	m.Config.Backup.SnapshotInterval = time.Nanosecond
+	litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 4, 4))
+	manifest = backup(t)
+	if got, want := manifest.TXID, uint64(4); got != want {
+		t.Fatalf("TXID=%d, want %d", got, want)
+	} else if manifest.Snapshot.Key == snapshotKey {
+		t.Fatal("expected new snapshot")
+	} else if got, want := len(manifest.Files), 0; got != want {
+		t.Fatalf("len(Files)=%d, want %d", got, want)
+	} else if _, err := os.Stat(filepath.Join(m.Config.Backup.Path, filepath.FromSlash(snapshotKey))); !os.IsNotExist(err) {
+		t.Fatalf("expected previous snapshot to be removed: %v", err)
+	}
+	restore(t)
+}
+
+// Ensure nodes sharing a snapshot path each write their own manifest.
+func TestSnapshotDB_SharedPath(t *testing.T) {
+	path := t.TempDir()
+
+	// snapshot writes a snapshot of a new database on a node named name.
+	snapshot := func(tb testing.TB, name string, txN int) {
+		tb.Helper()
+
+		m := main.NewMain()
+		m.Store = litefstest.NewStore(tb, litefstest.NewLeaser(), nil)
+		m.Store.NodeName = name
+		m.Config.Snapshots = main.SnapshotsConfig{Path: path, Retain: 2}
+
+		db, f, err := m.Store.CreateDB("db")
+		if err != nil {
+			tb.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			tb.Fatal(err)
+		}
+		for i := 0; i < txN; i++ {
+			litefstest.WriteTx(tb, db, litefstest.NewDatabase(4096, 2, byte(i+1)))
+		}
+		if err := m.SnapshotDB(context.Background(), m.SnapshotStore(), db); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	snapshot(t, "node1", 1)
+	snapshot(t, "node2", 2)
+
+	for _, tt := range []struct {
+		node string
+		txID uint64
+	}{{"node1", 1}, {"node2", 2}} {
+		store := main.NewFileBackupStore(filepath.Join(path, tt.node))
+		manifest, err := main.ReadSnapshotManifest(context.Background(), store, "db")
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := len(manifest.Snapshots), 1; got != want {
+			t.Fatalf("%s: len=%d, want %d", tt.node, got, want)
+		} else if got, want := manifest.Snapshots[0].TXID, tt.txID; got != want {
+			t.Fatalf("%s: TXID=%d, want %d", tt.node, got, want)
+		} else if _, err := os.Stat(filepath.Join(path, tt.node, manifest.Snapshots[0].Key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSink(t *testing.T) {
+	t.Run("Order", func(t *testing.T) {
+		target := newSinkTarget(t, nil)
+		db := runSink(t, "sink-order", target.config(main.SinkOnFailureDrop))
+		for i := 1; i <= 3; i++ {
+			litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 2, byte(i)))
+		}
+
+		target.waitTXIDs(t, "0000000000000001", "0000000000000002", "0000000000000003")
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		target := newSinkTarget(t, func(n int) int {
+			if n <= 2 {
+				return http.StatusInternalServerError
+			}
+			return http.StatusOK
+		})
+		config := target.config(main.SinkOnFailureDrop)
+		config.MaxRetries = 2
+		db := runSink(t, "sink-retry", config)
+
+		litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 2, 1))
+		target.waitTXIDs(t, "0000000000000001")
+		if got, want := target.RequestN(), 3; got != want {
+			t.Fatalf("RequestN=%d, want %d", got, want)
+		}
+	})
+
+	// A transaction is dropped once max-retries is exceeded & the sink moves
+	// on to the next transaction.
+	t.Run("MaxRetries", func(t *testing.T) {
+		target := newSinkTarget(t, func(n int) int {
+			if n <= 2 {
+				return http.StatusInternalServerError
+			}
+			return http.StatusOK
+		})
+		config := target.config(main.SinkOnFailureDrop)
+		config.MaxRetries = 1
+		db := runSink(t, "sink-max-retries", config)
+		dropN := sinkMetricValue(t, "litefs_sink_drop_count", "sink-max-retries")
+
+		litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 2, 1))
+		waitSinkMetricValue(t, "litefs_sink_drop_count", "sink-max-retries", dropN+1)
+		litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 2, 2))
+		target.waitTXIDs(t, "0000000000000002")
+	})
+
+	// With "drop", transactions are dropped while the buffer is full.
+	t.Run("FullBufferDrop", func(t *testing.T) {
+		target := newSinkTarget(t, nil)
+		target.releaseCh = make(chan struct{})
+		config := target.config(main.SinkOnFailureDrop)
+		config.BufferSize = 1
+		db := runSink(t, "sink-drop", config)
+		dropN := sinkMetricValue(t, "litefs_sink_drop_count", "sink-drop")
+
+		// Hold the first transaction in flight, then fill the buffer.
+		litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 2, 1))
+		<-target.enterCh
+		litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 2, 2))
+		litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 2, 3))
+		waitSinkMetricValue(t, "litefs_sink_drop_count", "sink-drop", dropN+1)
+
+		close(target.releaseCh)
+		target.waitTXIDs(t, "0000000000000001", "0000000000000002")
+
+		litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 2, 4))
+		target.waitTXIDs(t, "0000000000000001", "0000000000000002", "0000000000000004")
+	})
+
+	// With "block", the sink waits for buffer space so no transaction is lost.
+	t.Run("FullBufferBlock", func(t *testing.T) {
+		target := newSinkTarget(t, nil)
+		target.releaseCh = make(chan struct{})
+		config := target.config(main.SinkOnFailureBlock)
+		config.BufferSize = 1
+		db := runSink(t, "sink-block", config)
+		dropN := sinkMetricValue(t, "litefs_sink_drop_count", "sink-block")
+
+		litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 2, 1))
+		<-target.enterCh
+		litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 2, 2))
+		litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 2, 3))
+		time.Sleep(50 * time.Millisecond) // allow the sink to reach the full buffer
+
+		close(target.releaseCh)
+		target.waitTXIDs(t, "0000000000000001", "0000000000000002", "0000000000000003")
+		if got := sinkMetricValue(t, "litefs_sink_drop_count", "sink-block"); got != dropN {
+			t.Fatalf("dropped %v transaction(s)", got-dropN)
+		}
+	})
+
+	// Transactions are dropped without calling the target while the circuit
+	// breaker is open.
+	t.Run("CircuitBreaker", func(t *testing.T) {
+		target := newSinkTarget(t, func(n int) int { return http.StatusInternalServerError })
+		config := target.config(main.SinkOnFailureDrop)
+		config.CircuitBreaker = main.CircuitBreakerConfig{Threshold: 1, Cooldown: time.Hour}
+		db := runSink(t, "sink-breaker", config)
+		dropN := sinkMetricValue(t, "litefs_sink_drop_count", "sink-breaker")
+
+		litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 2, 1))
+		waitSinkMetricValue(t, "litefs_sink_drop_count", "sink-breaker", dropN+1)
+		litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 2, 2))
+		waitSinkMetricValue(t, "litefs_sink_drop_count", "sink-breaker", dropN+2)
+		if got, want := target.RequestN(), 1; got != want {
+			t.Fatalf("RequestN=%d, want %d", got, want)
+		}
+	})
+}
+
+// sinkTarget is an HTTP sink target that records delivered TXIDs.
+type sinkTarget struct {
+	*httptest.Server
+	status func(n int) int // returns the status code for the nth request
+
+	enterCh   chan struct{} // receives on each request, if releaseCh is set
+	releaseCh chan struct{} // if set, requests wait until closed
+
+	mu       sync.Mutex
+	requestN int
+	txIDs    []string
+}
+
+func newSinkTarget(tb testing.TB, status func(n int) int) *sinkTarget {
+	target := &sinkTarget{status: status, enterCh: make(chan struct{}, 16)}
+	target.Server = httptest.NewServer(http.HandlerFunc(target.serveHTTP))
+	tb.Cleanup(target.Close)
+	return target
+}
+
+func (target *sinkTarget) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if target.releaseCh != nil {
+		target.enterCh <- struct{}{}
+		<-target.releaseCh
+	}
+
+	target.mu.Lock()
+	defer target.mu.Unlock()
+	target.requestN++
+
+	if target.status != nil {
+		if code := target.status(target.requestN); code != http.StatusOK {
+			w.WriteHeader(code)
+			return
+		}
+	}
+	target.txIDs = append(target.txIDs, r.Header.Get("Litefs-Txid"))
+}
+
+// config returns a sink configuration which ships to the target.
+func (target *sinkTarget) config(onFailure string) main.SinkConfig {
+	return main.SinkConfig{
+		Type:          main.SinkTypeHTTP,
+		URL:           target.URL,
+		BufferSize:    main.DefaultSinkBufferSize,
+		RetryInterval: time.Millisecond,
+		OnFailure:     onFailure,
+	}
+}
+
+// RequestN returns the number of requests received.
+func (target *sinkTarget) RequestN() int {
+	target.mu.Lock()
+	defer target.mu.Unlock()
+	return target.requestN
+}
+
+// waitTXIDs waits until the target has received exactly the given TXIDs.
+func (target *sinkTarget) waitTXIDs(tb testing.TB, txIDs ...string) {
+	tb.Helper()
+	testingutil.RetryUntil(tb, 1*time.Millisecond, 5*time.Second, func() error {
+		target.mu.Lock()
+		defer target.mu.Unlock()
+		if !reflect.DeepEqual(target.txIDs, txIDs) {
+			return fmt.Errorf("txids=%v, want %v", target.txIDs, txIDs)
+		}
+		return nil
+	})
+}
+
+// runSink creates a database on a new primary store and runs a sink for it.
+// Returns once the sink is tracking the database.
+func runSink(tb testing.TB, name string, config main.SinkConfig) *litefs.DB {
+	tb.Helper()
+
+	store := litefstest.NewStore(tb, litefstest.NewLeaser(), nil)
+	db, f, err := store.CreateDB(name)
+	if err != nil {
+		tb.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		tb.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tb.Cleanup(cancel)
+
+	sub := store.Subscribe()
+	go main.NewSink(store, config).Run(ctx, sub)
+
+	// The lag is reported once the sink has read the database's position.
+	sub.MarkDirty(name)
+	testingutil.RetryUntil(tb, 1*time.Millisecond, 5*time.Second, func() error {
+		if !hasSinkMetric(tb, "litefs_sink_lag_txn_count", name) {
+			return fmt.Errorf("waiting for sink")
+		}
+		return nil
+	})
+	return db
+}
+
+// hasSinkMetric returns true if the named metric exists for the database.
+func hasSinkMetric(tb testing.TB, name, db string) bool {
+	_, ok := findSinkMetric(tb, name, db)
+	return ok
+}
+
+// sinkMetricValue returns the value of the named metric for the database.
+func sinkMetricValue(tb testing.TB, name, db string) float64 {
+	v, _ := findSinkMetric(tb, name, db)
+	return v
+}
+
+// waitSinkMetricValue waits until the named metric for the database is v.
+func waitSinkMetricValue(tb testing.TB, name, db string, v float64) {
+	tb.Helper()
+	testingutil.RetryUntil(tb, 1*time.Millisecond, 5*time.Second, func() error {
+		if got := sinkMetricValue(tb, name, db); got != v {
+			return fmt.Errorf("%s=%v, want %v", name, got, v)
+		}
+		return nil
+	})
+}
+
+func findSinkMetric(tb testing.TB, name, db string) (float64, bool) {
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.Metric {
+			for _, l := range m.Label {
+				if l.GetName() == "db" && l.GetValue() == db {
+					return m.GetCounter().GetValue() + m.GetGauge().GetValue(), true
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+type secretProviderFunc func(name string) (string, error)
+
+func (fn secretProviderFunc) Secret(name string) (string, error) { return fn(name) }
+
+// writeTestCert writes a self-signed certificate & its key to dir and returns
+// their paths. The certificate can also be used as its own CA bundle.
+func writeTestCert(tb testing.TB, dir, name string) (certFile, keyFile string) {
+	tb.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certDER, err := x509.CreateCertificate(crand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600); err != nil {
+		tb.Fatal(err)
+	} else if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		tb.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
 func newMain(tb testing.TB, dir string, peer *main.Main) *main.Main {
 	tb.Helper()
 
@@ -0,0 +1,136 @@
+// go:build linux
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// DefaultMetricsDumpInterval is the default frequency of metrics dumps.
+const DefaultMetricsDumpInterval = 1 * time.Minute
+
+// MetricsConfig represents the configuration for writing metrics to a file
+// for environments without a metrics scraper.
+type MetricsConfig struct {
+	DumpFile     string        `yaml:"dump-file"`
+	DumpInterval time.Duration `yaml:"dump-interval"`
+}
+
+// metricsDump is the JSON document written to the metrics dump file.
+type metricsDump struct {
+	Timestamp string                         `json:"timestamp"`
+	Node      string                         `json:"node"`
+	ID        string                         `json:"id"`
+	Metrics   map[string][]metricsDumpSample `json:"metrics"`
+	Expvar    map[string]json.RawMessage     `json:"expvar"`
+}
+
+// metricsDumpSample is a single labeled sample. Histograms & summaries only
+// report their count & sum.
+type metricsDumpSample struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  *float64          `json:"value,omitempty"`
+	Count  *uint64           `json:"count,omitempty"`
+	Sum    *float64          `json:"sum,omitempty"`
+}
+
+// monitorMetricsDump periodically writes the metrics dump file. A final dump
+// is written on shutdown.
+func (m *Main) monitorMetricsDump(ctx context.Context) {
+	ticker := time.NewTicker(m.Config.Metrics.DumpInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := m.writeMetricsDump(); err != nil {
+				log.Printf("cannot write metrics dump: %s", err)
+			}
+			return
+		case <-ticker.C:
+		}
+
+		if err := m.writeMetricsDump(); err != nil {
+			log.Printf("cannot write metrics dump: %s", err)
+		}
+	}
+}
+
+// writeMetricsDump writes the Prometheus & expvar metrics to the dump file.
+// The file is written to a temporary path & renamed so that readers never
+// see a partial dump.
+func (m *Main) writeMetricsDump() error {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("gather metrics: %w", err)
+	}
+
+	dump := metricsDump{
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		Node:      m.Store.NodeName,
+		ID:        m.Store.ID(),
+		Metrics:   make(map[string][]metricsDumpSample, len(families)),
+		Expvar:    make(map[string]json.RawMessage),
+	}
+	for _, family := range families {
+		samples := make([]metricsDumpSample, 0, len(family.GetMetric()))
+		for _, metric := range family.GetMetric() {
+			samples = append(samples, newMetricsDumpSample(family.GetType(), metric))
+		}
+		dump.Metrics[family.GetName()] = samples
+	}
+	expvar.Do(func(kv expvar.KeyValue) {
+		if v := kv.Value.String(); json.Valid([]byte(v)) {
+			dump.Expvar[kv.Key] = json.RawMessage(v)
+		}
+	})
+
+	buf, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := m.Config.Metrics.DumpFile
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, append(buf, '\n'), 0o666); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func newMetricsDumpSample(typ dto.MetricType, metric *dto.Metric) metricsDumpSample {
+	var sample metricsDumpSample
+	if pairs := metric.GetLabel(); len(pairs) > 0 {
+		sample.Labels = make(map[string]string, len(pairs))
+		for _, pair := range pairs {
+			sample.Labels[pair.GetName()] = pair.GetValue()
+		}
+	}
+
+	switch typ {
+	case dto.MetricType_COUNTER:
+		v := metric.GetCounter().GetValue()
+		sample.Value = &v
+	case dto.MetricType_GAUGE:
+		v := metric.GetGauge().GetValue()
+		sample.Value = &v
+	case dto.MetricType_HISTOGRAM:
+		n, sum := metric.GetHistogram().GetSampleCount(), metric.GetHistogram().GetSampleSum()
+		sample.Count, sample.Sum = &n, &sum
+	case dto.MetricType_SUMMARY:
+		n, sum := metric.GetSummary().GetSampleCount(), metric.GetSummary().GetSampleSum()
+		sample.Count, sample.Sum = &n, &sum
+	default:
+		v := metric.GetUntyped().GetValue()
+		sample.Value = &v
+	}
+	return sample
+}
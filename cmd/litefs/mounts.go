@@ -0,0 +1,148 @@
+// go:build linux
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// MountsCommand represents a command to list LiteFS FUSE mounts and to remove
+// stale mounts left behind by a process that exited uncleanly.
+type MountsCommand struct {
+	// If true, stale mounts are force-unmounted.
+	Clean bool
+
+	// Path to the mount table. Defaults to "/proc/mounts".
+	MountsPath string
+
+	Stdout io.Writer
+}
+
+// NewMountsCommand returns a new instance of MountsCommand.
+func NewMountsCommand() *MountsCommand {
+	return &MountsCommand{
+		MountsPath: "/proc/mounts",
+		Stdout:     os.Stdout,
+	}
+}
+
+// ParseFlags parses the command line flags for the "mounts" command.
+func (c *MountsCommand) ParseFlags(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("litefs-mounts", flag.ContinueOnError)
+	fs.BoolVar(&c.Clean, "clean", false, "force unmount stale mounts")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: litefs mounts [-clean]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() > 0 {
+		return fmt.Errorf("too many arguments")
+	}
+	return nil
+}
+
+// Run prints the status of each LiteFS mount. A mount is stale if its FUSE
+// connection is no longer served by a process. Only mounts with the "litefs"
+// fsname are considered so unrelated FUSE mounts are never touched.
+func (c *MountsCommand) Run(ctx context.Context) error {
+	paths, err := c.readMounts()
+	if err != nil {
+		return fmt.Errorf("read mounts: %w", err)
+	}
+
+	if len(paths) == 0 {
+		fmt.Fprintln(c.Stdout, "no litefs mounts found")
+		return nil
+	}
+
+	var errN int
+	for _, path := range paths {
+		status := "connected"
+		if _, err := os.Stat(path); errors.Is(err, syscall.ENOTCONN) {
+			status = "stale"
+		}
+
+		if status == "stale" && c.Clean {
+			if err := unmountStale(path); err != nil {
+				fmt.Fprintf(c.Stdout, "%-10s %s (cannot unmount: %s)\n", status, path, err)
+				errN++
+				continue
+			}
+			status = "unmounted"
+		}
+		fmt.Fprintf(c.Stdout, "%-10s %s\n", status, path)
+	}
+
+	if errN > 0 {
+		return fmt.Errorf("cannot unmount %d stale mount(s)", errN)
+	}
+	return nil
+}
+
+// readMounts returns the mount points of all LiteFS FUSE mounts.
+func (c *MountsCommand) readMounts() ([]string, error) {
+	f, err := os.Open(c.MountsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var paths []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Each line is: fsname, mount point, type, options, dump & pass.
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+
+		fsname, path, typ := fields[0], unescapeMountPath(fields[1]), fields[2]
+		if fsname != "litefs" || (typ != "fuse" && !strings.HasPrefix(typ, "fuse.")) {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// unmountStale unmounts a disconnected mount, falling back to a lazy unmount
+// as a disconnected mount may not unmount cleanly.
+func unmountStale(path string) error {
+	if err := syscall.Unmount(path, 0); err == nil {
+		return nil
+	}
+	return syscall.Unmount(path, syscall.MNT_DETACH)
+}
+
+// unescapeMountPath decodes the octal escapes used by the kernel for spaces,
+// tabs, newlines & backslashes in mount paths.
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,112 @@
+// go:build linux
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// Access modes passed to access(2).
+const (
+	accessExec  = 0x1
+	accessWrite = 0x2
+	accessRead  = 0x4
+)
+
+// CheckDirPermissions verifies that the process can read, write & traverse
+// the data & mount directories. If fix is true, inaccessible directories are
+// chown'd to the process user & granted owner rwx before checking again.
+func (m *Main) CheckDirPermissions(fix bool) error {
+	for _, dir := range []struct {
+		name, path string
+	}{
+		{"data directory", m.Config.DataDir},
+		{"mount directory", m.Config.MountDir},
+	} {
+		if err := checkDirPermission(dir.name, dir.path, fix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkDirPermission(name, path string, fix bool) error {
+	fi, err := os.Stat(path)
+	if errors.Is(err, syscall.ENOTCONN) {
+		return nil // stale FUSE mount, unmounted before mounting again
+	} else if os.IsNotExist(err) {
+		// LiteFS creates missing directories so the nearest existing parent
+		// must be writable instead.
+		parent := filepath.Dir(path)
+		for parent != filepath.Dir(parent) {
+			if _, err := os.Stat(parent); err == nil {
+				break
+			}
+			parent = filepath.Dir(parent)
+		}
+		if err := syscall.Access(parent, accessWrite|accessExec); err != nil {
+			return fmt.Errorf("%s %q does not exist and cannot be created: %s", name, path, describeDirPermission(parent, accessWrite|accessExec))
+		}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("cannot stat %s: %w", name, err)
+	} else if !fi.IsDir() {
+		return fmt.Errorf("%s %q is not a directory", name, path)
+	}
+
+	const mode = accessRead | accessWrite | accessExec
+	if err := syscall.Access(path, mode); err == nil {
+		return nil
+	} else if !fix {
+		return fmt.Errorf("%s %q is not accessible: %s; fix ownership or restart with -fix-permissions", name, path, describeDirPermission(path, mode))
+	}
+
+	uid, gid := os.Geteuid(), os.Getegid()
+	log.Printf("fixing permissions on %s %q: chown %d:%d, chmod u+rwx", name, path, uid, gid)
+	if err := os.Chown(path, uid, gid); err != nil {
+		return fmt.Errorf("cannot fix %s ownership: %w", name, err)
+	} else if err := os.Chmod(path, fi.Mode().Perm()|0700); err != nil {
+		return fmt.Errorf("cannot fix %s mode: %w", name, err)
+	}
+
+	if err := syscall.Access(path, mode); err != nil {
+		return fmt.Errorf("%s %q is still not accessible after fixing permissions: %s", name, path, describeDirPermission(path, mode))
+	}
+	return nil
+}
+
+// describeDirPermission returns the current owner & mode of path along with
+// the access required by the process.
+func describeDirPermission(path string, mode uint32) string {
+	required := "write & traverse"
+	if mode&accessRead != 0 {
+		required = "read, write & traverse"
+	}
+	current := "unknown owner"
+	if fi, err := os.Stat(path); err == nil {
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			current = fmt.Sprintf("owner=%s group=%s mode=%s", formatUID(int(st.Uid)), formatGID(int(st.Gid)), fi.Mode())
+		}
+	}
+	return fmt.Sprintf("%q has %s, requires %s access for uid=%s gid=%s", path, current, required, formatUID(os.Geteuid()), formatGID(os.Getegid()))
+}
+
+func formatUID(uid int) string {
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		return fmt.Sprintf("%s(%d)", u.Username, uid)
+	}
+	return strconv.Itoa(uid)
+}
+
+func formatGID(gid int) string {
+	if g, err := user.LookupGroupId(strconv.Itoa(gid)); err == nil {
+		return fmt.Sprintf("%s(%d)", g.Name, gid)
+	}
+	return strconv.Itoa(gid)
+}
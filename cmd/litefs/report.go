@@ -0,0 +1,224 @@
+// go:build linux
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/superfly/litefs"
+	"github.com/superfly/litefs/consul"
+	"github.com/superfly/litefs/fuse"
+)
+
+// Startup report formats.
+const (
+	StartupReportText = "text"
+	StartupReportJSON = "json"
+)
+
+// StartupReport summarizes the settings a node started with so that an
+// operator can confirm it came up as intended.
+type StartupReport struct {
+	Version        string   `json:"version"`
+	ConfigPath     string   `json:"configPath,omitempty"`
+	ConfigOverlays []string `json:"configOverlays,omitempty"`
+	MountDir       string   `json:"mountDir"`
+	DataDir        string   `json:"dataDir"`
+	FileSystem     string   `json:"fileSystem"`
+
+	Lease     StartupLeaseReport     `json:"lease"`
+	HTTP      StartupHTTPReport      `json:"http"`
+	Retention StartupRetentionReport `json:"retention"`
+	FUSE      *StartupFUSEReport     `json:"fuse,omitempty"`
+
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// StartupLeaseReport describes how the node determines the primary.
+type StartupLeaseReport struct {
+	Type         string `json:"type"` // "consul" or "static"
+	URL          string `json:"url,omitempty"`
+	Key          string `json:"key,omitempty"`
+	Primary      bool   `json:"primary,omitempty"`
+	Hostname     string `json:"hostname,omitempty"`
+	AdvertiseURL string `json:"advertiseURL,omitempty"`
+}
+
+// StartupHTTPReport describes the API server.
+type StartupHTTPReport struct {
+	Addr string `json:"addr"`
+	URL  string `json:"url,omitempty"`
+}
+
+// StartupRetentionReport describes the LTX retention settings.
+type StartupRetentionReport struct {
+	Duration        string `json:"duration"`
+	MonitorInterval string `json:"monitorInterval"`
+	MaxCount        int    `json:"maxCount,omitempty"`
+	MaxBytes        int64  `json:"maxBytes,omitempty"`
+}
+
+// StartupFUSEReport describes the FUSE capabilities negotiated with the kernel.
+type StartupFUSEReport struct {
+	Protocol string `json:"protocol"`
+	Features string `json:"features"`
+}
+
+// StartupReport returns a report of the node's settings. Warnings are
+// included for settings that are likely unintended or unsafe.
+func (m *Main) StartupReport() StartupReport {
+	r := StartupReport{
+		Version:        versionString(),
+		ConfigPath:     m.configPath,
+		ConfigOverlays: m.configOverlays,
+		MountDir:       m.Config.MountDir,
+		DataDir:        m.Config.DataDir,
+		HTTP:           StartupHTTPReport{Addr: m.Config.HTTP.Addr},
+		Retention: StartupRetentionReport{
+			Duration:        m.Config.Retention.Duration.String(),
+			MonitorInterval: m.Config.Retention.MonitorInterval.String(),
+			MaxCount:        m.Config.Retention.MaxCount,
+			MaxBytes:        m.Config.Retention.MaxBytes,
+		},
+	}
+
+	// Describe the file system backend & its capabilities.
+	switch fsys := m.FileSystem.(type) {
+	case nil:
+		if m.Config.Observer {
+			r.FileSystem = "none (observer)"
+		} else {
+			r.FileSystem = "none"
+		}
+	case *fuse.FileSystem:
+		r.FileSystem = "fuse"
+		r.MountDir = fsys.Path()
+
+		caps := fsys.Capabilities()
+		r.FUSE = &StartupFUSEReport{Protocol: caps.Protocol, Features: caps.Features}
+		if !caps.POSIXLocks {
+			r.Warnings = append(r.Warnings, "fuse: kernel did not negotiate POSIX locks, concurrent SQLite access is unsafe")
+		}
+	default:
+		r.FileSystem = m.Config.FileSystem.Backend
+		r.MountDir = fsys.Path()
+	}
+
+	// Describe the lease, using the resolved values from the leaser.
+	switch leaser := m.Leaser.(type) {
+	case *consul.Leaser:
+		r.Lease = StartupLeaseReport{
+			Type:         "consul",
+			URL:          redactURL(m.Config.Consul.URL),
+			Key:          leaser.Key,
+			Hostname:     leaser.Hostname(),
+			AdvertiseURL: leaser.AdvertiseURL(),
+		}
+	case *litefs.StaticLeaser:
+		r.Lease = StartupLeaseReport{
+			Type:         "static",
+			Primary:      leaser.IsPrimary(),
+			Hostname:     m.Config.Static.Hostname,
+			AdvertiseURL: leaser.AdvertiseURL(),
+		}
+	}
+
+	if m.HTTPServer != nil {
+		r.HTTP.URL = m.HTTPServer.URL()
+	}
+
+	// The API is unauthenticated so warn if it is reachable from other hosts
+	// with endpoints that are not needed for replication.
+	if isUnspecifiedAddr(m.Config.HTTP.Addr) {
+		if !m.Config.HTTP.ReadOnlyAPI {
+			r.Warnings = append(r.Warnings, fmt.Sprintf("http: listening on all interfaces (%s) without authentication, consider http.read-only-api", m.Config.HTTP.Addr))
+		}
+		if m.Config.HTTP.Pprof {
+			r.Warnings = append(r.Warnings, "http: pprof is enabled on all interfaces")
+		}
+	}
+
+	if m.Config.Retention.Duration == 0 && m.Config.Retention.MaxCount == 0 && m.Config.Retention.MaxBytes == 0 {
+		r.Warnings = append(r.Warnings, "retention: LTX files are not retained, lagging replicas will require a snapshot")
+	}
+
+	return r
+}
+
+// String returns the report as a multi-line block of text.
+func (r *StartupReport) String() string {
+	var b strings.Builder
+	fmt.Fprintln(&b, "startup report:")
+	fmt.Fprintf(&b, "  version:     %s\n", r.Version)
+	if r.ConfigPath != "" {
+		fmt.Fprintf(&b, "  config:      %s\n", r.ConfigPath)
+	}
+	for _, path := range r.ConfigOverlays {
+		fmt.Fprintf(&b, "  overlay:     %s\n", path)
+	}
+	fmt.Fprintf(&b, "  mount-dir:   %s\n", r.MountDir)
+	fmt.Fprintf(&b, "  data-dir:    %s\n", r.DataDir)
+	fmt.Fprintf(&b, "  filesystem:  %s\n", r.FileSystem)
+
+	switch r.Lease.Type {
+	case "consul":
+		fmt.Fprintf(&b, "  lease:       consul url=%s key=%s\n", r.Lease.URL, r.Lease.Key)
+	case "static":
+		fmt.Fprintf(&b, "  lease:       static primary=%v\n", r.Lease.Primary)
+	}
+	fmt.Fprintf(&b, "  hostname:    %s\n", r.Lease.Hostname)
+	fmt.Fprintf(&b, "  advertise:   %s\n", r.Lease.AdvertiseURL)
+
+	fmt.Fprintf(&b, "  http:        addr=%s url=%s\n", r.HTTP.Addr, r.HTTP.URL)
+	fmt.Fprintf(&b, "  retention:   duration=%s monitor-interval=%s max-count=%d max-bytes=%d\n",
+		r.Retention.Duration, r.Retention.MonitorInterval, r.Retention.MaxCount, r.Retention.MaxBytes)
+	if r.FUSE != nil {
+		fmt.Fprintf(&b, "  fuse:        protocol=%s features=%s\n", r.FUSE.Protocol, r.FUSE.Features)
+	}
+
+	for _, warning := range r.Warnings {
+		fmt.Fprintf(&b, "  WARNING: %s\n", warning)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// logStartupReport logs the startup report once as a single entry.
+func (m *Main) logStartupReport() {
+	report := m.StartupReport()
+	if m.Config.StartupReport != StartupReportJSON {
+		log.Print(report.String())
+		return
+	}
+
+	buf, err := json.Marshal(report)
+	if err != nil {
+		log.Printf("cannot marshal startup report: %s", err)
+		return
+	}
+	log.Printf("startup report: %s", buf)
+}
+
+// versionString returns the version & commit of the binary, if available.
+func versionString() string {
+	if Version != "" {
+		return fmt.Sprintf("LiteFS %s, commit=%s", Version, Commit)
+	} else if Commit != "" {
+		return fmt.Sprintf("LiteFS commit=%s", Commit)
+	}
+	return "LiteFS development build"
+}
+
+// isUnspecifiedAddr returns true if addr listens on all interfaces.
+func isUnspecifiedAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	} else if host == "" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsUnspecified()
+}
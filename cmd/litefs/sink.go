@@ -0,0 +1,417 @@
+// go:build linux
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-shellwords"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/superfly/litefs"
+	"github.com/superfly/ltx"
+)
+
+// Sink types.
+const (
+	SinkTypeFile = "file"
+	SinkTypeHTTP = "http"
+	SinkTypeExec = "exec"
+)
+
+// Sink failure modes.
+const (
+	SinkOnFailureDrop  = "drop"
+	SinkOnFailureBlock = "block"
+)
+
+// Default sink settings.
+const (
+	DefaultSinkBufferSize    = 1024
+	DefaultSinkRetryInterval = 1 * time.Second
+	DefaultSinkMaxRetries    = 10
+	DefaultSinkHTTPTimeout   = 30 * time.Second
+)
+
+// SinkConfig represents the configuration for shipping committed LTX files
+// from the primary to an external system.
+type SinkConfig struct {
+	Type          string        `yaml:"type"`
+	Path          string        `yaml:"path"`
+	URL           string        `yaml:"url"`
+	Command       string        `yaml:"command"`
+	BufferSize    int           `yaml:"buffer-size"`
+	RetryInterval time.Duration `yaml:"retry-interval"`
+	MaxRetries    int           `yaml:"max-retries"`
+	OnFailure     string        `yaml:"on-failure"`
+
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit-breaker"`
+}
+
+// validate returns an error if the sink configuration is invalid.
+func (c *SinkConfig) validate() error {
+	switch c.Type {
+	case "":
+		return nil
+	case SinkTypeFile:
+		if c.Path == "" {
+			return fmt.Errorf("sink path required for %q sink", c.Type)
+		}
+	case SinkTypeHTTP:
+		if c.URL == "" {
+			return fmt.Errorf("sink url required for %q sink", c.Type)
+		}
+	case SinkTypeExec:
+		if c.Command == "" {
+			return fmt.Errorf("sink command required for %q sink", c.Type)
+		}
+	default:
+		return fmt.Errorf("invalid sink type: %q", c.Type)
+	}
+
+	switch c.OnFailure {
+	case SinkOnFailureDrop, SinkOnFailureBlock:
+	default:
+		return fmt.Errorf("invalid sink on-failure: %q", c.OnFailure)
+	}
+
+	if c.BufferSize <= 0 {
+		return fmt.Errorf("sink buffer-size must be greater than zero")
+	} else if c.RetryInterval < 0 {
+		return fmt.Errorf("sink retry-interval cannot be negative")
+	} else if c.MaxRetries < 0 {
+		return fmt.Errorf("sink max-retries cannot be negative")
+	}
+	return c.CircuitBreaker.validate("sink")
+}
+
+// sinkFrame is a single committed transaction waiting to be shipped.
+type sinkFrame struct {
+	name string
+	txID uint64
+	data []byte
+}
+
+// Sink ships the LTX file of each transaction committed on the primary to an
+// external target. It runs separately from replication so a slow or failing
+// target never delays replicas; frames are buffered in memory up to a bound.
+type Sink struct {
+	mu      sync.Mutex
+	queued  map[string]uint64 // last TXID read into the buffer, per database
+	shipped map[string]uint64 // last TXID delivered to the target, per database
+
+	ch      chan sinkFrame
+	client  *http.Client
+	breaker *integrationBreaker
+
+	Config SinkConfig
+	Store  *litefs.Store
+
+	// Database whose transactions are not shipped, such as the health database.
+	ExcludeDB string
+}
+
+// NewSink returns a new instance of Sink.
+func NewSink(store *litefs.Store, config SinkConfig) *Sink {
+	return &Sink{
+		queued:  make(map[string]uint64),
+		shipped: make(map[string]uint64),
+		ch:      make(chan sinkFrame, config.BufferSize),
+		client:  &http.Client{Timeout: DefaultSinkHTTPTimeout},
+		breaker: newIntegrationBreaker(IntegrationSink, config.CircuitBreaker),
+
+		Config: config,
+		Store:  store,
+	}
+}
+
+// Run reads committed transactions & ships them until ctx is canceled.
+// Transactions that exist at startup are not shipped.
+func (s *Sink) Run(ctx context.Context, sub *litefs.Subscriber) {
+	defer func() { _ = sub.Close() }()
+
+	for _, db := range s.Store.DBs() {
+		s.queued[db.Name()], s.shipped[db.Name()] = db.TXID(), db.TXID()
+	}
+
+	go s.ship(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sub.NotifyCh():
+		}
+
+		for name := range sub.DirtySet() {
+			if name == s.ExcludeDB {
+				continue
+			}
+			if err := s.enqueue(ctx, name); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// enqueue reads unshipped transactions for a database into the buffer. On a
+// replica, the position is advanced without shipping so that only
+// transactions committed after a promotion are shipped.
+func (s *Sink) enqueue(ctx context.Context, name string) error {
+	db := s.Store.DB(name)
+	if db == nil {
+		s.mu.Lock()
+		delete(s.queued, name)
+		delete(s.shipped, name)
+		s.mu.Unlock()
+		sinkLagMetricVec.DeleteLabelValues(name)
+		return nil
+	}
+
+	txID := db.TXID()
+	if !s.Store.IsPrimary() {
+		s.mu.Lock()
+		s.queued[name], s.shipped[name] = txID, txID
+		s.mu.Unlock()
+		sinkLagMetricVec.WithLabelValues(name).Set(0)
+		return nil
+	}
+
+	s.mu.Lock()
+	queued := s.queued[name]
+	s.mu.Unlock()
+
+	for id := queued + 1; id <= txID; id++ {
+		data, err := s.readLTX(db, id)
+		if err != nil {
+			log.Printf("WARNING: sink cannot read ltx file, skipping: db=%s txid=%s err=%s", name, ltx.FormatTXID(id), err)
+			sinkDropCountMetricVec.WithLabelValues(name).Inc()
+			s.setQueued(name, id)
+			continue
+		}
+
+		frame := sinkFrame{name: name, txID: id, data: data}
+		if s.Config.OnFailure == SinkOnFailureBlock {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case s.ch <- frame:
+			}
+		} else {
+			select {
+			case s.ch <- frame:
+			default:
+				log.Printf("WARNING: sink buffer full, dropping transaction: db=%s txid=%s", name, ltx.FormatTXID(id))
+				sinkDropCountMetricVec.WithLabelValues(name).Inc()
+			}
+		}
+		s.setQueued(name, id)
+		sinkBufferCountMetric.Set(float64(len(s.ch)))
+	}
+
+	s.updateLag(name, txID)
+	return nil
+}
+
+func (s *Sink) setQueued(name string, txID uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queued[name] = txID
+}
+
+func (s *Sink) updateLag(name string, txID uint64) {
+	s.mu.Lock()
+	shipped := s.shipped[name]
+	s.mu.Unlock()
+
+	if txID < shipped {
+		txID = shipped
+	}
+	sinkLagMetricVec.WithLabelValues(name).Set(float64(txID - shipped))
+}
+
+// readLTX reads the LTX file for a single transaction into memory so that it
+// remains available even if it is removed by retention before it is shipped.
+func (s *Sink) readLTX(db *litefs.DB, txID uint64) ([]byte, error) {
+	f, err := db.OpenLTXFile(txID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return io.ReadAll(f)
+}
+
+// ship delivers buffered frames to the target in order. In "block" mode a
+// frame is retried until delivered; otherwise it is dropped after the
+// configured number of retries or while the circuit breaker is open.
+func (s *Sink) ship(ctx context.Context) {
+	for {
+		var frame sinkFrame
+		select {
+		case <-ctx.Done():
+			return
+		case frame = <-s.ch:
+		}
+		sinkBufferCountMetric.Set(float64(len(s.ch)))
+
+		for attempt := 0; ; attempt++ {
+			// Skip the target while the breaker is open. Blocking sinks wait
+			// for the cooldown instead so that no transaction is lost.
+			if !s.breaker.allow() {
+				if s.Config.OnFailure != SinkOnFailureBlock {
+					sinkDropCountMetricVec.WithLabelValues(frame.name).Inc()
+					break
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(s.Config.RetryInterval):
+				}
+				continue
+			}
+
+			err := s.write(ctx, frame)
+			if err == nil {
+				s.breaker.success()
+				sinkShippedCountMetricVec.WithLabelValues(frame.name).Inc()
+				break
+			} else if ctx.Err() != nil {
+				return
+			}
+
+			s.breaker.failure()
+			sinkErrorCountMetric.Inc()
+			if s.Config.OnFailure != SinkOnFailureBlock && attempt >= s.Config.MaxRetries {
+				log.Printf("WARNING: sink failed, dropping transaction: db=%s txid=%s err=%s", frame.name, ltx.FormatTXID(frame.txID), err)
+				sinkDropCountMetricVec.WithLabelValues(frame.name).Inc()
+				break
+			}
+			log.Printf("sink failed, retrying: db=%s txid=%s attempt=%d err=%s", frame.name, ltx.FormatTXID(frame.txID), attempt+1, err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.Config.RetryInterval):
+			}
+		}
+
+		s.mu.Lock()
+		s.shipped[frame.name] = frame.txID
+		s.mu.Unlock()
+
+		if db := s.Store.DB(frame.name); db != nil {
+			s.updateLag(frame.name, db.TXID())
+		}
+	}
+}
+
+// write delivers a single frame to the configured target.
+func (s *Sink) write(ctx context.Context, frame sinkFrame) error {
+	switch s.Config.Type {
+	case SinkTypeFile:
+		return s.writeFile(frame)
+	case SinkTypeHTTP:
+		return s.writeHTTP(ctx, frame)
+	case SinkTypeExec:
+		return s.writeExec(ctx, frame)
+	default:
+		return fmt.Errorf("invalid sink type: %q", s.Config.Type)
+	}
+}
+
+// writeFile atomically writes the frame to PATH/DB/TXID.ltx.
+func (s *Sink) writeFile(frame sinkFrame) error {
+	dir := filepath.Join(s.Config.Path, frame.name)
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, ltx.FormatTXID(frame.txID)+".ltx")
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, frame.data, 0o666); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// writeHTTP posts the frame to the configured URL.
+func (s *Sink) writeHTTP(ctx context.Context, frame sinkFrame) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", s.Config.URL, bytes.NewReader(frame.data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Litefs-Db", frame.name)
+	req.Header.Set("Litefs-Txid", ltx.FormatTXID(frame.txID))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// writeExec runs the configured command with the frame on stdin. The database
+// name & TXID are passed via the LITEFS_DB & LITEFS_TXID env vars.
+func (s *Sink) writeExec(ctx context.Context, frame sinkFrame) error {
+	args, err := shellwords.Parse(s.Config.Command)
+	if err != nil {
+		return fmt.Errorf("cannot parse sink command: %w", err)
+	} else if len(args) == 0 {
+		return fmt.Errorf("sink command required")
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(),
+		"LITEFS_DB="+frame.name,
+		"LITEFS_TXID="+ltx.FormatTXID(frame.txID),
+	)
+	cmd.Stdin = bytes.NewReader(frame.data)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// Sink metrics.
+var (
+	sinkLagMetricVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "litefs_sink_lag_txn_count",
+		Help: "Number of committed transactions not yet shipped to the sink.",
+	}, []string{"db"})
+
+	sinkBufferCountMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_sink_buffer_count",
+		Help: "Number of transactions buffered in memory for the sink.",
+	})
+
+	sinkShippedCountMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_sink_shipped_count",
+		Help: "Number of transactions shipped to the sink.",
+	}, []string{"db"})
+
+	sinkDropCountMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_sink_drop_count",
+		Help: "Number of transactions dropped without being shipped to the sink.",
+	}, []string{"db"})
+
+	sinkErrorCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_sink_error_count",
+		Help: "Number of failed attempts to ship a transaction to the sink.",
+	})
+)
@@ -0,0 +1,248 @@
+// go:build linux
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/superfly/litefs"
+	"github.com/superfly/ltx"
+)
+
+// Snapshot roles. Determines which nodes take scheduled snapshots.
+const (
+	SnapshotRunOnReplica = "replica"
+	SnapshotRunOnPrimary = "primary"
+	SnapshotRunOnAny     = "any"
+)
+
+// Default snapshot settings.
+const (
+	DefaultSnapshotInterval = 1 * time.Hour
+	DefaultSnapshotRetain   = 24
+)
+
+// SnapshotManifestKey is the name of the manifest object within a database's
+// snapshot directory.
+const SnapshotManifestKey = "snapshots.json"
+
+// SnapshotsConfig represents the configuration for scheduled snapshots.
+type SnapshotsConfig struct {
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval"`
+	Retain   int           `yaml:"retain"`
+	RunOn    string        `yaml:"run-on"`
+}
+
+// SnapshotManifest lists the retained snapshots of a database, oldest first.
+type SnapshotManifest struct {
+	Snapshots []SnapshotManifestEntry `json:"snapshots"`
+}
+
+// SnapshotManifestEntry references a single snapshot object.
+type SnapshotManifestEntry struct {
+	Key       string    `json:"key"`
+	TXID      uint64    `json:"txid"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ReadSnapshotManifest reads the snapshot manifest for a database. Returns an
+// empty manifest if no snapshots exist for the database.
+func ReadSnapshotManifest(ctx context.Context, store BackupStore, name string) (*SnapshotManifest, error) {
+	rc, err := store.OpenObject(ctx, path.Join(name, SnapshotManifestKey))
+	if os.IsNotExist(err) {
+		return &SnapshotManifest{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rc.Close() }()
+
+	var manifest SnapshotManifest
+	if err := json.NewDecoder(rc).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// WriteSnapshotManifest writes the snapshot manifest for a database.
+func WriteSnapshotManifest(ctx context.Context, store BackupStore, name string, manifest *SnapshotManifest) error {
+	buf, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = store.WriteObject(ctx, path.Join(name, SnapshotManifestKey), bytes.NewReader(buf))
+	return err
+}
+
+// monitorSnapshots periodically writes a snapshot of each database. Replicas
+// are preferred so the primary is not loaded by snapshot reads, so in the
+// default "replica" mode the primary only takes snapshots while no replica
+// is connected.
+func (m *Main) monitorSnapshots(ctx context.Context) {
+	store := m.SnapshotStore()
+
+	ticker := time.NewTicker(m.Config.Snapshots.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !m.shouldSnapshot() {
+			continue
+		}
+
+		for _, db := range m.Store.DBs() {
+			if db.LocalOnly() || db.Name() == m.Config.HTTP.Health.DB {
+				continue
+			}
+
+			if err := m.SnapshotDB(ctx, store, db); err != nil {
+				log.Printf("cannot snapshot database %q: %s", db.Name(), err)
+				snapshotErrorCountMetric.Inc()
+				snapshotStatusMetricVec.WithLabelValues(db.Name()).Set(0)
+				continue
+			}
+			snapshotStatusMetricVec.WithLabelValues(db.Name()).Set(1)
+			snapshotTimeMetricVec.WithLabelValues(db.Name()).SetToCurrentTime()
+		}
+	}
+}
+
+// SnapshotStore returns the store that this node writes snapshots to. Each
+// node writes under its own node name so nodes sharing a snapshot path never
+// overwrite each other's manifests.
+func (m *Main) SnapshotStore() BackupStore {
+	return NewFileBackupStore(filepath.Join(m.Config.Snapshots.Path, m.Store.NodeName))
+}
+
+// shouldSnapshot returns true if this node should take snapshots based on
+// its current role.
+func (m *Main) shouldSnapshot() bool {
+	switch m.Config.Snapshots.RunOn {
+	case SnapshotRunOnPrimary:
+		return m.Store.IsPrimary()
+	case SnapshotRunOnAny:
+		return true
+	default:
+		return !m.Store.IsPrimary() || len(m.Store.ReplicaPosMaps()) == 0
+	}
+}
+
+// SnapshotDB writes a snapshot of db as a SQLite database file & removes
+// snapshots beyond the retention count. No snapshot is written if the
+// database has not changed since the last snapshot.
+func (m *Main) SnapshotDB(ctx context.Context, store BackupStore, db *litefs.DB) error {
+	manifest, err := ReadSnapshotManifest(ctx, store, db.Name())
+	if err != nil {
+		return fmt.Errorf("read manifest: %w", err)
+	}
+
+	txID := db.TXID()
+	if txID == 0 {
+		return nil // no data
+	} else if n := len(manifest.Snapshots); n > 0 && manifest.Snapshots[n-1].TXID == txID {
+		return nil // no changes
+	}
+
+	entry, err := m.writeSnapshot(ctx, store, db)
+	if err != nil {
+		return err
+	}
+	manifest.Snapshots = append(manifest.Snapshots, entry)
+
+	// Remove the oldest snapshots once the manifest no longer references them.
+	var removed []SnapshotManifestEntry
+	if n := len(manifest.Snapshots) - m.Config.Snapshots.Retain; n > 0 {
+		removed, manifest.Snapshots = manifest.Snapshots[:n], manifest.Snapshots[n:]
+	}
+
+	if err := WriteSnapshotManifest(ctx, store, db.Name(), manifest); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	snapshotTXIDMetricVec.WithLabelValues(db.Name()).Set(float64(entry.TXID))
+
+	for _, e := range removed {
+		if err := store.DeleteObject(ctx, e.Key); err != nil {
+			log.Printf("cannot remove snapshot %q: %s", e.Key, err)
+		}
+	}
+	return nil
+}
+
+// writeSnapshot writes the current database state to the store. The LTX
+// snapshot is staged in a temp file so database locks are not held while
+// writing to the store.
+func (m *Main) writeSnapshot(ctx context.Context, store BackupStore, db *litefs.DB) (SnapshotManifestEntry, error) {
+	f, err := os.CreateTemp(m.Store.TmpDir, "litefs-snapshot-*.ltx")
+	if err != nil {
+		return SnapshotManifestEntry{}, err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	defer func() { _ = f.Close() }()
+
+	hdr, _, err := db.WriteSnapshotTo(ctx, f)
+	if err != nil {
+		return SnapshotManifestEntry{}, fmt.Errorf("write snapshot: %w", err)
+	} else if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return SnapshotManifestEntry{}, err
+	}
+
+	// Decode into a plain SQLite file while writing so it can be restored by copying.
+	pr, pw := io.Pipe()
+	go func() {
+		_, _, err := decodeSnapshotTo(pw, f)
+		_ = pw.CloseWithError(err)
+	}()
+
+	key := path.Join(db.Name(), ltx.FormatTXID(hdr.MaxTXID)+".db")
+	n, err := store.WriteObject(ctx, key, pr)
+	_ = pr.Close()
+	if err != nil {
+		return SnapshotManifestEntry{}, fmt.Errorf("upload snapshot: %w", err)
+	}
+	snapshotBytesMetricVec.WithLabelValues(db.Name()).Add(float64(n))
+
+	return SnapshotManifestEntry{Key: key, TXID: hdr.MaxTXID, CreatedAt: time.Now()}, nil
+}
+
+// Snapshot metrics.
+var (
+	snapshotTimeMetricVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "litefs_snapshot_timestamp_seconds",
+		Help: "Time of the last successful scheduled snapshot, including runs where the database was unchanged.",
+	}, []string{"db"})
+
+	snapshotStatusMetricVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "litefs_snapshot_ok",
+		Help: "Set to 1 if the last scheduled snapshot succeeded, 0 if it failed.",
+	}, []string{"db"})
+
+	snapshotTXIDMetricVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "litefs_snapshot_txid",
+		Help: "Transaction ID of the last scheduled snapshot.",
+	}, []string{"db"})
+
+	snapshotBytesMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_snapshot_bytes",
+		Help: "Number of bytes written to scheduled snapshots.",
+	}, []string{"db"})
+
+	snapshotErrorCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_snapshot_error_count",
+		Help: "Number of failed scheduled snapshots.",
+	})
+)
@@ -0,0 +1,120 @@
+// go:build linux
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/superfly/litefs"
+)
+
+// Static primary conflict modes.
+const (
+	StaticOnConflictWarn   = "warn"
+	StaticOnConflictDemote = "demote"
+)
+
+// Default static primary conflict detection settings.
+const (
+	DefaultStaticConflictCheckInterval = 10 * time.Second
+	DefaultStaticConflictCheckTimeout  = 5 * time.Second
+)
+
+// monitorStaticConflict periodically asks each configured peer whether it
+// is also a primary. LiteFS cannot prevent two nodes from being configured
+// with "static.primary", but detecting it limits the damage of split-brain.
+func (m *Main) monitorStaticConflict(ctx context.Context, leaser *litefs.StaticLeaser) {
+	interval := m.Config.Static.ConflictCheckInterval
+	if interval <= 0 {
+		interval = DefaultStaticConflictCheckInterval
+	}
+	client := &http.Client{Timeout: DefaultStaticConflictCheckTimeout}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !leaser.IsPrimary() {
+			return // demoted
+		}
+
+		var conflict *litefs.StaticConflict
+		for _, peer := range m.Config.Static.Peers {
+			id, isPrimary, err := fetchPeerPrimary(ctx, client, peer)
+			if err != nil {
+				if m.Config.Debug {
+					log.Printf("cannot check static peer %s for primary conflict: %s", peer, err)
+				}
+				continue
+			} else if !isPrimary || id == m.Store.ID() {
+				continue
+			}
+
+			conflict = &litefs.StaticConflict{PeerURL: peer, PeerID: id, DetectedAt: time.Now()}
+			if prev := leaser.Conflict(); prev != nil && prev.PeerURL == peer {
+				conflict.DetectedAt = prev.DetectedAt
+			}
+			break
+		}
+		leaser.SetConflict(conflict)
+
+		if conflict == nil {
+			staticConflictMetric.Set(0)
+			continue
+		}
+		staticConflictMetric.Set(1)
+		log.Printf("CRITICAL: static primary conflict, peer %s (id=%s) also claims to be primary; check that only one node sets static.primary", conflict.PeerURL, conflict.PeerID)
+
+		if m.Config.Static.OnConflict == StaticOnConflictDemote {
+			log.Printf("CRITICAL: demoting this node from static primary, writes are rejected until restart")
+			leaser.Demote()
+			return
+		}
+	}
+}
+
+// fetchPeerPrimary returns the node ID & primary status reported by the
+// "/info" endpoint of a peer.
+func fetchPeerPrimary(ctx context.Context, client *http.Client, peer string) (id string, isPrimary bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(peer, "/")+"/info", nil)
+	if err != nil {
+		return "", false, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", false, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", false, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var info struct {
+		ID        string `json:"id"`
+		IsPrimary bool   `json:"isPrimary"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return "", false, fmt.Errorf("decode info: %w", err)
+	}
+	return info.ID, info.IsPrimary, nil
+}
+
+var staticConflictMetric = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "litefs_static_primary_conflict",
+	Help: "Set to 1 while another node also claims to be the static primary.",
+})
@@ -0,0 +1,67 @@
+// go:build linux
+package main
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Default vacuum settings.
+const (
+	DefaultVacuumMinFreePages = 1024
+	DefaultVacuumBusyTimeout  = 5 * time.Second
+)
+
+// monitorVacuum periodically vacuums opted-in databases while this node is
+// primary. Vacuuming goes through the mount so the resulting transaction is
+// replicated like any other write.
+func (m *Main) monitorVacuum(ctx context.Context) {
+	ticker := time.NewTicker(m.Config.Maintenance.Vacuum.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Only the primary can write so replicas receive the vacuum via replication.
+		if !m.Store.IsPrimary() {
+			continue
+		}
+
+		for _, name := range m.Config.Maintenance.Vacuum.Databases {
+			if m.Store.DB(name) == nil {
+				continue
+			}
+
+			if err := m.vacuumDB(m.Store.PrimaryCtx(ctx), name); err != nil {
+				log.Printf("cannot vacuum database %q: %s", name, err)
+			}
+		}
+	}
+}
+
+// mountedDBPath returns the path to the database within the mount.
+func (m *Main) mountedDBPath(name string) string {
+	return filepath.Join(m.Config.MountDir, filepath.FromSlash(m.Config.FUSE.Subdir), name)
+}
+
+// Vacuum metrics.
+var (
+	vacuumLastTimeMetricVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "litefs_vacuum_last_time_seconds",
+		Help: "Unix time of the last completed vacuum.",
+	}, []string{"db"})
+
+	vacuumReclaimedBytesMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_vacuum_reclaimed_bytes",
+		Help: "Number of bytes reclaimed by vacuum.",
+	}, []string{"db"})
+)
@@ -0,0 +1,17 @@
+//go:build !vacuum
+
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// VacuumSupported is true if the binary is built with the "vacuum" tag. This
+// links in the cgo SQLite driver used to run VACUUM through the mount.
+const VacuumSupported = false
+
+// vacuumDB returns an error as scheduled vacuums require the "vacuum" build tag.
+func (m *Main) vacuumDB(ctx context.Context, name string) error {
+	return fmt.Errorf("vacuum not supported, litefs must be built with the \"vacuum\" tag")
+}
@@ -0,0 +1,73 @@
+//go:build vacuum
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// VacuumSupported is true if the binary is built with the "vacuum" tag. This
+// links in the cgo SQLite driver used to run VACUUM through the mount.
+const VacuumSupported = true
+
+// vacuumDB vacuums a database if its free page count exceeds the threshold.
+func (m *Main) vacuumDB(ctx context.Context, name string) error {
+	config := m.Config.Maintenance.Vacuum
+
+	sqldb, err := sql.Open("sqlite3", fmt.Sprintf("%s?_busy_timeout=%d", m.mountedDBPath(name), DefaultVacuumBusyTimeout.Milliseconds()))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = sqldb.Close() }()
+
+	pageSize, pageN, freeN, err := vacuumStats(ctx, sqldb)
+	if err != nil {
+		return fmt.Errorf("read stats: %w", err)
+	} else if freeN < config.MinFreePages {
+		return nil
+	}
+
+	if config.Incremental {
+		log.Printf("running incremental vacuum on %q: free=%d pages", name, freeN)
+		if _, err := sqldb.ExecContext(ctx, `PRAGMA incremental_vacuum`); err != nil {
+			return fmt.Errorf("incremental vacuum: %w", err)
+		}
+	} else {
+		log.Printf("WARNING: vacuuming %q rewrites the entire database (%d bytes) which is replicated to all replicas", name, pageN*pageSize)
+		if _, err := sqldb.ExecContext(ctx, `VACUUM`); err != nil {
+			return fmt.Errorf("vacuum: %w", err)
+		}
+	}
+
+	_, newPageN, _, err := vacuumStats(ctx, sqldb)
+	if err != nil {
+		return fmt.Errorf("read stats after vacuum: %w", err)
+	}
+
+	reclaimed := (pageN - newPageN) * pageSize
+	log.Printf("vacuum of %q complete: reclaimed=%d bytes", name, reclaimed)
+
+	vacuumLastTimeMetricVec.WithLabelValues(name).Set(float64(time.Now().Unix()))
+	if reclaimed > 0 {
+		vacuumReclaimedBytesMetricVec.WithLabelValues(name).Add(float64(reclaimed))
+	}
+	return nil
+}
+
+// vacuumStats returns the page size, page count & free page count of a database.
+func vacuumStats(ctx context.Context, db *sql.DB) (pageSize, pageN, freeN int64, err error) {
+	if err := db.QueryRowContext(ctx, `PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return 0, 0, 0, err
+	} else if err := db.QueryRowContext(ctx, `PRAGMA page_count`).Scan(&pageN); err != nil {
+		return 0, 0, 0, err
+	} else if err := db.QueryRowContext(ctx, `PRAGMA freelist_count`).Scan(&freeN); err != nil {
+		return 0, 0, 0, err
+	}
+	return pageSize, pageN, freeN, nil
+}
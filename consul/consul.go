@@ -2,15 +2,24 @@ package consul
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/consul/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/superfly/litefs"
 )
 
@@ -22,12 +31,36 @@ const (
 	DefaultLockDelay   = 1 * time.Second
 )
 
+// Default election churn settings.
+const (
+	DefaultChurnWindow     = 1 * time.Minute
+	DefaultChurnThreshold  = 3
+	DefaultChurnMaxBackoff = 30 * time.Second
+
+	// Backoff applied at the threshold. It doubles for each further
+	// acquisition within the window, up to ChurnMaxBackoff.
+	churnBaseBackoff = 1 * time.Second
+)
+
+// MaxLeaseValueSize is the largest lease value that is accepted. Larger values
+// cannot have been written by LiteFS and are treated as corrupted.
+const MaxLeaseValueSize = 4096
+
+// LeaseValueVersion is the version stamp written to the lease key's value.
+// Values without a stamp were written by older, compatible versions.
+const LeaseValueVersion = 1
+
+var _ litefs.AcquireDelayer = (*Leaser)(nil)
+
 // Leaser represents an API for obtaining a distributed lock on a single key.
 type Leaser struct {
+	mu           sync.Mutex
 	consulURL    string
 	hostname     string
 	advertiseURL string
 	client       *api.Client
+	closed       bool
+	acquiredAt   []time.Time // successful acquisitions within ChurnWindow
 
 	// SessionName is the name associated with the Consul session.
 	SessionName string
@@ -35,7 +68,9 @@ type Leaser struct {
 	// Key is the Consul KV key use to acquire the lock.
 	Key string
 
-	// Prefix that is prepended to the key. Automatically set if the URL contains a path.
+	// Prefix that is prepended to the key. Automatically set if the URL contains
+	// a path. A "unix" URL path refers to the socket so the prefix must be set
+	// here instead.
 	KeyPrefix string
 
 	// TTL is the time until the lease expires.
@@ -43,6 +78,30 @@ type Leaser struct {
 
 	// LockDefault is the time after the lock expires that a new lock can be acquired.
 	LockDelay time.Duration
+
+	// If true, a lease closed after the leaser is closed destroys its session
+	// so another node can take over immediately. Otherwise, the session is
+	// left to expire via TTL which avoids flapping when the node restarts quickly.
+	ReleaseOnShutdown bool
+
+	// If true, an incompatible value found in the lease key on Open() is
+	// deleted instead of returning an error.
+	ForceTakeover bool
+
+	// Election churn is detected when this node acquires the lease at least
+	// ChurnThreshold times within ChurnWindow. This usually indicates Consul
+	// instability, such as its own leadership changes, rather than a LiteFS
+	// problem. Detection is disabled if ChurnThreshold is zero.
+	ChurnWindow    time.Duration
+	ChurnThreshold int
+
+	// Maximum time to wait before acquiring the lease while churn is detected.
+	// No backoff is applied if zero so churn is only reported.
+	ChurnMaxBackoff time.Duration
+
+	// TLS configuration for an "https" Consul URL, such as a custom CA bundle
+	// or a client certificate. Uses the system defaults if nil.
+	TLSConfig *tls.Config
 }
 
 // NewLeaser returns a new instance of Leaser.
@@ -55,6 +114,11 @@ func NewLeaser(consulURL, hostname, advertiseURL string) *Leaser {
 		Key:          DefaultKey,
 		TTL:          DefaultTTL,
 		LockDelay:    DefaultLockDelay,
+
+		ReleaseOnShutdown: true,
+
+		ChurnWindow:    DefaultChurnWindow,
+		ChurnThreshold: DefaultChurnThreshold,
 	}
 }
 
@@ -73,12 +137,29 @@ func (l *Leaser) Open() error {
 
 	config := api.DefaultConfig()
 	config.HttpClient = http.DefaultClient
+	if l.TLSConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = l.TLSConfig
+		config.HttpClient = &http.Client{Transport: transport}
+	}
 	config.Address = u.Host
 	config.Scheme = u.Scheme
 	if u.User != nil {
 		config.Token, _ = u.User.Password()
 	}
-	if v := strings.TrimPrefix(u.Path, "/"); v != "" {
+
+	// Connect over a Unix socket to a local agent if using the "unix" scheme.
+	// The path refers to the socket so it cannot be used as a key prefix.
+	if u.Scheme == "unix" {
+		if err := checkSocketPath(u.Path); err != nil {
+			return err
+		}
+		config.Address = "unix://" + u.Path
+		config.Scheme = "http"
+	} else if v := strings.TrimPrefix(u.Path, "/"); v != "" {
+		if l.KeyPrefix != "" && l.KeyPrefix != v {
+			return fmt.Errorf("consul key prefix %q conflicts with url path %q", l.KeyPrefix, v)
+		}
 		l.KeyPrefix = v
 	}
 
@@ -92,18 +173,127 @@ func (l *Leaser) Open() error {
 			Node:    nodeName,
 			Address: "localhost", // not used
 		}, nil); err != nil {
-			return fmt.Errorf("register node %q: %w", nodeName, err)
+			return fmt.Errorf("register node %q: %w", nodeName, connError(err))
 		}
 	}
 
+	if err := l.checkExistingValue(); err != nil {
+		return connError(err)
+	}
+
+	return nil
+}
+
+// connError annotates err to distinguish TLS certificate problems from Consul
+// being unreachable, as both surface as a failed request.
+func connError(err error) error {
+	var (
+		unknownAuthorityErr x509.UnknownAuthorityError
+		hostnameErr         x509.HostnameError
+		certInvalidErr      x509.CertificateInvalidError
+		recordHeaderErr     tls.RecordHeaderError
+		opErr               *net.OpError
+	)
+
+	switch {
+	case errors.As(err, &unknownAuthorityErr), errors.As(err, &hostnameErr), errors.As(err, &certInvalidErr):
+		return fmt.Errorf("consul server certificate rejected, check tls ca-file & server-name: %w", err)
+	case strings.Contains(err.Error(), "remote error: tls:"):
+		return fmt.Errorf("consul rejected the tls handshake, check tls cert-file & key-file: %w", err)
+	case errors.As(err, &recordHeaderErr), strings.Contains(err.Error(), "server gave HTTP response to HTTPS client"):
+		return fmt.Errorf("consul did not respond with tls, check the url scheme: %w", err)
+	case errors.As(err, &opErr):
+		return fmt.Errorf("consul unreachable: %w", err)
+	default:
+		return err
+	}
+}
+
+// checkExistingValue ensures that the lease key, if set, holds a value written
+// by a compatible version of LiteFS. If ForceTakeover is set then an
+// incompatible value is deleted instead.
+func (l *Leaser) checkExistingValue() error {
+	key := path.Join(l.KeyPrefix, l.Key)
+	kv, _, err := l.client.KV().Get(key, nil)
+	if err != nil {
+		return fmt.Errorf("read consul key %q: %w", key, err)
+	} else if kv == nil {
+		return nil
+	}
+
+	err = validateLeaseValue(kv.Value)
+	if err == nil {
+		return nil
+	} else if !l.ForceTakeover {
+		return fmt.Errorf("consul key %q holds an incompatible value (%s), use a different key or set force-takeover to overwrite it", key, err)
+	}
+
+	log.Printf("WARNING: consul key %q holds an incompatible value (%s), removing due to force-takeover", key, err)
+	if _, err := l.client.KV().Delete(key, nil); err != nil {
+		return fmt.Errorf("delete consul key %q: %w", key, err)
+	}
+	return nil
+}
+
+// validateLeaseValue returns an error if data is not a primary info value
+// with a supported version stamp.
+func validateLeaseValue(data []byte) error {
+	if len(data) > MaxLeaseValueSize {
+		return fmt.Errorf("value is %d bytes, exceeds %d", len(data), MaxLeaseValueSize)
+	}
+
+	var v leaseValue
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("invalid json")
+	} else if v.Hostname == "" || v.AdvertiseURL == "" {
+		return fmt.Errorf("missing primary info")
+	} else if err := v.PrimaryInfo.Validate(); err != nil {
+		return err
+	} else if v.Version > LeaseValueVersion {
+		return fmt.Errorf("unsupported version %d", v.Version)
+	}
+	return nil
+}
+
+// leaseValue is the value stored in the lease key by the primary.
+type leaseValue struct {
+	litefs.PrimaryInfo
+	Version int `json:"version,omitempty"`
+}
+
+// checkSocketPath returns an error if path does not exist or is not a socket.
+func checkSocketPath(path string) error {
+	if path == "" {
+		return fmt.Errorf("must specify a socket path for unix consul url")
+	}
+
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("consul socket not found: %s", path)
+	} else if err != nil {
+		return fmt.Errorf("cannot stat consul socket: %w", err)
+	} else if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("consul socket path is not a socket: %s", path)
+	}
 	return nil
 }
 
-// Close closes the underlying client.
+// Close marks the leaser as shutting down. Leases closed afterward follow the
+// ReleaseOnShutdown setting.
 func (l *Leaser) Close() (err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
 	return nil
 }
 
+// isClosed returns true if Close() has been called.
+func (l *Leaser) isClosed() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closed
+}
+
 // Hostname returns the hostname for this node.
 func (l *Leaser) Hostname() string {
 	return l.hostname
@@ -111,9 +301,19 @@ func (l *Leaser) Hostname() string {
 
 // AdvertiseURL returns the URL being advertised to nodes when primary.
 func (l *Leaser) AdvertiseURL() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	return l.advertiseURL
 }
 
+// SetAdvertiseURL changes the URL advertised to nodes. This takes effect the
+// next time the lease is acquired.
+func (l *Leaser) SetAdvertiseURL(v string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.advertiseURL = v
+}
+
 // NodeName returns a name for a node based on the key prefix.
 func (l *Leaser) NodeName() string {
 	if l.KeyPrefix == "" {
@@ -141,14 +341,17 @@ func (l *Leaser) Acquire(ctx context.Context) (_ litefs.Lease, retErr error) {
 	// Attempt to clean up session. It'll be removed via TTL eventually anyway though.
 	defer func() {
 		if retErr != nil {
-			_ = lease.Close()
+			_ = lease.destroy()
 		}
 	}()
 
 	// Marshal information about the primary node.
-	value, err := json.Marshal(litefs.PrimaryInfo{
-		Hostname:     l.hostname,
-		AdvertiseURL: l.advertiseURL,
+	value, err := json.Marshal(leaseValue{
+		PrimaryInfo: litefs.PrimaryInfo{
+			Hostname:     l.hostname,
+			AdvertiseURL: l.AdvertiseURL(),
+		},
+		Version: LeaseValueVersion,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("marshal lease info: %w", err)
@@ -165,9 +368,74 @@ func (l *Leaser) Acquire(ctx context.Context) (_ litefs.Lease, retErr error) {
 	} else if !acquired {
 		return nil, litefs.ErrPrimaryExists
 	}
+	l.recordAcquire(time.Now())
 	return lease, nil
 }
 
+// AcquireDelay returns the time to wait before acquiring the lease so that it
+// has a chance to settle if it has been changing hands rapidly. The store
+// waits before promoting so writes are not rejected during the backoff.
+func (l *Leaser) AcquireDelay() time.Duration {
+	d := l.churnBackoff(time.Now())
+	if d > 0 {
+		log.Printf("election churn: waiting %s before acquiring lease", d)
+	}
+	return d
+}
+
+// recordAcquire records a successful acquisition & reports election churn if
+// the lease has been acquired too often within the churn window.
+func (l *Leaser) recordAcquire(now time.Time) {
+	if l.ChurnThreshold <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pruneAcquisitions(now)
+	l.acquiredAt = append(l.acquiredAt, now)
+	if n := len(l.acquiredAt); n >= l.ChurnThreshold {
+		churnCountMetric.Inc()
+		log.Printf("election churn detected: lease acquired %d times within %s, check consul cluster stability", n, l.ChurnWindow)
+	}
+}
+
+// churnBackoff returns the time to wait before the next acquisition attempt.
+func (l *Leaser) churnBackoff(now time.Time) time.Duration {
+	if l.ChurnThreshold <= 0 || l.ChurnMaxBackoff <= 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pruneAcquisitions(now)
+
+	var d time.Duration
+	if n := len(l.acquiredAt); n >= l.ChurnThreshold {
+		d = churnBaseBackoff
+		for i := l.ChurnThreshold; i < n && d < l.ChurnMaxBackoff; i++ {
+			d *= 2
+		}
+		if d > l.ChurnMaxBackoff {
+			d = l.ChurnMaxBackoff
+		}
+	}
+	churnBackoffMetric.Set(d.Seconds())
+	return d
+}
+
+// pruneAcquisitions removes acquisitions outside the churn window.
+// Must hold l.mu.
+func (l *Leaser) pruneAcquisitions(now time.Time) {
+	i := 0
+	for i < len(l.acquiredAt) && now.Sub(l.acquiredAt[i]) >= l.ChurnWindow {
+		i++
+	}
+	l.acquiredAt = l.acquiredAt[i:]
+}
+
 // AcquireExisting acquires a lock using an existing session ID. This can occur
 // if an existing primary hands off to a replica. Returns an error if the lease
 // could not be renewed.
@@ -188,14 +456,40 @@ func (l *Leaser) PrimaryInfo(ctx context.Context) (info litefs.PrimaryInfo, err
 		return info, litefs.ErrNoPrimary
 	}
 
-	if err := json.Unmarshal(kv.Value, &info); err != nil {
-		return info, err
+	// Reject values that cannot have been written by LiteFS before decoding.
+	if len(kv.Value) > MaxLeaseValueSize {
+		return info, fmt.Errorf("%w: lease value is %d bytes, exceeds %d", litefs.ErrInvalidPrimaryInfo, len(kv.Value), MaxLeaseValueSize)
+	} else if err := json.Unmarshal(kv.Value, &info); err != nil {
+		return info, fmt.Errorf("%w: %s", litefs.ErrInvalidPrimaryInfo, err)
 	}
 	return info, nil
 }
 
+// ConfigValues returns the key/value pairs stored under prefix. Keys are
+// returned relative to the prefix. If waitIndex is non-zero then the call
+// blocks until the values change after that index. Returns the index of the
+// values so it can be passed into the next call.
+func (l *Leaser) ConfigValues(ctx context.Context, prefix string, waitIndex uint64) (map[string]string, uint64, error) {
+	prefix = path.Join(l.KeyPrefix, prefix) + "/"
+
+	opts := (&api.QueryOptions{WaitIndex: waitIndex}).WithContext(ctx)
+	pairs, meta, err := l.client.KV().List(prefix, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	m := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		if key := strings.TrimPrefix(pair.Key, prefix); key != "" {
+			m[key] = string(pair.Value)
+		}
+	}
+	return m, meta.LastIndex, nil
+}
+
 // Lease represents a distributed lock obtained by the Leaser.
 type Lease struct {
+	mu        sync.Mutex
 	leaser    *Leaser
 	sessionID string
 	renewedAt time.Time
@@ -213,7 +507,11 @@ func newLease(leaser *Leaser, sessionID string, renewedAt time.Time) *Lease {
 func (l *Lease) TTL() time.Duration { return l.leaser.TTL }
 
 // RenewedAt returns the time that the lease was created or renewed.
-func (l *Lease) RenewedAt() time.Time { return l.renewedAt }
+func (l *Lease) RenewedAt() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.renewedAt
+}
 
 // Renew attempts to reset the TTL on the lease by renewing it.
 // Returns ErrLeaseExpired if lease no longer exists.
@@ -226,12 +524,40 @@ func (l *Lease) Renew(ctx context.Context) error {
 	}
 
 	// Reset the last renewed time.
+	l.mu.Lock()
 	l.renewedAt = time.Now()
+	l.mu.Unlock()
 	return nil
 }
 
-// Close destroys the underlying session.
+// Close destroys the underlying session. If the leaser is shutting down and
+// ReleaseOnShutdown is false, the session is left to expire via TTL instead.
 func (l *Lease) Close() error {
+	if l.leaser.isClosed() {
+		if !l.leaser.ReleaseOnShutdown {
+			log.Printf("leaving consul session to expire after ttl (%s)", l.leaser.TTL)
+			return nil
+		}
+		log.Printf("releasing consul session on shutdown")
+	}
+	return l.destroy()
+}
+
+// destroy removes the underlying session from Consul.
+func (l *Lease) destroy() error {
 	_, err := l.leaser.client.Session().Destroy(l.sessionID, nil)
 	return err
 }
+
+// Consul leaser metrics.
+var (
+	churnCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_consul_election_churn_count",
+		Help: "Number of lease acquisitions made while election churn was detected.",
+	})
+
+	churnBackoffMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_consul_election_churn_backoff_seconds",
+		Help: "Backoff applied before the most recent lease acquisition attempt.",
+	})
+)
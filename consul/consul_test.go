@@ -0,0 +1,345 @@
+package consul_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/superfly/litefs/consul"
+)
+
+func TestLease_Close(t *testing.T) {
+	t.Run("ReleaseOnShutdown", func(t *testing.T) {
+		c := newFakeConsul(t)
+		leaser := newOpenLeaser(t, c)
+
+		lease, err := leaser.Acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		} else if err := leaser.Close(); err != nil {
+			t.Fatal(err)
+		} else if err := lease.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := c.Destroyed(), []string{"session-1"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("destroyed=%v, want %v", got, want)
+		}
+	})
+
+	t.Run("ExpireOnShutdown", func(t *testing.T) {
+		c := newFakeConsul(t)
+		leaser := newOpenLeaser(t, c)
+		leaser.ReleaseOnShutdown = false
+
+		lease, err := leaser.Acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		} else if err := leaser.Close(); err != nil {
+			t.Fatal(err)
+		} else if err := lease.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := c.Destroyed(); len(got) != 0 {
+			t.Fatalf("expected session to be left to expire, destroyed=%v", got)
+		}
+	})
+
+	// Ensure a lease released while running, such as on drain, is always destroyed.
+	t.Run("ReleaseWhileRunning", func(t *testing.T) {
+		c := newFakeConsul(t)
+		leaser := newOpenLeaser(t, c)
+		leaser.ReleaseOnShutdown = false
+
+		lease, err := leaser.Acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		} else if err := lease.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := c.Destroyed(), []string{"session-1"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("destroyed=%v, want %v", got, want)
+		}
+	})
+}
+
+func TestLeaser_AcquireDelay(t *testing.T) {
+	// acquire acquires the lease n times & returns the subsequent delay.
+	acquire := func(tb testing.TB, leaser *consul.Leaser, n int) time.Duration {
+		tb.Helper()
+		for i := 0; i < n; i++ {
+			if _, err := leaser.Acquire(context.Background()); err != nil {
+				tb.Fatal(err)
+			}
+		}
+		return leaser.AcquireDelay()
+	}
+
+	// Ensure the backoff doubles for each acquisition past the threshold.
+	t.Run("Backoff", func(t *testing.T) {
+		leaser := newOpenLeaser(t, newFakeConsul(t))
+		leaser.ChurnWindow, leaser.ChurnThreshold, leaser.ChurnMaxBackoff = time.Hour, 2, 3*time.Second
+
+		for i, want := range []time.Duration{0, 0, 1 * time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+			n := 1
+			if i == 0 {
+				n = 0
+			}
+			if got := acquire(t, leaser, n); got != want {
+				t.Fatalf("%d: delay=%s, want %s", i, got, want)
+			}
+		}
+	})
+
+	// Ensure acquisitions outside the window no longer count as churn.
+	t.Run("Window", func(t *testing.T) {
+		leaser := newOpenLeaser(t, newFakeConsul(t))
+		leaser.ChurnWindow, leaser.ChurnThreshold, leaser.ChurnMaxBackoff = 100*time.Millisecond, 2, time.Minute
+
+		if got, want := acquire(t, leaser, 2), 1*time.Second; got != want {
+			t.Fatalf("delay=%s, want %s", got, want)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if got := leaser.AcquireDelay(); got != 0 {
+			t.Fatalf("delay=%s, want 0", got)
+		}
+	})
+
+	// Ensure churn is only reported if there is no maximum backoff.
+	t.Run("NoMaxBackoff", func(t *testing.T) {
+		leaser := newOpenLeaser(t, newFakeConsul(t))
+		leaser.ChurnWindow, leaser.ChurnThreshold = time.Hour, 2
+
+		if got := acquire(t, leaser, 3); got != 0 {
+			t.Fatalf("delay=%s, want 0", got)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		leaser := newOpenLeaser(t, newFakeConsul(t))
+		leaser.ChurnWindow, leaser.ChurnThreshold, leaser.ChurnMaxBackoff = time.Hour, 0, time.Minute
+
+		if got := acquire(t, leaser, 3); got != 0 {
+			t.Fatalf("delay=%s, want 0", got)
+		}
+	})
+}
+
+func TestLeaser_Open_TLS(t *testing.T) {
+	// newLeaser returns a leaser connecting to u with the given TLS config.
+	newLeaser := func(u string, config *tls.Config) *consul.Leaser {
+		leaser := consul.NewLeaser(u, "node1", "http://node1:20202")
+		leaser.TLSConfig = config
+		return leaser
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		c := newFakeConsulTLS(t, tls.NoClientCert)
+		pool := x509.NewCertPool()
+		pool.AddCert(c.Certificate())
+		if err := newLeaser(c.URL, &tls.Config{RootCAs: pool}).Open(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ErrUnknownAuthority", func(t *testing.T) {
+		c := newFakeConsulTLS(t, tls.NoClientCert)
+		if err := newLeaser(c.URL, &tls.Config{}).Open(); err == nil || !strings.HasPrefix(err.Error(), "consul server certificate rejected, check tls ca-file & server-name: ") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrServerName", func(t *testing.T) {
+		c := newFakeConsulTLS(t, tls.NoClientCert)
+		pool := x509.NewCertPool()
+		pool.AddCert(c.Certificate())
+		if err := newLeaser(c.URL, &tls.Config{RootCAs: pool, ServerName: "consul.invalid"}).Open(); err == nil || !strings.HasPrefix(err.Error(), "consul server certificate rejected, check tls ca-file & server-name: ") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrClientCertRequired", func(t *testing.T) {
+		c := newFakeConsulTLS(t, tls.RequireAnyClientCert)
+		pool := x509.NewCertPool()
+		pool.AddCert(c.Certificate())
+		if err := newLeaser(c.URL, &tls.Config{RootCAs: pool}).Open(); err == nil || !strings.HasPrefix(err.Error(), "consul rejected the tls handshake, check tls cert-file & key-file: ") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrNotTLS", func(t *testing.T) {
+		c := newFakeConsul(t)
+		u := "https://" + strings.TrimPrefix(c.URL, "http://")
+		if err := newLeaser(u, &tls.Config{}).Open(); err == nil || !strings.HasPrefix(err.Error(), "consul did not respond with tls, check the url scheme: ") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestLeaser_Open_Unix(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "consul.sock")
+		c := newFakeConsulUnix(t, path)
+
+		leaser := consul.NewLeaser("unix://"+path, "node1", "http://node1:20202")
+		leaser.KeyPrefix = "myapp"
+		if err := leaser.Open(); err != nil {
+			t.Fatal(err)
+		} else if got, want := c.Keys(), []string{"myapp/litefs/primary"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("keys=%v, want %v", got, want)
+		}
+	})
+
+	t.Run("ErrSocketPathRequired", func(t *testing.T) {
+		leaser := consul.NewLeaser("unix://", "node1", "http://node1:20202")
+		if err := leaser.Open(); err == nil || err.Error() != `must specify a socket path for unix consul url` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrSocketNotFound", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "consul.sock")
+		leaser := consul.NewLeaser("unix://"+path, "node1", "http://node1:20202")
+		if err := leaser.Open(); err == nil || err.Error() != `consul socket not found: `+path {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrNotSocket", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "consul.sock")
+		if err := os.WriteFile(path, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+		leaser := consul.NewLeaser("unix://"+path, "node1", "http://node1:20202")
+		if err := leaser.Open(); err == nil || err.Error() != `consul socket path is not a socket: `+path {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestLeaser_Open_KeyPrefix(t *testing.T) {
+	t.Run("URLPath", func(t *testing.T) {
+		c := newFakeConsul(t)
+		if err := consul.NewLeaser(c.URL+"/myapp", "node1", "http://node1:20202").Open(); err != nil {
+			t.Fatal(err)
+		} else if got, want := c.Keys(), []string{"myapp/litefs/primary"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("keys=%v, want %v", got, want)
+		}
+	})
+
+	t.Run("ErrConflict", func(t *testing.T) {
+		c := newFakeConsul(t)
+		leaser := consul.NewLeaser(c.URL+"/myapp", "node1", "http://node1:20202")
+		leaser.KeyPrefix = "other"
+		if err := leaser.Open(); err == nil || err.Error() != `consul key prefix "other" conflicts with url path "myapp"` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// fakeConsul implements the subset of the Consul HTTP API used by the leaser.
+type fakeConsul struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	sessionN  int
+	destroyed []string
+	keys      []string // kv paths read
+}
+
+func newFakeConsul(tb testing.TB) *fakeConsul {
+	c := &fakeConsul{}
+	c.Server = httptest.NewServer(http.HandlerFunc(c.serveHTTP))
+	tb.Cleanup(c.Close)
+	return c
+}
+
+// newFakeConsulTLS returns a fake Consul server which only accepts TLS.
+func newFakeConsulTLS(tb testing.TB, clientAuth tls.ClientAuthType) *fakeConsul {
+	c := &fakeConsul{}
+	c.Server = httptest.NewUnstartedServer(http.HandlerFunc(c.serveHTTP))
+	c.Server.Config.ErrorLog = log.New(io.Discard, "", 0) // ignore handshake errors
+	c.Server.TLS = &tls.Config{ClientAuth: clientAuth}
+	c.StartTLS()
+	tb.Cleanup(c.Close)
+	return c
+}
+
+// newFakeConsulUnix returns a fake Consul agent listening on a Unix socket.
+func newFakeConsulUnix(tb testing.TB, path string) *fakeConsul {
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	c := &fakeConsul{}
+	c.Server = httptest.NewUnstartedServer(http.HandlerFunc(c.serveHTTP))
+	c.Listener = ln
+	c.Start()
+	tb.Cleanup(c.Close)
+	return c
+}
+
+// Keys returns the KV paths read by the leaser.
+func (c *fakeConsul) Keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.keys...)
+}
+
+// Destroyed returns the IDs of destroyed sessions.
+func (c *fakeConsul) Destroyed() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.destroyed...)
+}
+
+func (c *fakeConsul) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v1/kv/"):
+		c.keys = append(c.keys, strings.TrimPrefix(r.URL.Path, "/v1/kv/"))
+		http.NotFound(w, r) // no existing value
+	case r.Method == http.MethodPut && r.URL.Path == "/v1/catalog/register":
+		_, _ = fmt.Fprint(w, "true")
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/v1/kv/"):
+		_, _ = fmt.Fprint(w, "true")
+	case r.Method == http.MethodPut && r.URL.Path == "/v1/session/create":
+		c.sessionN++
+		_ = json.NewEncoder(w).Encode(map[string]string{"ID": fmt.Sprintf("session-%d", c.sessionN)})
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/v1/session/destroy/"):
+		c.destroyed = append(c.destroyed, strings.TrimPrefix(r.URL.Path, "/v1/session/destroy/"))
+		_, _ = fmt.Fprint(w, "true")
+	default:
+		http.Error(w, "unexpected request", http.StatusNotImplemented)
+	}
+}
+
+// newOpenLeaser returns an opened leaser connected to c.
+func newOpenLeaser(tb testing.TB, c *fakeConsul) *consul.Leaser {
+	tb.Helper()
+
+	leaser := consul.NewLeaser(c.URL, "node1", "http://node1:20202")
+	if err := leaser.Open(); err != nil {
+		tb.Fatal(err)
+	}
+	return leaser
+}
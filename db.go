@@ -7,17 +7,21 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/superfly/litefs/internal"
 	"github.com/superfly/ltx"
+	"golang.org/x/sync/errgroup"
 )
 
 // DBMode represents either a rollback journal or WAL mode.
@@ -40,11 +44,28 @@ type DB struct {
 	pos      Pos    // current tx position
 	mode     DBMode // database journaling mode (rollback, wal)
 
+	journalMode JournalMode // last detected SQLite journal mode
+
 	dirtyPageSet map[uint32]struct{}
 
 	walOffset       int64            // offset of the start of the transaction
 	walFrameOffsets map[uint32]int64 // WAL frame offset of the last version of a given pgno before current tx
 
+	// Write statistics, including transactions applied from the primary.
+	txN            atomic.Int64  // total transactions
+	pageWriteN     atomic.Int64  // total pages written
+	writeRate      atomic.Uint64 // pages written per second, as float64 bits
+	lastPageWriteN int64         // page count at the last rate update
+
+	ltxDirDirty atomic.Bool // LTX directory requires sync, if SyncModeNormal
+
+	localOnly atomic.Bool // created on a replica & never replicated
+
+	checkpointing atomic.Bool // true while a forced checkpoint is running
+
+	txInflight  atomic.Bool // true once a transaction has written while primary
+	txLeaseLost atomic.Bool // true if the lease was lost during the transaction
+
 	// SQLite database locks
 	pendingLock  RWMutex
 	sharedLock   RWMutex
@@ -91,6 +112,41 @@ func (db *DB) Path() string { return db.path }
 // LTXDir returns the path to the directory of LTX transaction files.
 func (db *DB) LTXDir() string { return filepath.Join(db.path, "ltx") }
 
+// syncLTXFile fsyncs an LTX file before it is renamed into the LTX directory.
+// This is skipped if the store's sync mode is "off".
+func (db *DB) syncLTXFile(f *os.File) error {
+	if db.store.SyncMode == SyncModeOff {
+		return nil
+	}
+	return f.Sync()
+}
+
+// syncLTXDir fsyncs the LTX directory after a file is renamed into it. In
+// "normal" mode, the directory is marked dirty & synced in batches by the store.
+func (db *DB) syncLTXDir() error {
+	switch db.store.SyncMode {
+	case SyncModeOff:
+		return nil
+	case SyncModeNormal:
+		db.ltxDirDirty.Store(true)
+		return nil
+	default:
+		return internal.Sync(db.LTXDir())
+	}
+}
+
+// flushLTXDir syncs the LTX directory if it has been marked dirty.
+func (db *DB) flushLTXDir() error {
+	if !db.ltxDirDirty.Swap(false) {
+		return nil
+	}
+	if err := internal.Sync(db.LTXDir()); err != nil {
+		db.ltxDirDirty.Store(true)
+		return err
+	}
+	return nil
+}
+
 // LTXPath returns the path of an LTX file.
 func (db *DB) LTXPath(minTXID, maxTXID uint64) string {
 	return filepath.Join(db.LTXDir(), ltx.FormatFilename(minTXID, maxTXID))
@@ -117,6 +173,15 @@ func (db *DB) ReadLTXDir() ([]fs.DirEntry, error) {
 	return ents, nil
 }
 
+// LTXTempPath returns the path used to assemble an LTX file before it is
+// atomically renamed to ltxPath. Uses the store's temp directory, if set.
+func (db *DB) LTXTempPath(ltxPath string) string {
+	if db.store.TmpDir == "" {
+		return ltxPath + ".tmp"
+	}
+	return filepath.Join(db.store.TmpDir, db.name+"-"+filepath.Base(ltxPath)+".tmp")
+}
+
 // DatabasePath returns the path to the underlying database file.
 func (db *DB) DatabasePath() string { return filepath.Join(db.path, "database") }
 
@@ -129,6 +194,18 @@ func (db *DB) WALPath() string { return filepath.Join(db.path, "wal") }
 // SHMPath returns the path to the underlying shared memory file.
 func (db *DB) SHMPath() string { return filepath.Join(db.path, "shm") }
 
+// LocalOnlyPath returns the path to the marker file for local-only databases.
+func (db *DB) LocalOnlyPath() string { return filepath.Join(db.path, "local-only") }
+
+// LocalOnly returns true if the database was shadowed on a replica. Local-only
+// databases are writable on any node but are not replicated.
+func (db *DB) LocalOnly() bool { return db.localOnly.Load() }
+
+// Writable returns true if the current node can write to the database.
+func (db *DB) Writable() bool {
+	return db.store.IsPrimary() || db.LocalOnly()
+}
+
 // PageSize returns the page size of the underlying database.
 func (db *DB) PageSize() uint32 {
 	db.mu.Lock()
@@ -136,6 +213,21 @@ func (db *DB) PageSize() uint32 {
 	return db.pageSize
 }
 
+// JournalMode returns the journal mode detected from the database's file
+// activity. Returns a blank string if the mode has not been detected yet.
+func (db *DB) JournalMode() JournalMode {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.journalMode
+}
+
+// Mode returns the journaling mode of the underlying database file.
+func (db *DB) Mode() DBMode {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.mode
+}
+
 // Pos returns the current transaction position of the database.
 func (db *DB) Pos() Pos {
 	db.mu.Lock()
@@ -170,6 +262,13 @@ func (db *DB) Open() error {
 		return err
 	}
 
+	// Load local-only flag from marker file.
+	if _, err := os.Stat(db.LocalOnlyPath()); err == nil {
+		db.localOnly.Store(true)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
 	// Read page size & page count from database file.
 	if err := db.initFromDatabaseHeader(); err != nil {
 		return fmt.Errorf("init from database header: %w", err)
@@ -193,7 +292,7 @@ func (db *DB) Open() error {
 	// Copy the WAL file back to the main database. This ensures that we can
 	// compute the checksum only using the database file instead of having to
 	// first compute the latest page set from the WAL to overlay.
-	if err := db.checkpoint(context.Background()); err != nil {
+	if err := db.checkpoint(context.Background(), false); err != nil {
 		return fmt.Errorf("checkpoint: %w", err)
 	}
 
@@ -232,6 +331,7 @@ func (db *DB) initFromDatabaseHeader() error {
 	// Initialize database mode.
 	if hdr.WriteVersion == 2 && hdr.ReadVersion == 2 {
 		db.mode = DBModeWAL
+		db.journalMode = JournalModeWAL
 	} else {
 		db.mode = DBModeRollback
 	}
@@ -302,7 +402,10 @@ func (db *DB) rollbackJournalSegment(ctx context.Context, r *JournalReader, dbFi
 	}
 }
 
-func (db *DB) checkpoint(ctx context.Context) error {
+// checkpoint copies the committed pages in the WAL into the database file.
+// The WAL is removed unless truncate is set, in which case it is truncated
+// so that SQLite connections holding it open can continue to use it.
+func (db *DB) checkpoint(ctx context.Context, truncate bool) error {
 	// Open the database file we'll checkpoint into. Skip if this hasn't been created.
 	dbFile, err := os.OpenFile(db.DatabasePath(), os.O_RDWR, 0666)
 	if os.IsNotExist(err) {
@@ -345,10 +448,21 @@ func (db *DB) checkpoint(ctx context.Context) error {
 			return fmt.Errorf("truncate: %w", err)
 		}
 
+		if err := dbFile.Sync(); err != nil {
+			return fmt.Errorf("sync db: %w", err)
+		}
+
 		// Save the size of the database, in pages, based on last commit.
 		db.pageN = commit
 	}
 
+	if truncate {
+		if err := os.Truncate(db.WALPath(), 0); err != nil {
+			return fmt.Errorf("truncate wal: %w", err)
+		}
+		return nil
+	}
+
 	// Remove WAL file.
 	if err := os.Remove(db.WALPath()); err != nil {
 		return fmt.Errorf("remove wal: %w", err)
@@ -357,6 +471,79 @@ func (db *DB) checkpoint(ctx context.Context) error {
 	return nil
 }
 
+// Checkpoint copies the committed pages in the WAL into the database file &
+// truncates the WAL. All WAL locks are held exclusively so SQLite connections
+// rebuild their WAL index afterward. The database contents & position are not
+// changed so no LTX file is written & replicas are unaffected.
+func (db *DB) Checkpoint(ctx context.Context) error {
+	guard, err := db.AcquireWriteLock(ctx)
+	if err != nil {
+		return err
+	}
+	defer guard.Unlock()
+
+	if db.mode != DBModeWAL {
+		return nil
+	}
+
+	db.mu.Lock()
+	err = db.checkpoint(ctx, true)
+	if err == nil {
+		db.walOffset = 0
+		db.walFrameOffsets = make(map[uint32]int64)
+	}
+	size := int64(db.pageN) * int64(db.pageSize)
+	db.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	// Drop cached database pages & WAL data so connections read the new state.
+	if invalidator := db.store.Invalidator; invalidator != nil {
+		if err := invalidator.InvalidateDB(db, 0, size); err != nil {
+			return fmt.Errorf("invalidate db: %w", err)
+		} else if err := invalidator.InvalidateWAL(db); err != nil {
+			return fmt.Errorf("invalidate wal: %w", err)
+		}
+	}
+	if err := db.invalidateSHM(ctx); err != nil {
+		return fmt.Errorf("invalidate shm: %w", err)
+	}
+
+	dbWALSizeMetricVec.WithLabelValues(db.name).Set(0)
+	dbCheckpointCountMetricVec.WithLabelValues(db.name).Inc()
+
+	return nil
+}
+
+// checkWALSize starts a checkpoint in the background once the WAL grows past
+// the store's MaxWALSize. It cannot run inline as the writing connection
+// holds the WAL write lock until its transaction ends.
+func (db *DB) checkWALSize(size int64) {
+	dbWALSizeMetricVec.WithLabelValues(db.name).Set(float64(size))
+
+	if max := db.store.MaxWALSize; max <= 0 || size <= max {
+		return
+	} else if !db.checkpointing.CompareAndSwap(false, true) {
+		return // checkpoint already in progress
+	}
+
+	db.store.g.Go(func() error {
+		defer db.checkpointing.Store(false)
+
+		ctx, cancel := context.WithTimeout(db.store.ctx, CheckpointTimeout)
+		defer cancel()
+
+		if err := db.Checkpoint(ctx); err != nil {
+			log.Printf("WARNING: cannot checkpoint database %q, wal size (%d bytes) exceeds max: %s", db.name, size, err)
+		} else if db.store.Debug() {
+			log.Printf("checkpointed database %q, wal size (%d bytes) exceeded max", db.name, size)
+		}
+		return nil
+	})
+}
+
 // readWALPageOffsets returns a map of the offsets of the last committed version
 // of each page in the WAL. Also returns the commit size of the last transaction.
 func (db *DB) readWALPageOffsets(f *os.File) (_ map[uint32]int64, lastCommit uint32, _ error) {
@@ -494,8 +681,8 @@ func (db *DB) WriteDatabase(f *os.File, data []byte, offset int64) error {
 	defer db.mu.Unlock()
 
 	// Return an error if the current process is not the leader.
-	if !db.store.IsPrimary() {
-		return ErrReadOnlyReplica
+	if err := db.checkTxWritable(); err != nil {
+		return err
 	} else if len(data) == 0 {
 		return nil
 	}
@@ -512,24 +699,62 @@ func (db *DB) WriteDatabase(f *os.File, data []byte, offset int64) error {
 		db.pageSize = hdr.PageSize
 	}
 
+	// Writing the first page of a rollback transaction without a journal means
+	// SQLite is using journal_mode=OFF or MEMORY. LiteFS detects transactions
+	// by the journal so these writes can never be replicated.
+	if db.mode == DBModeRollback && len(db.dirtyPageSet) == 0 {
+		if _, err := os.Stat(db.JournalPath()); os.IsNotExist(err) {
+			if err := db.detectUnsupportedJournalMode(); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+
 	// Mark page as dirty.
 	pgno := uint32(offset/int64(db.pageSize)) + 1
 	db.dirtyPageSet[pgno] = struct{}{}
 
 	// Callback to perform write on handle.
 	if _, err := f.WriteAt(data, offset); err != nil {
-		return err
+		return db.store.handleNoSpace(err)
 	}
 
 	dbDatabaseWriteCountMetricVec.WithLabelValues(db.name).Inc()
 	return nil
 }
 
+// detectUnsupportedJournalMode records that the database is being written
+// without a journal. Returns an error if the store rejects these writes.
+func (db *DB) detectUnsupportedJournalMode() error {
+	if db.journalMode != JournalModeOff {
+		log.Printf("WARNING: database %q is being written without a journal (journal_mode=OFF or MEMORY), changes will not be replicated", db.name)
+		db.journalMode = JournalModeOff
+	}
+
+	if db.store.StrictJournalMode {
+		return ErrUnsupportedJournalMode
+	}
+	return nil
+}
+
 // CreateJournal creates a new journal file on disk.
 func (db *DB) CreateJournal() (*os.File, error) {
-	if !db.store.IsPrimary() {
-		return nil, ErrReadOnlyReplica
+	if !db.Writable() {
+		db.store.waitPrimary()
 	}
+
+	if !db.Writable() {
+		return nil, db.store.readOnlyError()
+	} else if err := db.store.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	// A new journal begins a new transaction. Clear any state left by a
+	// transaction that rolled back without its journal being committed.
+	db.endTx()
+
 	return os.OpenFile(db.JournalPath(), os.O_RDWR|os.O_CREATE|os.O_EXCL|os.O_TRUNC, 0666)
 }
 
@@ -541,12 +766,48 @@ func (db *DB) CreateWAL() (*os.File, error) {
 // WriteWAL writes data to the WAL file. On final commit write, an LTX file is
 // generated for the transaction.
 func (db *DB) WriteWAL(f *os.File, data []byte, offset int64) error {
+	// Wait for an election to finish outside the lock before a new
+	// transaction begins, if enabled.
+	if !db.Writable() && !db.txInflight.Load() {
+		db.store.waitPrimary()
+	}
+
 	db.mu.Lock()
-	defer db.mu.Unlock()
+	prevTXID := db.pos.TXID
+	err := db.writeWAL(f, data, offset)
+	txID, walSize := db.pos.TXID, db.walOffset
+	db.mu.Unlock()
+
+	if err != nil {
+		return db.store.handleNoSpace(err)
+	} else if txID == prevTXID {
+		return nil // no commit
+	}
+
+	db.checkWALSize(walSize)
+
+	// Wait for replicas outside the lock so that the stream can read the position.
+	return db.store.waitForQuorum(db.name, txID)
+}
+
+func (db *DB) writeWAL(f *os.File, data []byte, offset int64) error {
+	// A write at the end of the last transaction begins a new one. WAL
+	// transactions can roll back without a commit so reset the state here.
+	if offset <= db.walOffset {
+		db.endTx()
+	}
+
+	// Pause new transactions while the disk is full or the store is shutting
+	// down. Frames of a transaction already in progress are allowed to finish.
+	if !db.txInflight.Load() {
+		if err := db.store.checkWritable(); err != nil {
+			return err
+		}
+	}
 
 	// Return an error if the current process is not the leader.
-	if !db.store.IsPrimary() {
-		return ErrReadOnlyReplica
+	if err := db.checkTxWritable(); err != nil {
+		return err
 	} else if len(data) == 0 {
 		return nil
 	}
@@ -585,7 +846,9 @@ func (db *DB) WriteWAL(f *os.File, data []byte, offset int64) error {
 		return nil // not a commit frame, exit
 	}
 
-	if err := db.commitWAL(f, commit); err != nil {
+	err := db.commitWAL(f, commit)
+	db.endTx()
+	if err != nil {
 		return fmt.Errorf("commit wal: %w", err)
 	}
 	return nil
@@ -615,7 +878,8 @@ func (db *DB) buildTxFrameOffsets(walFile *os.File) (map[uint32]int64, error) {
 
 // commitWAL is called on the last write to the WAL page in a transaction.
 // The transaction data is copied from the WAL into an LTX file and committed.
-func (db *DB) commitWAL(walFile *os.File, commit uint32) error {
+func (db *DB) commitWAL(walFile *os.File, commit uint32) (err error) {
+	startTime := time.Now()
 	walFrameSize := int64(WALFrameHeaderSize + db.pageSize)
 
 	// Sync WAL to disk as this avoids data loss issues with SYNCHRONOUS=normal
@@ -652,7 +916,7 @@ func (db *DB) commitWAL(walFile *os.File, commit uint32) error {
 
 	// Open file descriptors for the header & page blocks for new LTX file.
 	ltxPath := db.LTXPath(txID, txID)
-	tmpPath := ltxPath + ".tmp"
+	tmpPath := db.LTXTempPath(ltxPath)
 	_ = os.Remove(tmpPath)
 
 	f, err := os.Create(tmpPath)
@@ -661,6 +925,14 @@ func (db *DB) commitWAL(walFile *os.File, commit uint32) error {
 	}
 	defer func() { _ = f.Close() }()
 
+	// A partial LTX file is never renamed into place. Remove it on failure so
+	// it does not hold onto disk space, such as when the disk is full.
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
 	enc := ltx.NewEncoder(f)
 	if err := enc.EncodeHeader(ltx.Header{
 		Version:          1,
@@ -670,7 +942,7 @@ func (db *DB) commitWAL(walFile *os.File, commit uint32) error {
 		MaxTXID:          txID,
 		PreApplyChecksum: preApplyChecksum,
 	}); err != nil {
-		return fmt.Errorf("cannot encode ltx header: %s", err)
+		return fmt.Errorf("cannot encode ltx header: %w", err)
 	}
 
 	// Build sorted list of page numbers in current transaction.
@@ -735,17 +1007,17 @@ func (db *DB) commitWAL(walFile *os.File, commit uint32) error {
 	// Finish page block to compute checksum and then finish header block.
 	enc.SetPostApplyChecksum(postApplyChecksum)
 	if err := enc.Close(); err != nil {
-		return fmt.Errorf("close ltx encoder: %s", err)
-	} else if err := f.Sync(); err != nil {
-		return fmt.Errorf("sync ltx file: %s", err)
+		return fmt.Errorf("close ltx encoder: %w", err)
+	} else if err := db.syncLTXFile(f); err != nil {
+		return fmt.Errorf("sync ltx file: %w", err)
 	} else if err := f.Close(); err != nil {
-		return fmt.Errorf("close ltx file: %s", err)
+		return fmt.Errorf("close ltx file: %w", err)
 	}
 
 	// Atomically rename the file
 	if err := os.Rename(tmpPath, ltxPath); err != nil {
 		return fmt.Errorf("rename ltx file: %w", err)
-	} else if err := internal.Sync(filepath.Dir(ltxPath)); err != nil {
+	} else if err := db.syncLTXDir(); err != nil {
 		return fmt.Errorf("sync ltx dir: %w", err)
 	}
 
@@ -756,6 +1028,7 @@ func (db *DB) commitWAL(walFile *os.File, commit uint32) error {
 
 	db.pageN = commit
 	db.walOffset = maxOffset + walFrameSize
+	db.journalMode = JournalModeWAL
 
 	// Update transaction for database.
 	if err := db.setPos(Pos{
@@ -767,13 +1040,14 @@ func (db *DB) commitWAL(walFile *os.File, commit uint32) error {
 
 	// Update metrics
 	dbCommitCountMetricVec.WithLabelValues(db.name).Inc()
+	dbCommitDurationMetricVec.WithLabelValues(db.name).Observe(time.Since(startTime).Seconds())
 	dbLTXCountMetricVec.WithLabelValues(db.name).Inc()
 	dbLTXBytesMetricVec.WithLabelValues(db.name).Set(float64(enc.N()))
+	db.recordTx(len(pgnos))
 
 	// Notify store of database change.
 	db.store.MarkDirty(db.name)
-
-	return nil
+	db.store.publishTxEvent(db.name, db.pos)
 
 	return nil
 }
@@ -812,20 +1086,25 @@ func (db *DB) WriteSHM(f *os.File, data []byte, offset int64) (int, error) {
 
 // WriteJournal writes data to the rollback journal file.
 func (db *DB) WriteJournal(f *os.File, data []byte, offset int64) error {
-	if !db.store.IsPrimary() {
-		return ErrReadOnlyReplica
+	if err := db.checkTxWritable(); err != nil {
+		return err
 	}
 
 	// Assume this is a PERSIST commit if the initial header bytes are cleared.
+	// Otherwise a header write starts a new transaction so pause while full.
 	if offset == 0 && len(data) == SQLITE_DATABASE_SIZE_OFFSET && isByteSliceZero(data) {
 		if err := db.CommitJournal(JournalModePersist); err != nil {
 			return fmt.Errorf("commit journal (PERSIST): %w", err)
 		}
+	} else if offset == 0 {
+		if err := db.store.checkWritable(); err != nil {
+			return err
+		}
 	}
 
 	_, err := f.WriteAt(data, offset)
 	dbJournalWriteCountMetricVec.WithLabelValues(db.name).Inc()
-	return err
+	return db.store.handleNoSpace(err)
 }
 
 // isByteSliceZero returns true if b only contains NULL bytes.
@@ -841,11 +1120,29 @@ func isByteSliceZero(b []byte) bool {
 // CommitJournal deletes the journal file which commits or rolls back the transaction.
 func (db *DB) CommitJournal(mode JournalMode) error {
 	db.mu.Lock()
-	defer db.mu.Unlock()
+	prevTXID := db.pos.TXID
+	err := db.commitJournal(mode)
+	txID := db.pos.TXID
+	db.endTx() // commit or rollback, the transaction is no longer in flight
+	db.mu.Unlock()
 
-	// Return an error if the current process is not the leader.
-	if !db.store.IsPrimary() {
-		return ErrReadOnlyReplica
+	if err != nil {
+		return db.store.handleNoSpace(err)
+	} else if txID == prevTXID {
+		return nil // rollback or empty transaction
+	}
+
+	// Wait for replicas outside the lock so that the stream can read the position.
+	return db.store.waitForQuorum(db.name, txID)
+}
+
+func (db *DB) commitJournal(mode JournalMode) (err error) {
+	startTime := time.Now()
+
+	// Return an error if the current process is not the leader. The lease is
+	// checked again at commit time in case it was lost mid-transaction.
+	if err := db.checkTxWritable(); err != nil {
+		return err
 	}
 
 	// Read journal header to ensure it's valid.
@@ -909,7 +1206,7 @@ func (db *DB) CommitJournal(mode JournalMode) error {
 
 	// Open file descriptors for the header & page blocks for new LTX file.
 	ltxPath := db.LTXPath(txID, txID)
-	tmpPath := ltxPath + ".tmp"
+	tmpPath := db.LTXTempPath(ltxPath)
 	_ = os.Remove(tmpPath)
 
 	f, err := os.Create(tmpPath)
@@ -918,6 +1215,14 @@ func (db *DB) CommitJournal(mode JournalMode) error {
 	}
 	defer func() { _ = f.Close() }()
 
+	// A partial LTX file is never renamed into place. Remove it on failure so
+	// it does not hold onto disk space, such as when the disk is full.
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
 	enc := ltx.NewEncoder(f)
 	if err := enc.EncodeHeader(ltx.Header{
 		Version:          1,
@@ -927,7 +1232,7 @@ func (db *DB) CommitJournal(mode JournalMode) error {
 		MaxTXID:          txID,
 		PreApplyChecksum: preApplyChecksum,
 	}); err != nil {
-		return fmt.Errorf("cannot encode ltx header: %s", err)
+		return fmt.Errorf("cannot encode ltx header: %w", err)
 	}
 
 	// Copy transactions from main database to the LTX file in sorted order.
@@ -991,17 +1296,17 @@ func (db *DB) CommitJournal(mode JournalMode) error {
 	// Finish page block to compute checksum and then finish header block.
 	enc.SetPostApplyChecksum(postApplyChecksum)
 	if err := enc.Close(); err != nil {
-		return fmt.Errorf("close ltx encoder: %s", err)
-	} else if err := f.Sync(); err != nil {
-		return fmt.Errorf("sync ltx file: %s", err)
+		return fmt.Errorf("close ltx encoder: %w", err)
+	} else if err := db.syncLTXFile(f); err != nil {
+		return fmt.Errorf("sync ltx file: %w", err)
 	} else if err := f.Close(); err != nil {
-		return fmt.Errorf("close ltx file: %s", err)
+		return fmt.Errorf("close ltx file: %w", err)
 	}
 
 	// Atomically rename the file
 	if err := os.Rename(tmpPath, ltxPath); err != nil {
 		return fmt.Errorf("rename ltx file: %w", err)
-	} else if err := internal.Sync(filepath.Dir(ltxPath)); err != nil {
+	} else if err := db.syncLTXDir(); err != nil {
 		return fmt.Errorf("sync ltx dir: %w", err)
 	}
 
@@ -1017,6 +1322,10 @@ func (db *DB) CommitJournal(mode JournalMode) error {
 	// Update database flags.
 	db.pageN = commit
 	db.mode = dbMode
+	db.journalMode = mode
+	if dbMode == DBModeWAL {
+		db.journalMode = JournalModeWAL
+	}
 
 	// Update transaction for database.
 	if err := db.setPos(Pos{
@@ -1028,11 +1337,14 @@ func (db *DB) CommitJournal(mode JournalMode) error {
 
 	// Update metrics
 	dbCommitCountMetricVec.WithLabelValues(db.name).Inc()
+	dbCommitDurationMetricVec.WithLabelValues(db.name).Observe(time.Since(startTime).Seconds())
 	dbLTXCountMetricVec.WithLabelValues(db.name).Inc()
 	dbLTXBytesMetricVec.WithLabelValues(db.name).Set(float64(enc.N()))
+	db.recordTx(len(pgnos))
 
 	// Notify store of database change.
 	db.store.MarkDirty(db.name)
+	db.store.publishTxEvent(db.name, db.pos)
 
 	return nil
 }
@@ -1097,7 +1409,15 @@ func (db *DB) invalidateJournal(mode JournalMode) error {
 }
 
 // ApplyLTX applies an LTX file to the database.
+//
+// Pages are written by a pool of Store.ApplyConcurrency workers, if greater
+// than one. Each page number appears once in an LTX file so writes within a
+// transaction are independent. All writes complete before the file is synced
+// & the position is advanced, so transactions are still applied in order and
+// the checksum chain is verified as before.
 func (db *DB) ApplyLTX(ctx context.Context, path string) error {
+	t := time.Now()
+
 	guard, err := db.AcquireWriteLock(ctx)
 	if err != nil {
 		return err
@@ -1124,31 +1444,39 @@ func (db *DB) ApplyLTX(ctx context.Context, path string) error {
 	}
 
 	dbMode := db.mode
-	pageBuf := make([]byte, dec.Header().PageSize)
-	for i := 0; ; i++ {
-		// Read pgno & page data from LTX file.
-		var phdr ltx.PageHeader
-		if err := dec.DecodePage(&phdr, pageBuf); err == io.EOF {
-			break
-		} else if err != nil {
-			return fmt.Errorf("decode ltx page[%d]: %w", i, err)
+	var pageN int
+	if n := db.store.ApplyConcurrency; n > 1 {
+		if dbMode, pageN, err = db.applyLTXPagesParallel(dbf, dec, dbMode, n); err != nil {
+			return err
 		}
+	} else {
+		pageBuf := make([]byte, dec.Header().PageSize)
+		for i := 0; ; i++ {
+			// Read pgno & page data from LTX file.
+			var phdr ltx.PageHeader
+			if err := dec.DecodePage(&phdr, pageBuf); err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("decode ltx page[%d]: %w", i, err)
+			}
 
-		// Update the mode if this is the first page and the write/read versions as set to WAL (2).
-		if phdr.Pgno == 1 && pageBuf[18] == 2 && pageBuf[19] == 2 {
-			dbMode = DBModeWAL
-		}
+			// Update the mode if this is the first page and the write/read versions as set to WAL (2).
+			if phdr.Pgno == 1 && pageBuf[18] == 2 && pageBuf[19] == 2 {
+				dbMode = DBModeWAL
+			}
 
-		// Copy to database file.
-		offset := int64(phdr.Pgno-1) * int64(dec.Header().PageSize)
-		if _, err := dbf.WriteAt(pageBuf, offset); err != nil {
-			return fmt.Errorf("write to database file: %w", err)
-		}
+			// Copy to database file.
+			offset := int64(phdr.Pgno-1) * int64(dec.Header().PageSize)
+			if _, err := dbf.WriteAt(pageBuf, offset); err != nil {
+				return fmt.Errorf("write to database file: %w", err)
+			}
+			pageN++
 
-		// Invalidate page cache.
-		if invalidator := db.store.Invalidator; invalidator != nil {
-			if err := invalidator.InvalidateDB(db, offset, int64(len(pageBuf))); err != nil {
-				return fmt.Errorf("invalidate db: %w", err)
+			// Invalidate page cache.
+			if invalidator := db.store.Invalidator; invalidator != nil {
+				if err := invalidator.InvalidateDB(db, offset, int64(len(pageBuf))); err != nil {
+					return fmt.Errorf("invalidate db: %w", err)
+				}
 			}
 		}
 	}
@@ -1190,12 +1518,176 @@ func (db *DB) ApplyLTX(ctx context.Context, path string) error {
 		return fmt.Errorf("invalidate shm: %w", err)
 	}
 
+	db.recordTx(pageN)
+	dbApplyPageCountMetricVec.WithLabelValues(db.name).Add(float64(pageN))
+	dbApplyDurationMetricVec.WithLabelValues(db.name).Observe(time.Since(t).Seconds())
+
 	// Notify store of database change.
 	db.store.MarkDirty(db.name)
+	db.store.publishTxEvent(db.name, db.pos)
 
 	return nil
 }
 
+// applyLTXPagesParallel decodes pages from dec & writes them to dbf using n
+// workers. Decoding remains sequential so the LTX file checksum is still
+// computed over the pages in order. Returns the updated database mode & the
+// number of pages written.
+func (db *DB) applyLTXPagesParallel(dbf *os.File, dec *ltx.Decoder, dbMode DBMode, n int) (DBMode, int, error) {
+	pageSize := dec.Header().PageSize
+
+	type pageWrite struct {
+		offset int64
+		data   []byte
+	}
+
+	// Page buffers are recycled through a free list so memory stays bounded
+	// by the number of pages in flight.
+	ch := make(chan pageWrite, n)
+	free := make(chan []byte, 2*n)
+	for i := 0; i < cap(free); i++ {
+		free <- make([]byte, pageSize)
+	}
+
+	var g errgroup.Group
+	done := make(chan struct{})
+	var closeDone sync.Once
+	for i := 0; i < n; i++ {
+		g.Go(func() error {
+			for w := range ch {
+				_, err := dbf.WriteAt(w.data, w.offset)
+				free <- w.data
+				if err != nil {
+					closeDone.Do(func() { close(done) })
+					return fmt.Errorf("write to database file: %w", err)
+				}
+			}
+			return nil
+		})
+	}
+
+	var offsets []int64
+	err := func() error {
+		defer close(ch)
+
+		for i := 0; ; i++ {
+			var buf []byte
+			select {
+			case <-done:
+				return nil // worker error is returned by the group
+			case buf = <-free:
+			}
+
+			// Read pgno & page data from LTX file.
+			var phdr ltx.PageHeader
+			if err := dec.DecodePage(&phdr, buf); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("decode ltx page[%d]: %w", i, err)
+			}
+
+			// Update the mode if this is the first page and the write/read versions as set to WAL (2).
+			if phdr.Pgno == 1 && buf[18] == 2 && buf[19] == 2 {
+				dbMode = DBModeWAL
+			}
+
+			w := pageWrite{offset: int64(phdr.Pgno-1) * int64(pageSize), data: buf}
+			select {
+			case <-done:
+				return nil
+			case ch <- w:
+			}
+			offsets = append(offsets, w.offset)
+		}
+	}()
+	if gerr := g.Wait(); err == nil {
+		err = gerr
+	}
+	if err != nil {
+		return dbMode, 0, err
+	}
+
+	// Invalidate page cache once all pages are written.
+	if invalidator := db.store.Invalidator; invalidator != nil {
+		for _, offset := range offsets {
+			if err := invalidator.InvalidateDB(db, offset, int64(pageSize)); err != nil {
+				return dbMode, 0, fmt.Errorf("invalidate db: %w", err)
+			}
+		}
+	}
+
+	return dbMode, len(offsets), nil
+}
+
+// WriteStats represents cumulative write counters for a database.
+type WriteStats struct {
+	TXN        int64   // total transactions committed or applied
+	PageWriteN int64   // total pages written by transactions
+	WriteRate  float64 // pages written per second, over the last interval
+}
+
+// WriteStats returns the write counters for the database.
+func (db *DB) WriteStats() WriteStats {
+	return WriteStats{
+		TXN:        db.txN.Load(),
+		PageWriteN: db.pageWriteN.Load(),
+		WriteRate:  math.Float64frombits(db.writeRate.Load()),
+	}
+}
+
+// recordTx updates write counters after a transaction of pageN pages.
+func (db *DB) recordTx(pageN int) {
+	db.txN.Add(1)
+	db.pageWriteN.Add(int64(pageN))
+	dbTXCountMetricVec.WithLabelValues(db.name).Inc()
+	dbPageWriteCountMetricVec.WithLabelValues(db.name).Add(float64(pageN))
+}
+
+// updateWriteRate computes the page write rate since the last update, which
+// occurred interval ago. Must only be called by the store's rate monitor.
+func (db *DB) updateWriteRate(interval time.Duration) {
+	n := db.pageWriteN.Load()
+	rate := float64(n-db.lastPageWriteN) / interval.Seconds()
+	db.lastPageWriteN = n
+
+	db.writeRate.Store(math.Float64bits(rate))
+	dbWriteRateMetricVec.WithLabelValues(db.name).Set(rate)
+}
+
+// checkTxWritable returns nil if the current transaction can continue. If the
+// lease was lost after the transaction began writing, the store's OnLeaseLoss
+// mode determines whether the transaction is aborted or completed locally.
+func (db *DB) checkTxWritable() error {
+	if db.Writable() {
+		db.txInflight.Store(true)
+		return nil
+	} else if !db.txInflight.Load() {
+		return db.store.readOnlyError()
+	}
+
+	// Report once per transaction as this is checked on every write.
+	mode := db.store.OnLeaseLoss
+	if !db.txLeaseLost.Swap(true) {
+		dbLeaseLossCountMetricVec.WithLabelValues(db.name, string(mode)).Inc()
+		if mode == LeaseLossComplete {
+			log.Printf("WARNING: lease lost during transaction on %q, completing locally; the transaction will not be replicated", db.name)
+		} else {
+			log.Printf("lease lost during transaction on %q, aborting", db.name)
+		}
+	}
+
+	if mode == LeaseLossComplete {
+		return nil
+	}
+	return ErrReadOnlyReplica
+}
+
+// endTx clears the transaction state after a commit or rollback.
+func (db *DB) endTx() {
+	db.txInflight.Store(false)
+	db.txLeaseLost.Store(false)
+}
+
 // invalidateSHM clears the SHM header so that SQLite needs to rebuild it.
 func (db *DB) invalidateSHM(ctx context.Context) error {
 	f, err := os.OpenFile(db.SHMPath(), os.O_RDWR, 0666)
@@ -1406,46 +1898,469 @@ func (db *DB) WriteSnapshotTo(ctx context.Context, dst io.Writer) (header ltx.He
 
 // EnforceRetention removes all LTX files created before minTime.
 func (db *DB) EnforceRetention(ctx context.Context, minTime time.Time) error {
-	// Collect all LTX files.
-	ents, err := db.ReadLTXDir()
+	_, err := db.enforceRetention(ctx, minTime, db.store.RetentionMaxCount, db.store.RetentionMaxBytes)
+	return err
+}
+
+// enforceRetention removes LTX files modified before minTime. If maxCount or
+// maxBytes are non-zero then a file is only removed once at least that many
+// newer files, or bytes of newer files, are retained. The latest LTX file is
+// always kept.
+func (db *DB) enforceRetention(ctx context.Context, minTime time.Time, maxCount int, maxBytes int64) (ret RetentionResult, err error) {
+	removed, retained, err := db.planRetention(minTime, maxCount, maxBytes)
 	if err != nil {
-		return fmt.Errorf("read ltx dir: %w", err)
-	} else if len(ents) == 0 {
-		return nil // no LTX files, exit
+		return ret, err
 	}
 
-	// Ensure the latest LTX file is not removed.
-	ents = ents[:len(ents)-1]
-
-	// Delete all files that are before the minimum time.
-	var totalN int
-	var totalSize int64
-	for _, ent := range ents {
-		// Check if file qualifies for deletion.
-		fi, err := ent.Info()
-		if err != nil {
-			return fmt.Errorf("info: %w", err)
-		} else if fi.ModTime().After(minTime) {
-			totalN++
-			totalSize += fi.Size()
-			continue // after minimum time, skip
+	for _, fi := range removed {
+		if db.store.Debug() {
+			reason := fmt.Sprintf("modified before %s", minTime.Format(time.RFC3339))
+			if maxCount > 0 {
+				reason += fmt.Sprintf(", beyond max-count (%d)", maxCount)
+			}
+			if maxBytes > 0 {
+				reason += fmt.Sprintf(", beyond max-bytes (%d)", maxBytes)
+			}
+			log.Printf("retention: removing %s/%s: %s", db.name, fi.Name(), reason)
 		}
 
 		// Remove file if it passes all the checks.
-		filename := filepath.Join(db.LTXDir(), ent.Name())
+		filename := filepath.Join(db.LTXDir(), fi.Name())
 		if err := os.Remove(filename); err != nil {
-			return err
+			return ret, err
 		}
+		ret.FileN++
+		ret.Size += fi.Size()
 
 		// Update metrics.
 		dbLTXReapCountMetricVec.WithLabelValues(db.name).Inc()
 	}
 
 	// Reset metrics for LTX disk usage.
-	dbLTXCountMetricVec.WithLabelValues(db.name).Set(float64(totalN))
-	dbLTXBytesMetricVec.WithLabelValues(db.name).Set(float64(totalSize))
+	var retainedSize int64
+	for _, fi := range retained {
+		retainedSize += fi.Size()
+	}
+	dbLTXCountMetricVec.WithLabelValues(db.name).Set(float64(len(retained)))
+	dbLTXBytesMetricVec.WithLabelValues(db.name).Set(float64(retainedSize))
 
-	return nil
+	return ret, nil
+}
+
+// planRetention returns the LTX files a retention pass would remove & retain,
+// each ordered oldest first. See enforceRetention() for the policy.
+func (db *DB) planRetention(minTime time.Time, maxCount int, maxBytes int64) (removed, retained []fs.FileInfo, err error) {
+	ents, err := db.ReadLTXDir()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read ltx dir: %w", err)
+	}
+
+	// Walk from newest to oldest so the count & size of retained files is known.
+	var totalSize int64
+	for i := len(ents) - 1; i >= 0; i-- {
+		// Check if file qualifies for deletion. Ensure the latest is not removed.
+		fi, err := ents[i].Info()
+		if err != nil {
+			return nil, nil, fmt.Errorf("info: %w", err)
+		} else if i == len(ents)-1 || fi.ModTime().After(minTime) || len(retained) < maxCount || totalSize < maxBytes {
+			retained = append(retained, fi)
+			totalSize += fi.Size()
+			continue
+		}
+		removed = append(removed, fi)
+	}
+
+	reverseFileInfos(removed)
+	reverseFileInfos(retained)
+	return removed, retained, nil
+}
+
+// PreviewRetention reports the LTX files that a retention pass with the given
+// policy would remove, without removing them.
+func (db *DB) PreviewRetention(minTime time.Time, maxCount int, maxBytes int64) (*RetentionPreview, error) {
+	removed, retained, err := db.planRetention(minTime, maxCount, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &RetentionPreview{Name: db.name, RetainedN: len(retained)}
+	for _, fi := range removed {
+		preview.Files = append(preview.Files, fi.Name())
+		preview.Size += fi.Size()
+	}
+	for _, fi := range retained {
+		preview.RetainedSize += fi.Size()
+	}
+
+	// Report the earliest transaction a replica can still catch up from.
+	if len(retained) > 0 {
+		if preview.MinTXID, _, err = ltx.ParseFilename(retained[0].Name()); err != nil {
+			return nil, fmt.Errorf("parse ltx filename: %w", err)
+		}
+	}
+	return preview, nil
+}
+
+// RetentionResult reports the LTX files removed by a retention pass.
+type RetentionResult struct {
+	FileN int   // number of files removed
+	Size  int64 // total bytes removed
+}
+
+// RetentionPreview reports the LTX files a retention pass would remove from a
+// database & what would remain.
+type RetentionPreview struct {
+	Name  string
+	Files []string // LTX files that would be removed, oldest first
+	Size  int64    // total bytes that would be reclaimed
+
+	RetainedN    int    // number of LTX files that would remain
+	RetainedSize int64  // total bytes of LTX files that would remain
+	MinTXID      uint64 // oldest TXID that would remain, zero if no LTX files
+}
+
+// reverseFileInfos reverses a in place.
+func reverseFileInfos(a []fs.FileInfo) {
+	for i, j := 0, len(a)-1; i < j; i, j = i+1, j-1 {
+		a[i], a[j] = a[j], a[i]
+	}
+}
+
+// PageChecksums returns the checksum of every page in the current database
+// state, in page number order, and the position the checksums were taken at.
+// Pages are read under the same locks as a snapshot so they are consistent.
+// The lock page is skipped & reported with a zero checksum as its contents
+// are undefined and can differ between nodes.
+func (db *DB) PageChecksums(ctx context.Context) (Pos, []uint64, error) {
+	pr, pw := io.Pipe()
+	defer func() { _ = pr.Close() }()
+
+	go func() {
+		_, _, err := db.WriteSnapshotTo(ctx, pw)
+		_ = pw.CloseWithError(err)
+	}()
+
+	dec := ltx.NewDecoder(pr)
+	if err := dec.DecodeHeader(); err != nil {
+		return Pos{}, nil, fmt.Errorf("decode snapshot header: %w", err)
+	}
+
+	chksums := make([]uint64, 0, dec.Header().Commit)
+	data := make([]byte, dec.Header().PageSize)
+	lockPgno := LockPgno(dec.Header().PageSize)
+	for {
+		var hdr ltx.PageHeader
+		if err := dec.DecodePage(&hdr, data); err == io.EOF {
+			break
+		} else if err != nil {
+			return Pos{}, nil, fmt.Errorf("decode snapshot page: %w", err)
+		}
+
+		if hdr.Pgno == lockPgno {
+			chksums = append(chksums, 0)
+			continue
+		}
+		chksums = append(chksums, ltx.ChecksumPage(hdr.Pgno, data))
+	}
+
+	if err := dec.Close(); err != nil {
+		return Pos{}, nil, fmt.Errorf("close snapshot decoder: %w", err)
+	}
+	return Pos{TXID: dec.Header().MaxTXID, PostApplyChecksum: dec.Trailer().PostApplyChecksum}, chksums, nil
+}
+
+// TXPages reports the pages written by the transactions in an LTX file.
+type TXPages struct {
+	MinTXID uint64
+	MaxTXID uint64
+	Commit  uint32   // database size, in pages, after the transactions
+	Pgnos   []uint32 // page numbers written, in order
+}
+
+// ChangedPages returns the pages written by each transaction after from, up
+// to & including to. Returns ErrTXNotApplied if to is after the current
+// position and ErrTXNotAvailable if any LTX file in the range is missing.
+func (db *DB) ChangedPages(from, to uint64) ([]TXPages, error) {
+	if to > db.TXID() {
+		return nil, ErrTXNotApplied
+	}
+
+	ents, err := db.ReadLTXDir()
+	if err != nil {
+		return nil, fmt.Errorf("read ltx dir: %w", err)
+	}
+
+	var a []TXPages
+	next := from + 1
+	for _, ent := range ents {
+		minTXID, maxTXID, err := ltx.ParseFilename(ent.Name())
+		if err != nil || minTXID < next || maxTXID > to {
+			continue
+		} else if minTXID != next {
+			return nil, ErrTXNotAvailable
+		}
+
+		txPages, err := readLTXPages(filepath.Join(db.LTXDir(), ent.Name()))
+		if os.IsNotExist(err) {
+			return nil, ErrTXNotAvailable // removed by retention
+		} else if err != nil {
+			return nil, fmt.Errorf("read ltx file (%s): %w", ent.Name(), err)
+		}
+		a = append(a, txPages)
+		next = maxTXID + 1
+	}
+
+	if next <= to {
+		return nil, ErrTXNotAvailable
+	}
+	return a, nil
+}
+
+// readLTXPages returns the page numbers written by the LTX file.
+func readLTXPages(filename string) (TXPages, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return TXPages{}, err
+	}
+	defer func() { _ = f.Close() }()
+
+	dec := ltx.NewDecoder(f)
+	if err := dec.DecodeHeader(); err != nil {
+		return TXPages{}, err
+	}
+
+	hdr := dec.Header()
+	ret := TXPages{MinTXID: hdr.MinTXID, MaxTXID: hdr.MaxTXID, Commit: hdr.Commit}
+	data := make([]byte, hdr.PageSize)
+	for {
+		var pageHeader ltx.PageHeader
+		if err := dec.DecodePage(&pageHeader, data); err == io.EOF {
+			break
+		} else if err != nil {
+			return TXPages{}, err
+		}
+		ret.Pgnos = append(ret.Pgnos, pageHeader.Pgno)
+	}
+	return ret, dec.Close()
+}
+
+// ChecksumAt returns the post-apply checksum of the database at the given TXID.
+// Returns ErrTXNotApplied if the TXID is after the current position. Returns
+// ErrTXNotAvailable if no LTX file ends at the TXID, such as when it has been
+// removed by retention enforcement.
+func (db *DB) ChecksumAt(txID uint64) (uint64, error) {
+	pos := db.Pos()
+	if txID > pos.TXID {
+		return 0, ErrTXNotApplied
+	} else if txID == pos.TXID {
+		return pos.PostApplyChecksum, nil
+	}
+
+	ents, err := db.ReadLTXDir()
+	if err != nil {
+		return 0, fmt.Errorf("read ltx dir: %w", err)
+	}
+
+	for _, ent := range ents {
+		if _, maxTXID, _ := ltx.ParseFilename(ent.Name()); maxTXID != txID {
+			continue
+		}
+
+		_, trailer, err := readAndVerifyLTXFile(filepath.Join(db.LTXDir(), ent.Name()))
+		if os.IsNotExist(err) {
+			break // removed by retention
+		} else if err != nil {
+			return 0, fmt.Errorf("read ltx file (%s): %w", ent.Name(), err)
+		}
+		return trailer.PostApplyChecksum, nil
+	}
+	return 0, ErrTXNotAvailable
+}
+
+// Rebuild discards the database file & reconstructs it by replaying the LTX
+// files from the most recent snapshot up to the current position. Each file
+// is verified while it is replayed and its pre-apply checksum must match the
+// previous file. The rebuilt file must match the current position's checksum
+// before it replaces the database contents.
+//
+// The database is write locked for the duration so reads & writes wait until
+// the rebuild completes. Returns ErrLTXChainIncomplete if the LTX files do not
+// form a chain from a snapshot to the current position, such as after
+// retention removed the last snapshot.
+func (db *DB) Rebuild(ctx context.Context) (Pos, error) {
+	guard, err := db.AcquireWriteLock(ctx)
+	if err != nil {
+		return Pos{}, err
+	}
+	defer guard.Unlock()
+
+	pos := db.Pos()
+	if pos.TXID == 0 {
+		return pos, nil // no data
+	}
+
+	paths, err := db.rebuildChain(pos.TXID)
+	if err != nil {
+		return Pos{}, err
+	}
+
+	// Replay the chain into a temp file so the database is only overwritten
+	// once the result has been verified.
+	dir := db.store.TmpDir
+	if dir == "" {
+		dir = db.path
+	}
+	f, err := os.CreateTemp(dir, "litefs-rebuild-*.db")
+	if err != nil {
+		return Pos{}, err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	defer func() { _ = f.Close() }()
+
+	var pageSize uint32
+	var chksum uint64
+	for _, path := range paths {
+		hdr, trailer, err := replayLTXFile(f, path, chksum)
+		if err != nil {
+			return Pos{}, fmt.Errorf("replay %s: %w", filepath.Base(path), err)
+		}
+		pageSize, chksum = hdr.PageSize, trailer.PostApplyChecksum
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Pos{}, err
+	} else if v, err := ltx.ChecksumReader(f, int(pageSize)); err != nil {
+		return Pos{}, fmt.Errorf("checksum rebuilt database: %w", err)
+	} else if v != pos.PostApplyChecksum {
+		return Pos{}, fmt.Errorf("rebuilt database checksum (%016x) does not match current position (%016x)", v, pos.PostApplyChecksum)
+	}
+
+	// Overwrite in place so that open file handles see the rebuilt contents.
+	dbf, err := os.OpenFile(db.DatabasePath(), os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return Pos{}, fmt.Errorf("open database file: %w", err)
+	}
+	defer func() { _ = dbf.Close() }()
+
+	prevSize, err := dbf.Seek(0, io.SeekEnd)
+	if err != nil {
+		return Pos{}, err
+	} else if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Pos{}, err
+	} else if _, err := dbf.Seek(0, io.SeekStart); err != nil {
+		return Pos{}, err
+	}
+	size, err := io.Copy(dbf, f)
+	if err != nil {
+		return Pos{}, fmt.Errorf("write database file: %w", err)
+	} else if err := dbf.Truncate(size); err != nil {
+		return Pos{}, fmt.Errorf("truncate database file: %w", err)
+	} else if err := dbf.Sync(); err != nil {
+		return Pos{}, fmt.Errorf("sync database file: %w", err)
+	}
+
+	// Invalidate page cache for the old & new extent of the file.
+	if invalidator := db.store.Invalidator; invalidator != nil {
+		if prevSize > size {
+			size = prevSize
+		}
+		if err := invalidator.InvalidateDB(db, 0, size); err != nil {
+			return Pos{}, fmt.Errorf("invalidate db: %w", err)
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.invalidateSHM(ctx); err != nil {
+		return Pos{}, fmt.Errorf("invalidate shm: %w", err)
+	}
+	return pos, nil
+}
+
+// rebuildChain returns the paths of the LTX files to replay to reach txID,
+// starting from the most recent snapshot.
+func (db *DB) rebuildChain(txID uint64) ([]string, error) {
+	ents, err := db.ReadLTXDir()
+	if err != nil {
+		return nil, fmt.Errorf("read ltx dir: %w", err)
+	}
+
+	type span struct{ min, max uint64 }
+	var spans []span
+	var snapshot *span
+	for _, ent := range ents {
+		minTXID, maxTXID, _ := ltx.ParseFilename(ent.Name())
+		if maxTXID > txID {
+			continue
+		}
+		spans = append(spans, span{minTXID, maxTXID})
+		if s := spans[len(spans)-1]; s.min == 1 && (snapshot == nil || s.max > snapshot.max) {
+			snapshot = &s
+		}
+	}
+	if snapshot == nil {
+		return nil, fmt.Errorf("no snapshot: %w", ErrLTXChainIncomplete)
+	}
+
+	// Follow the chain forward, preferring the file that covers the most
+	// transactions at each step.
+	paths := []string{db.LTXPath(snapshot.min, snapshot.max)}
+	for cur := snapshot.max; cur < txID; {
+		next := cur
+		for _, s := range spans {
+			if s.min == cur+1 && s.max > next {
+				next = s.max
+			}
+		}
+		if next == cur {
+			return nil, fmt.Errorf("missing txid %s: %w", ltx.FormatTXID(cur+1), ErrLTXChainIncomplete)
+		}
+		paths = append(paths, db.LTXPath(cur+1, next))
+		cur = next
+	}
+	return paths, nil
+}
+
+// replayLTXFile writes the pages of the LTX file at path to f & truncates it to
+// the commit size. A non-snapshot file must have a pre-apply checksum matching
+// chksum, the post-apply checksum of the previous file.
+func replayLTXFile(f *os.File, path string, chksum uint64) (ltx.Header, ltx.Trailer, error) {
+	r, err := os.Open(path)
+	if err != nil {
+		return ltx.Header{}, ltx.Trailer{}, err
+	}
+	defer func() { _ = r.Close() }()
+
+	dec := ltx.NewDecoder(r)
+	if err := dec.DecodeHeader(); err != nil {
+		return ltx.Header{}, ltx.Trailer{}, fmt.Errorf("decode ltx header: %w", err)
+	}
+	hdr := dec.Header()
+	if !hdr.IsSnapshot() && hdr.PreApplyChecksum != chksum {
+		return hdr, ltx.Trailer{}, fmt.Errorf("pre-apply checksum (%016x) does not match previous file (%016x)", hdr.PreApplyChecksum, chksum)
+	}
+
+	data := make([]byte, hdr.PageSize)
+	for {
+		var phdr ltx.PageHeader
+		if err := dec.DecodePage(&phdr, data); err == io.EOF {
+			break
+		} else if err != nil {
+			return hdr, ltx.Trailer{}, fmt.Errorf("decode ltx page: %w", err)
+		}
+		if _, err := f.WriteAt(data, int64(phdr.Pgno-1)*int64(hdr.PageSize)); err != nil {
+			return hdr, ltx.Trailer{}, err
+		}
+	}
+
+	// Closing the decoder verifies the file checksum.
+	if err := dec.Close(); err != nil {
+		return hdr, ltx.Trailer{}, fmt.Errorf("close ltx decode: %w", err)
+	} else if err := f.Truncate(int64(hdr.Commit) * int64(hdr.PageSize)); err != nil {
+		return hdr, ltx.Trailer{}, err
+	}
+	return hdr, dec.Trailer(), nil
 }
 
 type dbVarJSON struct {
@@ -1454,6 +2369,8 @@ type dbVarJSON struct {
 	TXID     string `json:"txid"`
 	Checksum string `json:"checksum"`
 
+	JournalMode string `json:"journalMode,omitempty"`
+
 	Locks struct {
 		Pending  string `json:"pending"`
 		Shared   string `json:"shared"`
@@ -1857,6 +2774,11 @@ var (
 		Help: "Number of writes to the WAL file.",
 	}, []string{"db"})
 
+	dbLeaseLossCountMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_db_lease_loss_tx_count",
+		Help: "Number of transactions in progress when the lease was lost.",
+	}, []string{"db", "mode"})
+
 	dbSHMWriteCountMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "litefs_db_shm_write_count",
 		Help: "Number of writes to the shared memory file.",
@@ -1867,11 +2789,51 @@ var (
 		Help: "Number of database commits.",
 	}, []string{"db"})
 
+	dbCommitDurationMetricVec = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "litefs_db_commit_duration_seconds",
+		Help: "Time to commit a transaction, including LTX file sync.",
+	}, []string{"db"})
+
+	dbTXCountMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_db_tx_count",
+		Help: "Number of transactions committed or applied from the primary.",
+	}, []string{"db"})
+
+	dbPageWriteCountMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_db_page_write_count",
+		Help: "Number of pages written by transactions.",
+	}, []string{"db"})
+
+	dbApplyPageCountMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_db_apply_page_count",
+		Help: "Number of pages written while applying LTX files from the primary.",
+	}, []string{"db"})
+
+	dbApplyDurationMetricVec = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "litefs_db_apply_duration_seconds",
+		Help: "Time to apply an LTX file from the primary, including database sync.",
+	}, []string{"db"})
+
+	dbWriteRateMetricVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "litefs_db_write_rate",
+		Help: "Pages written per second, averaged over the rate interval.",
+	}, []string{"db"})
+
 	dbLTXCountMetricVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "litefs_db_ltx_count",
 		Help: "Number of LTX files on disk.",
 	}, []string{"db"})
 
+	dbWALSizeMetricVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "litefs_db_wal_size",
+		Help: "Size of the WAL, in bytes, as of the last commit or checkpoint.",
+	}, []string{"db"})
+
+	dbCheckpointCountMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_db_checkpoint_count",
+		Help: "Number of checkpoints forced by the WAL exceeding its max size.",
+	}, []string{"db"})
+
 	dbLTXBytesMetricVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "litefs_db_ltx_bytes",
 		Help: "Number of bytes used by LTX files on disk.",
@@ -3,12 +3,19 @@ package litefs_test
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/superfly/litefs"
+	"github.com/superfly/litefs/litefstest"
 	"github.com/superfly/ltx"
 )
 
@@ -109,6 +116,60 @@ func TestDB_WriteSnapshotTo(t *testing.T) {
 	}
 }
 
+func TestDB_PreviewRetention(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short enabled, skipping")
+	}
+
+	store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+	db, dbh := newDB(t, store, "db")
+
+	data, _ := testdata.ReadFile("testdata/db/enforce-retention/database")
+
+	// Write three LTX files.
+	if err := writeEmptyJournal(t, db); err != nil {
+		t.Fatal(err)
+	} else if err := db.WriteDatabase(dbh, data[0:4096], 0); err != nil {
+		t.Fatal(err)
+	} else if err := db.WriteDatabase(dbh, data[4096:8192], 4096); err != nil {
+		t.Fatal(err)
+	} else if err := db.CommitJournal(litefs.JournalModeDelete); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := writeEmptyJournal(t, db); err != nil {
+			t.Fatal(err)
+		} else if err := db.WriteDatabase(dbh, data[0:4096], 0); err != nil {
+			t.Fatal(err)
+		} else if err := db.CommitJournal(litefs.JournalModeDelete); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(1 * time.Second)
+
+	// All files are old but the last two are kept by count.
+	preview, err := db.PreviewRetention(time.Now(), 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	} else if got, want := preview.Files, []string{"0000000000000001-0000000000000001.ltx"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Files=%v, want %v", got, want)
+	} else if got, want := preview.RetainedN, 2; got != want {
+		t.Fatalf("RetainedN=%d, want %d", got, want)
+	} else if got, want := preview.MinTXID, uint64(2); got != want {
+		t.Fatalf("MinTXID=%d, want %d", got, want)
+	} else if preview.Size <= 0 {
+		t.Fatalf("expected reclaimed size, got %d", preview.Size)
+	}
+
+	// Ensure no files were removed.
+	if ents, err := db.ReadLTXDir(); err != nil {
+		t.Fatal(err)
+	} else if got, want := len(ents), 3; got != want {
+		t.Fatalf("n=%d, want %d", got, want)
+	}
+}
+
 func TestDB_EnforceRetention(t *testing.T) {
 	if testing.Short() {
 		t.Skip("short enabled, skipping")
@@ -171,6 +232,49 @@ func TestDB_EnforceRetention(t *testing.T) {
 		}
 	})
 
+	t.Run("MaxCount", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		store.RetentionMaxCount = 2
+		db, dbh := newDB(t, store, "db")
+
+		data, _ := testdata.ReadFile("testdata/db/enforce-retention/database")
+
+		// Write three LTX files.
+		if err := writeEmptyJournal(t, db); err != nil {
+			t.Fatal(err)
+		} else if err := db.WriteDatabase(dbh, data[0:4096], 0); err != nil {
+			t.Fatal(err)
+		} else if err := db.WriteDatabase(dbh, data[4096:8192], 4096); err != nil {
+			t.Fatal(err)
+		} else if err := db.CommitJournal(litefs.JournalModeDelete); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := writeEmptyJournal(t, db); err != nil {
+				t.Fatal(err)
+			} else if err := db.WriteDatabase(dbh, data[0:4096], 0); err != nil {
+				t.Fatal(err)
+			} else if err := db.CommitJournal(litefs.JournalModeDelete); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		time.Sleep(1 * time.Second)
+
+		// Enforce retention; all files are old but the last two are kept by count.
+		if err := db.EnforceRetention(context.Background(), time.Now()); err != nil {
+			t.Fatal(err)
+		}
+
+		if ents, err := db.ReadLTXDir(); err != nil {
+			t.Fatal(err)
+		} else if got, want := len(ents), 2; got != want {
+			t.Fatalf("n=%d, want %d", got, want)
+		} else if got, want := ents[0].Name(), "0000000000000002-0000000000000002.ltx"; got != want {
+			t.Fatalf("ent[0]=%s, want %s", got, want)
+		}
+	})
+
 	t.Run("MinimumCount", func(t *testing.T) {
 		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
 		db, dbh := newDB(t, store, "db")
@@ -215,6 +319,481 @@ func TestDB_EnforceRetention(t *testing.T) {
 	})
 }
 
+func TestDB_ChecksumAt(t *testing.T) {
+	store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+	db, dbh := newDB(t, store, "db")
+
+	data, _ := testdata.ReadFile("testdata/db/enforce-retention/database")
+
+	// Write two transactions.
+	if err := writeEmptyJournal(t, db); err != nil {
+		t.Fatal(err)
+	} else if err := db.WriteDatabase(dbh, data[0:4096], 0); err != nil {
+		t.Fatal(err)
+	} else if err := db.WriteDatabase(dbh, data[4096:8192], 4096); err != nil {
+		t.Fatal(err)
+	} else if err := db.CommitJournal(litefs.JournalModeDelete); err != nil {
+		t.Fatal(err)
+	}
+	pos1 := db.Pos()
+
+	if err := writeEmptyJournal(t, db); err != nil {
+		t.Fatal(err)
+	} else if err := db.WriteDatabase(dbh, data[0:4096], 0); err != nil {
+		t.Fatal(err)
+	} else if err := db.CommitJournal(litefs.JournalModeDelete); err != nil {
+		t.Fatal(err)
+	}
+	pos2 := db.Pos()
+
+	if chksum, err := db.ChecksumAt(1); err != nil {
+		t.Fatal(err)
+	} else if got, want := chksum, pos1.PostApplyChecksum; got != want {
+		t.Fatalf("checksum(1)=%016x, want %016x", got, want)
+	}
+	if chksum, err := db.ChecksumAt(2); err != nil {
+		t.Fatal(err)
+	} else if got, want := chksum, pos2.PostApplyChecksum; got != want {
+		t.Fatalf("checksum(2)=%016x, want %016x", got, want)
+	}
+
+	if _, err := db.ChecksumAt(3); err != litefs.ErrTXNotApplied {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Remove first LTX file to simulate retention enforcement.
+	if err := os.Remove(db.LTXPath(1, 1)); err != nil {
+		t.Fatal(err)
+	} else if _, err := db.ChecksumAt(1); err != litefs.ErrTXNotAvailable {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestDB_ChangedPages(t *testing.T) {
+	store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+	db, dbh := newDB(t, store, "db")
+
+	data, _ := testdata.ReadFile("testdata/db/enforce-retention/database")
+
+	// Write two transactions.
+	if err := writeEmptyJournal(t, db); err != nil {
+		t.Fatal(err)
+	} else if err := db.WriteDatabase(dbh, data[0:4096], 0); err != nil {
+		t.Fatal(err)
+	} else if err := db.CommitJournal(litefs.JournalModeDelete); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeEmptyJournal(t, db); err != nil {
+		t.Fatal(err)
+	} else if err := db.WriteDatabase(dbh, data[4096:8192], 4096); err != nil {
+		t.Fatal(err)
+	} else if err := db.CommitJournal(litefs.JournalModeDelete); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := db.ChangedPages(0, 2)
+	if err != nil {
+		t.Fatal(err)
+	} else if got, want := len(a), 2; got != want {
+		t.Fatalf("len=%d, want %d", got, want)
+	} else if got, want := a[0].Pgnos, []uint32{1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("pgnos[0]=%v, want %v", got, want)
+	} else if got, want := a[1].Pgnos, []uint32{2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("pgnos[1]=%v, want %v", got, want)
+	}
+
+	if a, err := db.ChangedPages(1, 2); err != nil {
+		t.Fatal(err)
+	} else if got, want := len(a), 1; got != want {
+		t.Fatalf("len=%d, want %d", got, want)
+	} else if got, want := a[0].MinTXID, uint64(2); got != want {
+		t.Fatalf("MinTXID=%d, want %d", got, want)
+	}
+
+	if _, err := db.ChangedPages(0, 3); err != litefs.ErrTXNotApplied {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Verify per-page checksums cover the whole database.
+	if pos, chksums, err := db.PageChecksums(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if got, want := pos.TXID, uint64(2); got != want {
+		t.Fatalf("TXID=%d, want %d", got, want)
+	} else if got, want := len(chksums), 2; got != want {
+		t.Fatalf("len(chksums)=%d, want %d", got, want)
+	}
+
+	// Remove first LTX file to simulate retention enforcement.
+	if err := os.Remove(db.LTXPath(1, 1)); err != nil {
+		t.Fatal(err)
+	} else if _, err := db.ChangedPages(0, 2); err != litefs.ErrTXNotAvailable {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the lock page of a database larger than 1GB is skipped.
+func TestDB_PageChecksums_LockPage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short mode")
+	}
+
+	store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+	db, _ := newDB(t, store, "db")
+
+	const pageSize = 65536
+	litefstest.WriteTx(t, db, litefstest.NewDatabase(pageSize, 1, 0))
+
+	// Grow the database past the lock page. The lock page is filled with
+	// garbage to ensure it does not affect the checksums.
+	lockPgno := litefs.LockPgno(pageSize)
+	commit := lockPgno + 1
+	header := litefstest.NewDatabase(pageSize, int(commit), 0)[:pageSize]
+	lockPage := bytes.Repeat([]byte{0xff}, pageSize)
+	lastPage := bytes.Repeat([]byte{0x01}, pageSize)
+
+	// Journal the original first page so the rolling checksum stays valid.
+	journal := make([]byte, 512)
+	copy(journal, litefs.SQLITE_JOURNAL_HEADER_STRING)
+	binary.BigEndian.PutUint32(journal[8:], 1)    // page count
+	binary.BigEndian.PutUint32(journal[16:], 1)   // initial database size
+	binary.BigEndian.PutUint32(journal[20:], 512) // sector size
+	binary.BigEndian.PutUint32(journal[24:], pageSize)
+	journal = binary.BigEndian.AppendUint32(journal, 1)
+	journal = append(journal, litefstest.NewDatabase(pageSize, 1, 0)...)
+	journal = binary.BigEndian.AppendUint32(journal, 0)
+
+	jf, err := db.CreateJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = jf.Close() }()
+	if err := db.WriteJournal(jf, journal, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(db.DatabasePath(), os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	zero := make([]byte, pageSize)
+	for pgno := uint32(1); pgno <= commit; pgno++ {
+		data := zero
+		switch pgno {
+		case 1:
+			data = header
+		case lockPgno:
+			data = lockPage
+		case commit:
+			data = lastPage
+		}
+		if err := db.WriteDatabase(f, data, int64(pgno-1)*pageSize); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.CommitJournal(litefs.JournalModeDelete); err != nil {
+		t.Fatal(err)
+	}
+
+	_, chksums, err := db.PageChecksums(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if got, want := len(chksums), int(commit); got != want {
+		t.Fatalf("len(chksums)=%d, want %d", got, want)
+	} else if got, want := chksums[0], ltx.ChecksumPage(1, header); got != want {
+		t.Fatalf("chksums[0]=%016x, want %016x", got, want)
+	} else if got := chksums[lockPgno-1]; got != 0 {
+		t.Fatalf("lock page checksum=%016x, want zero", got)
+	} else if got, want := chksums[commit-1], ltx.ChecksumPage(commit, lastPage); got != want {
+		t.Fatalf("last page checksum=%016x, want %016x", got, want)
+	}
+}
+
+// Ensure write counters track committed transactions & pages.
+func TestDB_WriteStats(t *testing.T) {
+	store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+	db, dbh := newDB(t, store, "db")
+
+	data, _ := testdata.ReadFile("testdata/db/enforce-retention/database")
+	if err := writeEmptyJournal(t, db); err != nil {
+		t.Fatal(err)
+	} else if err := db.WriteDatabase(dbh, data[0:4096], 0); err != nil {
+		t.Fatal(err)
+	} else if err := db.WriteDatabase(dbh, data[4096:8192], 4096); err != nil {
+		t.Fatal(err)
+	} else if err := db.CommitJournal(litefs.JournalModeDelete); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeEmptyJournal(t, db); err != nil {
+		t.Fatal(err)
+	} else if err := db.WriteDatabase(dbh, data[0:4096], 0); err != nil {
+		t.Fatal(err)
+	} else if err := db.CommitJournal(litefs.JournalModeDelete); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := db.WriteStats()
+	if got, want := stats.TXN, int64(2); got != want {
+		t.Fatalf("TXN=%d, want %d", got, want)
+	} else if got, want := stats.PageWriteN, int64(3); got != want {
+		t.Fatalf("PageWriteN=%d, want %d", got, want)
+	}
+}
+
+func TestDB_SyncMode(t *testing.T) {
+	for _, mode := range []litefs.SyncMode{litefs.SyncModeFull, litefs.SyncModeNormal, litefs.SyncModeOff} {
+		t.Run(string(mode), func(t *testing.T) {
+			store := newStore(t, newPrimaryStaticLeaser(), nil)
+			store.SyncMode = mode
+			store.SyncInterval = 1 * time.Millisecond
+			if err := store.Open(); err != nil {
+				t.Fatal(err)
+			}
+			<-store.ReadyCh()
+
+			name := "sync-mode-" + string(mode)
+			db, dbh := newDB(t, store, name)
+			prevN := commitDurationSampleCount(t, name)
+
+			data, _ := testdata.ReadFile("testdata/db/enforce-retention/database")
+			if err := writeEmptyJournal(t, db); err != nil {
+				t.Fatal(err)
+			} else if err := db.WriteDatabase(dbh, data[0:4096], 0); err != nil {
+				t.Fatal(err)
+			} else if err := db.CommitJournal(litefs.JournalModeDelete); err != nil {
+				t.Fatal(err)
+			}
+
+			// Every mode writes the LTX file; only durability differs.
+			if got, want := db.Pos().TXID, uint64(1); got != want {
+				t.Fatalf("TXID=%d, want %d", got, want)
+			} else if _, err := os.Stat(db.LTXPath(1, 1)); err != nil {
+				t.Fatal(err)
+			}
+
+			// Commit latency is reported per database.
+			if got, want := commitDurationSampleCount(t, name), prevN+1; got != want {
+				t.Fatalf("commit duration samples=%d, want %d", got, want)
+			}
+		})
+	}
+}
+
+// commitDurationSampleCount returns the number of commit duration observations
+// reported for a database by the default registry.
+func commitDurationSampleCount(tb testing.TB, name string) uint64 {
+	tb.Helper()
+
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "litefs_db_commit_duration_seconds" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "db" && label.GetValue() == name {
+					return m.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// Ensure new WAL transactions are rejected once writes are disabled but frames
+// of a transaction already in progress are still accepted.
+func TestDB_WriteWAL_WritesDisabled(t *testing.T) {
+	store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+	db, dbh := newDB(t, store, "db")
+
+	data, _ := testdata.ReadFile("testdata/db/enforce-retention/database")
+	if err := writeEmptyJournal(t, db); err != nil {
+		t.Fatal(err)
+	} else if err := db.WriteDatabase(dbh, data[0:4096], 0); err != nil {
+		t.Fatal(err)
+	} else if err := db.CommitJournal(litefs.JournalModeDelete); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := db.CreateWAL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	// Begin a transaction with a non-commit frame.
+	frame := make([]byte, litefs.WALFrameHeaderSize+4096)
+	frameOffset := func(i int) int64 { return litefs.WALHeaderSize + int64(i*len(frame)) }
+	if err := db.WriteWAL(f, make([]byte, litefs.WALHeaderSize), 0); err != nil {
+		t.Fatal(err)
+	} else if err := db.WriteWAL(f, frame, frameOffset(0)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.MarkDrained(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The in-progress transaction can continue writing frames.
+	if err := db.WriteWAL(f, frame, frameOffset(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new transaction starting at the end of the last commit is rejected.
+	if err := db.WriteWAL(f, frame, frameOffset(0)); err != litefs.ErrWritesDisabled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestDB_ApplyLTX(t *testing.T) {
+	for _, n := range []int{1, 4} {
+		t.Run(fmt.Sprintf("Concurrency%d", n), func(t *testing.T) {
+			src, dbh := newDB(t, newOpenStore(t, newPrimaryStaticLeaser(), nil), "db")
+			data, _ := testdata.ReadFile("testdata/db/write-snapshot-to/database")
+			if err := writeEmptyJournal(t, src); err != nil {
+				t.Fatal(err)
+			} else if err := src.WriteDatabase(dbh, data[0:4096], 0); err != nil {
+				t.Fatal(err)
+			} else if err := src.WriteDatabase(dbh, data[4096:8192], 4096); err != nil {
+				t.Fatal(err)
+			} else if err := src.CommitJournal(litefs.JournalModeDelete); err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join(t.TempDir(), "snapshot.ltx")
+			f, err := os.Create(path)
+			if err != nil {
+				t.Fatal(err)
+			} else if _, _, err := src.WriteSnapshotTo(context.Background(), f); err != nil {
+				t.Fatal(err)
+			} else if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+			store.ApplyConcurrency = n
+			db, _ := newDB(t, store, "db")
+			if err := db.ApplyLTX(context.Background(), path); err != nil {
+				t.Fatal(err)
+			} else if got, want := db.Pos(), src.Pos(); got != want {
+				t.Fatalf("pos=%v, want %v", got, want)
+			}
+
+			if buf, err := os.ReadFile(db.DatabasePath()); err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(buf, data[0:8192]) {
+				t.Fatal("database mismatch")
+			}
+		})
+	}
+}
+
+func TestDB_Rebuild(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		db, dbh := newDB(t, newOpenStore(t, newPrimaryStaticLeaser(), nil), "db")
+		data, _ := testdata.ReadFile("testdata/db/write-snapshot-to/database")
+		if err := writeEmptyJournal(t, db); err != nil {
+			t.Fatal(err)
+		} else if err := db.WriteDatabase(dbh, data[0:4096], 0); err != nil {
+			t.Fatal(err)
+		} else if err := db.WriteDatabase(dbh, data[4096:8192], 4096); err != nil {
+			t.Fatal(err)
+		} else if err := db.CommitJournal(litefs.JournalModeDelete); err != nil {
+			t.Fatal(err)
+		}
+		want, err := os.ReadFile(db.DatabasePath())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Corrupt the second page & rebuild from the LTX files.
+		if err := os.WriteFile(db.DatabasePath(), append(append([]byte{}, want[:4096]...), make([]byte, 4096)...), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if pos, err := db.Rebuild(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if got, want := pos, db.Pos(); got != want {
+			t.Fatalf("pos=%v, want %v", got, want)
+		}
+
+		if got, err := os.ReadFile(db.DatabasePath()); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(got, want) {
+			t.Fatal("database mismatch")
+		}
+	})
+
+	t.Run("ErrLTXChainIncomplete", func(t *testing.T) {
+		db, dbh := newDB(t, newOpenStore(t, newPrimaryStaticLeaser(), nil), "db")
+		data, _ := testdata.ReadFile("testdata/db/enforce-retention/database")
+		if err := writeEmptyJournal(t, db); err != nil {
+			t.Fatal(err)
+		} else if err := db.WriteDatabase(dbh, data[0:4096], 0); err != nil {
+			t.Fatal(err)
+		} else if err := db.CommitJournal(litefs.JournalModeDelete); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Remove(db.LTXPath(1, 1)); err != nil {
+			t.Fatal(err)
+		} else if _, err := db.Rebuild(context.Background()); !errors.Is(err, litefs.ErrLTXChainIncomplete) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func BenchmarkDB_ApplyLTX(b *testing.B) {
+	const pageN, pageSize = 1024, 4096
+
+	// Build a synthetic snapshot touching every page.
+	path := filepath.Join(b.TempDir(), "snapshot.ltx")
+	f, err := os.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	enc := ltx.NewEncoder(f)
+	if err := enc.EncodeHeader(ltx.Header{Version: 1, PageSize: pageSize, Commit: pageN, MinTXID: 1, MaxTXID: 1}); err != nil {
+		b.Fatal(err)
+	}
+	data := make([]byte, pageSize)
+	var chksum uint64
+	for pgno := uint32(1); pgno <= pageN; pgno++ {
+		data[0] = byte(pgno)
+		if err := enc.EncodePage(ltx.PageHeader{Pgno: pgno}, data); err != nil {
+			b.Fatal(err)
+		}
+		chksum ^= ltx.ChecksumPage(pgno, data)
+	}
+	enc.SetPostApplyChecksum(ltx.ChecksumFlag | chksum)
+	if err := enc.Close(); err != nil {
+		b.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, n := range []int{1, 4} {
+		b.Run(fmt.Sprintf("Concurrency%d", n), func(b *testing.B) {
+			store := newOpenStore(b, newPrimaryStaticLeaser(), nil)
+			store.ApplyConcurrency = n
+			db, _ := newDB(b, store, "db")
+
+			b.SetBytes(pageN * pageSize)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := db.ApplyLTX(context.Background(), path); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 // newDB returns a new instance of DB attached to a temporary store.
 func newDB(tb testing.TB, store *litefs.Store, name string) (*litefs.DB, *os.File) {
 	tb.Helper()
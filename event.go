@@ -0,0 +1,209 @@
+package litefs
+
+import (
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/superfly/ltx"
+)
+
+// DefaultEventBufferSize is the number of events buffered per event
+// subscriber before the oldest events are dropped.
+const DefaultEventBufferSize = 1024
+
+// DefaultEventHistorySize is the number of recent events retained in memory
+// for the event history.
+const DefaultEventHistorySize = 256
+
+// Event types published to event subscribers.
+const (
+	EventTypeTx             = "tx"              // database position changed
+	EventTypePrimaryAcquire = "primary-acquire" // node acquired the primary lease
+	EventTypePrimaryLost    = "primary-lost"    // node lost the primary lease
+	EventTypeReady          = "ready"           // node became ready
+
+	EventTypeInvalidPrimaryInfo = "invalid-primary-info" // leaser returned unusable primary info
+	EventTypeReplicaDisconnect  = "replica-disconnect"   // replica lost its connection to the primary
+	EventTypeResync             = "resync"               // replica received a snapshot of a database
+)
+
+// Event represents a change to the store published to event subscribers.
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Node string    `json:"node,omitempty"`
+
+	// Database name & position. Only set for tx & resync events.
+	DB   string `json:"db,omitempty"`
+	TXID string `json:"txid,omitempty"`
+
+	// Description of the problem with any URL credentials redacted. Only set
+	// for invalid-primary-info & replica-disconnect events.
+	Error string `json:"error,omitempty"`
+}
+
+// urlRegex matches URLs embedded in error messages.
+var urlRegex = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"']+`)
+
+// redactURLs replaces the password of any URL in s.
+func redactURLs(s string) string {
+	return urlRegex.ReplaceAllStringFunc(s, func(rawurl string) string {
+		u, err := url.Parse(rawurl)
+		if err != nil || u.User == nil {
+			return rawurl
+		}
+		return u.Redacted()
+	})
+}
+
+// EventSubscriber receives events published by the store.
+//
+// Events are held in a bounded buffer so that a slow subscriber never blocks
+// the store. Once the buffer is full, the oldest event is dropped for each
+// new event. Subscribers which miss events should reconcile their state from
+// the store directly.
+type EventSubscriber struct {
+	store *Store
+
+	mu       sync.Mutex
+	notifyCh chan struct{}
+	events   []Event // ring buffer, allocated on first publish
+	head     int     // index of the oldest event
+	n        int     // number of buffered events
+	size     int
+	dropped  int
+}
+
+// newEventSubscriber returns a new instance of EventSubscriber associated with a store.
+func newEventSubscriber(store *Store, size int) *EventSubscriber {
+	if size <= 0 {
+		size = DefaultEventBufferSize
+	}
+	return &EventSubscriber{
+		store:    store,
+		notifyCh: make(chan struct{}, 1),
+		size:     size,
+	}
+}
+
+// Close removes the subscriber from the store.
+func (s *EventSubscriber) Close() error {
+	s.store.UnsubscribeEvents(s)
+	return nil
+}
+
+// NotifyCh returns a channel that receives a value when events are available.
+func (s *EventSubscriber) NotifyCh() <-chan struct{} { return s.notifyCh }
+
+// publish adds an event to the buffer, dropping the oldest event if full.
+func (s *EventSubscriber) publish(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.events == nil {
+		s.events = make([]Event, s.size)
+	}
+
+	if s.n == s.size {
+		s.events[s.head] = e // overwrite oldest
+		s.head = (s.head + 1) % s.size
+		s.dropped++
+		storeEventDroppedCountMetric.Inc()
+	} else {
+		s.events[(s.head+s.n)%s.size] = e
+		s.n++
+	}
+
+	select {
+	case s.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// Events returns buffered events & the number of events dropped since the
+// last call to Events(). This call clears the buffer.
+func (s *EventSubscriber) Events() (events []Event, dropped int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.n > 0 {
+		events = make([]Event, s.n)
+		for i := range events {
+			events[i] = s.events[(s.head+i)%s.size]
+			s.events[(s.head+i)%s.size] = Event{} // release references
+		}
+	}
+	dropped = s.dropped
+	s.head, s.n, s.dropped = 0, 0, 0
+	return events, dropped
+}
+
+// SubscribeEvents creates a new event subscriber which buffers up to size
+// events. Uses DefaultEventBufferSize if size is not positive.
+func (s *Store) SubscribeEvents(size int) *EventSubscriber {
+	s.eventMu.Lock()
+	defer s.eventMu.Unlock()
+
+	sub := newEventSubscriber(s, size)
+	s.eventSubscribers[sub] = struct{}{}
+
+	storeEventSubscriberCountMetric.Set(float64(len(s.eventSubscribers)))
+	return sub
+}
+
+// UnsubscribeEvents removes an event subscriber from the store.
+func (s *Store) UnsubscribeEvents(sub *EventSubscriber) {
+	s.eventMu.Lock()
+	defer s.eventMu.Unlock()
+
+	delete(s.eventSubscribers, sub)
+	storeEventSubscriberCountMetric.Set(float64(len(s.eventSubscribers)))
+}
+
+// EventHistory returns retained events which occurred after since, oldest
+// first. Tx events are not retained as they would quickly evict the rest.
+func (s *Store) EventHistory(since time.Time) []Event {
+	s.eventMu.Lock()
+	defer s.eventMu.Unlock()
+
+	a := make([]Event, 0, len(s.eventHistory))
+	for _, e := range s.eventHistory {
+		if e.Time.After(since) {
+			a = append(a, e)
+		}
+	}
+	return a
+}
+
+// publishEvent sends an event to all event subscribers. It never blocks on
+// subscribers so it is safe to call while holding the store or database lock.
+func (s *Store) publishEvent(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Node = s.NodeName
+	e.Error = redactURLs(e.Error)
+
+	s.eventMu.Lock()
+	defer s.eventMu.Unlock()
+
+	// Retain a bounded history, dropping the oldest event once full.
+	if e.Type != EventTypeTx && s.EventHistorySize > 0 {
+		if len(s.eventHistory) >= s.EventHistorySize {
+			n := copy(s.eventHistory, s.eventHistory[len(s.eventHistory)-s.EventHistorySize+1:])
+			s.eventHistory = s.eventHistory[:n]
+		}
+		s.eventHistory = append(s.eventHistory, e)
+	}
+
+	for sub := range s.eventSubscribers {
+		sub.publish(e)
+	}
+}
+
+// publishTxEvent publishes the position of a database after a change.
+func (s *Store) publishTxEvent(name string, pos Pos) {
+	s.publishEvent(Event{Type: EventTypeTx, DB: name, TXID: ltx.FormatTXID(pos.TXID)})
+}
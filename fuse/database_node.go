@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"syscall"
+	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
@@ -44,11 +45,7 @@ func (n *DatabaseNode) Attr(ctx context.Context, attr *fuse.Attr) error {
 		return err
 	}
 
-	if n.db.Store().IsPrimary() {
-		attr.Mode = 0666
-	} else {
-		attr.Mode = 0444
-	}
+	attr.Mode = n.fsys.fileMode(n.db.Writable())
 
 	attr.Size = uint64(fi.Size())
 	attr.Uid = uint32(n.fsys.Uid)
@@ -60,7 +57,7 @@ func (n *DatabaseNode) Attr(ctx context.Context, attr *fuse.Attr) error {
 func (n *DatabaseNode) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
 	if req.Valid.Size() {
 		if err := os.Truncate(n.db.DatabasePath(), int64(req.Size)); err != nil {
-			return err
+			return ToError(err)
 		}
 	}
 	return n.Attr(ctx, &resp.Attr)
@@ -77,16 +74,19 @@ func (n *DatabaseNode) Open(ctx context.Context, req *fuse.OpenRequest, resp *fu
 }
 
 func (n *DatabaseNode) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
+	defer n.fsys.observeOp("fsync", n.db.Name(), time.Now())
+
 	f, err := os.Open(n.db.DatabasePath())
 	if err != nil {
-		return err
+		return ToError(err)
 	}
 	defer func() { _ = f.Close() }()
 
 	if err := f.Sync(); err != nil {
-		return err
+		log.Printf("fuse: fsync(): database error: %s", err)
+		return ToError(err)
 	} else if err := f.Close(); err != nil {
-		return err
+		return ToError(err)
 	}
 
 	// TODO: fsync parent directory
@@ -131,10 +131,13 @@ type DatabaseHandle struct {
 }
 
 func newDatabaseHandle(node *DatabaseNode, file *os.File) *DatabaseHandle {
+	node.fsys.openHandle(node.db)
 	return &DatabaseHandle{node: node, file: file}
 }
 
 func (h *DatabaseHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	defer h.node.fsys.observeOp("read", h.node.db.Name(), time.Now())
+
 	buf := make([]byte, req.Size)
 	n, err := h.file.ReadAt(buf, req.Offset)
 	if err == io.EOF {
@@ -145,9 +148,11 @@ func (h *DatabaseHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *
 }
 
 func (h *DatabaseHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	defer h.node.fsys.observeOp("write", h.node.db.Name(), time.Now())
+
 	if err := h.node.db.WriteDatabase(h.file, req.Data, req.Offset); err != nil {
 		log.Printf("fuse: write(): database error: %s", err)
-		return err
+		return ToError(err)
 	}
 	resp.Size = len(req.Data)
 	return nil
@@ -161,6 +166,7 @@ func (h *DatabaseHandle) Flush(ctx context.Context, req *fuse.FlushRequest) erro
 }
 
 func (h *DatabaseHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	h.node.fsys.releaseHandle(h.node.db)
 	return h.file.Close()
 }
 
@@ -174,6 +180,16 @@ func (h *DatabaseHandle) Lock(ctx context.Context, req *fuse.LockRequest) error
 	}
 	lockType := lockTypes[0]
 
+	// A SHARED lock starts a read transaction in rollback journal mode so wait
+	// for the primary's position first if reads are linearizable. In WAL mode,
+	// the wait occurs on the SHM read-mark lock instead.
+	if req.Lock.Type == fuse.LockRead && lockType == litefs.LockTypeShared && h.node.db.Mode() != litefs.DBModeWAL {
+		if err := h.node.fsys.store.WaitLinearizable(ctx, h.node.db); err != nil {
+			log.Printf("fuse: lock(): linearizable read: %s", err)
+			return ToError(err)
+		}
+	}
+
 	guard := h.node.fsys.CreateGuardSetIfNotExists(h.node.db, req.LockOwner).Guard(lockType)
 
 	switch typ := req.Lock.Type; typ {
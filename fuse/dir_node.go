@@ -0,0 +1,52 @@
+package fuse
+
+import (
+	"context"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+)
+
+var _ fs.Node = (*DirNode)(nil)
+var _ fs.NodeStringLookuper = (*DirNode)(nil)
+var _ fs.HandleReadDirAller = (*DirNode)(nil)
+
+// DirNode represents a read-only directory above the database directory when
+// the file system is configured with a subdirectory. It contains a single
+// entry which is either another DirNode or the RootNode.
+type DirNode struct {
+	fsys  *FileSystem
+	inode uint64  // zero if dynamically allocated
+	name  string  // name of the child entry
+	child fs.Node // next directory in the path
+}
+
+func newDirNode(fsys *FileSystem, inode uint64, name string, child fs.Node) *DirNode {
+	return &DirNode{
+		fsys:  fsys,
+		inode: inode,
+		name:  name,
+		child: child,
+	}
+}
+
+func (n *DirNode) Attr(ctx context.Context, attr *fuse.Attr) error {
+	attr.Inode = n.inode
+	attr.Mode = n.fsys.dirMode(false)
+	attr.Uid = uint32(n.fsys.Uid)
+	attr.Gid = uint32(n.fsys.Gid)
+	return nil
+}
+
+// Lookup returns the child node if name matches the next path segment.
+func (n *DirNode) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	if name != n.name {
+		return nil, fuse.ToErrno(syscall.ENOENT)
+	}
+	return n.child, nil
+}
+
+func (n *DirNode) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	return []fuse.Dirent{{Name: n.name, Type: fuse.DT_Dir}}, nil
+}
@@ -2,16 +2,29 @@ package fuse
 
 import (
 	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
 	"log"
 	"os"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/superfly/litefs"
 )
 
+// Default permission bits for files & directories in the mount.
+const (
+	DefaultFileMode = os.FileMode(0666)
+	DefaultDirMode  = os.FileMode(0777)
+)
+
 var _ fs.FS = (*FileSystem)(nil)
 var _ fs.FSStatfser = (*FileSystem)(nil)
 var _ litefs.Invalidator = (*FileSystem)(nil)
@@ -29,10 +42,35 @@ type FileSystem struct {
 
 	guardSets map[fuse.LockOwner]*litefs.GuardSet
 
+	// Open file handle counts & high-water marks by database name.
+	handleNs    map[string]int
+	maxHandleNs map[string]int
+	maxInodeN   int
+
 	// User & Group ID for all files in the filesystem.
 	Uid int
 	Gid int
 
+	// Slash-separated path under the mount point where databases are
+	// presented. Databases appear directly in the mount point if blank.
+	Subdir string
+
+	// If greater than zero, read, write, fsync & lookup operations which
+	// take longer than the threshold are logged.
+	SlowOpThreshold time.Duration
+
+	// If true, "-wal" & "-shm" files are omitted from directory listings so
+	// that tools copying the mount do not pick up sidecar files separately
+	// from their database. They can still be opened by name, which SQLite
+	// requires, so WAL mode & the SQLite backup API are unaffected.
+	HideWAL bool
+
+	// Permission bits presented for database files & directories in the mount,
+	// regardless of the application's umask. Database sidecar files use the
+	// file mode too. Write bits are removed while the node is read-only.
+	FileMode os.FileMode
+	DirMode  os.FileMode
+
 	// If set, function is called for each FUSE request & response.
 	Debug func(msg any)
 }
@@ -45,9 +83,15 @@ func NewFileSystem(path string, store *litefs.Store) *FileSystem {
 
 		guardSets: make(map[fuse.LockOwner]*litefs.GuardSet),
 
+		handleNs:    make(map[string]int),
+		maxHandleNs: make(map[string]int),
+
 		Uid: os.Getuid(),
 		Gid: os.Getgid(),
 
+		FileMode: DefaultFileMode,
+		DirMode:  DefaultDirMode,
+
 		Debug: store.DebugFn,
 	}
 
@@ -56,6 +100,24 @@ func NewFileSystem(path string, store *litefs.Store) *FileSystem {
 	return fsys
 }
 
+// fileMode returns the mode presented for files. Write bits are removed if
+// the file is not writable.
+func (fsys *FileSystem) fileMode(writable bool) os.FileMode {
+	if !writable {
+		return fsys.FileMode.Perm() &^ 0222
+	}
+	return fsys.FileMode.Perm()
+}
+
+// dirMode returns the mode presented for directories. Write bits are removed
+// if new files cannot be created in the directory.
+func (fsys *FileSystem) dirMode(writable bool) os.FileMode {
+	if !writable {
+		return os.ModeDir | fsys.DirMode.Perm()&^0222
+	}
+	return os.ModeDir | fsys.DirMode.Perm()
+}
+
 // Path returns the path to the mount point.
 func (fsys *FileSystem) Path() string { return fsys.path }
 
@@ -87,10 +149,14 @@ func (fsys *FileSystem) Mount() (err error) {
 			}
 		},
 	}
-	fsys.server = fs.New(fsys.conn, &config)
+	server := fs.New(fsys.conn, &config)
+
+	fsys.mu.Lock()
+	fsys.server = server
+	fsys.mu.Unlock()
 
 	go func() {
-		if err := fsys.server.Serve(fsys); err != nil {
+		if err := server.Serve(fsys); err != nil {
 			log.Printf("fuse serve error: %s", err)
 		}
 	}()
@@ -98,6 +164,27 @@ func (fsys *FileSystem) Mount() (err error) {
 	return nil
 }
 
+// Capabilities represents the FUSE protocol & features negotiated with the kernel.
+type Capabilities struct {
+	Protocol   string
+	Features   string
+	POSIXLocks bool // required for SQLite locking
+}
+
+// Capabilities returns the protocol & features negotiated when mounted.
+// Returns a zero value if the file system is not mounted.
+func (fsys *FileSystem) Capabilities() Capabilities {
+	if fsys.conn == nil {
+		return Capabilities{}
+	}
+	features := fsys.conn.Features()
+	return Capabilities{
+		Protocol:   fsys.conn.Protocol().String(),
+		Features:   features.String(),
+		POSIXLocks: features&fuse.InitPOSIXLocks != 0,
+	}
+}
+
 // Unmount unmounts the file system.
 func (fsys *FileSystem) Unmount() (err error) {
 	if fsys.conn != nil {
@@ -109,9 +196,58 @@ func (fsys *FileSystem) Unmount() (err error) {
 	return err
 }
 
-// Root returns the root directory in the file system.
+// Remount forcibly unmounts the file system and mounts it again. This is used
+// to recover when the connection to the kernel has been lost. Locks & handles
+// held through the previous mount are released as they can no longer be used.
+func (fsys *FileSystem) Remount() error {
+	// Fall back to a lazy unmount as a disconnected mount may not unmount cleanly.
+	if err := fuse.Unmount(fsys.path); err != nil {
+		if err := syscall.Unmount(fsys.path, syscall.MNT_DETACH); err != nil {
+			return fmt.Errorf("force unmount: %w", err)
+		}
+	}
+	fsys.conn = nil
+
+	fsys.mu.Lock()
+	for owner, gs := range fsys.guardSets {
+		gs.Unlock()
+		delete(fsys.guardSets, owner)
+	}
+	for name := range fsys.handleNs {
+		fsys.handleNs[name] = 0
+	}
+	fsys.mu.Unlock()
+
+	// Clear cached nodes as the kernel no longer references them.
+	fsys.root.mu.Lock()
+	fsys.root.nodes = make(map[string]fs.Node)
+	fsys.root.mu.Unlock()
+
+	if err := fsys.Mount(); err != nil {
+		return fmt.Errorf("mount: %w", err)
+	}
+
+	remountCountMetric.Inc()
+	return nil
+}
+
+// Root returns the root directory in the file system. If a subdirectory is
+// set, the root is the top of the directory chain leading to the databases.
 func (fsys *FileSystem) Root() (fs.Node, error) {
-	return fsys.root, nil
+	if fsys.Subdir == "" {
+		return fsys.root, nil
+	}
+
+	parts := strings.Split(fsys.Subdir, "/")
+	node := fs.Node(fsys.root)
+	for i := len(parts) - 1; i >= 0; i-- {
+		var inode uint64
+		if i == 0 {
+			inode = RootInode
+		}
+		node = newDirNode(fsys, inode, parts[i], node)
+	}
+	return node, nil
 }
 
 // GuardSet returns a database guard set for the given owner.
@@ -141,6 +277,89 @@ func (fsys *FileSystem) CreateGuardSetIfNotExists(db *litefs.DB, owner fuse.Lock
 	return gs
 }
 
+// openHandle increments the open file handle count for a database.
+func (fsys *FileSystem) openHandle(db *litefs.DB) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	n := fsys.handleNs[db.Name()] + 1
+	fsys.handleNs[db.Name()] = n
+	if n > fsys.maxHandleNs[db.Name()] {
+		fsys.maxHandleNs[db.Name()] = n
+	}
+}
+
+// releaseHandle decrements the open file handle count for a database.
+func (fsys *FileSystem) releaseHandle(db *litefs.DB) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	fsys.handleNs[db.Name()]--
+}
+
+// trackInodes updates the inode high-water mark. Called by the root node
+// whenever a node is cached.
+func (fsys *FileSystem) trackInodes(n int) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	if n > fsys.maxInodeN {
+		fsys.maxInodeN = n
+	}
+}
+
+// Stats returns the current open file handle & inode counts.
+func (fsys *FileSystem) Stats() FileSystemStats {
+	inodeNs := fsys.root.inodeNs()
+
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	stats := FileSystemStats{
+		MaxInodes: fsys.maxInodeN,
+		DBs:       make(map[string]DBStats),
+	}
+	for name, n := range inodeNs {
+		stats.Inodes += n
+		stats.DBs[name] = DBStats{Inodes: n}
+	}
+	for name, maxN := range fsys.maxHandleNs {
+		dbStats := stats.DBs[name]
+		dbStats.Handles = fsys.handleNs[name]
+		dbStats.MaxHandles = maxN
+		stats.DBs[name] = dbStats
+		stats.Handles += dbStats.Handles
+	}
+	return stats
+}
+
+// FileSystemStats represents open file handle & inode counts for the mount.
+type FileSystemStats struct {
+	Handles   int                `json:"handles"`
+	Inodes    int                `json:"inodes"`
+	MaxInodes int                `json:"maxInodes"`
+	DBs       map[string]DBStats `json:"dbs"`
+}
+
+// DBStats represents open file handle & inode counts for a single database.
+// Inodes are counted for all files associated with the database.
+type DBStats struct {
+	Handles    int `json:"handles"`
+	MaxHandles int `json:"maxHandles"`
+	Inodes     int `json:"inodes"`
+}
+
+var _ expvar.Var = (*FileSystemVar)(nil)
+
+// FileSystemVar exposes file system stats as an expvar.
+type FileSystemVar FileSystem
+
+func (v *FileSystemVar) String() string {
+	b, err := json.Marshal((*FileSystem)(v).Stats())
+	if err != nil {
+		return "null"
+	}
+	return string(b)
+}
+
 // Statfs is a passthrough to the underlying file system.
 func (fsys *FileSystem) Statfs(ctx context.Context, req *fuse.StatfsRequest, resp *fuse.StatfsResponse) error {
 	// Obtain statfs() call from underlying store path.
@@ -162,6 +381,13 @@ func (fsys *FileSystem) Statfs(ctx context.Context, req *fuse.StatfsRequest, res
 	return nil
 }
 
+// fuseServer returns the server for the current mount.
+func (fsys *FileSystem) fuseServer() *fs.Server {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	return fsys.server
+}
+
 // InvalidateDB invalidates a database in the kernel page cache.
 func (fsys *FileSystem) InvalidateDB(db *litefs.DB, offset, size int64) error {
 	node := fsys.root.Node(db.Name())
@@ -169,7 +395,7 @@ func (fsys *FileSystem) InvalidateDB(db *litefs.DB, offset, size int64) error {
 		return nil
 	}
 
-	if err := fsys.server.InvalidateNodeDataRange(node, offset, size); err != nil && err != fuse.ErrNotCached {
+	if err := fsys.fuseServer().InvalidateNodeDataRange(node, offset, size); err != nil && err != fuse.ErrNotCached {
 		return err
 	}
 	return nil
@@ -182,12 +408,48 @@ func (fsys *FileSystem) InvalidateSHM(db *litefs.DB) error {
 		return nil
 	}
 
-	if err := fsys.server.InvalidateNodeData(node); err != nil && err != fuse.ErrNotCached {
+	if err := fsys.fuseServer().InvalidateNodeData(node); err != nil && err != fuse.ErrNotCached {
+		return err
+	}
+	return nil
+}
+
+// InvalidateWAL invalidates the WAL file in the kernel page cache.
+func (fsys *FileSystem) InvalidateWAL(db *litefs.DB) error {
+	node := fsys.root.Node(db.Name() + "-wal")
+	if node == nil {
+		return nil
+	}
+
+	if err := fsys.fuseServer().InvalidateNodeData(node); err != nil && err != fuse.ErrNotCached {
 		return err
 	}
 	return nil
 }
 
+// InvalidateEntry removes the cached nodes & kernel entries for all files of
+// the named database.
+func (fsys *FileSystem) InvalidateEntry(name string) error {
+	for _, filename := range fsys.root.forgetDB(name) {
+		if err := fsys.fuseServer().InvalidateEntry(fsys.root, filename); err != nil && err != fuse.ErrNotCached {
+			return err
+		}
+	}
+	return nil
+}
+
+// observeOp records the latency of a FUSE operation started at t and logs it
+// if it exceeds the slow operation threshold.
+func (fsys *FileSystem) observeOp(op, dbName string, t time.Time) {
+	d := time.Since(t)
+	fuseOpDurationMetricVec.WithLabelValues(op).Observe(d.Seconds())
+
+	if fsys.SlowOpThreshold > 0 && d >= fsys.SlowOpThreshold {
+		log.Printf("slow fuse operation: op=%s db=%q duration=%s", op, dbName, d)
+		fuseSlowOpCountMetricVec.WithLabelValues(op).Inc()
+	}
+}
+
 // InvalidatePos invalidates the position file in the kernel page cache.
 func (fsys *FileSystem) InvalidatePos(db *litefs.DB) error {
 	node := fsys.root.Node(db.Name() + "-pos")
@@ -195,8 +457,27 @@ func (fsys *FileSystem) InvalidatePos(db *litefs.DB) error {
 		return nil
 	}
 
-	if err := fsys.server.InvalidateNodeData(node); err != nil && err != fuse.ErrNotCached {
+	if err := fsys.fuseServer().InvalidateNodeData(node); err != nil && err != fuse.ErrNotCached {
 		return err
 	}
 	return nil
 }
+
+// File system metrics.
+var (
+	remountCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_fuse_remount_count",
+		Help: "Number of times the FUSE file system was remounted.",
+	})
+
+	fuseOpDurationMetricVec = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "litefs_fuse_op_duration_seconds",
+		Help:    "Latency of FUSE read, write, fsync & lookup operations.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, []string{"op"})
+
+	fuseSlowOpCountMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_fuse_slow_op_count",
+		Help: "Number of FUSE operations exceeding the slow operation threshold.",
+	}, []string{"op"})
+)
@@ -192,6 +192,32 @@ func TestFileSystem_NoWrite(t *testing.T) {
 	}
 }
 
+// Ensure writes without a journal are detected as an unsupported journal mode.
+func TestFileSystem_JournalModeOff(t *testing.T) {
+	if testingutil.IsWALMode() {
+		t.Skip("rollback journal only")
+	}
+
+	fs := newOpenFileSystem(t, t.TempDir(), litefs.NewStaticLeaser(true, "localhost", "http://localhost:20202"))
+	dsn := filepath.Join(fs.Path(), "db")
+	db := testingutil.OpenSQLDB(t, dsn)
+
+	if _, err := db.Exec(`CREATE TABLE t (x)`); err != nil {
+		t.Fatal(err)
+	} else if got, want := fs.Store().DB("db").JournalMode(), litefs.JournalMode(strings.ToUpper(testingutil.JournalMode())); got != want {
+		t.Fatalf("JournalMode=%q, want %q", got, want)
+	}
+
+	// Disable the journal and write again.
+	if _, err := db.Exec(`PRAGMA journal_mode = OFF`); err != nil {
+		t.Fatal(err)
+	} else if _, err := db.Exec(`INSERT INTO t VALUES (100)`); err != nil {
+		t.Fatal(err)
+	} else if got, want := fs.Store().DB("db").JournalMode(), litefs.JournalMode(litefs.JournalModeOff); got != want {
+		t.Fatalf("JournalMode=%q, want %q", got, want)
+	}
+}
+
 func TestFileSystem_MultipleJournalSegments(t *testing.T) {
 	fs := newOpenFileSystem(t, t.TempDir(), litefs.NewStaticLeaser(true, "localhost", "http://localhost:20202"))
 	dsn := filepath.Join(fs.Path(), "db")
@@ -332,6 +358,102 @@ func TestFileSystem_ReadDir(t *testing.T) {
 	}
 }
 
+func TestFileSystem_ReadDir_HideWAL(t *testing.T) {
+	fs := newFileSystem(t, t.TempDir(), litefs.NewStaticLeaser(true, "localhost", "http://localhost:20202"))
+	fs.HideWAL = true
+	if err := fs.Mount(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = fs.Unmount() })
+
+	db := testingutil.OpenSQLDB(t, filepath.Join(fs.Path(), "db"))
+	if _, err := db.Exec(`CREATE TABLE t (x)`); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "db\ndb-pos\n"
+	switch testingutil.JournalMode() {
+	case "persist", "truncate":
+		want = "db\ndb-journal\ndb-pos\n"
+	}
+
+	// Sidecar files are omitted while SQLite continues to use them by name.
+	cmd := exec.Command("ls")
+	cmd.Dir = fs.Path()
+	if buf, err := cmd.CombinedOutput(); err != nil {
+		t.Fatal(err)
+	} else if got := string(buf); got != want {
+		t.Fatalf("unexpected output: %q", got)
+	}
+
+	if _, err := db.Exec(`INSERT INTO t VALUES (1)`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure databases can be presented under a nested subdirectory.
+func TestFileSystem_Subdir(t *testing.T) {
+	dir := t.TempDir()
+	fs := newFileSystem(t, dir, litefs.NewStaticLeaser(true, "localhost", "http://localhost:20202"))
+	fs.Subdir = "app/data"
+	if err := fs.Mount(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = fs.Unmount() })
+
+	db := testingutil.OpenSQLDB(t, filepath.Join(fs.Path(), "app", "data", "db"))
+	if _, err := db.Exec(`CREATE TABLE t (x)`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ensure the database is stored by its logical name.
+	if fs.Store().DB("db") == nil {
+		t.Fatal("expected database")
+	}
+
+	// Ensure intermediate directories only list the next path segment.
+	if ents, err := os.ReadDir(filepath.Join(fs.Path(), "app")); err != nil {
+		t.Fatal(err)
+	} else if len(ents) != 1 || ents[0].Name() != "data" || !ents[0].IsDir() {
+		t.Fatalf("unexpected entries: %v", ents)
+	}
+}
+
+// Ensure configured modes are presented regardless of the process umask.
+func TestFileSystem_FileMode(t *testing.T) {
+	dir := t.TempDir()
+	fs := newFileSystem(t, dir, litefs.NewStaticLeaser(true, "localhost", "http://localhost:20202"))
+	fs.FileMode, fs.DirMode = 0600, 0700
+	fs.Subdir = "app"
+	if err := fs.Mount(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = fs.Unmount() })
+
+	db := testingutil.OpenSQLDB(t, filepath.Join(fs.Path(), "app", "db"))
+	if _, err := db.Exec(`CREATE TABLE t (x)`); err != nil {
+		t.Fatal(err)
+	}
+
+	if fi, err := os.Stat(filepath.Join(fs.Path(), "app", "db")); err != nil {
+		t.Fatal(err)
+	} else if got, want := fi.Mode(), os.FileMode(0600); got != want {
+		t.Fatalf("db mode=%s, want %s", got, want)
+	}
+	if fi, err := os.Stat(filepath.Join(fs.Path(), "app")); err != nil {
+		t.Fatal(err)
+	} else if got, want := fi.Mode(), os.ModeDir|0700; got != want {
+		t.Fatalf("root mode=%s, want %s", got, want)
+	}
+
+	// Intermediate directories never allow new files.
+	if fi, err := os.Stat(fs.Path()); err != nil {
+		t.Fatal(err)
+	} else if got, want := fi.Mode(), os.ModeDir|0500; got != want {
+		t.Fatalf("dir mode=%s, want %s", got, want)
+	}
+}
+
 // Ensures the statfs() executes and does not panic.
 func TestFileSystem_Statfs(t *testing.T) {
 	fs := newOpenFileSystem(t, t.TempDir(), litefs.NewStaticLeaser(true, "localhost", "http://localhost:20202"))
@@ -341,6 +463,40 @@ func TestFileSystem_Statfs(t *testing.T) {
 	}
 }
 
+// Ensure open file handles & inodes are tracked by database.
+func TestFileSystem_Stats(t *testing.T) {
+	fs := newOpenFileSystem(t, t.TempDir(), litefs.NewStaticLeaser(true, "localhost", "http://localhost:20202"))
+	dsn := filepath.Join(fs.Path(), "db")
+	db := testingutil.OpenSQLDB(t, dsn)
+
+	if _, err := db.Exec(`CREATE TABLE t (x)`); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := fs.Stats().DBs["db"]
+	if stats.Handles < 1 {
+		t.Fatalf("Handles=%d, want at least 1", stats.Handles)
+	} else if stats.MaxHandles < stats.Handles {
+		t.Fatalf("MaxHandles=%d, want at least %d", stats.MaxHandles, stats.Handles)
+	} else if stats.Inodes < 1 {
+		t.Fatalf("Inodes=%d, want at least 1", stats.Inodes)
+	}
+
+	// Ensure handles are released once the database is closed. The high-water
+	// mark should remain.
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.RetryUntil(t, 1*time.Millisecond, 5*time.Second, func() error {
+		if got := fs.Stats().DBs["db"]; got.Handles != 0 {
+			return fmt.Errorf("Handles=%d, want 0", got.Handles)
+		} else if got.MaxHandles != stats.MaxHandles {
+			return fmt.Errorf("MaxHandles=%d, want %d", got.MaxHandles, stats.MaxHandles)
+		}
+		return nil
+	})
+}
+
 func TestFileSystem_Pos(t *testing.T) {
 	t.Run("ReopenHandle", func(t *testing.T) {
 		fs := newOpenFileSystem(t, t.TempDir(), litefs.NewStaticLeaser(true, "localhost", "http://localhost:20202"))
@@ -559,11 +715,62 @@ func testFileSystem_Checkpoint(t *testing.T, mode string) {
 	}
 }
 
+// Ensure the WAL is checkpointed by LiteFS once it exceeds the max size, even
+// if SQLite's own automatic checkpointing is disabled.
+func TestFileSystem_MaxWALSize(t *testing.T) {
+	if !testingutil.IsWALMode() {
+		t.Skip("checkpointing does not apply to the rollback journal, skipping")
+	}
+
+	fs := newOpenFileSystem(t, t.TempDir(), litefs.NewStaticLeaser(true, "localhost", "http://localhost:20202"))
+	fs.Store().MaxWALSize = 16384
+	dsn := filepath.Join(fs.Path(), "db")
+	db := testingutil.OpenSQLDB(t, dsn)
+
+	if _, err := db.Exec(`PRAGMA wal_autocheckpoint = 0`); err != nil {
+		t.Fatal(err)
+	} else if _, err := db.Exec(`CREATE TABLE t (x)`); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := db.Exec(`INSERT INTO t VALUES (?)`, strings.Repeat("x", 4000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Wait for the background checkpoint to truncate the WAL.
+	walPath := fs.Store().DB("db").WALPath()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if fi, err := os.Stat(walPath); err != nil {
+			t.Fatal(err)
+		} else if fi.Size() == 0 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("wal not checkpointed, size=%d", fi.Size())
+		}
+	}
+
+	// Ensure writes continue after the WAL is reset & the data is intact.
+	txID := fs.Store().DB("db").TXID()
+	if _, err := db.Exec(`INSERT INTO t VALUES ('y')`); err != nil {
+		t.Fatal(err)
+	} else if got, want := fs.Store().DB("db").TXID(), txID+1; got != want {
+		t.Fatalf("txid=%d, want %d", got, want)
+	}
+
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM t`).Scan(&n); err != nil {
+		t.Fatal(err)
+	} else if got, want := n, 11; got != want {
+		t.Fatalf("count=%d, want %d", got, want)
+	}
+}
+
 func newFileSystem(tb testing.TB, path string, leaser litefs.Leaser) *fuse.FileSystem {
 	tb.Helper()
 
 	store := litefs.NewStore(filepath.Join(path, "data"), true)
-	store.Debug = *debug
+	store.SetDebug(*debug)
 	store.StrictVerify = true
 	store.Leaser = leaser
 	if err := store.Open(); err != nil {
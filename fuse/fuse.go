@@ -1,6 +1,8 @@
 package fuse
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -43,11 +45,44 @@ func ParseFilename(name string) (dbName string, fileType litefs.FileType) {
 }
 
 // ToError converts an error to a wrapped error with a FUSE status code.
+//
+// SQLite decides whether to retry or abort a transaction from the errno of a
+// failed write or sync so each failure class maps to a specific errno:
+//
+//	ENOENT: the file or database does not exist.
+//	EEXIST: the database name differs only by case from an existing database
+//	        on a case-insensitive data directory.
+//	EPERM:  the database name is excluded by the databases include/exclude
+//	        patterns or a non-database file is rejected by fuse.non-db-files.
+//	EACCES: the node is a replica or lost its lease during the transaction.
+//	EAGAIN: the node is being promoted to primary. Retry once promoted.
+//	ENOSPC: the disk is full or over quota. SQLite reports SQLITE_FULL.
+//	EROFS:  writes are disabled while the store shuts down or drains.
+//	EBUSY:  a timeout elapsed while waiting on the store or no primary is
+//	        available for a linearizable read. SQLite reports SQLITE_BUSY.
+//
+// Otherwise the errno of a failed system call, such as EIO, is passed through.
+// Other errors are returned as-is and are reported to the kernel as EIO.
 func ToError(err error) error {
-	if os.IsNotExist(err) {
+	var errno syscall.Errno
+	if os.IsNotExist(err) || errors.Is(err, litefs.ErrDatabaseNotFound) {
 		return &Error{err: err, errno: fuse.ENOENT}
-	} else if err == litefs.ErrReadOnlyReplica {
+	} else if errors.Is(err, litefs.ErrDatabaseNameCollision) {
+		return &Error{err: err, errno: fuse.Errno(syscall.EEXIST)}
+	} else if errors.Is(err, litefs.ErrDatabaseNotManaged) || errors.Is(err, litefs.ErrNonDBFileRejected) {
+		return &Error{err: err, errno: fuse.Errno(syscall.EPERM)}
+	} else if errors.Is(err, litefs.ErrReadOnlyReplica) {
 		return &Error{err: err, errno: fuse.Errno(syscall.EACCES)}
+	} else if errors.Is(err, litefs.ErrPromoting) {
+		return &Error{err: err, errno: fuse.Errno(syscall.EAGAIN)}
+	} else if errors.Is(err, litefs.ErrNoSpace) || errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT) {
+		return &Error{err: err, errno: fuse.Errno(syscall.ENOSPC)}
+	} else if errors.Is(err, litefs.ErrWritesDisabled) {
+		return &Error{err: err, errno: fuse.Errno(syscall.EROFS)}
+	} else if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, litefs.ErrNoPrimary) {
+		return &Error{err: err, errno: fuse.Errno(syscall.EBUSY)}
+	} else if errors.As(err, &errno) {
+		return &Error{err: err, errno: fuse.Errno(errno)}
 	}
 	return err
 }
@@ -1,14 +1,22 @@
 package fuse_test
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"strings"
 	"syscall"
 	"testing"
+	"time"
 
+	bazilfuse "bazil.org/fuse"
+	"bazil.org/fuse/fs"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/superfly/litefs"
 	"github.com/superfly/litefs/fuse"
 )
@@ -68,6 +76,45 @@ func TestToErrno(t *testing.T) {
 		}
 	})
 
+	t.Run("Errno", func(t *testing.T) {
+		for _, tt := range []struct {
+			name  string
+			err   error
+			errno syscall.Errno
+		}{
+			{"DatabaseNotFound", fmt.Errorf("open: %w", litefs.ErrDatabaseNotFound), syscall.ENOENT},
+			{"DatabaseNameCollision", litefs.ErrDatabaseNameCollision, syscall.EEXIST},
+			{"DatabaseNotManaged", litefs.ErrDatabaseNotManaged, syscall.EPERM},
+			{"NonDBFileRejected", litefs.ErrNonDBFileRejected, syscall.EPERM},
+			{"LeaseLost", fmt.Errorf("write: %w", litefs.ErrReadOnlyReplica), syscall.EACCES},
+			{"Promoting", litefs.ErrPromoting, syscall.EAGAIN},
+			{"NoSpace", fmt.Errorf("%w (free=0 bytes)", litefs.ErrNoSpace), syscall.ENOSPC},
+			{"DiskFull", fmt.Errorf("sync ltx file: %w", &os.PathError{Op: "write", Path: "x", Err: syscall.ENOSPC}), syscall.ENOSPC},
+			{"OverQuota", &os.PathError{Op: "write", Path: "x", Err: syscall.EDQUOT}, syscall.ENOSPC},
+			{"WritesDisabled", fmt.Errorf("commit journal: %w", litefs.ErrWritesDisabled), syscall.EROFS},
+			{"Timeout", fmt.Errorf("wait: %w", context.DeadlineExceeded), syscall.EBUSY},
+			{"DiskError", &os.PathError{Op: "fsync", Path: "x", Err: syscall.EIO}, syscall.EIO},
+			{"ReadOnlyFileSystem", &os.PathError{Op: "write", Path: "x", Err: syscall.EROFS}, syscall.EROFS},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				err, ok := fuse.ToError(tt.err).(*fuse.Error)
+				if !ok {
+					t.Fatalf("expected fuse error, got %#v", err)
+				} else if got, want := err.Error(), tt.err.Error(); got != want {
+					t.Fatalf("Error()=%q, want %q", got, want)
+				} else if got, want := syscall.Errno(err.Errno()), tt.errno; got != want {
+					t.Fatalf("Errno()=%v, want %v", got, want)
+				}
+			})
+		}
+	})
+
+	t.Run("Nil", func(t *testing.T) {
+		if err := fuse.ToError(nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
 	t.Run("Passthrough", func(t *testing.T) {
 		if _, ok := fuse.ToError(errors.New("marker")).(*fuse.Error); ok {
 			t.Fatal("expected original error")
@@ -95,3 +142,107 @@ func TestParseFilename(t *testing.T) {
 		}
 	}
 }
+
+// Ensure FUSE operations are timed & slow ones are logged without a mount.
+func TestFileSystem_SlowOp(t *testing.T) {
+	store := litefs.NewStore(t.TempDir(), true)
+	store.Leaser = litefs.NewStaticLeaser(true, "localhost", "http://localhost:20202")
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = store.Close() }()
+	<-store.ReadyCh()
+
+	if _, f, err := store.CreateDB("db"); err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := func(tb testing.TB, fsys *fuse.FileSystem) string {
+		tb.Helper()
+
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		defer log.SetOutput(os.Stderr)
+
+		root, err := fsys.Root()
+		if err != nil {
+			tb.Fatal(err)
+		} else if _, err := root.(fs.NodeStringLookuper).Lookup(context.Background(), "db"); err != nil {
+			tb.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	t.Run("Slow", func(t *testing.T) {
+		fsys := fuse.NewFileSystem(t.TempDir(), store)
+		fsys.SlowOpThreshold = 1 * time.Nanosecond
+
+		prevN := fuseOpSampleCount(t, "lookup")
+		if out := lookup(t, fsys); !strings.Contains(out, `slow fuse operation: op=lookup db="db" duration=`) {
+			t.Fatalf("expected slow operation log, got %q", out)
+		} else if got, want := fuseOpSampleCount(t, "lookup"), prevN+1; got != want {
+			t.Fatalf("lookup samples=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		fsys := fuse.NewFileSystem(t.TempDir(), store)
+
+		prevN := fuseOpSampleCount(t, "lookup")
+		if out := lookup(t, fsys); strings.Contains(out, "slow fuse operation") {
+			t.Fatalf("unexpected slow operation log: %q", out)
+		} else if got, want := fuseOpSampleCount(t, "lookup"), prevN+1; got != want {
+			t.Fatalf("lookup samples=%d, want %d", got, want)
+		}
+	})
+}
+
+// Ensure creating a database on a replica which rejects local writes returns
+// the same errno as other writes on a replica.
+func TestRootNode_Create_ReadOnlyReplica(t *testing.T) {
+	store := litefs.NewStore(t.TempDir(), false)
+	store.Leaser = litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202")
+	store.LocalWrite = litefs.LocalWriteReject
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = store.Close() }()
+
+	root, err := fuse.NewFileSystem(t.TempDir(), store).Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fuseErr *fuse.Error
+	_, _, err = root.(fs.NodeCreater).Create(context.Background(), &bazilfuse.CreateRequest{Name: "db"}, &bazilfuse.CreateResponse{})
+	if !errors.As(err, &fuseErr) {
+		t.Fatalf("unexpected error: %#v", err)
+	} else if got, want := syscall.Errno(fuseErr.Errno()), syscall.EACCES; got != want {
+		t.Fatalf("Errno()=%v, want %v", got, want)
+	}
+}
+
+// fuseOpSampleCount returns the number of latency observations for a FUSE op.
+func fuseOpSampleCount(tb testing.TB, op string) uint64 {
+	tb.Helper()
+
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "litefs_fuse_op_duration_seconds" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "op" && label.GetValue() == op {
+					return m.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	return 0
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"syscall"
+	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
@@ -39,7 +40,7 @@ func (n *JournalNode) Attr(ctx context.Context, attr *fuse.Attr) error {
 		return err
 	}
 
-	attr.Mode = 0666
+	attr.Mode = n.fsys.fileMode(true)
 	attr.Size = uint64(fi.Size())
 	attr.Uid = uint32(n.fsys.Uid)
 	attr.Gid = uint32(n.fsys.Gid)
@@ -59,16 +60,19 @@ func (n *JournalNode) Open(ctx context.Context, req *fuse.OpenRequest, resp *fus
 
 // Fsync performs an fsync() on the underlying file.
 func (n *JournalNode) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
+	defer n.fsys.observeOp("fsync", n.db.Name(), time.Now())
+
 	f, err := os.Open(n.db.JournalPath())
 	if err != nil {
-		return err
+		return ToError(err)
 	}
 	defer func() { _ = f.Close() }()
 
 	if err := f.Sync(); err != nil {
-		return err
+		log.Printf("fuse: fsync(): journal error: %s", err)
+		return ToError(err)
 	} else if err := f.Close(); err != nil {
-		return err
+		return ToError(err)
 	}
 
 	// TODO: fsync parent directory
@@ -82,7 +86,8 @@ func (n *JournalNode) Setattr(ctx context.Context, req *fuse.SetattrRequest, res
 			return syscall.EINVAL
 		}
 		if err := n.db.CommitJournal(litefs.JournalModeTruncate); err != nil {
-			return fmt.Errorf("commit journal (TRUNCATE): %w", err)
+			log.Printf("fuse: commit error: %s", err)
+			return ToError(fmt.Errorf("commit journal (TRUNCATE): %w", err))
 		}
 	}
 
@@ -126,10 +131,13 @@ type JournalHandle struct {
 }
 
 func newJournalHandle(node *JournalNode, file *os.File) *JournalHandle {
+	node.fsys.openHandle(node.db)
 	return &JournalHandle{node: node, file: file}
 }
 
 func (h *JournalHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	defer h.node.fsys.observeOp("read", h.node.db.Name(), time.Now())
+
 	n, err := h.file.ReadAt(resp.Data, req.Offset)
 	if n != len(resp.Data) {
 		return io.ErrShortBuffer
@@ -138,6 +146,8 @@ func (h *JournalHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *f
 }
 
 func (h *JournalHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	defer h.node.fsys.observeOp("write", h.node.db.Name(), time.Now())
+
 	if err := h.node.db.WriteJournal(h.file, req.Data, req.Offset); err != nil {
 		log.Printf("fuse: write(): journal error: %s", err)
 		return ToError(err)
@@ -152,6 +162,7 @@ func (h *JournalHandle) Flush(ctx context.Context, req *fuse.FlushRequest) error
 }
 
 func (h *JournalHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	h.node.fsys.releaseHandle(h.node.db)
 	_ = h.file.Close()
 	return nil
 }
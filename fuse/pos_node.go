@@ -4,11 +4,14 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"log"
+	"strings"
 	"syscall"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
 	"github.com/superfly/litefs"
+	"github.com/superfly/ltx"
 )
 
 // PosFileSize is the size, in bytes, of the "-pos" file.
@@ -22,6 +25,7 @@ var _ fs.NodeGetxattrer = (*PosNode)(nil)
 var _ fs.NodeSetxattrer = (*PosNode)(nil)
 var _ fs.NodeRemovexattrer = (*PosNode)(nil)
 var _ fs.NodePoller = (*PosNode)(nil)
+var _ fs.HandleWriter = (*PosNode)(nil)
 
 // PosNode represents a file that returns the current position of the database.
 type PosNode struct {
@@ -34,7 +38,7 @@ func newPosNode(fsys *FileSystem, db *litefs.DB) *PosNode {
 }
 
 func (n *PosNode) Attr(ctx context.Context, attr *fuse.Attr) error {
-	attr.Mode = 0666
+	attr.Mode = n.fsys.fileMode(true)
 	attr.Size = uint64(PosFileSize)
 	attr.Uid = uint32(n.fsys.Uid)
 	attr.Gid = uint32(n.fsys.Gid)
@@ -64,6 +68,34 @@ func (n *PosNode) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.Re
 	return nil
 }
 
+// Write blocks until the database reaches the position written to the file.
+// Writing a TXID as 16 hex characters waits until it has been applied locally.
+// Writing "primary" waits until the primary's current position is applied,
+// which acts as a linearizable read barrier for the transactions that follow.
+// Returns EBUSY if the position is not reached within the store's ReadTimeout.
+func (n *PosNode) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	store := n.fsys.store
+
+	var err error
+	switch s := strings.TrimSpace(string(req.Data)); s {
+	case "primary":
+		err = store.WaitPrimaryPos(ctx, n.db)
+	default:
+		txID, e := ltx.ParseTXID(s)
+		if e != nil {
+			return fuse.Errno(syscall.EINVAL)
+		}
+		err = store.WaitPos(ctx, n.db, txID)
+	}
+	if err != nil {
+		log.Printf("fuse: write(): pos wait error: %s", err)
+		return ToError(err)
+	}
+
+	resp.Size = len(req.Data)
+	return nil
+}
+
 func (n *PosNode) Forget() { n.fsys.root.ForgetNode(n) }
 
 // ENOSYS is a special return code for xattr requests that will be treated as a permanent failure for any such
@@ -34,7 +34,7 @@ func (n *PrimaryNode) Attr(ctx context.Context, attr *fuse.Attr) error {
 		return fuse.Errno(syscall.ENOENT)
 	}
 
-	attr.Mode = 0444
+	attr.Mode = n.fsys.fileMode(false)
 	attr.Size = uint64(len(info.Hostname) + 1)
 	attr.Uid = uint32(n.fsys.Uid)
 	attr.Gid = uint32(n.fsys.Gid)
@@ -8,6 +8,7 @@ import (
 	"sort"
 	"sync"
 	"syscall"
+	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
@@ -20,7 +21,10 @@ var _ fs.Node = (*RootNode)(nil)
 var _ fs.NodeStringLookuper = (*RootNode)(nil)
 var _ fs.NodeOpener = (*RootNode)(nil)
 var _ fs.NodeCreater = (*RootNode)(nil)
+var _ fs.NodeSymlinker = (*RootNode)(nil)
+var _ fs.NodeMknoder = (*RootNode)(nil)
 var _ fs.NodeRemover = (*RootNode)(nil)
+var _ fs.NodeRenamer = (*RootNode)(nil)
 var _ fs.NodeFsyncer = (*RootNode)(nil)
 var _ fs.NodeListxattrer = (*RootNode)(nil)
 var _ fs.NodeGetxattrer = (*RootNode)(nil)
@@ -52,14 +56,14 @@ func (n *RootNode) Node(name string) fs.Node {
 
 // Attr returns the attributes for the root directory.
 func (n *RootNode) Attr(ctx context.Context, attr *fuse.Attr) error {
-	attr.Inode = RootInode
-
-	if n.fsys.store.IsPrimary() {
-		attr.Mode = os.ModeDir | 0777
-	} else {
-		attr.Mode = os.ModeDir | 0555
+	// Use a dynamic inode when nested under a subdirectory.
+	if n.fsys.Subdir == "" {
+		attr.Inode = RootInode
 	}
 
+	// Replicas that shadow local writes allow new databases to be created.
+	attr.Mode = n.fsys.dirMode(n.fsys.store.IsPrimary() || n.fsys.store.LocalWrite == litefs.LocalWriteShadow)
+
 	attr.Uid = uint32(n.fsys.Uid)
 	attr.Gid = uint32(n.fsys.Gid)
 	return nil
@@ -67,6 +71,9 @@ func (n *RootNode) Attr(ctx context.Context, attr *fuse.Attr) error {
 
 // Lookup returns a node for a file in the root directory.
 func (n *RootNode) Lookup(ctx context.Context, name string) (node fs.Node, err error) {
+	dbName, _ := ParseFilename(name)
+	defer n.fsys.observeOp("lookup", dbName, time.Now())
+
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
@@ -88,6 +95,7 @@ func (n *RootNode) Lookup(ctx context.Context, name string) (node fs.Node, err e
 
 	// Cache node on successful lookup.
 	n.nodes[name] = node
+	n.fsys.trackInodes(len(n.nodes))
 
 	return node, nil
 }
@@ -105,7 +113,7 @@ func (n *RootNode) lookupDBNode(ctx context.Context, name string) (fs.Node, erro
 
 	db := n.fsys.store.DB(dbName)
 	if db == nil {
-		return nil, fuse.ToErrno(syscall.ENOENT)
+		return n.lookupSpecialFileNode(ctx, name)
 	}
 
 	switch fileType {
@@ -144,12 +152,66 @@ func (n *RootNode) lookupDBNode(ctx context.Context, name string) (fs.Node, erro
 	}
 }
 
+func (n *RootNode) lookupSpecialFileNode(ctx context.Context, name string) (fs.Node, error) {
+	if f, err := n.fsys.store.SpecialFile(name); err != nil {
+		return nil, err
+	} else if f == nil {
+		return nil, fuse.ToErrno(syscall.ENOENT)
+	}
+	return newSpecialFileNode(n.fsys, name), nil
+}
+
+// Symlink creates a symlink, which is stored as a special file & is never
+// treated as a database.
+func (n *RootNode) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (fs.Node, error) {
+	return n.createSpecialFile(litefs.SpecialFile{Name: req.NewName, Mode: os.ModeSymlink | 0777, Target: req.Target})
+}
+
+// Mknod creates a socket or FIFO, which is stored as a special file. Regular
+// files are created through Create() as databases & device nodes are rejected.
+func (n *RootNode) Mknod(ctx context.Context, req *fuse.MknodRequest) (fs.Node, error) {
+	switch req.Mode.Type() {
+	case os.ModeNamedPipe, os.ModeSocket:
+	default:
+		return nil, fuse.Errno(syscall.EPERM)
+	}
+	return n.createSpecialFile(litefs.SpecialFile{Name: req.Name, Mode: req.Mode &^ req.Umask})
+}
+
+func (n *RootNode) createSpecialFile(f litefs.SpecialFile) (fs.Node, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// Names of a database's journal, WAL & SHM files would be shadowed.
+	if dbName, _ := ParseFilename(f.Name); n.fsys.store.DB(dbName) != nil {
+		return nil, fuse.Errno(syscall.EEXIST)
+	}
+
+	if err := n.fsys.store.CreateSpecialFile(f); err == litefs.ErrDatabaseExists {
+		return nil, fuse.Errno(syscall.EEXIST)
+	} else if err != nil {
+		return nil, ToError(err)
+	}
+
+	node := newSpecialFileNode(n.fsys, f.Name)
+	n.nodes[f.Name] = node
+	n.fsys.trackInodes(len(n.nodes))
+	return node, nil
+}
+
 func (n *RootNode) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (node fs.Node, h fs.Handle, err error) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
 	resp.Flags |= fuse.OpenKeepCache
 
+	// Special files share the namespace with databases & their sidecar files.
+	if f, err := n.fsys.store.SpecialFile(req.Name); err != nil {
+		return nil, nil, ToError(err)
+	} else if f != nil {
+		return nil, nil, fuse.Errno(syscall.EEXIST)
+	}
+
 	dbName, fileType := ParseFilename(req.Name)
 
 	switch fileType {
@@ -175,6 +237,7 @@ func (n *RootNode) Create(ctx context.Context, req *fuse.CreateRequest, resp *fu
 
 	// Cache node on creation.
 	n.nodes[req.Name] = node
+	n.fsys.trackInodes(len(n.nodes))
 
 	return node, h, nil
 }
@@ -183,13 +246,15 @@ func (n *RootNode) createDatabase(ctx context.Context, dbName string, req *fuse.
 	db, file, err := n.fsys.store.CreateDB(dbName)
 	if err == litefs.ErrDatabaseExists {
 		return nil, nil, fuse.Errno(syscall.EEXIST)
+	} else if err == litefs.ErrReadOnlyReplica || err == litefs.ErrPromoting {
+		return nil, nil, ToError(err) // rejected by replica.local-write or promotion
 	} else if err != nil {
 		log.Printf("fuse: create(): cannot create database: %s", err)
 		return nil, nil, ToError(err)
 	}
 
 	node := newDatabaseNode(n.fsys, db)
-	return node, &DatabaseHandle{node: node, file: file}, nil
+	return node, newDatabaseHandle(node, file), nil
 }
 
 func (n *RootNode) createJournal(ctx context.Context, dbName string, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
@@ -260,28 +325,112 @@ func (n *RootNode) Remove(ctx context.Context, req *fuse.RemoveRequest) (err err
 
 	db := n.fsys.store.DB(dbName)
 	if db == nil {
-		return fuse.ToErrno(syscall.ENOENT)
+		return n.removeSpecialFile(req.Name)
 	}
 
 	switch fileType {
 	case litefs.FileTypeJournal:
 		if err := db.CommitJournal(litefs.JournalModeDelete); err != nil {
 			log.Printf("fuse: commit error: %s", err)
-			return err
+			return ToError(err)
 		}
 		return nil
 
 	case litefs.FileTypeWAL:
-		return os.Remove(db.WALPath())
+		return ToError(os.Remove(db.WALPath()))
 
 	case litefs.FileTypeSHM:
-		return os.Remove(db.SHMPath())
+		return ToError(os.Remove(db.SHMPath()))
+
+	case litefs.FileTypeDatabase:
+		if err := n.fsys.store.DropDB(ctx, dbName); err != nil {
+			log.Printf("fuse: remove(): cannot drop database: %s", err)
+			return ToError(err)
+		}
+		n.forgetDB(dbName)
+		return nil
 
 	default:
 		return fuse.ToErrno(syscall.ENOSYS)
 	}
 }
 
+func (n *RootNode) removeSpecialFile(name string) error {
+	if f, err := n.fsys.store.SpecialFile(name); err != nil {
+		return ToError(err)
+	} else if f == nil {
+		return fuse.ToErrno(syscall.ENOENT)
+	}
+
+	if err := n.fsys.store.RemoveSpecialFile(name); err != nil {
+		return ToError(err)
+	}
+
+	n.mu.Lock()
+	delete(n.nodes, name)
+	n.mu.Unlock()
+	return nil
+}
+
+// Rename renames a database. Only database files can be renamed and only
+// within the same directory. Renaming over an existing database is not
+// supported & returns EEXIST.
+func (n *RootNode) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Node) error {
+	if newDir != n {
+		return fuse.ToErrno(syscall.EXDEV)
+	}
+
+	oldName, oldType := ParseFilename(req.OldName)
+	newName, newType := ParseFilename(req.NewName)
+	if oldType != litefs.FileTypeDatabase || newType != litefs.FileTypeDatabase {
+		return fuse.ToErrno(syscall.ENOSYS)
+	}
+
+	if err := n.fsys.store.RenameDB(ctx, oldName, newName); err == litefs.ErrDatabaseExists {
+		return fuse.ToErrno(syscall.EEXIST)
+	} else if err != nil {
+		log.Printf("fuse: rename(): cannot rename database: %s", err)
+		return ToError(err)
+	}
+	n.forgetDB(oldName)
+	return nil
+}
+
+// forgetDB removes all cached nodes for the named database and returns their
+// filenames. The kernel updates its own entries for unlink & rename requests.
+func (n *RootNode) forgetDB(name string) []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var a []string
+	for filename := range n.nodes {
+		if filename == PrimaryFilename {
+			continue
+		} else if dbName, _ := ParseFilename(filename); dbName == name {
+			delete(n.nodes, filename)
+			a = append(a, filename)
+		}
+	}
+	n.fsys.trackInodes(len(n.nodes))
+	return a
+}
+
+// inodeNs returns the number of cached nodes for each database.
+func (n *RootNode) inodeNs() map[string]int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	m := make(map[string]int)
+	for name := range n.nodes {
+		if name == PrimaryFilename {
+			continue
+		}
+		dbName, _ := ParseFilename(name)
+		m[dbName]++
+	}
+	return m
+}
+
 // ForgetNode removes the node from the node map.
 func (n *RootNode) ForgetNode(node fs.Node) {
 	n.mu.Lock()
@@ -339,6 +488,18 @@ func (h *RootHandle) ReadDirAll(ctx context.Context) (ents []fuse.Dirent, err er
 		})
 	}
 
+	// Return special files, such as symlinks, which are not databases.
+	files, err := h.node.fsys.store.SpecialFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		ents = append(ents, fuse.Dirent{
+			Name: f.Name,
+			Type: specialFileDirentType(f.Mode),
+		})
+	}
+
 	// Return a list of database files.
 	dbs := h.node.fsys.store.DBs()
 	sort.Slice(dbs, func(i, j int) bool { return dbs[i].Name() < dbs[j].Name() })
@@ -360,6 +521,9 @@ func (h *RootHandle) ReadDirAll(ctx context.Context) (ents []fuse.Dirent, err er
 				Type: fuse.DT_File,
 			})
 		}
+		if h.node.fsys.HideWAL {
+			continue
+		}
 		if _, err := os.Stat(db.SHMPath()); err == nil {
 			ents = append(ents, fuse.Dirent{
 				Name: fmt.Sprintf("%s-shm", db.Name()),
@@ -376,3 +540,17 @@ func (h *RootHandle) ReadDirAll(ctx context.Context) (ents []fuse.Dirent, err er
 
 	return ents, nil
 }
+
+// specialFileDirentType returns the directory entry type of a special file.
+func specialFileDirentType(mode os.FileMode) fuse.DirentType {
+	switch mode.Type() {
+	case os.ModeSymlink:
+		return fuse.DT_Link
+	case os.ModeNamedPipe:
+		return fuse.DT_FIFO
+	case os.ModeSocket:
+		return fuse.DT_Socket
+	default:
+		return fuse.DT_Unknown
+	}
+}
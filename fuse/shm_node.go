@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"syscall"
+	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
@@ -44,7 +45,7 @@ func (n *SHMNode) Attr(ctx context.Context, attr *fuse.Attr) error {
 		return err
 	}
 
-	attr.Mode = 0666
+	attr.Mode = n.fsys.fileMode(true)
 	attr.Size = uint64(fi.Size())
 	attr.Uid = uint32(n.fsys.Uid)
 	attr.Gid = uint32(n.fsys.Gid)
@@ -72,6 +73,8 @@ func (n *SHMNode) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.Op
 }
 
 func (n *SHMNode) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
+	defer n.fsys.observeOp("fsync", n.db.Name(), time.Now())
+
 	f, err := os.Open(n.db.SHMPath())
 	if err != nil {
 		return err
@@ -126,10 +129,13 @@ type SHMHandle struct {
 }
 
 func newSHMHandle(node *SHMNode, file *os.File) *SHMHandle {
+	node.fsys.openHandle(node.db)
 	return &SHMHandle{node: node, file: file}
 }
 
 func (h *SHMHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	defer h.node.fsys.observeOp("read", h.node.db.Name(), time.Now())
+
 	buf := make([]byte, req.Size)
 	n, err := h.file.ReadAt(buf, req.Offset)
 	if err == io.EOF {
@@ -140,11 +146,13 @@ func (h *SHMHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.
 }
 
 func (h *SHMHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	defer h.node.fsys.observeOp("write", h.node.db.Name(), time.Now())
+
 	n, err := h.node.db.WriteSHM(h.file, req.Data, req.Offset)
 	resp.Size = n
 	if err != nil {
 		log.Printf("fuse: write(): shm error: %s", err)
-		return err
+		return ToError(err)
 	}
 	return nil
 }
@@ -157,6 +165,7 @@ func (h *SHMHandle) Flush(ctx context.Context, req *fuse.FlushRequest) error {
 }
 
 func (h *SHMHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	h.node.fsys.releaseHandle(h.node.db)
 	return h.file.Close()
 }
 
@@ -167,6 +176,15 @@ func (h *SHMHandle) Lock(ctx context.Context, req *fuse.LockRequest) error {
 		return fmt.Errorf("no wal locks")
 	}
 
+	// A shared read-mark lock starts a read transaction in WAL mode so wait
+	// for the primary's position first if reads are linearizable.
+	if req.Lock.Type == fuse.LockRead && isWALReadLock(lockTypes[0]) {
+		if err := h.node.fsys.store.WaitLinearizable(ctx, h.node.db); err != nil {
+			log.Printf("fuse: lock(): linearizable read: %s", err)
+			return ToError(err)
+		}
+	}
+
 	for _, lockType := range lockTypes {
 		guard := h.node.fsys.CreateGuardSetIfNotExists(h.node.db, req.LockOwner).Guard(lockType)
 
@@ -186,6 +204,16 @@ func (h *SHMHandle) Lock(ctx context.Context, req *fuse.LockRequest) error {
 	return nil
 }
 
+// isWALReadLock returns true if lockType is one of the WAL read-mark locks.
+func isWALReadLock(lockType litefs.LockType) bool {
+	switch lockType {
+	case litefs.LockTypeRead0, litefs.LockTypeRead1, litefs.LockTypeRead2, litefs.LockTypeRead3, litefs.LockTypeRead4:
+		return true
+	default:
+		return false
+	}
+}
+
 func (h *SHMHandle) LockWait(ctx context.Context, req *fuse.LockWaitRequest) error {
 	return fuse.Errno(syscall.ENOSYS)
 }
@@ -0,0 +1,75 @@
+package fuse
+
+import (
+	"context"
+	"os"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+)
+
+var _ fs.Node = (*SpecialFileNode)(nil)
+var _ fs.NodeReadlinker = (*SpecialFileNode)(nil)
+var _ fs.NodeForgetter = (*SpecialFileNode)(nil)
+var _ fs.NodeListxattrer = (*SpecialFileNode)(nil)
+var _ fs.NodeGetxattrer = (*SpecialFileNode)(nil)
+var _ fs.NodeSetxattrer = (*SpecialFileNode)(nil)
+var _ fs.NodeRemovexattrer = (*SpecialFileNode)(nil)
+
+// SpecialFileNode represents a symlink, socket or FIFO in the mount. Only the
+// file's metadata is stored by LiteFS. The kernel handles the I/O of sockets
+// & FIFOs itself.
+type SpecialFileNode struct {
+	fsys *FileSystem
+	name string
+}
+
+func newSpecialFileNode(fsys *FileSystem, name string) *SpecialFileNode {
+	return &SpecialFileNode{fsys: fsys, name: name}
+}
+
+func (n *SpecialFileNode) Attr(ctx context.Context, attr *fuse.Attr) error {
+	f, err := n.fsys.store.SpecialFile(n.name)
+	if err != nil {
+		return err
+	} else if f == nil {
+		return fuse.ENOENT
+	}
+
+	attr.Mode = f.Mode
+	attr.Size = uint64(len(f.Target))
+	attr.Uid = uint32(n.fsys.Uid)
+	attr.Gid = uint32(n.fsys.Gid)
+	return nil
+}
+
+func (n *SpecialFileNode) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
+	f, err := n.fsys.store.SpecialFile(n.name)
+	if err != nil {
+		return "", err
+	} else if f == nil {
+		return "", fuse.ENOENT
+	} else if f.Mode.Type() != os.ModeSymlink {
+		return "", fuse.Errno(syscall.EINVAL)
+	}
+	return f.Target, nil
+}
+
+func (n *SpecialFileNode) Forget() { n.fsys.root.ForgetNode(n) }
+
+func (n *SpecialFileNode) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	return fuse.ToErrno(syscall.ENOSYS)
+}
+
+func (n *SpecialFileNode) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	return fuse.ToErrno(syscall.ENOSYS)
+}
+
+func (n *SpecialFileNode) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	return fuse.ToErrno(syscall.ENOSYS)
+}
+
+func (n *SpecialFileNode) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
+	return fuse.ToErrno(syscall.ENOSYS)
+}
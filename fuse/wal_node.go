@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"syscall"
+	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
@@ -43,7 +44,7 @@ func (n *WALNode) Attr(ctx context.Context, attr *fuse.Attr) error {
 		return err
 	}
 
-	attr.Mode = 0666
+	attr.Mode = n.fsys.fileMode(true)
 	attr.Size = uint64(fi.Size())
 	attr.Uid = uint32(n.fsys.Uid)
 	attr.Gid = uint32(n.fsys.Gid)
@@ -54,7 +55,7 @@ func (n *WALNode) Attr(ctx context.Context, attr *fuse.Attr) error {
 func (n *WALNode) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
 	if req.Valid.Size() {
 		if err := os.Truncate(n.db.WALPath(), int64(req.Size)); err != nil {
-			return err
+			return ToError(err)
 		}
 	}
 	return n.Attr(ctx, &resp.Attr)
@@ -71,16 +72,19 @@ func (n *WALNode) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.Op
 }
 
 func (n *WALNode) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
+	defer n.fsys.observeOp("fsync", n.db.Name(), time.Now())
+
 	f, err := os.Open(n.db.WALPath())
 	if err != nil {
-		return err
+		return ToError(err)
 	}
 	defer func() { _ = f.Close() }()
 
 	if err := f.Sync(); err != nil {
-		return err
+		log.Printf("fuse: fsync(): wal error: %s", err)
+		return ToError(err)
 	} else if err := f.Close(); err != nil {
-		return err
+		return ToError(err)
 	}
 
 	// TODO: fsync parent directory
@@ -124,10 +128,13 @@ type WALHandle struct {
 }
 
 func newWALHandle(node *WALNode, file *os.File) *WALHandle {
+	node.fsys.openHandle(node.db)
 	return &WALHandle{node: node, file: file}
 }
 
 func (h *WALHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	defer h.node.fsys.observeOp("read", h.node.db.Name(), time.Now())
+
 	buf := make([]byte, req.Size)
 	n, err := h.file.ReadAt(buf, req.Offset)
 	if err == io.EOF {
@@ -138,6 +145,8 @@ func (h *WALHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.
 }
 
 func (h *WALHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	defer h.node.fsys.observeOp("write", h.node.db.Name(), time.Now())
+
 	// TODO(wal): Generate SQLITE_READONLY for WAL.
 	if err := h.node.db.WriteWAL(h.file, req.Data, req.Offset); err != nil {
 		log.Printf("fuse: write(): wal error: %s", err)
@@ -148,5 +157,6 @@ func (h *WALHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fus
 }
 
 func (h *WALHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	h.node.fsys.releaseHandle(h.node.db)
 	return h.file.Close()
 }
@@ -4,13 +4,19 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/superfly/litefs"
+	"github.com/superfly/ltx"
 	"golang.org/x/net/http2"
 )
 
@@ -20,20 +26,34 @@ var _ litefs.Client = (*Client)(nil)
 type Client struct {
 	// Underlying HTTP client
 	HTTPClient *http.Client
+
+	// Checksum algorithm sent to the primary so mismatched nodes are rejected.
+	ChecksumAlgorithm litefs.ChecksumAlgorithm
+
+	// Dialer used for connections to the primary. Set LocalAddr to originate
+	// connections from a specific source address.
+	Dialer *net.Dialer
+
+	// If true, the primary is asked to verify the replica's checksum at its
+	// current TXID on connect and to resync the replica if it has diverged.
+	VerifyOnConnect bool
 }
 
 // NewClient returns an instance of Client.
 func NewClient() *Client {
-	return &Client{
-		HTTPClient: &http.Client{
-			Transport: &http2.Transport{
-				AllowHTTP: true,
-				DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
-					return net.Dial(network, addr) // h2c-only right now
-				},
+	c := &Client{
+		ChecksumAlgorithm: litefs.ChecksumAlgorithmCRC64,
+		Dialer:            &net.Dialer{},
+	}
+	c.HTTPClient = &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return c.Dialer.Dial(network, addr) // h2c-only right now
 			},
 		},
 	}
+	return c
 }
 
 // Stream returns a snapshot and continuous stream of WAL updates.
@@ -59,20 +79,178 @@ func (c *Client) Stream(ctx context.Context, rawurl string, nodeID string, posMa
 		return nil, fmt.Errorf("cannot write pos map: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", u.String(), &buf)
+	// The request body stays open after the initial position map so that
+	// applied positions can be acknowledged back to the primary.
+	pr, pw := io.Pipe()
+
+	req, err := http.NewRequest("POST", u.String(), io.MultiReader(&buf, pr))
 	if err != nil {
+		_ = pw.Close()
 		return nil, err
 	}
 	req = req.WithContext(ctx)
 
 	req.Header.Set("Litefs-Id", nodeID)
+	req.Header.Set("Litefs-Checksum-Algorithm", string(c.ChecksumAlgorithm))
+	req.Header.Set(ProtocolVersionsHeader, FormatProtocolVersions())
+	// Verification is a per-connection request rather than a stream format
+	// capability, which is implied by the negotiated protocol version.
+	if c.VerifyOnConnect {
+		req.Header.Set("Litefs-Stream-Verify", "1")
+	}
+
+	// Identify the connection so logs on both nodes can be correlated.
+	connID := newRequestID()
+	req.Header.Set(RequestIDHeader, connID)
 
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
+		_ = pw.Close()
 		return nil, err
+	} else if resp.StatusCode == http.StatusUpgradeRequired {
+		_ = pw.Close()
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("primary refused stream: %s conn=%s", strings.TrimSpace(string(body)), connID)
 	} else if resp.StatusCode != http.StatusOK {
+		_ = pw.Close()
 		_ = resp.Body.Close()
-		return nil, fmt.Errorf("invalid response: code=%d", resp.StatusCode)
+
+		// Report the primary's requested delay so the replica can back off.
+		err := fmt.Errorf("invalid response: code=%d conn=%s", resp.StatusCode, connID)
+		if sec, e := strconv.Atoi(resp.Header.Get("Retry-After")); e == nil && sec > 0 {
+			return nil, &litefs.RetryAfterError{Err: err, RetryAfter: time.Duration(sec) * time.Second}
+		}
+		return nil, err
+	}
+
+	// Primaries which predate negotiation do not respond with a version.
+	version := ProtocolVersionMin
+	if s := resp.Header.Get(ProtocolVersionHeader); s != "" {
+		if version, err = strconv.Atoi(s); err != nil || version < ProtocolVersionMin || version > ProtocolVersionMax {
+			_ = pw.Close()
+			_ = resp.Body.Close()
+			return nil, fmt.Errorf("primary chose unsupported protocol version: %q conn=%s", s, connID)
+		}
+	}
+
+	log.Printf("stream connected to primary: conn=%s protocol=%d", connID, version)
+	return &stream{ReadCloser: resp.Body, pw: pw, protocolVersion: version}, nil
+}
+
+// Bench sends the payload from r to the server's bench endpoint and reads back
+// the echoed payload. Returns the number of bytes received.
+func (c *Client) Bench(ctx context.Context, rawurl string, r io.Reader) (int64, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return 0, fmt.Errorf("invalid client URL: %w", err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return 0, fmt.Errorf("invalid URL scheme")
+	} else if u.Host == "" {
+		return 0, fmt.Errorf("URL host required")
+	}
+
+	// Strip off everything but the scheme & host.
+	*u = url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   "/bench",
+	}
+
+	req, err := http.NewRequest("POST", u.String(), r)
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("invalid response: code=%d", resp.StatusCode)
+	}
+	return io.Copy(io.Discard, resp.Body)
+}
+
+var _ litefs.PosFetcher = (*Client)(nil)
+
+// FetchPos returns the current position of the named database on the node at
+// rawurl. This is used by replicas to wait for the primary's position.
+func (c *Client) FetchPos(ctx context.Context, rawurl string, name string) (litefs.Pos, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return litefs.Pos{}, fmt.Errorf("invalid client URL: %w", err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return litefs.Pos{}, fmt.Errorf("invalid URL scheme")
+	} else if u.Host == "" {
+		return litefs.Pos{}, fmt.Errorf("URL host required")
+	}
+
+	// Strip off everything but the scheme & host.
+	*u = url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   "/db/" + name + "/pos",
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return litefs.Pos{}, err
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return litefs.Pos{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return litefs.Pos{}, nil // not yet created on the primary
+	} else if resp.StatusCode != http.StatusOK {
+		return litefs.Pos{}, fmt.Errorf("invalid response: code=%d", resp.StatusCode)
+	}
+
+	var v dbChecksumJSON
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return litefs.Pos{}, fmt.Errorf("decode pos: %w", err)
+	}
+
+	var pos litefs.Pos
+	if pos.TXID, err = ltx.ParseTXID(v.TXID); err != nil {
+		return litefs.Pos{}, fmt.Errorf("parse txid: %w", err)
+	} else if pos.PostApplyChecksum, err = strconv.ParseUint(v.Checksum, 16, 64); err != nil {
+		return litefs.Pos{}, fmt.Errorf("parse checksum: %w", err)
+	}
+	return pos, nil
+}
+
+var _ litefs.StreamAcker = (*stream)(nil)
+
+// stream represents a replication stream from the primary.
+type stream struct {
+	io.ReadCloser
+	pw              *io.PipeWriter
+	protocolVersion int
+}
+
+// ProtocolVersion returns the replication protocol version chosen by the primary.
+func (s *stream) ProtocolVersion() int { return s.protocolVersion }
+
+// Ack writes the applied position of a database to the primary.
+func (s *stream) Ack(name string, pos litefs.Pos) error {
+	return WritePosMapTo(s.pw, map[string]litefs.Pos{name: pos})
+}
+
+// Close closes the acknowledgement writer and the response body.
+func (s *stream) Close() (err error) {
+	if e := s.pw.Close(); err == nil {
+		err = e
+	}
+	if e := s.ReadCloser.Close(); err == nil {
+		err = e
 	}
-	return resp.Body, nil
+	return err
 }
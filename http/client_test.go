@@ -0,0 +1,45 @@
+package http_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	litefshttp "github.com/superfly/litefs/http"
+)
+
+func TestClient_SourceAddr(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+
+	// Record the remote address of the first connection.
+	remoteAddrCh := make(chan net.Addr, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		remoteAddrCh <- conn.RemoteAddr()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := litefshttp.NewClient()
+	client.Dialer.LocalAddr = &net.TCPAddr{IP: net.ParseIP("127.0.0.2")}
+	go func() { _, _ = client.Stream(ctx, "http://"+ln.Addr().String(), "node2", nil) }()
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for connection")
+	case addr := <-remoteAddrCh:
+		if got, want := addr.(*net.TCPAddr).IP.String(), "127.0.0.2"; got != want {
+			t.Fatalf("source=%s, want %s", got, want)
+		}
+	}
+}
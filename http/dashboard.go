@@ -0,0 +1,27 @@
+package http
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+)
+
+// dashboardHTML is a single-page status view that polls the "/info" endpoint.
+//
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// handleDashboard serves the embedded status dashboard.
+func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if !s.Dashboard {
+		http.NotFound(w, r)
+		return
+	} else if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(dashboardHTML)
+}
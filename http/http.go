@@ -6,10 +6,70 @@ import (
 	"io"
 	"math"
 	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/superfly/litefs"
 )
 
+// Replication protocol versions supported by this build. Nodes which predate
+// version negotiation do not send a version header and speak version 1.
+// Bump ProtocolVersionMax when the stream format changes incompatibly.
+//
+// Version 1 streams only hold LTX, ready & end frames and the replica uses
+// the default checksum algorithm. Version 2 adds the cluster ID, config, drop
+// database & special files frames and the replica reports its checksum
+// algorithm in the Litefs-Checksum-Algorithm header.
+const (
+	ProtocolVersionMin = 1
+	ProtocolVersionMax = 2
+)
+
+// ProtocolVersionFrames is the first version whose streams include the
+// cluster ID, config, drop database & special files frames.
+const ProtocolVersionFrames = 2
+
+// Headers used to negotiate the replication protocol. The replica sends the
+// versions it supports & the primary responds with the version it chose.
+const (
+	ProtocolVersionsHeader = "Litefs-Protocol-Versions"
+	ProtocolVersionHeader  = "Litefs-Protocol-Version"
+)
+
+// FormatProtocolVersions returns the comma-separated list of supported versions.
+func FormatProtocolVersions() string {
+	a := make([]string, 0, ProtocolVersionMax-ProtocolVersionMin+1)
+	for v := ProtocolVersionMin; v <= ProtocolVersionMax; v++ {
+		a = append(a, strconv.Itoa(v))
+	}
+	return strings.Join(a, ",")
+}
+
+// NegotiateProtocolVersion returns the highest version in the comma-separated
+// list s that is also supported by this build. An empty list is treated as
+// version 1. Returns an error if there is no version in common.
+func NegotiateProtocolVersion(s string) (int, error) {
+	if s == "" {
+		s = "1"
+	}
+
+	version := 0
+	for _, part := range strings.Split(s, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return 0, fmt.Errorf("invalid protocol version: %q", part)
+		}
+		if v >= ProtocolVersionMin && v <= ProtocolVersionMax && v > version {
+			version = v
+		}
+	}
+
+	if version == 0 {
+		return 0, fmt.Errorf("incompatible protocol versions: local=%s remote=%s", FormatProtocolVersions(), s)
+	}
+	return version, nil
+}
+
 func ReadPosMapFrom(r io.Reader) (map[string]litefs.Pos, error) {
 	// Read entry count.
 	var n uint32
@@ -0,0 +1,45 @@
+package http_test
+
+import (
+	"testing"
+
+	litefshttp "github.com/superfly/litefs/http"
+)
+
+func TestNegotiateProtocolVersion(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		s       string
+		version int
+		err     string
+	}{
+		{name: "Empty", s: "", version: 1},
+		{name: "Single", s: "1", version: 1},
+		{name: "Unordered", s: "3,1,2", version: 2},
+		{name: "Whitespace", s: " 2 , 1 ", version: 2},
+		{name: "ErrIncompatible", s: "3,4", err: `incompatible protocol versions: local=1,2 remote=3,4`},
+		{name: "ErrZero", s: "0", err: `incompatible protocol versions: local=1,2 remote=0`},
+		{name: "ErrInvalid", s: "1,x", err: `invalid protocol version: "x"`},
+		{name: "ErrTrailingComma", s: "1,", err: `invalid protocol version: ""`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			version, err := litefshttp.NegotiateProtocolVersion(tt.s)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			} else if got, want := version, tt.version; got != want {
+				t.Fatalf("version=%d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestFormatProtocolVersions(t *testing.T) {
+	if got, want := litefshttp.FormatProtocolVersions(), "1,2"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -2,16 +2,27 @@ package http
 
 import (
 	"context"
+	crand "crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"expvar"
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/http/pprof"
 	"os"
+	"path"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -26,6 +37,33 @@ import (
 // Default settings
 const (
 	DefaultAddr = ":20202"
+
+	// Window over which rejected replicas are spread when they reconnect.
+	DefaultReconnectWindow = 10 * time.Second
+
+	// Time to wait for a database to reach a TXID, if not specified.
+	DefaultWaitTimeout = 5 * time.Second
+	MaxWaitTimeout     = 30 * time.Second
+
+	// Maximum number of databases returned per page by a pattern request.
+	MaxDBMatches = 100
+
+	// Time allowed for a deep health check to write & replicate its marker.
+	DefaultHealthTimeout = 5 * time.Second
+
+	// Largest request body echoed by the "/bench" endpoint.
+	MaxBenchPayloadSize = 64 << 20
+)
+
+// HealthForwardedHeader is set when a replica asks the primary to write a
+// deep health check marker so the request is never forwarded again.
+const HealthForwardedHeader = "Litefs-Health-Forwarded"
+
+// Headers reporting the node's role so that clients can send writes directly
+// to the primary. See PrimaryHeaders on Server.
+const (
+	RoleHeader    = "Litefs-Role"    // "primary" or "replica"
+	PrimaryHeader = "Litefs-Primary" // advertise URL of the primary, sent by replicas
 )
 
 // Server represents an HTTP API server for LiteFS.
@@ -39,6 +77,83 @@ type Server struct {
 	addr  string
 	store *litefs.Store
 
+	// Maximum number of concurrent replica streams. Additional replicas are
+	// rejected with a 503 until a stream disconnects. Unlimited if zero.
+	MaxReplicas int
+
+	// Maximum number of replica streams accepted per second. Additional
+	// replicas are rejected until the rate drops. Unlimited if zero.
+	MaxAcceptRate int
+
+	// Rejected replicas are told to retry after a random delay within this
+	// window so that reconnections are spread out after a primary restart.
+	ReconnectWindow time.Duration
+
+	// If true, each replica's checksum at its current TXID is verified against
+	// the primary's history when it connects. Diverged replicas are resynced
+	// from a snapshot. Replicas can also request verification individually.
+	VerifyOnConnect bool
+
+	// Maximum number of snapshots sent to replicas concurrently. Streams that
+	// need a snapshot beyond the limit queue it & continue sending incremental
+	// transactions for their other databases until a slot frees up. Unlimited
+	// if zero.
+	MaxConcurrentSnapshots int
+
+	replicaN atomic.Int64 // number of connected replica streams
+
+	snapshotSem    chan struct{} // snapshot slots, nil if unlimited
+	snapshotMu     sync.Mutex
+	snapshotFreeCh chan struct{} // closed when a snapshot slot is released
+	snapshotQueueN atomic.Int64  // number of queued snapshots
+
+	acceptMu    sync.Mutex
+	acceptTimes []time.Time // stream accept times within the last second
+
+	// If true, an HTML status dashboard is served at "/".
+	Dashboard bool
+
+	// If true, control endpoints which change node state return 403. These
+	// are all non-GET requests other than the replication stream & bench,
+	// currently PUT & DELETE "/sys/debug", POST & DELETE "/primary/pin",
+	// POST "/retention/sweep", POST "/drain" and POST "/db/{name}/rebuild",
+	// as well as GET "/healthz/deep" which writes to the primary.
+	ReadOnlyAPI bool
+
+	// If true, profiling handlers are served under "/debug/pprof/" to requests
+	// bearing DebugStateToken. Disabled by default as it exposes process internals.
+	Pprof bool
+
+	// If true, every response includes the node's role & replicas include
+	// the primary's advertise URL, read from the store on each request so
+	// that it follows failover.
+	PrimaryHeaders bool
+
+	// If set, reported by the "/mount" endpoint. Typically the FUSE file
+	// system's open handle & inode stats.
+	MountVar expvar.Var
+
+	// If true, a snapshot of node state is served from "/debug/state". The
+	// request must include DebugStateToken as a bearer token.
+	DebugState      bool
+	DebugStateToken string
+
+	// Redacted configuration reported by "/debug/state".
+	DebugConfig string
+
+	// Number of events buffered for each "/events" client. Slow clients miss
+	// the oldest events once their buffer is full.
+	EventBufferSize int
+
+	// If set, "/healthz/deep" writes a marker transaction to HealthDB with
+	// HealthWriter on the primary. On a replica, it asks the primary to write
+	// the marker & waits up to HealthTimeout for it to be applied locally.
+	// Requests must bear DebugStateToken, if set, which is forwarded to the
+	// primary.
+	HealthDB      string
+	HealthTimeout time.Duration
+	HealthWriter  func(ctx context.Context, name string) error
+
 	g      errgroup.Group
 	ctx    context.Context
 	cancel func()
@@ -48,9 +163,19 @@ func NewServer(store *litefs.Store, addr string) *Server {
 	s := &Server{
 		addr:  addr,
 		store: store,
+
+		ReconnectWindow: DefaultReconnectWindow,
+		EventBufferSize: litefs.DefaultEventBufferSize,
+		HealthTimeout:   DefaultHealthTimeout,
+		PrimaryHeaders:  true,
+
+		snapshotFreeCh: make(chan struct{}),
 	}
 	s.ctx, s.cancel = context.WithCancel(context.Background())
 
+	// The default registry includes the Go runtime & process collectors so
+	// "go_*" & "process_*" metrics are served alongside LiteFS metrics. Expvar
+	// data is only served from "/debug/vars" and is not collected here.
 	s.promHandler = promhttp.Handler()
 	s.http2Server = &http2.Server{}
 	s.httpServer = &http.Server{
@@ -70,6 +195,10 @@ func (s *Server) Listen() (err error) {
 }
 
 func (s *Server) Serve() {
+	serverMaxStreamCountMetric.Set(float64(s.MaxReplicas))
+	if s.MaxConcurrentSnapshots > 0 {
+		s.snapshotSem = make(chan struct{}, s.MaxConcurrentSnapshots)
+	}
 	s.g.Go(func() error {
 		if err := s.httpServer.Serve(s.ln); s.ctx.Err() != nil {
 			return err
@@ -114,7 +243,22 @@ func (s *Server) URL() string {
 }
 
 func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	r = withRequestID(w, r)
+	s.setPrimaryHeaders(w)
+
+	if s.ReadOnlyAPI && isMutatingRequest(r) {
+		Error(w, r, fmt.Errorf("api is read-only"), http.StatusForbidden)
+		return
+	}
+
 	if strings.HasPrefix(r.URL.Path, "/debug/pprof") {
+		if !s.Pprof {
+			http.NotFound(w, r)
+			return
+		} else if !s.authorizeDebug(w, r) {
+			return
+		}
+
 		switch r.URL.Path {
 		case "/debug/pprof/cmdline":
 			pprof.Cmdline(w, r)
@@ -131,6 +275,9 @@ func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	switch r.URL.Path {
+	case "/":
+		s.handleDashboard(w, r)
+		return
 	case "/debug/vars":
 		expvar.Handler().ServeHTTP(w, r)
 		return
@@ -140,6 +287,64 @@ func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
 	case "/sys/debug":
 		s.handleSysDebug(w, r)
 		return
+	case "/mount":
+		s.handleMount(w, r)
+		return
+	case "/info":
+		s.handleInfo(w, r)
+		return
+	case "/events":
+		s.handleEvents(w, r)
+		return
+	case "/events/history":
+		s.handleEventsHistory(w, r)
+		return
+	case "/debug/state":
+		s.handleDebugState(w, r)
+		return
+	case "/primary/pin":
+		s.handlePrimaryPin(w, r)
+		return
+	case "/retention/preview":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetRetentionPreview(w, r)
+		default:
+			Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		}
+		return
+	case "/retention/sweep":
+		switch r.Method {
+		case http.MethodPost:
+			s.handlePostRetentionSweep(w, r)
+		default:
+			Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		}
+		return
+	case "/drain":
+		switch r.Method {
+		case http.MethodPost:
+			s.handlePostDrain(w, r)
+		default:
+			Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		}
+		return
+	case "/ready":
+		s.handleReady(w, r)
+		return
+	case "/healthz/deep":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleDeepHealth(w, r)
+		default:
+			Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/db/") {
+		s.handleDB(w, r)
+		return
 	}
 
 	// Require HTTP/2 for all internal endpoints.
@@ -156,6 +361,13 @@ func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
 		default:
 			Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
 		}
+	case "/bench":
+		switch r.Method {
+		case http.MethodPost:
+			s.handlePostBench(w, r)
+		default:
+			Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		}
 	default:
 		http.NotFound(w, r)
 	}
@@ -163,11 +375,33 @@ func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handlePostStream(w http.ResponseWriter, r *http.Request) {
 	// Prevent nodes from connecting to themselves.
-	if id := r.Header.Get("Litefs-Id"); id == s.store.ID() {
+	id := r.Header.Get("Litefs-Id")
+	if id == s.store.ID() {
 		Error(w, r, fmt.Errorf("cannot connect to self"), http.StatusBadRequest)
 		return
 	}
 
+	// Agree on the highest replication protocol both nodes support so that a
+	// replica never receives a stream format it cannot parse.
+	version, err := NegotiateProtocolVersion(r.Header.Get(ProtocolVersionsHeader))
+	if err != nil {
+		Error(w, r, err, http.StatusUpgradeRequired)
+		return
+	}
+	sendFrames := version >= ProtocolVersionFrames
+
+	// Reject replicas using a different checksum algorithm as their checksum
+	// chains could never agree. Version 1 replicas only support the default.
+	algo := litefs.ChecksumAlgorithmCRC64
+	if sendFrames {
+		algo = litefs.ChecksumAlgorithm(r.Header.Get("Litefs-Checksum-Algorithm"))
+	}
+	if algo != s.store.ChecksumAlgorithm {
+		Error(w, r, fmt.Errorf("checksum algorithm mismatch: primary=%s replica=%s", s.store.ChecksumAlgorithm, algo), http.StatusConflict)
+		return
+	}
+	w.Header().Set(ProtocolVersionHeader, strconv.Itoa(version))
+
 	// Wrap context so that it cancels when the primary lease is lost.
 	r = r.WithContext(s.store.PrimaryCtx(r.Context()))
 	if err := r.Context().Err(); err != nil {
@@ -175,8 +409,26 @@ func (s *Server) handlePostStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("stream connected")
-	defer log.Printf("stream disconnected")
+	// Reject replicas over the limit so a mass reconnect cannot overwhelm the
+	// primary. Replicas will retry after a short delay.
+	n := s.replicaN.Add(1)
+	defer s.replicaN.Add(-1)
+	if s.MaxReplicas > 0 && n > int64(s.MaxReplicas) {
+		w.Header().Set("Retry-After", strconv.Itoa(s.retryAfter()))
+		Error(w, r, fmt.Errorf("max replicas exceeded (%d)", s.MaxReplicas), http.StatusServiceUnavailable)
+		return
+	}
+
+	// Reject replicas connecting faster than the accept rate. This smooths
+	// out the spike when all replicas reconnect after a primary restart.
+	if !s.accept(time.Now()) {
+		w.Header().Set("Retry-After", strconv.Itoa(s.retryAfter()))
+		Error(w, r, fmt.Errorf("max accept rate exceeded (%d/s)", s.MaxAcceptRate), http.StatusServiceUnavailable)
+		return
+	}
+
+	logf(r.Context(), "stream connected: node=%s protocol=%d", id, version)
+	defer logf(r.Context(), "stream disconnected: node=%s", id)
 
 	serverStreamCountMetric.Inc()
 	defer serverStreamCountMetric.Dec()
@@ -192,9 +444,18 @@ func (s *Server) handlePostStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Continuously read acknowledged positions from the replica. Acks are
+	// tracked per stream as a reconnecting replica can briefly have two.
+	go s.readAcks(id, newRequestID(), r.Body)
+
 	dbs := s.store.DBs()
 	sort.Slice(dbs, func(i, j int) bool { return dbs[i].Name() < dbs[j].Name() })
 
+	// Track databases waiting on a snapshot slot. These are retried whenever
+	// a slot is released & the "ready" frame is held until they are sent.
+	pending := newPendingSnapshots(r.Context(), s, id)
+	defer pending.close()
+
 	// Build initial dirty set of databases.
 	dirtySet := make(map[string]struct{})
 	for name := range posMap {
@@ -215,19 +476,68 @@ func (s *Server) handlePostStream(w http.ResponseWriter, r *http.Request) {
 		w.(http.Flusher).Flush()
 	}()
 
+	// Identify the cluster first so replicas verify it before applying data.
+	if clusterID := s.store.ClusterID(); clusterID != "" && sendFrames {
+		if err := litefs.WriteStreamFrame(w, &litefs.ClusterIDStreamFrame{ClusterID: clusterID}); err != nil {
+			Error(w, r, fmt.Errorf("stream error: write cluster id frame: %s", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Resync replicas whose position has diverged from the primary's history
+	// before streaming any transactions on top of it.
+	if s.VerifyOnConnect || r.Header.Get("Litefs-Stream-Verify") != "" {
+		if err := s.verifyPosMap(r.Context(), w, id, posMap, pending); err != nil {
+			Error(w, r, fmt.Errorf("stream error: verify: %s", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Config, drop & special files frames are only sent to version 2 replicas.
+	var configSent *litefs.ConfigStreamFrame
+	var specialFilesGenSent uint64
+
 	// Continually iterate by writing dirty changes and then waiting for new changes.
 	var readySent bool
 	for {
+		// Grab the release notification before attempting any snapshots so
+		// that a slot released during this iteration is never missed.
+		snapshotFreeCh := s.snapshotFree()
+
+		// Send cluster-wide config whenever it changes.
+		if frame := s.store.ConfigStreamFrame(); sendFrames && (configSent == nil || *frame != *configSent) {
+			if err := litefs.WriteStreamFrame(w, frame); err != nil {
+				Error(w, r, fmt.Errorf("stream error: write config frame: %s", err), http.StatusInternalServerError)
+				return
+			}
+			configSent = frame
+		}
+
+		// Send the full set of special files whenever it changes.
+		if sendFrames {
+			frame, gen, err := s.store.SpecialFilesStreamFrame()
+			if err != nil {
+				Error(w, r, fmt.Errorf("stream error: read special files: %s", err), http.StatusInternalServerError)
+				return
+			} else if frame != nil && gen != specialFilesGenSent {
+				if err := litefs.WriteStreamFrame(w, frame); err != nil {
+					Error(w, r, fmt.Errorf("stream error: write special files frame: %s", err), http.StatusInternalServerError)
+					return
+				}
+				specialFilesGenSent = gen
+			}
+		}
+
 		// Send pending transactions for each database.
 		for name := range dirtySet {
-			if err := s.streamDB(r.Context(), w, name, posMap); err != nil {
+			if err := s.streamDB(r.Context(), w, name, posMap, sendFrames, pending); err != nil {
 				Error(w, r, fmt.Errorf("stream error: db=%q err=%s", name, err), http.StatusInternalServerError)
 				return
 			}
 		}
 
 		// Send "ready" frame after initial replication set
-		if !readySent {
+		if !readySent && pending.len() == 0 {
 			if err := litefs.WriteStreamFrame(w, &litefs.ReadyStreamFrame{}); err != nil {
 				Error(w, r, fmt.Errorf("stream error: write ready frame: %s", err), http.StatusInternalServerError)
 				return
@@ -245,20 +555,269 @@ func (s *Server) handlePostStream(w http.ResponseWriter, r *http.Request) {
 			return // client disconnect
 		case <-subscription.NotifyCh():
 			dirtySet = subscription.DirtySet()
+		case <-pending.waitCh(snapshotFreeCh):
+			dirtySet = make(map[string]struct{})
+		}
+
+		for _, name := range pending.names() {
+			dirtySet[name] = struct{}{}
+		}
+	}
+}
+
+// accept records a stream accepted at now and returns true if it is within the
+// accept rate. The rate is measured over a sliding one second window.
+func (s *Server) accept(now time.Time) bool {
+	s.acceptMu.Lock()
+	defer s.acceptMu.Unlock()
+
+	// Remove accepts that have fallen out of the window.
+	i := 0
+	for ; i < len(s.acceptTimes); i++ {
+		if now.Sub(s.acceptTimes[i]) < time.Second {
+			break
+		}
+	}
+	s.acceptTimes = s.acceptTimes[i:]
+
+	if s.MaxAcceptRate > 0 && len(s.acceptTimes) >= s.MaxAcceptRate {
+		serverStreamAcceptRateMetric.Set(float64(len(s.acceptTimes)))
+		return false
+	}
+
+	s.acceptTimes = append(s.acceptTimes, now)
+	serverStreamAcceptRateMetric.Set(float64(len(s.acceptTimes)))
+	return true
+}
+
+// retryAfter returns a jittered number of seconds for a rejected replica to
+// wait before reconnecting. Always at least one second.
+func (s *Server) retryAfter() int {
+	n := int(s.ReconnectWindow / time.Second)
+	if n <= 1 {
+		return 1
+	}
+	return 1 + rand.Intn(n)
+}
+
+// errSnapshotQueued is returned when a snapshot must wait for a free slot.
+var errSnapshotQueued = errors.New("snapshot queued")
+
+// acquireSnapshot reserves a snapshot slot without blocking. Returns false if
+// MaxConcurrentSnapshots snapshots are already in progress.
+func (s *Server) acquireSnapshot() bool {
+	if s.snapshotSem != nil {
+		select {
+		case s.snapshotSem <- struct{}{}:
+		default:
+			return false
+		}
+	}
+	serverSnapshotInProgressMetric.Inc()
+	return true
+}
+
+// releaseSnapshot frees a snapshot slot & wakes streams with queued snapshots.
+func (s *Server) releaseSnapshot() {
+	serverSnapshotInProgressMetric.Dec()
+	if s.snapshotSem == nil {
+		return
+	}
+	<-s.snapshotSem
+
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+	close(s.snapshotFreeCh)
+	s.snapshotFreeCh = make(chan struct{})
+}
+
+// snapshotFree returns a channel that is closed when a snapshot slot is next
+// released.
+func (s *Server) snapshotFree() <-chan struct{} {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+	return s.snapshotFreeCh
+}
+
+// pendingSnapshots is the set of databases on a single stream that are waiting
+// for a snapshot slot. Its size is included in the server-wide queue depth.
+type pendingSnapshots struct {
+	ctx context.Context
+	s   *Server
+	id  string
+	m   map[string]struct{}
+}
+
+func newPendingSnapshots(ctx context.Context, s *Server, id string) *pendingSnapshots {
+	return &pendingSnapshots{ctx: ctx, s: s, id: id, m: make(map[string]struct{})}
+}
+
+func (p *pendingSnapshots) len() int { return len(p.m) }
+
+func (p *pendingSnapshots) has(name string) bool {
+	_, ok := p.m[name]
+	return ok
+}
+
+func (p *pendingSnapshots) names() []string {
+	a := make([]string, 0, len(p.m))
+	for name := range p.m {
+		a = append(a, name)
+	}
+	return a
+}
+
+func (p *pendingSnapshots) add(name string) {
+	if p.has(name) {
+		return
+	}
+	p.m[name] = struct{}{}
+	n := p.s.snapshotQueueN.Add(1)
+	serverSnapshotQueueDepthMetric.Set(float64(n))
+	logf(p.ctx, "snapshot queued, max concurrent snapshots in progress (%d): node=%s db=%q queued=%d", p.s.MaxConcurrentSnapshots, p.id, name, n)
+}
+
+func (p *pendingSnapshots) remove(name string) {
+	if !p.has(name) {
+		return
+	}
+	delete(p.m, name)
+	serverSnapshotQueueDepthMetric.Set(float64(p.s.snapshotQueueN.Add(-1)))
+}
+
+// close removes any remaining databases from the queue when the stream ends.
+func (p *pendingSnapshots) close() {
+	serverSnapshotQueueDepthMetric.Set(float64(p.s.snapshotQueueN.Add(-int64(len(p.m)))))
+	p.m = make(map[string]struct{})
+}
+
+// waitCh returns ch if any snapshots are queued. Otherwise returns nil so the
+// stream does not wake up on slots it has no use for.
+func (p *pendingSnapshots) waitCh(ch <-chan struct{}) <-chan struct{} {
+	if len(p.m) == 0 {
+		return nil
+	}
+	return ch
+}
+
+// handlePostBench echoes the request body back to the client. This is used by
+// the "bench" command to measure replication throughput between nodes. The
+// endpoint is unauthenticated so the body is capped at MaxBenchPayloadSize.
+func (s *Server) handlePostBench(w http.ResponseWriter, r *http.Request) {
+	if r.ContentLength > MaxBenchPayloadSize {
+		Error(w, r, fmt.Errorf("bench payload too large: %d bytes", r.ContentLength), http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, MaxBenchPayloadSize)
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, r.Body); err != nil {
+		logf(r.Context(), "bench: cannot echo payload: %s", err)
+	}
+}
+
+// readAcks reads position acknowledgements from the replica until the request
+// body is closed. The replica's positions are removed from the store on exit.
+func (s *Server) readAcks(id, streamID string, r io.Reader) {
+	defer s.store.RemoveReplica(streamID)
+
+	for {
+		posMap, err := ReadPosMapFrom(r)
+		if err != nil {
+			return
+		}
+		for name, pos := range posMap {
+			s.store.Ack(streamID, id, name, pos)
+		}
+	}
+}
+
+// verifyPosMap compares the replica's checksum for each database against the
+// primary's checksum at the same TXID. A snapshot is sent for each database
+// that has diverged and posMap is updated to the snapshot position. Databases
+// whose history is no longer available are left to the normal stream checks.
+func (s *Server) verifyPosMap(ctx context.Context, w http.ResponseWriter, id string, posMap map[string]litefs.Pos, pending *pendingSnapshots) error {
+	names := make([]string, 0, len(posMap))
+	for name := range posMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		clientPos := posMap[name]
+		db := s.store.DB(name)
+		if db == nil || db.LocalOnly() || clientPos.TXID == 0 {
+			continue
+		}
+
+		chksum, err := db.ChecksumAt(clientPos.TXID)
+		switch {
+		case err == litefs.ErrTXNotAvailable:
+			serverVerifyCountMetricVec.WithLabelValues(name, "unavailable").Inc()
+			continue
+		case err == litefs.ErrTXNotApplied:
+			logf(ctx, "replica ahead of primary at txid %s, forcing resync: node=%s db=%q", ltx.FormatTXID(clientPos.TXID), id, name)
+		case err != nil:
+			return fmt.Errorf("checksum at txid %s: db=%q err=%w", ltx.FormatTXID(clientPos.TXID), name, err)
+		case chksum == clientPos.PostApplyChecksum:
+			serverVerifyCountMetricVec.WithLabelValues(name, "ok").Inc()
+			continue
+		default:
+			logf(ctx, "replica checksum diverged at txid %s (%016x <> %016x), forcing resync: node=%s db=%q", ltx.FormatTXID(clientPos.TXID), clientPos.PostApplyChecksum, chksum, id, name)
+		}
+		serverVerifyCountMetricVec.WithLabelValues(name, "diverged").Inc()
+
+		newPos, err := s.streamLTXSnapshot(ctx, w, db)
+		if err == errSnapshotQueued {
+			pending.add(name)
+			continue
+		} else if err != nil {
+			return fmt.Errorf("resync: db=%q err=%w", name, err)
 		}
+		posMap[name] = newPos
 	}
+	return nil
 }
 
-func (s *Server) streamDB(ctx context.Context, w http.ResponseWriter, name string, posMap map[string]litefs.Pos) error {
+func (s *Server) streamDB(ctx context.Context, w http.ResponseWriter, name string, posMap map[string]litefs.Pos, sendDrop bool, pending *pendingSnapshots) error {
 	db := s.store.DB(name)
+	if db != nil && db.LocalOnly() {
+		return nil // never advertised
+	}
 
-	// If the replica has a database that doesn't exist on the primary, skip it.
-	// TODO: Send a deletion message to the replica to remove the database.
+	// If the replica has a database that doesn't exist on the primary, notify
+	// the replica so it removes its copy. Older replicas cannot process drops.
 	if db == nil {
-		log.Printf("database not found, skipping: name=%q", name)
+		if _, ok := posMap[name]; !ok {
+			return nil
+		} else if !sendDrop {
+			logf(ctx, "database not found, skipping: name=%q", name)
+			return nil
+		}
+
+		if err := litefs.WriteStreamFrame(w, &litefs.DropDBStreamFrame{Name: name}); err != nil {
+			return fmt.Errorf("write drop db stream frame: %w", err)
+		}
+		w.(http.Flusher).Flush()
+		delete(posMap, name)
+		pending.remove(name)
+
+		serverFrameSendCountMetricVec.WithLabelValues(name, "drop").Inc()
 		return nil
 	}
 
+	// Send a previously queued snapshot before any incremental transactions
+	// as the replica's position cannot be trusted.
+	if pending.has(name) {
+		newPos, err := s.streamLTXSnapshot(ctx, w, db)
+		if err == errSnapshotQueued {
+			return nil // still waiting for a slot
+		} else if err != nil {
+			return fmt.Errorf("stream queued snapshot: %w", err)
+		}
+		posMap[name] = newPos
+		pending.remove(name)
+	}
+
 	for {
 		clientPos := posMap[name]
 		dbPos := db.Pos()
@@ -268,14 +827,14 @@ func (s *Server) streamDB(ctx context.Context, w http.ResponseWriter, name strin
 		// then loses its primary status and reconnects. By invalidating, we
 		// will cause a snapshot to occur.
 		if clientPos.TXID > dbPos.TXID {
-			log.Printf("client transaction id (%s) exceeds primary transaction id (%s), resetting to snapshot", ltx.FormatTXID(clientPos.TXID), ltx.FormatTXID(dbPos.TXID))
+			logf(ctx, "client transaction id (%s) exceeds primary transaction id (%s), resetting to snapshot", ltx.FormatTXID(clientPos.TXID), ltx.FormatTXID(dbPos.TXID))
 			clientPos = litefs.Pos{}
 		}
 
 		// Invalidate client position if the TXID matches but the checksum does not.
 		// This can also occur if an old primary has unreplicated transactions.
 		if clientPos.TXID == dbPos.TXID && clientPos.PostApplyChecksum != dbPos.PostApplyChecksum {
-			log.Printf("client transaction id (%s) caught up but checksum is mismatched (%016x <> %016x), resetting to snapshot", ltx.FormatTXID(clientPos.TXID), clientPos.PostApplyChecksum, dbPos.PostApplyChecksum)
+			logf(ctx, "client transaction id (%s) caught up but checksum is mismatched (%016x <> %016x), resetting to snapshot", ltx.FormatTXID(clientPos.TXID), clientPos.PostApplyChecksum, dbPos.PostApplyChecksum)
 			clientPos = litefs.Pos{}
 		}
 
@@ -285,7 +844,10 @@ func (s *Server) streamDB(ctx context.Context, w http.ResponseWriter, name strin
 		}
 
 		newPos, err := s.streamLTX(ctx, w, db, clientPos.TXID+1, clientPos.PostApplyChecksum)
-		if err != nil {
+		if err == errSnapshotQueued {
+			pending.add(name)
+			return nil
+		} else if err != nil {
 			return fmt.Errorf("stream ltx (tx %d): %w", clientPos.TXID, err)
 		}
 		posMap[name] = newPos
@@ -296,7 +858,7 @@ func (s *Server) streamLTX(ctx context.Context, w http.ResponseWriter, db *litef
 	// Open LTX file, read header.
 	f, err := db.OpenLTXFile(txID)
 	if os.IsNotExist(err) {
-		log.Printf("transaction file for txid %s no longer available, resetting to snapshot", ltx.FormatTXID(txID))
+		logf(ctx, "transaction file for txid %s no longer available, resetting to snapshot", ltx.FormatTXID(txID))
 		return s.streamLTXSnapshot(ctx, w, db)
 	} else if err != nil {
 		return litefs.Pos{}, fmt.Errorf("open ltx file: %w", err)
@@ -310,7 +872,7 @@ func (s *Server) streamLTX(ctx context.Context, w http.ResponseWriter, db *litef
 
 	// If previous checksum on client does not match, return snapshot instead.
 	if r.Header().PreApplyChecksum != preApplyChecksum {
-		log.Printf("client preapply checksum mismatch, resetting from txid %s to snapshot", ltx.FormatTXID(txID))
+		logf(ctx, "client preapply checksum mismatch, resetting from txid %s to snapshot", ltx.FormatTXID(txID))
 		return s.streamLTXSnapshot(ctx, w, db)
 	}
 
@@ -331,7 +893,14 @@ func (s *Server) streamLTX(ctx context.Context, w http.ResponseWriter, db *litef
 	return litefs.Pos{TXID: r.Header().MaxTXID, PostApplyChecksum: r.Trailer().PostApplyChecksum}, nil
 }
 
+// streamLTXSnapshot writes a snapshot of db to the stream. Returns
+// errSnapshotQueued without writing anything if no snapshot slot is free.
 func (s *Server) streamLTXSnapshot(ctx context.Context, w http.ResponseWriter, db *litefs.DB) (newPos litefs.Pos, err error) {
+	if !s.acquireSnapshot() {
+		return litefs.Pos{}, errSnapshotQueued
+	}
+	defer s.releaseSnapshot()
+
 	// Write frame.
 	if err := litefs.WriteStreamFrame(w, &litefs.LTXStreamFrame{Name: db.Name()}); err != nil {
 		return litefs.Pos{}, fmt.Errorf("write ltx snapshot stream frame: %w", err)
@@ -349,35 +918,1325 @@ func (s *Server) streamLTXSnapshot(ctx context.Context, w http.ResponseWriter, d
 	return litefs.Pos{TXID: header.MaxTXID, PostApplyChecksum: trailer.PostApplyChecksum}, nil
 }
 
-func (s *Server) handleSysDebug(w http.ResponseWriter, r *http.Request) {
+// isMutatingRequest returns true if r is a request to a control endpoint that
+// can change node state. Replication streams & bench are not considered control
+// endpoints as the cluster cannot function without them.
+func isMutatingRequest(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/stream", "/bench":
+		return false
+	case "/healthz/deep":
+		return true // writes a marker transaction on the primary
+	}
+
 	switch r.Method {
-	case "GET":
-		_, _ = fmt.Fprintln(w, s.store.Debug)
-	case "PUT":
-		s.store.Debug = true
-		_, _ = fmt.Fprintln(w, "debug logging enabled")
-	case "DELETE":
-		s.store.Debug = false
-		_, _ = fmt.Fprintln(w, "debug logging disabled")
-	default:
-		Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
 	}
+	return true
 }
 
-func Error(w http.ResponseWriter, r *http.Request, err error, code int) {
-	log.Printf("http: error: %s", err)
-	http.Error(w, err.Error(), code)
-}
+// handleDB routes requests for a single database in the form of "/db/{name}/{action}".
+// If name contains glob characters then the request is applied to all matching databases.
+func (s *Server) handleDB(w http.ResponseWriter, r *http.Request) {
+	name, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/db/"), "/")
+	if isDBPattern(name) {
+		s.handleDBPattern(w, r, name, action)
+		return
+	}
 
-// HTTP server metrics.
-var (
-	serverStreamCountMetric = promauto.NewGauge(prometheus.GaugeOpts{
-		Name: "litefs_http_stream_count",
-		Help: "Number of streams currently connected.",
-	})
+	db := s.store.DB(name)
+	if db == nil {
+		Error(w, r, litefs.ErrDatabaseNotFound, http.StatusNotFound)
+		return
+	}
 
-	serverFrameSendCountMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "litefs_http_frame_send_count",
-		Help: "Number of frames sent.",
-	}, []string{"db", "type"})
+	switch action {
+	case "info":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetDBInfo(w, r, db)
+		default:
+			Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		}
+	case "pos":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetDBPos(w, r, db)
+		default:
+			Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		}
+	case "checksum":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetDBChecksum(w, r, db)
+		default:
+			Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		}
+	case "wait":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetDBWait(w, r, db)
+		default:
+			Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		}
+	case "pages":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetDBPages(w, r, db)
+		default:
+			Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		}
+	case "changes":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetDBChanges(w, r, db)
+		default:
+			Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		}
+	case "export":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetDBExport(w, r, db)
+		default:
+			Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		}
+	case "rebuild":
+		switch r.Method {
+		case http.MethodPost:
+			s.handlePostDBRebuild(w, r, db)
+		default:
+			Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handlePostDBRebuild reconstructs a replica's database file from its LTX
+// files, such as after a bad disk sector corrupted it. The primary's database
+// file may be ahead of its LTX files while the WAL is checkpointed so it is
+// not rebuilt.
+func (s *Server) handlePostDBRebuild(w http.ResponseWriter, r *http.Request, db *litefs.DB) {
+	if s.store.IsPrimary() {
+		Error(w, r, fmt.Errorf("cannot rebuild database on primary"), http.StatusConflict)
+		return
+	}
+
+	t := time.Now()
+	pos, err := db.Rebuild(r.Context())
+	if errors.Is(err, litefs.ErrLTXChainIncomplete) {
+		Error(w, r, fmt.Errorf("%w, restart the node to resync from the primary", err), http.StatusConflict)
+		return
+	} else if err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	logf(r.Context(), "database %q rebuilt from ltx files: txid=%s elapsed=%s", db.Name(), ltx.FormatTXID(pos.TXID), time.Since(t))
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(dbChecksumJSON{
+		Name:     db.Name(),
+		TXID:     ltx.FormatTXID(pos.TXID),
+		Checksum: fmt.Sprintf("%016x", pos.PostApplyChecksum),
+	}); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleGetDBExport streams an LTX snapshot of the database for "litefs
+// export". The snapshot is taken under a read lock so it represents a single
+// TXID, which is stored in the snapshot header, even while writes continue.
+func (s *Server) handleGetDBExport(w http.ResponseWriter, r *http.Request, db *litefs.DB) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	// Errors after the first write cannot change the status code. The client
+	// detects them as a truncated snapshot that fails checksum verification.
+	header, _, err := db.WriteSnapshotTo(r.Context(), w)
+	if err != nil {
+		logf(r.Context(), "export %q failed: %s", db.Name(), err)
+		return
+	}
+	logf(r.Context(), "exported %q at txid %s", db.Name(), ltx.FormatTXID(header.MaxTXID))
+}
+
+// handleGetDBPages returns the checksum of every page in the database so
+// that two nodes can be compared page by page with "litefs diff".
+func (s *Server) handleGetDBPages(w http.ResponseWriter, r *http.Request, db *litefs.DB) {
+	pos, chksums, err := db.PageChecksums(r.Context())
+	if err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := DBPagesJSON{
+		Name:     db.Name(),
+		TXID:     ltx.FormatTXID(pos.TXID),
+		Checksum: fmt.Sprintf("%016x", pos.PostApplyChecksum),
+		PageSize: db.PageSize(),
+		Pages:    make([]string, len(chksums)),
+	}
+	for i, chksum := range chksums {
+		resp.Pages[i] = fmt.Sprintf("%016x", chksum)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// DBPagesJSON is the response for the "/db/{name}/pages" endpoint. Pages
+// holds the checksum of each page, starting from page 1.
+type DBPagesJSON struct {
+	Name     string   `json:"name"`
+	TXID     string   `json:"txid"`
+	Checksum string   `json:"checksum"`
+	PageSize uint32   `json:"pageSize"`
+	Pages    []string `json:"pages"`
+}
+
+// handleGetDBChanges returns the pages written by each transaction after the
+// "from" TXID up to & including the "to" TXID, which defaults to the current
+// position. Returns 410 if the LTX files for the range are no longer available.
+func (s *Server) handleGetDBChanges(w http.ResponseWriter, r *http.Request, db *litefs.DB) {
+	from, err := parseTXIDParam(r.URL.Query().Get("from"))
+	if err != nil {
+		Error(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	to := db.TXID()
+	if v := r.URL.Query().Get("to"); v != "" {
+		if to, err = parseTXIDParam(v); err != nil {
+			Error(w, r, err, http.StatusBadRequest)
+			return
+		}
+	}
+	if from > to {
+		Error(w, r, fmt.Errorf("from txid cannot be after to txid"), http.StatusBadRequest)
+		return
+	}
+
+	a, err := db.ChangedPages(from, to)
+	if err == litefs.ErrTXNotApplied {
+		Error(w, r, err, http.StatusNotFound)
+		return
+	} else if err == litefs.ErrTXNotAvailable {
+		Error(w, r, err, http.StatusGone)
+		return
+	} else if err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := DBChangesJSON{Name: db.Name(), TXs: make([]TXPagesJSON, len(a))}
+	for i, txPages := range a {
+		resp.TXs[i] = TXPagesJSON{
+			MinTXID: ltx.FormatTXID(txPages.MinTXID),
+			MaxTXID: ltx.FormatTXID(txPages.MaxTXID),
+			Commit:  txPages.Commit,
+			Pgnos:   txPages.Pgnos,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// DBChangesJSON is the response for the "/db/{name}/changes" endpoint.
+type DBChangesJSON struct {
+	Name string        `json:"name"`
+	TXs  []TXPagesJSON `json:"txs"`
+}
+
+// TXPagesJSON reports the pages written by the transactions in an LTX file.
+type TXPagesJSON struct {
+	MinTXID string   `json:"minTXID"`
+	MaxTXID string   `json:"maxTXID"`
+	Commit  uint32   `json:"commit"`
+	Pgnos   []uint32 `json:"pgnos"`
+}
+
+// handleDBPattern applies a read-only action to every database matching
+// pattern and returns the results keyed by database name. Matches are sorted
+// by name and returned in pages of up to "limit" entries. If more matches
+// exist, the response includes a "next" name to pass as "after".
+func (s *Server) handleDBPattern(w http.ResponseWriter, r *http.Request, pattern, action string) {
+	switch action {
+	case "info", "pos", "checksum":
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	} else if _, err := path.Match(pattern, ""); err != nil {
+		Error(w, r, fmt.Errorf("invalid database pattern: %q", pattern), http.StatusBadRequest)
+		return
+	}
+
+	limit := MaxDBMatches
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > MaxDBMatches {
+			Error(w, r, fmt.Errorf("invalid limit: %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var txID uint64
+	if action == "checksum" {
+		var err error
+		if txID, err = parseTXIDParam(r.URL.Query().Get("txid")); err != nil {
+			Error(w, r, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Collect matching databases after the cursor in name order.
+	after := r.URL.Query().Get("after")
+	var dbs []*litefs.DB
+	for _, db := range s.store.DBs() {
+		if ok, _ := path.Match(pattern, db.Name()); ok && db.Name() > after {
+			dbs = append(dbs, db)
+		}
+	}
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i].Name() < dbs[j].Name() })
+
+	var resp dbMatchesJSON
+	if len(dbs) > limit {
+		dbs = dbs[:limit]
+		resp.Next = dbs[limit-1].Name()
+	}
+
+	resp.DBs = make(map[string]interface{}, len(dbs))
+	for _, db := range dbs {
+		switch action {
+		case "info":
+			resp.DBs[db.Name()] = newDBInfoJSON(db)
+		case "pos":
+			resp.DBs[db.Name()] = newDBPosJSON(db)
+		case "checksum":
+			v := dbChecksumJSON{Name: db.Name(), TXID: ltx.FormatTXID(txID)}
+			if chksum, err := db.ChecksumAt(txID); err != nil {
+				v.Error = err.Error()
+			} else {
+				v.Checksum = fmt.Sprintf("%016x", chksum)
+			}
+			resp.DBs[db.Name()] = v
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// isDBPattern returns true if name contains glob characters.
+func isDBPattern(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+func (s *Server) handleGetDBInfo(w http.ResponseWriter, r *http.Request, db *litefs.DB) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newDBInfoJSON(db)); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleGetDBPos returns the current replication position of the database.
+func (s *Server) handleGetDBPos(w http.ResponseWriter, r *http.Request, db *litefs.DB) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newDBPosJSON(db)); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// parseTXIDParam parses a TXID specified in decimal or as a 16-character hex string.
+func parseTXIDParam(q string) (txID uint64, err error) {
+	if len(q) == 16 {
+		txID, err = ltx.ParseTXID(q)
+	} else {
+		txID, err = strconv.ParseUint(q, 10, 64)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid txid: %q", q)
+	}
+	return txID, nil
+}
+
+// handleGetDBWait blocks until the database has applied the given TXID. This
+// allows clients to read their own writes from a replica by passing the TXID
+// from the primary's position file. On timeout, the primary's URL is returned
+// in the "Litefs-Primary" header so the client can redirect its read.
+func (s *Server) handleGetDBWait(w http.ResponseWriter, r *http.Request, db *litefs.DB) {
+	txID, err := parseTXIDParam(r.URL.Query().Get("txid"))
+	if err != nil {
+		Error(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	timeout := DefaultWaitTimeout
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		if timeout, err = time.ParseDuration(v); err != nil || timeout < 0 {
+			Error(w, r, fmt.Errorf("invalid timeout: %q", v), http.StatusBadRequest)
+			return
+		} else if timeout > MaxWaitTimeout {
+			timeout = MaxWaitTimeout
+		}
+	}
+
+	// Subscribe before checking the position so no changes are missed.
+	subscription := s.store.Subscribe()
+	defer func() { _ = subscription.Close() }()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for db.TXID() < txID {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-timer.C:
+			if info := s.store.PrimaryInfo(); info != nil {
+				w.Header().Set(PrimaryHeader, info.AdvertiseURL)
+			}
+			Error(w, r, fmt.Errorf("timeout waiting for txid %s", ltx.FormatTXID(txID)), http.StatusGatewayTimeout)
+			return
+		case <-subscription.NotifyCh():
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newDBPosJSON(db)); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleGetDBChecksum returns the post-apply checksum at a given TXID so that
+// external tools can verify that nodes agree. The TXID can be specified in
+// decimal or as a 16-character hex string.
+func (s *Server) handleGetDBChecksum(w http.ResponseWriter, r *http.Request, db *litefs.DB) {
+	txID, err := parseTXIDParam(r.URL.Query().Get("txid"))
+	if err != nil {
+		Error(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	chksum, err := db.ChecksumAt(txID)
+	if err == litefs.ErrTXNotApplied {
+		Error(w, r, err, http.StatusNotFound)
+		return
+	} else if err == litefs.ErrTXNotAvailable {
+		Error(w, r, err, http.StatusGone)
+		return
+	} else if err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(dbChecksumJSON{
+		Name:     db.Name(),
+		TXID:     ltx.FormatTXID(txID),
+		Checksum: fmt.Sprintf("%016x", chksum),
+	}); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+type dbChecksumJSON struct {
+	Name     string `json:"name"`
+	TXID     string `json:"txid"`
+	Checksum string `json:"checksum,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func newDBPosJSON(db *litefs.DB) dbChecksumJSON {
+	pos := db.Pos()
+	return dbChecksumJSON{
+		Name:     db.Name(),
+		TXID:     ltx.FormatTXID(pos.TXID),
+		Checksum: fmt.Sprintf("%016x", pos.PostApplyChecksum),
+	}
+}
+
+// dbMatchesJSON is the response for a database pattern request.
+type dbMatchesJSON struct {
+	DBs  map[string]interface{} `json:"dbs"`
+	Next string                 `json:"next,omitempty"`
+}
+
+func newDBInfoJSON(db *litefs.DB) dbInfoJSON {
+	pos := db.Pos()
+	stats := db.WriteStats()
+	return dbInfoJSON{
+		Name:           db.Name(),
+		PageSize:       db.PageSize(),
+		TXID:           ltx.FormatTXID(pos.TXID),
+		Checksum:       fmt.Sprintf("%016x", pos.PostApplyChecksum),
+		JournalMode:    string(db.JournalMode()),
+		TXCount:        stats.TXN,
+		PageWriteCount: stats.PageWriteN,
+		WriteRate:      stats.WriteRate,
+		LocalOnly:      db.LocalOnly(),
+	}
+}
+
+type dbInfoJSON struct {
+	Name        string `json:"name"`
+	PageSize    uint32 `json:"pageSize"`
+	TXID        string `json:"txid"`
+	Checksum    string `json:"checksum"`
+	JournalMode string `json:"journalMode"`
+
+	TXCount        int64   `json:"txCount"`
+	PageWriteCount int64   `json:"pageWriteCount"`
+	WriteRate      float64 `json:"writeRate"`
+
+	LocalOnly bool `json:"localOnly,omitempty"`
+}
+
+func (s *Server) handleMount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	} else if s.MountVar == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = fmt.Fprintln(w, s.MountVar.String())
+}
+
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.info()); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleEvents streams store events to the client as server-sent events.
+//
+// Events are buffered per client so a slow client never stalls the store.
+// When the buffer overflows, the oldest events are dropped and the client is
+// sent a "dropped" event with the number missed. Clients should then
+// reconcile their state by polling "/info" or "/db/{name}".
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	sub := s.store.SubscribeEvents(s.EventBufferSize)
+	defer func() { _ = sub.Close() }()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.NotifyCh():
+		}
+
+		// Report dropped events first as they precede the buffered events.
+		events, dropped := sub.Events()
+		if dropped > 0 {
+			if _, err := fmt.Fprintf(w, "event: dropped\ndata: {\"count\":%d}\n\n", dropped); err != nil {
+				return
+			}
+		}
+
+		for _, e := range events {
+			buf, err := json.Marshal(e)
+			if err != nil {
+				logf(r.Context(), "cannot marshal event: %s", err)
+				return
+			} else if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, buf); err != nil {
+				return
+			}
+		}
+		w.(http.Flusher).Flush()
+	}
+}
+
+// handleEventsHistory returns the events retained by the store, oldest first.
+// If the "since" parameter is set to an RFC 3339 timestamp, only events after
+// that time are returned. Tx events are only available from "/events".
+func (s *Server) handleEventsHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			Error(w, r, fmt.Errorf("invalid since timestamp: %q", v), http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(eventHistoryJSON{
+		Node:   s.store.NodeName,
+		Events: s.store.EventHistory(since),
+	}); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// info returns the node's role & replication positions.
+func (s *Server) info() infoJSON {
+	info := infoJSON{
+		ID:                s.store.ID(),
+		Name:              s.store.NodeName,
+		IsPrimary:         s.store.IsPrimary(),
+		Candidate:         s.store.Candidate(),
+		CandidatePriority: s.store.CandidatePriority,
+		Observer:          s.store.Observer,
+		Drained:           s.store.Drained(),
+		Promoting:         s.store.Promoting(),
+		DBs:               make(map[string]posJSON),
+		LocalOnlyDBs:      s.store.LocalOnlyDBs(),
+		Protocol: protocolJSON{
+			Min:     ProtocolVersionMin,
+			Max:     ProtocolVersionMax,
+			Primary: s.store.ProtocolVersion(),
+		},
+	}
+	for name, pos := range s.store.PosMap() {
+		info.DBs[name] = posJSON{
+			TXID:     ltx.FormatTXID(pos.TXID),
+			Checksum: fmt.Sprintf("%016x", pos.PostApplyChecksum),
+		}
+	}
+	if primaryInfo := s.store.PrimaryInfo(); primaryInfo != nil {
+		info.Primary = primaryInfo.Hostname
+	}
+	if lease := s.store.LeaseRenewal(); lease != nil && lease.Static {
+		info.Lease = &leaseJSON{Static: true}
+	} else if lease != nil {
+		info.Lease = &leaseJSON{
+			RenewedAt:    lease.RenewedAt.UTC().Format(time.RFC3339Nano),
+			SinceRenewal: time.Since(lease.RenewedAt).Round(time.Millisecond).String(),
+			TTL:          lease.TTL.String(),
+		}
+	}
+	if t := s.store.PinnedUntil(); !t.IsZero() {
+		info.Pin = &pinJSON{
+			Until:     t.UTC().Format(time.RFC3339),
+			Remaining: time.Until(t).Round(time.Second).String(),
+		}
+	}
+	if leaser, ok := s.store.Leaser.(*litefs.StaticLeaser); ok {
+		if c := leaser.Conflict(); c != nil {
+			info.StaticConflict = &staticConflictJSON{
+				PeerURL:    c.PeerURL,
+				PeerID:     c.PeerID,
+				DetectedAt: c.DetectedAt.UTC().Format(time.RFC3339),
+				Demoted:    c.Demoted,
+			}
+		}
+	}
+	return info
+}
+
+// authorizeDebug returns true if the request bears DebugStateToken. Otherwise
+// it writes an unauthorized response. This protects "/debug/state" & pprof.
+func (s *Server) authorizeDebug(w http.ResponseWriter, r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if s.DebugStateToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.DebugStateToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		Error(w, r, fmt.Errorf("unauthorized"), http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// handleDebugState returns a snapshot of node state for attaching to bug
+// reports. It includes the redacted config, node info, LTX file listings &
+// connected replica positions.
+func (s *Server) handleDebugState(w http.ResponseWriter, r *http.Request) {
+	if !s.DebugState {
+		http.NotFound(w, r)
+		return
+	} else if r.Method != http.MethodGet {
+		Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.authorizeDebug(w, r) {
+		return
+	}
+
+	state := debugStateJSON{
+		Time:     time.Now().UTC().Format(time.RFC3339Nano),
+		Config:   s.DebugConfig,
+		Info:     s.info(),
+		DBs:      make(map[string]debugDBJSON),
+		Replicas: make(map[string]map[string]posJSON),
+		Events:   s.store.EventHistory(time.Time{}),
+	}
+	if s.MountVar != nil {
+		state.Mount = json.RawMessage(s.MountVar.String())
+	}
+
+	for _, db := range s.store.DBs() {
+		ents, err := db.ReadLTXDir()
+		if err != nil {
+			Error(w, r, fmt.Errorf("db=%q: %w", db.Name(), err), http.StatusInternalServerError)
+			return
+		}
+
+		dbState := debugDBJSON{LTXFiles: make([]ltxFileJSON, 0, len(ents))}
+		for _, ent := range ents {
+			fi, err := ent.Info()
+			if os.IsNotExist(err) {
+				continue // removed by retention
+			} else if err != nil {
+				Error(w, r, fmt.Errorf("db=%q: %w", db.Name(), err), http.StatusInternalServerError)
+				return
+			}
+			dbState.LTXFiles = append(dbState.LTXFiles, ltxFileJSON{
+				Name:    ent.Name(),
+				Size:    fi.Size(),
+				ModTime: fi.ModTime().UTC().Format(time.RFC3339Nano),
+			})
+		}
+		state.DBs[db.Name()] = dbState
+	}
+
+	for nodeID, posMap := range s.store.ReplicaPosMaps() {
+		m := make(map[string]posJSON, len(posMap))
+		for name, pos := range posMap {
+			m[name] = posJSON{
+				TXID:     ltx.FormatTXID(pos.TXID),
+				Checksum: fmt.Sprintf("%016x", pos.PostApplyChecksum),
+			}
+		}
+		state.Replicas[nodeID] = m
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(state); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+type debugStateJSON struct {
+	Time string `json:"time"`
+
+	// Configuration as YAML with credentials redacted.
+	Config string `json:"config,omitempty"`
+
+	Info  infoJSON               `json:"info"`
+	Mount json.RawMessage        `json:"mount,omitempty"`
+	DBs   map[string]debugDBJSON `json:"dbs"`
+
+	// Acknowledged positions of connected replicas, keyed by node ID.
+	Replicas map[string]map[string]posJSON `json:"replicas"`
+
+	// Recent events from the store's event history, oldest first.
+	Events []litefs.Event `json:"events"`
+}
+
+type debugDBJSON struct {
+	LTXFiles []ltxFileJSON `json:"ltxFiles"`
+}
+
+type ltxFileJSON struct {
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	ModTime string `json:"modTime"`
+}
+
+type infoJSON struct {
+	ID                string   `json:"id"`
+	Name              string   `json:"name,omitempty"`
+	IsPrimary         bool     `json:"isPrimary"`
+	Candidate         bool     `json:"candidate"`
+	CandidatePriority int      `json:"candidatePriority"`
+	Observer          bool     `json:"observer,omitempty"`
+	Drained           bool     `json:"drained,omitempty"`
+	Promoting         bool     `json:"promoting,omitempty"`
+	Primary           string   `json:"primary,omitempty"`
+	Pin               *pinJSON `json:"pin,omitempty"`
+
+	// Another node found claiming to be the static primary, if any.
+	StaticConflict *staticConflictJSON `json:"staticConflict,omitempty"`
+
+	// Renewal state of the lease, only set while primary.
+	Lease *leaseJSON `json:"lease,omitempty"`
+
+	// Current replication position of each database, keyed by name.
+	DBs map[string]posJSON `json:"dbs"`
+
+	// Databases created on this replica which are not replicated.
+	LocalOnlyDBs []string `json:"localOnlyDBs,omitempty"`
+
+	// Replication protocol versions supported & negotiated with the primary.
+	Protocol protocolJSON `json:"protocol"`
+}
+
+type eventHistoryJSON struct {
+	Node   string         `json:"node,omitempty"`
+	Events []litefs.Event `json:"events"`
+}
+
+type staticConflictJSON struct {
+	PeerURL    string `json:"peerURL"`
+	PeerID     string `json:"peerID"`
+	DetectedAt string `json:"detectedAt"`
+	Demoted    bool   `json:"demoted"`
+}
+
+type protocolJSON struct {
+	Min     int `json:"min"`
+	Max     int `json:"max"`
+	Primary int `json:"primary,omitempty"` // only set on connected replicas
+}
+
+type posJSON struct {
+	TXID     string `json:"txid"`
+	Checksum string `json:"checksum"`
+}
+
+// leaseJSON reports lease renewals. Static leases are never renewed so only
+// the static flag is set.
+type leaseJSON struct {
+	Static       bool   `json:"static,omitempty"`
+	RenewedAt    string `json:"renewedAt,omitempty"`
+	SinceRenewal string `json:"sinceRenewal,omitempty"`
+	TTL          string `json:"ttl,omitempty"`
+}
+
+type pinJSON struct {
+	Until     string `json:"until"`
+	Remaining string `json:"remaining"`
+}
+
+// handlePostRetentionSweep runs a retention pass immediately instead of
+// waiting for the next scheduled pass.
+func (s *Server) handlePostRetentionSweep(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "manual retention sweep triggered")
+
+	ret, err := s.store.SweepRetention(r.Context())
+	if err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	logf(r.Context(), "manual retention sweep complete: files=%d bytes=%d", ret.FileN, ret.Size)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(retentionSweepJSON{
+		Files: ret.FileN,
+		Bytes: ret.Size,
+	}); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+type retentionSweepJSON struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// handleGetRetentionPreview reports the LTX files that a retention sweep
+// would remove without removing them. The current policy is used unless
+// overridden by the "duration", "max-count" & "max-bytes" query parameters
+// so that a new policy can be checked before it is applied.
+func (s *Server) handleGetRetentionPreview(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	duration, maxCount, maxBytes := s.store.RetentionDuration(), s.store.RetentionMaxCount, s.store.RetentionMaxBytes
+	if v := q.Get("duration"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			Error(w, r, fmt.Errorf("invalid duration: %q", v), http.StatusBadRequest)
+			return
+		}
+		duration = d
+	}
+	if v := q.Get("max-count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			Error(w, r, fmt.Errorf("invalid max-count: %q", v), http.StatusBadRequest)
+			return
+		}
+		maxCount = n
+	}
+	if v := q.Get("max-bytes"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			Error(w, r, fmt.Errorf("invalid max-bytes: %q", v), http.StatusBadRequest)
+			return
+		}
+		maxBytes = n
+	}
+
+	previews, err := s.store.PreviewRetention(duration, maxCount, maxBytes)
+	if err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := retentionPreviewJSON{
+		Duration: duration.String(),
+		MaxCount: maxCount,
+		MaxBytes: maxBytes,
+		DBs:      make([]retentionPreviewDBJSON, 0, len(previews)),
+	}
+	for _, p := range previews {
+		other := retentionPreviewDBJSON{
+			Name:          p.Name,
+			Files:         p.Files,
+			Bytes:         p.Size,
+			RetainedFiles: p.RetainedN,
+			RetainedBytes: p.RetainedSize,
+		}
+		if other.Files == nil {
+			other.Files = []string{}
+		}
+		if p.MinTXID != 0 {
+			other.MinTXID = ltx.FormatTXID(p.MinTXID)
+		}
+		resp.DBs = append(resp.DBs, other)
+
+		resp.Files += len(p.Files)
+		resp.Bytes += p.Size
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+type retentionPreviewJSON struct {
+	// Policy the preview was computed with.
+	Duration string `json:"duration"`
+	MaxCount int    `json:"maxCount"`
+	MaxBytes int64  `json:"maxBytes"`
+
+	// Totals across all databases.
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+
+	DBs []retentionPreviewDBJSON `json:"dbs"`
+}
+
+type retentionPreviewDBJSON struct {
+	Name  string   `json:"name"`
+	Files []string `json:"files"` // LTX files that would be removed
+	Bytes int64    `json:"bytes"` // bytes that would be reclaimed
+
+	RetainedFiles int    `json:"retainedFiles"`
+	RetainedBytes int64  `json:"retainedBytes"`
+	MinTXID       string `json:"minTXID,omitempty"` // oldest TXID that would remain
+}
+
+// handlePostDrain makes the node read-only & hands off the lease if primary.
+// The request blocks until the handoff completes or the request is canceled.
+func (s *Server) handlePostDrain(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "drain requested")
+
+	if err := s.store.MarkDrained(r.Context()); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	logf(r.Context(), "drain complete: primary=%v", s.store.IsPrimary())
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(drainJSON{
+		Drained:   true,
+		IsPrimary: s.store.IsPrimary(),
+	}); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+type drainJSON struct {
+	Drained   bool `json:"drained"`
+	IsPrimary bool `json:"isPrimary"`
+}
+
+// handleReady returns 200 once the store is ready and 503 before then or
+// after the node is drained, so load balancers stop routing to it.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	var ready bool
+	select {
+	case <-s.store.ReadyCh():
+		ready = !s.store.Drained()
+	default:
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(readyJSON{
+		Ready:   ready,
+		Drained: s.store.Drained(),
+	})
+}
+
+type readyJSON struct {
+	Ready   bool `json:"ready"`
+	Drained bool `json:"drained,omitempty"`
+}
+
+// handleDeepHealth verifies that writes replicate end-to-end. On the primary,
+// a marker transaction is written to the health database. On a replica, the
+// primary is asked to write the marker and the replica waits until it has
+// applied that transaction. Reports 503 if any step fails or times out.
+func (s *Server) handleDeepHealth(w http.ResponseWriter, r *http.Request) {
+	if s.HealthDB == "" || s.HealthWriter == nil {
+		Error(w, r, fmt.Errorf("deep health check not enabled"), http.StatusNotFound)
+		return
+	} else if s.DebugStateToken != "" && !s.authorizeDebug(w, r) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.HealthTimeout)
+	defer cancel()
+
+	t := time.Now()
+	resp := deepHealthJSON{DB: s.HealthDB}
+
+	var err error
+	if s.store.IsPrimary() {
+		resp.Role = "primary"
+		err = s.writeHealthMarker(ctx, &resp)
+	} else if r.Header.Get(HealthForwardedHeader) != "" {
+		resp.Role = "replica"
+		err = fmt.Errorf("node is no longer primary")
+	} else {
+		resp.Role = "replica"
+		err = s.verifyHealthMarker(ctx, r.Header.Get("Authorization"), &resp)
+	}
+	resp.Duration = time.Since(t).String()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		logf(r.Context(), "deep health check failed: %s", err)
+		resp.Error = err.Error()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		resp.OK = true
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// writeHealthMarker writes a marker transaction on the primary.
+func (s *Server) writeHealthMarker(ctx context.Context, resp *deepHealthJSON) error {
+	t := time.Now()
+	if err := s.HealthWriter(ctx, s.HealthDB); err != nil {
+		return fmt.Errorf("write marker: %w", err)
+	}
+	resp.WriteDuration = time.Since(t).String()
+
+	db := s.store.DB(s.HealthDB)
+	if db == nil {
+		return fmt.Errorf("health database not found after write")
+	}
+	resp.TXID = ltx.FormatTXID(db.TXID())
+	return nil
+}
+
+// verifyHealthMarker asks the primary to write a marker transaction and waits
+// for it to be applied on this replica. The caller's authorization is passed
+// through to the primary.
+func (s *Server) verifyHealthMarker(ctx context.Context, authorization string, resp *deepHealthJSON) error {
+	info := s.store.PrimaryInfo()
+	if info == nil {
+		return fmt.Errorf("no primary")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(info.AdvertiseURL, "/")+"/healthz/deep", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(HealthForwardedHeader, "1")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	httpResp, err := s.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("primary: %w", err)
+	}
+	defer func() { _ = httpResp.Body.Close() }()
+
+	var primary deepHealthJSON
+	if err := json.NewDecoder(httpResp.Body).Decode(&primary); err != nil {
+		return fmt.Errorf("primary: invalid response (%d): %w", httpResp.StatusCode, err)
+	} else if !primary.OK {
+		return fmt.Errorf("primary: %s", primary.Error)
+	}
+	resp.WriteDuration = primary.WriteDuration
+
+	txID, err := ltx.ParseTXID(primary.TXID)
+	if err != nil {
+		return fmt.Errorf("primary: invalid txid: %w", err)
+	}
+	resp.TXID = primary.TXID
+
+	// The health database may not exist locally until its first transaction
+	// has been replicated.
+	t := time.Now()
+	sub := s.store.Subscribe()
+	defer func() { _ = sub.Close() }()
+
+	db := s.store.DB(s.HealthDB)
+	for db == nil {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("wait for health database: %w", ctx.Err())
+		case <-sub.NotifyCh():
+		}
+		db = s.store.DB(s.HealthDB)
+	}
+
+	if err := s.store.WaitPos(ctx, db, txID); err != nil {
+		return err
+	}
+	resp.ReplicationDuration = time.Since(t).String()
+	return nil
+}
+
+// httpClient returns the client the store uses to connect to other nodes so
+// that its dialer & transport settings apply. Falls back to the default client.
+func (s *Server) httpClient() *http.Client {
+	if c, ok := s.store.Client.(*Client); ok && c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+type deepHealthJSON struct {
+	OK   bool   `json:"ok"`
+	Role string `json:"role"`
+	DB   string `json:"db"`
+	TXID string `json:"txid,omitempty"`
+
+	// Time for the primary to write the marker, for it to be applied on
+	// this replica after the primary responded, and for the whole check.
+	WriteDuration       string `json:"writeDuration,omitempty"`
+	ReplicationDuration string `json:"replicationDuration,omitempty"`
+	Duration            string `json:"duration"`
+
+	Error string `json:"error,omitempty"`
+}
+
+func (s *Server) handlePrimaryPin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		d, err := time.ParseDuration(r.URL.Query().Get("duration"))
+		if err != nil || d <= 0 {
+			Error(w, r, fmt.Errorf("invalid pin duration: %q", r.URL.Query().Get("duration")), http.StatusBadRequest)
+			return
+		} else if !s.store.Candidate() {
+			Error(w, r, fmt.Errorf("node is not a candidate"), http.StatusConflict)
+			return
+		} else if err := s.store.Pin(r.Context(), d); err == litefs.ErrNotPrimary {
+			s.notPrimaryError(w, r, err)
+			return
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			Error(w, r, err, http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			Error(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "primary pinned for %s\n", d)
+	case http.MethodDelete:
+		s.store.Unpin()
+		_, _ = fmt.Fprintln(w, "primary unpinned")
+	default:
+		Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleSysDebug(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		_, _ = fmt.Fprintln(w, s.store.Debug())
+	case "PUT":
+		s.store.SetDebug(true)
+		_, _ = fmt.Fprintln(w, "debug logging enabled")
+	case "DELETE":
+		s.store.SetDebug(false)
+		_, _ = fmt.Fprintln(w, "debug logging disabled")
+	default:
+		Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+	}
+}
+
+// setPrimaryHeaders sets the role & primary headers, if enabled.
+func (s *Server) setPrimaryHeaders(w http.ResponseWriter) {
+	if !s.PrimaryHeaders {
+		return
+	}
+
+	if s.store.IsPrimary() {
+		w.Header().Set(RoleHeader, "primary")
+		return
+	}
+	w.Header().Set(RoleHeader, "replica")
+	if info := s.store.PrimaryInfo(); info != nil {
+		w.Header().Set(PrimaryHeader, info.AdvertiseURL)
+	}
+}
+
+// notPrimaryError rejects a request that can only be served by the primary
+// with 409. The primary headers are refreshed in case the primary changed
+// during the request. If no primary is known, such as during an election,
+// a Retry-After hint is set instead.
+func (s *Server) notPrimaryError(w http.ResponseWriter, r *http.Request, err error) {
+	s.setPrimaryHeaders(w)
+	if s.store.PrimaryInfo() == nil && !s.store.IsPrimary() {
+		w.Header().Del(PrimaryHeader)
+		w.Header().Set("Retry-After", strconv.Itoa(s.retryAfter()))
+	}
+	Error(w, r, err, http.StatusConflict)
+}
+
+func Error(w http.ResponseWriter, r *http.Request, err error, code int) {
+	logf(r.Context(), "http: error: %s", err)
+	http.Error(w, err.Error(), code)
+}
+
+// RequestIDHeader is the header used to correlate a request with the log lines
+// it produced. For replication streams, it identifies the connection.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDContextKey struct{}
+
+// RequestIDFromContext returns the request ID attached by the server, if any.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// MaxRequestIDLen is the maximum length of a client-supplied request ID.
+const MaxRequestIDLen = 64
+
+// withRequestID attaches the client's request ID to the request context or
+// generates one if the client did not send a valid one. The ID is echoed back
+// in the response header.
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := r.Header.Get(RequestIDHeader)
+	if !isValidRequestID(id) {
+		id = newRequestID()
+	}
+	w.Header().Set(RequestIDHeader, id)
+	return r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, id))
+}
+
+// isValidRequestID returns true if id is non-empty, at most MaxRequestIDLen
+// characters, & only contains [A-Za-z0-9._-]. Other IDs are replaced so that
+// clients cannot inject arbitrary text into log lines.
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > MaxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		switch ch := id[i]; {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9':
+		case ch == '.', ch == '_', ch == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random 16-character hex identifier.
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := crand.Read(b); err != nil {
+		return fmt.Sprintf("%016x", rand.Uint64())
+	}
+	return hex.EncodeToString(b)
+}
+
+// logf writes a log line prefixed by the request ID in ctx, if any.
+func logf(ctx context.Context, format string, v ...any) {
+	if id := RequestIDFromContext(ctx); id != "" {
+		format, v = "req=%s "+format, append([]any{id}, v...)
+	}
+	log.Printf(format, v...)
+}
+
+// HTTP server metrics.
+var (
+	serverStreamCountMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_http_stream_count",
+		Help: "Number of streams currently connected.",
+	})
+
+	serverMaxStreamCountMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_http_max_stream_count",
+		Help: "Maximum number of concurrent streams allowed. Zero if unlimited.",
+	})
+
+	serverStreamAcceptRateMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_http_stream_accept_rate",
+		Help: "Number of streams accepted over the last second.",
+	})
+
+	serverFrameSendCountMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_http_frame_send_count",
+		Help: "Number of frames sent.",
+	}, []string{"db", "type"})
+
+	serverVerifyCountMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_http_stream_verify_count",
+		Help: "Number of replica positions verified on connect by result.",
+	}, []string{"db", "result"})
+
+	serverSnapshotInProgressMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_http_snapshot_in_progress_count",
+		Help: "Number of snapshots currently being sent to replicas.",
+	})
+
+	serverSnapshotQueueDepthMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_http_snapshot_queue_depth",
+		Help: "Number of snapshots waiting for a free slot.",
+	})
 )
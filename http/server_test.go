@@ -0,0 +1,902 @@
+package http_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/superfly/litefs"
+	litefshttp "github.com/superfly/litefs/http"
+	"github.com/superfly/litefs/litefstest"
+	"github.com/superfly/ltx"
+)
+
+func TestServer_DBPattern(t *testing.T) {
+	store := newOpenStore(t, newPrimaryStaticLeaser())
+	for _, name := range []string{"tenant-b", "other", "tenant-a"} {
+		newDB(t, store, name)
+	}
+	server := newOpenServer(t, store)
+
+	t.Run("Pos", func(t *testing.T) {
+		var resp dbMatchesJSON
+		getJSON(t, server.URL()+"/db/tenant-*/pos", http.StatusOK, &resp)
+		if got, want := len(resp.DBs), 2; got != want {
+			t.Fatalf("len=%d, want %d", got, want)
+		} else if _, ok := resp.DBs["tenant-a"]; !ok {
+			t.Fatal("expected tenant-a")
+		} else if _, ok := resp.DBs["tenant-b"]; !ok {
+			t.Fatal("expected tenant-b")
+		} else if resp.Next != "" {
+			t.Fatalf("unexpected next: %q", resp.Next)
+		}
+
+		var pos struct {
+			Name string `json:"name"`
+			TXID string `json:"txid"`
+		}
+		if err := json.Unmarshal(resp.DBs["tenant-a"], &pos); err != nil {
+			t.Fatal(err)
+		} else if got, want := pos.Name, "tenant-a"; got != want {
+			t.Fatalf("Name=%q, want %q", got, want)
+		}
+	})
+
+	t.Run("Paginate", func(t *testing.T) {
+		var page0 dbMatchesJSON
+		getJSON(t, server.URL()+"/db/*/info?limit=2", http.StatusOK, &page0)
+		if got, want := len(page0.DBs), 2; got != want {
+			t.Fatalf("len=%d, want %d", got, want)
+		} else if _, ok := page0.DBs["other"]; !ok {
+			t.Fatal("expected first page in name order")
+		} else if got, want := page0.Next, "tenant-a"; got != want {
+			t.Fatalf("Next=%q, want %q", got, want)
+		}
+
+		var page1 dbMatchesJSON
+		getJSON(t, server.URL()+"/db/*/info?limit=2&after="+page0.Next, http.StatusOK, &page1)
+		if got, want := len(page1.DBs), 1; got != want {
+			t.Fatalf("len=%d, want %d", got, want)
+		} else if _, ok := page1.DBs["tenant-b"]; !ok {
+			t.Fatal("expected tenant-b")
+		} else if page1.Next != "" {
+			t.Fatalf("unexpected next: %q", page1.Next)
+		}
+	})
+
+	t.Run("Checksum", func(t *testing.T) {
+		var resp dbMatchesJSON
+		getJSON(t, server.URL()+"/db/tenant-%3F/checksum?txid=0000000000000001", http.StatusOK, &resp)
+
+		var v struct {
+			TXID  string `json:"txid"`
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(resp.DBs["tenant-a"], &v); err != nil {
+			t.Fatal(err)
+		} else if got, want := v.TXID, "0000000000000001"; got != want {
+			t.Fatalf("TXID=%q, want %q", got, want)
+		} else if v.Error == "" {
+			t.Fatal("expected per-database error for missing TXID")
+		}
+	})
+
+	t.Run("ErrInvalidPattern", func(t *testing.T) {
+		getJSON(t, server.URL()+"/db/tenant-%5B/pos", http.StatusBadRequest, nil)
+	})
+	t.Run("ErrInvalidLimit", func(t *testing.T) {
+		getJSON(t, server.URL()+"/db/*/pos?limit=101", http.StatusBadRequest, nil)
+	})
+	t.Run("ErrUnsupportedAction", func(t *testing.T) {
+		getJSON(t, server.URL()+"/db/*/wait", http.StatusNotFound, nil)
+	})
+	t.Run("ErrMethodNotAllowed", func(t *testing.T) {
+		resp, err := http.Post(server.URL()+"/db/*/pos", "text/plain", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		if got, want := resp.StatusCode, http.StatusMethodNotAllowed; got != want {
+			t.Fatalf("StatusCode=%d, want %d", got, want)
+		}
+	})
+}
+
+func TestServer_Pprof(t *testing.T) {
+	store := newOpenStore(t, newPrimaryStaticLeaser())
+
+	t.Run("Disabled", func(t *testing.T) {
+		server := newOpenServer(t, store)
+		if got, want := getWithToken(t, server.URL()+"/debug/pprof/", ""), http.StatusNotFound; got != want {
+			t.Fatalf("StatusCode=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("Enabled", func(t *testing.T) {
+		server := newServer(t, store)
+		server.Pprof = true
+		server.DebugStateToken = "secret"
+		openServer(t, server)
+
+		if got, want := getWithToken(t, server.URL()+"/debug/pprof/", ""), http.StatusUnauthorized; got != want {
+			t.Fatalf("StatusCode=%d, want %d", got, want)
+		} else if got, want := getWithToken(t, server.URL()+"/debug/pprof/heap", "wrong"), http.StatusUnauthorized; got != want {
+			t.Fatalf("StatusCode=%d, want %d", got, want)
+		} else if got, want := getWithToken(t, server.URL()+"/debug/pprof/heap", "secret"), http.StatusOK; got != want {
+			t.Fatalf("StatusCode=%d, want %d", got, want)
+		}
+	})
+
+	// The handlers are unreachable without a token even when enabled.
+	t.Run("NoToken", func(t *testing.T) {
+		server := newServer(t, store)
+		server.Pprof = true
+		openServer(t, server)
+
+		if got, want := getWithToken(t, server.URL()+"/debug/pprof/", ""), http.StatusUnauthorized; got != want {
+			t.Fatalf("StatusCode=%d, want %d", got, want)
+		}
+	})
+}
+
+func TestServer_ReadOnlyAPI(t *testing.T) {
+	store := newOpenStore(t, newPrimaryStaticLeaser())
+	newDB(t, store, "db")
+	server := newServer(t, store)
+	server.ReadOnlyAPI = true
+	openServer(t, server)
+
+	for _, tt := range []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPut, "/sys/debug"},
+		{http.MethodDelete, "/sys/debug"},
+		{http.MethodPost, "/primary/pin?duration=1m"},
+		{http.MethodDelete, "/primary/pin"},
+		{http.MethodPost, "/retention/sweep"},
+		{http.MethodPost, "/drain"},
+		{http.MethodPost, "/db/db/rebuild"},
+		{http.MethodGet, "/healthz/deep"},
+	} {
+		t.Run(tt.method+tt.path, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, server.URL()+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = resp.Body.Close() }()
+			if got, want := resp.StatusCode, http.StatusForbidden; got != want {
+				t.Fatalf("StatusCode=%d, want %d", got, want)
+			}
+		})
+	}
+
+	// Read endpoints are unaffected.
+	t.Run("Read", func(t *testing.T) {
+		getJSON(t, server.URL()+"/db/db/info", http.StatusOK, nil)
+	})
+}
+
+func TestServer_DebugState(t *testing.T) {
+	store := newOpenStore(t, newPrimaryStaticLeaser())
+	db := newDB(t, store, "db")
+	litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 1, 1))
+
+	server := newServer(t, store)
+	server.DebugState = true
+	server.DebugStateToken = "secret"
+	openServer(t, server)
+
+	t.Run("OK", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, server.URL()+"/debug/state", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		if got, want := resp.StatusCode, http.StatusOK; got != want {
+			t.Fatalf("StatusCode=%d, want %d", got, want)
+		}
+
+		var state struct {
+			DBs map[string]struct {
+				LTXFiles []struct {
+					Name string `json:"name"`
+				} `json:"ltxFiles"`
+			} `json:"dbs"`
+			Events []litefs.Event `json:"events"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+			t.Fatal(err)
+		} else if got, want := len(state.DBs["db"].LTXFiles), 1; got != want {
+			t.Fatalf("len(ltxFiles)=%d, want %d", got, want)
+		}
+
+		// Events match the store's event history, oldest first.
+		history := store.EventHistory(time.Time{})
+		if got, want := len(state.Events), len(history); got != want || got == 0 {
+			t.Fatalf("len(events)=%d, want %d", got, want)
+		} else if got, want := state.Events[0].Type, litefs.EventTypePrimaryAcquire; got != want {
+			t.Fatalf("type=%q, want %q", got, want)
+		} else if got, want := state.Events[len(state.Events)-1].Type, history[len(history)-1].Type; got != want {
+			t.Fatalf("type=%q, want %q", got, want)
+		}
+	})
+
+	t.Run("ErrUnauthorized", func(t *testing.T) {
+		if got, want := getWithToken(t, server.URL()+"/debug/state", "wrong"), http.StatusUnauthorized; got != want {
+			t.Fatalf("StatusCode=%d, want %d", got, want)
+		}
+	})
+}
+
+func TestServer_DeepHealth(t *testing.T) {
+	t.Run("Disabled", func(t *testing.T) {
+		server := newOpenServer(t, newOpenStore(t, newPrimaryStaticLeaser()))
+		getJSON(t, server.URL()+"/healthz/deep", http.StatusNotFound, nil)
+	})
+
+	// Ensure a replica forwards the caller's token to the primary & waits for
+	// the marker to be replicated.
+	t.Run("Replica", func(t *testing.T) {
+		primaryStore := newOpenStore(t, newPrimaryStaticLeaser())
+		db := newDB(t, primaryStore, "health.db")
+		primaryServer := newServer(t, primaryStore)
+		primaryServer.DebugStateToken = "secret"
+		primaryServer.HealthDB = "health.db"
+		var writeN int
+		primaryServer.HealthWriter = func(ctx context.Context, name string) error {
+			writeN++
+			litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 1, byte(writeN)))
+			return nil
+		}
+		openServer(t, primaryServer)
+
+		replicaStore := litefs.NewStore(t.TempDir(), true)
+		replicaStore.Leaser = litefs.NewStaticLeaser(false, "localhost", primaryServer.URL())
+		replicaStore.Client = litefshttp.NewClient()
+		if err := replicaStore.Open(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = replicaStore.Close() })
+		t.Cleanup(func() { _ = primaryServer.Close() }) // disconnect replica before closing
+
+		replicaServer := newServer(t, replicaStore)
+		replicaServer.DebugStateToken = "secret"
+		replicaServer.HealthDB = "health.db"
+		replicaServer.HealthWriter = func(ctx context.Context, name string) error {
+			return fmt.Errorf("unexpected write on replica")
+		}
+		openServer(t, replicaServer)
+
+		if got, want := getWithToken(t, replicaServer.URL()+"/healthz/deep", ""), http.StatusUnauthorized; got != want {
+			t.Fatalf("StatusCode=%d, want %d", got, want)
+		} else if got, want := getWithToken(t, replicaServer.URL()+"/healthz/deep", "secret"), http.StatusOK; got != want {
+			t.Fatalf("StatusCode=%d, want %d", got, want)
+		} else if got, want := writeN, 1; got != want {
+			t.Fatalf("writeN=%d, want %d", got, want)
+		}
+	})
+}
+
+func TestServer_EventsHistory(t *testing.T) {
+	store := litefs.NewStore(t.TempDir(), true)
+	store.NodeName = "node1"
+	store.Leaser = newPrimaryStaticLeaser()
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	<-store.ReadyCh()
+
+	server := newOpenServer(t, store)
+
+	type historyJSON struct {
+		Node   string         `json:"node"`
+		Events []litefs.Event `json:"events"`
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		var history historyJSON
+		getJSON(t, server.URL()+"/events/history", http.StatusOK, &history)
+		if got, want := history.Node, "node1"; got != want {
+			t.Fatalf("node=%q, want %q", got, want)
+		} else if got, want := len(history.Events), 2; got != want {
+			t.Fatalf("len=%d, want %d", got, want)
+		} else if got, want := history.Events[0].Type, litefs.EventTypePrimaryAcquire; got != want {
+			t.Fatalf("type=%q, want %q", got, want)
+		} else if got, want := history.Events[1].Type, litefs.EventTypeReady; got != want {
+			t.Fatalf("type=%q, want %q", got, want)
+		} else if got, want := history.Events[1].Node, "node1"; got != want {
+			t.Fatalf("event node=%q, want %q", got, want)
+		}
+	})
+
+	// Ensure only events after the "since" timestamp are returned.
+	t.Run("Since", func(t *testing.T) {
+		events := store.EventHistory(time.Time{})
+
+		var history historyJSON
+		getJSON(t, server.URL()+"/events/history?since="+url.QueryEscape(events[0].Time.Format(time.RFC3339Nano)), http.StatusOK, &history)
+		if got, want := len(history.Events), 1; got != want {
+			t.Fatalf("len=%d, want %d", got, want)
+		} else if got, want := history.Events[0].Type, litefs.EventTypeReady; got != want {
+			t.Fatalf("type=%q, want %q", got, want)
+		}
+
+		getJSON(t, server.URL()+"/events/history?since="+url.QueryEscape(events[1].Time.Format(time.RFC3339Nano)), http.StatusOK, &history)
+		if got := len(history.Events); got != 0 {
+			t.Fatalf("len=%d, want 0", got)
+		}
+	})
+
+	t.Run("ErrInvalidSince", func(t *testing.T) {
+		getJSON(t, server.URL()+"/events/history?since=yesterday", http.StatusBadRequest, nil)
+	})
+
+	t.Run("ErrMethodNotAllowed", func(t *testing.T) {
+		resp, err := http.Post(server.URL()+"/events/history", "application/json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if got, want := resp.StatusCode, http.StatusMethodNotAllowed; got != want {
+			t.Fatalf("StatusCode=%d, want %d", got, want)
+		}
+	})
+}
+
+func TestServer_RequestID(t *testing.T) {
+	store := newOpenStore(t, newPrimaryStaticLeaser())
+	server := newOpenServer(t, store)
+
+	// requestID sends id in the request header & returns the echoed ID.
+	requestID := func(tb testing.TB, id string) string {
+		tb.Helper()
+		req, err := http.NewRequest(http.MethodGet, server.URL()+"/db/*/pos", nil)
+		if err != nil {
+			tb.Fatal(err)
+		} else if id != "" {
+			req.Header.Set(litefshttp.RequestIDHeader, id)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		return resp.Header.Get(litefshttp.RequestIDHeader)
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		for _, id := range []string{"abc", "A.b_c-1", strings.Repeat("x", litefshttp.MaxRequestIDLen)} {
+			if got := requestID(t, id); got != id {
+				t.Fatalf("ID=%q, want %q", got, id)
+			}
+		}
+	})
+
+	// Missing or invalid IDs are replaced with a generated ID.
+	t.Run("Generated", func(t *testing.T) {
+		for _, id := range []string{
+			"",
+			strings.Repeat("x", litefshttp.MaxRequestIDLen+1),
+			"abc def",
+			"abc\tdef",
+			"abc=1",
+			"caf\u00e9",
+		} {
+			if got := requestID(t, id); got == id || len(got) != 16 {
+				t.Fatalf("ID=%q for %q, expected generated ID", got, id)
+			}
+		}
+	})
+}
+
+func TestServer_StreamChecksumAlgorithm(t *testing.T) {
+	store := newOpenStore(t, newPrimaryStaticLeaser())
+	server := newOpenServer(t, store)
+
+	t.Run("OK", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		client := litefshttp.NewClient()
+		st, err := client.Stream(ctx, server.URL(), "node2", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = st.Close()
+	})
+
+	t.Run("ErrMismatch", func(t *testing.T) {
+		client := litefshttp.NewClient()
+		client.ChecksumAlgorithm = litefs.ChecksumAlgorithm("sha256")
+		if _, err := client.Stream(context.Background(), server.URL(), "node2", nil); err == nil || !strings.Contains(err.Error(), "code=409") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	// Version 1 nodes do not send the header & only support the default.
+	t.Run("Version1", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var buf bytes.Buffer
+		if err := litefshttp.WritePosMapTo(&buf, nil); err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL()+"/stream", &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Litefs-Id", "node2")
+		req.Header.Set(litefshttp.ProtocolVersionsHeader, "1")
+
+		resp, err := litefshttp.NewClient().HTTPClient.Do(req) // h2c
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		if got, want := resp.StatusCode, http.StatusOK; got != want {
+			t.Fatalf("StatusCode=%d, want %d", got, want)
+		}
+	})
+}
+
+// Ensure frames added in protocol version 2 are only sent to replicas which
+// negotiated that version.
+func TestServer_StreamProtocolVersion(t *testing.T) {
+	store := litefs.NewStore(t.TempDir(), true)
+	store.Leaser = newPrimaryStaticLeaser()
+	store.ExpectedClusterID = "LFSC0123456789ABCDEF"
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	<-store.ReadyCh()
+
+	server := newOpenServer(t, store)
+
+	// stream connects with the given versions & returns the frame types sent
+	// before the ready frame.
+	stream := func(tb testing.TB, versions string) (version string, types []litefs.StreamFrameType) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var buf bytes.Buffer
+		if err := litefshttp.WritePosMapTo(&buf, nil); err != nil {
+			tb.Fatal(err)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL()+"/stream", &buf)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		req.Header.Set("Litefs-Id", "node2")
+		req.Header.Set("Litefs-Checksum-Algorithm", string(litefs.ChecksumAlgorithmCRC64))
+		req.Header.Set(litefshttp.ProtocolVersionsHeader, versions)
+
+		resp, err := litefshttp.NewClient().HTTPClient.Do(req) // h2c
+		if err != nil {
+			tb.Fatal(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		if got, want := resp.StatusCode, http.StatusOK; got != want {
+			tb.Fatalf("StatusCode=%d, want %d", got, want)
+		}
+
+		for {
+			frame, err := litefs.ReadStreamFrame(resp.Body)
+			if err != nil {
+				tb.Fatal(err)
+			} else if _, ok := frame.(*litefs.ReadyStreamFrame); ok {
+				return resp.Header.Get(litefshttp.ProtocolVersionHeader), types
+			}
+			types = append(types, frame.Type())
+		}
+	}
+
+	t.Run("Version1", func(t *testing.T) {
+		version, types := stream(t, "1")
+		if got, want := version, "1"; got != want {
+			t.Fatalf("version=%q, want %q", got, want)
+		} else if len(types) != 0 {
+			t.Fatalf("unexpected frames: %v", types)
+		}
+	})
+
+	t.Run("Version2", func(t *testing.T) {
+		version, types := stream(t, litefshttp.FormatProtocolVersions())
+		if got, want := version, "2"; got != want {
+			t.Fatalf("version=%q, want %q", got, want)
+		} else if got, want := types, []litefs.StreamFrameType{litefs.StreamFrameTypeClusterID, litefs.StreamFrameTypeConfig}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("frames=%v, want %v", got, want)
+		}
+	})
+}
+
+func TestServer_Bench(t *testing.T) {
+	server := newOpenServer(t, newOpenStore(t, newPrimaryStaticLeaser()))
+
+	t.Run("OK", func(t *testing.T) {
+		payload := make([]byte, 1<<20)
+		if _, err := rand.Read(payload); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := litefshttp.NewClient().Bench(context.Background(), server.URL(), bytes.NewReader(payload)); err != nil {
+			t.Fatal(err)
+		} else if got, want := n, int64(len(payload)); got != want {
+			t.Fatalf("n=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("ErrTooLarge", func(t *testing.T) {
+		payload := make([]byte, litefshttp.MaxBenchPayloadSize+1)
+		if _, err := litefshttp.NewClient().Bench(context.Background(), server.URL(), bytes.NewReader(payload)); err == nil || err.Error() != `invalid response: code=413` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// Ensure a replica is told to drop a database which no longer exists on the
+// primary & that the drop frame is counted.
+func TestServer_StreamDropDB(t *testing.T) {
+	store := newOpenStore(t, newPrimaryStaticLeaser())
+	server := newOpenServer(t, store)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dropN := frameSendCount(t, "gone", "drop")
+
+	st, err := litefshttp.NewClient().Stream(ctx, server.URL(), "node2", map[string]litefs.Pos{"gone": {TXID: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = st.Close() }()
+
+	var names []string
+	for {
+		frame, err := litefs.ReadStreamFrame(st)
+		if err != nil {
+			t.Fatal(err)
+		} else if _, ok := frame.(*litefs.ReadyStreamFrame); ok {
+			break
+		} else if frame, ok := frame.(*litefs.DropDBStreamFrame); ok {
+			names = append(names, frame.Name)
+		}
+	}
+
+	if got, want := names, []string{"gone"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("names=%v, want %v", got, want)
+	} else if got, want := frameSendCount(t, "gone", "drop"), dropN+1; got != want {
+		t.Fatalf("drop count=%v, want %v", got, want)
+	}
+}
+
+// frameSendCount returns the number of frames of a type sent for a database.
+func frameSendCount(tb testing.TB, name, typ string) float64 {
+	tb.Helper()
+
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "litefs_http_frame_send_count" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range m.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["db"] == name && labels["type"] == typ {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func TestServer_VerifyOnConnect(t *testing.T) {
+	store := newOpenStore(t, newPrimaryStaticLeaser())
+	db := newDB(t, store, "db")
+	litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 2, 1))
+	litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 2, 2))
+	server := newOpenServer(t, store)
+
+	chksum, err := db.ChecksumAt(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// stream connects as a replica at pos & returns the LTX headers sent
+	// before the ready frame.
+	stream := func(tb testing.TB, pos litefs.Pos) []ltx.Header {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		client := litefshttp.NewClient()
+		client.VerifyOnConnect = true
+		st, err := client.Stream(ctx, server.URL(), "node2", map[string]litefs.Pos{"db": pos})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		defer func() { _ = st.Close() }()
+		return readStreamUntilReady(tb, st)
+	}
+
+	// A replica with a matching checksum continues from its position.
+	t.Run("OK", func(t *testing.T) {
+		hdrs := stream(t, litefs.Pos{TXID: 1, PostApplyChecksum: chksum})
+		if got, want := len(hdrs), 1; got != want {
+			t.Fatalf("len=%d, want %d", got, want)
+		} else if hdrs[0].IsSnapshot() || hdrs[0].MinTXID != 2 {
+			t.Fatalf("unexpected header: %#v", hdrs[0])
+		}
+	})
+
+	// A replica which diverged at its position is resynced with a snapshot.
+	t.Run("Diverged", func(t *testing.T) {
+		hdrs := stream(t, litefs.Pos{TXID: 1, PostApplyChecksum: chksum ^ 1})
+		if got, want := len(hdrs), 1; got != want {
+			t.Fatalf("len=%d, want %d", got, want)
+		} else if !hdrs[0].IsSnapshot() || hdrs[0].MaxTXID != 2 {
+			t.Fatalf("expected snapshot: %#v", hdrs[0])
+		}
+	})
+}
+
+// Ensure a snapshot queued behind MaxConcurrentSnapshots is sent once the
+// slot is released & that the stream is not reported ready until then.
+func TestServer_MaxConcurrentSnapshots(t *testing.T) {
+	store := newOpenStore(t, newPrimaryStaticLeaser())
+	db := newDB(t, store, "db")
+
+	// Use a database larger than the stream's flow control window so that a
+	// snapshot holds its slot until the replica reads it.
+	data := litefstest.NewDatabase(4096, 4096, 0)
+	if _, err := rand.Read(data[4096:]); err != nil {
+		t.Fatal(err)
+	}
+	litefstest.WriteTx(t, db, data)
+
+	// Remove the LTX file, as retention would, so replicas require a snapshot.
+	if err := os.Remove(db.LTXPath(1, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	server := newServer(t, store)
+	server.MaxConcurrentSnapshots = 1
+	openServer(t, server)
+
+	// Connect the first replica & read up to its snapshot frame so that it
+	// holds the only slot. The snapshot itself is left unread.
+	ctx0, cancel0 := context.WithCancel(context.Background())
+	defer cancel0()
+	st0, err := litefshttp.NewClient().Stream(ctx0, server.URL(), "node2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		frame, err := litefs.ReadStreamFrame(st0)
+		if err != nil {
+			t.Fatal(err)
+		} else if _, ok := frame.(*litefs.LTXStreamFrame); ok {
+			break
+		}
+	}
+
+	// Connect the second replica, which must wait for the slot.
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	st1, err := litefshttp.NewClient().Stream(ctx1, server.URL(), "node3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = st1.Close() }()
+
+	type result struct {
+		hdrs []ltx.Header
+		err  error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		var hdrs []ltx.Header
+		for {
+			frame, err := litefs.ReadStreamFrame(st1)
+			if err != nil {
+				resultCh <- result{err: err}
+				return
+			}
+
+			switch frame.(type) {
+			case *litefs.ReadyStreamFrame:
+				resultCh <- result{hdrs: hdrs}
+				return
+			case *litefs.LTXStreamFrame:
+				lr := ltx.NewReader(st1)
+				if _, err := io.Copy(io.Discard, lr); err != nil {
+					resultCh <- result{err: err}
+					return
+				}
+				hdrs = append(hdrs, lr.Header())
+			}
+		}
+	}()
+
+	select {
+	case r := <-resultCh:
+		t.Fatalf("unexpected ready before slot released: n=%d err=%v", len(r.hdrs), r.err)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// Disconnect the first replica to release its slot.
+	_ = st0.Close()
+	cancel0()
+
+	select {
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for queued snapshot")
+	case r := <-resultCh:
+		if r.err != nil {
+			t.Fatal(r.err)
+		} else if got, want := len(r.hdrs), 1; got != want {
+			t.Fatalf("len=%d, want %d", got, want)
+		} else if !r.hdrs[0].IsSnapshot() || r.hdrs[0].MaxTXID != 1 {
+			t.Fatalf("expected snapshot: %#v", r.hdrs[0])
+		}
+	}
+}
+
+type dbMatchesJSON struct {
+	DBs  map[string]json.RawMessage `json:"dbs"`
+	Next string                     `json:"next"`
+}
+
+// readStreamUntilReady reads frames from a replication stream up to the ready
+// frame & returns the header of each LTX file received.
+func readStreamUntilReady(tb testing.TB, r io.Reader) []ltx.Header {
+	tb.Helper()
+
+	var hdrs []ltx.Header
+	for {
+		frame, err := litefs.ReadStreamFrame(r)
+		if err != nil {
+			tb.Fatal(err)
+		}
+
+		switch frame.(type) {
+		case *litefs.ReadyStreamFrame:
+			return hdrs
+		case *litefs.LTXStreamFrame:
+			lr := ltx.NewReader(r)
+			if _, err := io.Copy(io.Discard, lr); err != nil {
+				tb.Fatal(err)
+			}
+			hdrs = append(hdrs, lr.Header())
+		}
+	}
+}
+
+// getJSON issues a GET request and decodes the response into v, if not nil.
+func getJSON(tb testing.TB, url string, statusCode int, v interface{}) {
+	tb.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if got, want := resp.StatusCode, statusCode; got != want {
+		tb.Fatalf("StatusCode=%d, want %d", got, want)
+	} else if v == nil {
+		return
+	} else if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// getWithToken issues a GET request with an optional bearer token and
+// returns the response status code.
+func getWithToken(tb testing.TB, url, token string) int {
+	tb.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		tb.Fatal(err)
+	} else if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode
+}
+
+// newServer returns a new server on a random port for store. The server is
+// closed when the test ends.
+func newServer(tb testing.TB, store *litefs.Store) *litefshttp.Server {
+	server := litefshttp.NewServer(store, "localhost:0")
+	tb.Cleanup(func() { _ = server.Close() })
+	return server
+}
+
+// newOpenServer returns a new server that is listening & serving.
+func newOpenServer(tb testing.TB, store *litefs.Store) *litefshttp.Server {
+	tb.Helper()
+	server := newServer(tb, store)
+	openServer(tb, server)
+	return server
+}
+
+// openServer starts listening & serving requests on server.
+func openServer(tb testing.TB, server *litefshttp.Server) {
+	tb.Helper()
+	if err := server.Listen(); err != nil {
+		tb.Fatal(err)
+	}
+	server.Serve()
+}
+
+// newOpenStore returns a new instance of an empty, opened store.
+func newOpenStore(tb testing.TB, leaser litefs.Leaser) *litefs.Store {
+	tb.Helper()
+
+	store := litefs.NewStore(tb.TempDir(), true)
+	store.Leaser = leaser
+	if err := store.Open(); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { _ = store.Close() })
+
+	select {
+	case <-time.After(5 * time.Second):
+		tb.Fatal("timeout waiting for store ready")
+	case <-store.ReadyCh():
+	}
+	return store
+}
+
+// newDB creates an empty database on store.
+func newDB(tb testing.TB, store *litefs.Store, name string) *litefs.DB {
+	tb.Helper()
+
+	db, f, err := store.CreateDB(name)
+	if err != nil {
+		tb.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	return db
+}
+
+func newPrimaryStaticLeaser() *litefs.StaticLeaser {
+	return litefs.NewStaticLeaser(true, "localhost", "http://localhost:20202")
+}
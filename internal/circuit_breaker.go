@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"sync"
+	"time"
+)
+
+// Circuit breaker states.
+const (
+	CircuitClosed   = CircuitState(0)
+	CircuitOpen     = CircuitState(1)
+	CircuitHalfOpen = CircuitState(2)
+)
+
+// CircuitState represents the state of a CircuitBreaker.
+type CircuitState int
+
+// String returns the name of the state.
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreaker stops calls to a failing endpoint. The breaker opens after
+// Threshold consecutive failures & calls are rejected until Cooldown elapses.
+// A single trial call is then allowed; the breaker closes if it succeeds and
+// opens for another cooldown if it fails.
+//
+// A breaker with a zero threshold is disabled & always allows calls.
+type CircuitBreaker struct {
+	mu       sync.Mutex
+	state    CircuitState
+	failures int       // consecutive failures
+	openedAt time.Time // time the breaker last opened
+
+	Threshold int
+	Cooldown  time.Duration
+
+	// Returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// NewCircuitBreaker returns a new instance of CircuitBreaker.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+		Now:       time.Now,
+	}
+}
+
+// State returns the current state of the breaker.
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Allow returns true if a call may be made. Once the cooldown of an open
+// breaker elapses, only the first caller is allowed until it reports back.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if b.Now().Sub(b.openedAt) < b.Cooldown {
+			return false
+		}
+		b.state = CircuitHalfOpen
+		return true
+	case CircuitHalfOpen:
+		return false // trial call in progress
+	default:
+		return true
+	}
+}
+
+// Success reports a successful call & closes the breaker.
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state, b.failures = CircuitClosed, 0
+}
+
+// Failure reports a failed call. Opens the breaker if the trial call failed
+// or the threshold of consecutive failures is reached.
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.Threshold <= 0 {
+		return
+	}
+
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.Threshold {
+		b.state, b.openedAt = CircuitOpen, b.Now()
+	}
+}
@@ -0,0 +1,62 @@
+package internal_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/superfly/litefs/internal"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		now := time.Unix(0, 0)
+		b := internal.NewCircuitBreaker(2, 10*time.Second)
+		b.Now = func() time.Time { return now }
+
+		// Opens after consecutive failures reach the threshold.
+		b.Failure()
+		if !b.Allow() {
+			t.Fatal("expected allow below threshold")
+		}
+		b.Failure()
+		if got, want := b.State(), internal.CircuitOpen; got != want {
+			t.Fatalf("state=%s, want %s", got, want)
+		} else if b.Allow() {
+			t.Fatal("expected reject while open")
+		}
+
+		// A single trial call is allowed after the cooldown.
+		now = now.Add(10 * time.Second)
+		if !b.Allow() {
+			t.Fatal("expected trial call")
+		} else if b.Allow() {
+			t.Fatal("expected reject during trial call")
+		}
+
+		// A failed trial reopens immediately.
+		b.Failure()
+		if got, want := b.State(), internal.CircuitOpen; got != want {
+			t.Fatalf("state=%s, want %s", got, want)
+		}
+
+		// A successful trial closes the breaker.
+		now = now.Add(10 * time.Second)
+		if !b.Allow() {
+			t.Fatal("expected trial call")
+		}
+		b.Success()
+		if got, want := b.State(), internal.CircuitClosed; got != want {
+			t.Fatalf("state=%s, want %s", got, want)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		b := internal.NewCircuitBreaker(0, time.Minute)
+		for i := 0; i < 10; i++ {
+			b.Failure()
+		}
+		if !b.Allow() {
+			t.Fatal("expected allow")
+		}
+	})
+}
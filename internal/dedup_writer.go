@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxDedupEntries is the number of distinct messages tracked by DedupWriter.
+// Messages beyond this limit are written without deduplication.
+const MaxDedupEntries = 1024
+
+// DedupWriter wraps a log writer & collapses repeated identical messages. The
+// first occurrence of a message is always written immediately. Identical
+// messages within the window that follows are counted instead of written and
+// a single summary line is written once the window ends.
+//
+// Each call to Write() is treated as one message so the logger must not add
+// varying prefixes such as timestamps. Connection IDs ("conn=ID") are ignored
+// when comparing messages as they differ on every reconnection attempt.
+type DedupWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	window  time.Duration
+	entries map[string]*dedupEntry
+
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	// Returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+type dedupEntry struct {
+	msg   string    // first occurrence of the message
+	start time.Time // time of the first occurrence
+	n     int       // number of suppressed occurrences
+}
+
+// connIDRegex matches connection IDs which are masked in deduplication keys.
+var connIDRegex = regexp.MustCompile(`\bconn=\S+`)
+
+// dedupKey returns the key used to compare msg with previous messages.
+func dedupKey(msg string) string {
+	return connIDRegex.ReplaceAllString(msg, "conn=*")
+}
+
+// NewDedupWriter returns a new instance of DedupWriter that writes to w.
+func NewDedupWriter(w io.Writer, window time.Duration) *DedupWriter {
+	return &DedupWriter{
+		w:       w,
+		window:  window,
+		entries: make(map[string]*dedupEntry),
+		done:    make(chan struct{}),
+		Now:     time.Now,
+	}
+}
+
+// Open starts a background goroutine which writes summaries for windows that
+// end without another message being written.
+func (w *DedupWriter) Open() {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		ticker := time.NewTicker(w.window)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+				w.Flush()
+			}
+		}
+	}()
+}
+
+// Close stops the background goroutine & writes all pending summaries.
+func (w *DedupWriter) Close() error {
+	close(w.done)
+	w.wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.flush(time.Time{})
+}
+
+// Write writes p unless it is a repeat of a message written within the window.
+func (w *DedupWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Write summaries of expired windows first so output stays in order.
+	if err := w.flush(w.Now()); err != nil {
+		return 0, err
+	}
+
+	msg := string(p)
+	key := dedupKey(msg)
+	if e := w.entries[key]; e != nil {
+		e.n++
+		return len(p), nil
+	}
+
+	if len(w.entries) < MaxDedupEntries {
+		w.entries[key] = &dedupEntry{msg: msg, start: w.Now()}
+	}
+	return w.w.Write(p)
+}
+
+// Flush writes summaries for all windows which have ended.
+func (w *DedupWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.flush(w.Now())
+}
+
+// flush writes summaries for windows that ended before now, in the order the
+// messages first occurred. All windows are flushed if now is zero.
+func (w *DedupWriter) flush(now time.Time) error {
+	var keys []string
+	for key, e := range w.entries {
+		if now.IsZero() || now.Sub(e.start) >= w.window {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return w.entries[keys[i]].start.Before(w.entries[keys[j]].start) })
+
+	for _, key := range keys {
+		e := w.entries[key]
+		delete(w.entries, key)
+
+		if e.n == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(w.w, "%s (repeated %d times in the last %s)\n", strings.TrimSuffix(e.msg, "\n"), e.n, w.window); err != nil {
+			return err
+		}
+	}
+	return nil
+}
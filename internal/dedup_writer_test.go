@@ -0,0 +1,89 @@
+package internal_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/superfly/litefs/internal"
+)
+
+func TestDedupWriter(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		var buf bytes.Buffer
+		now := time.Unix(0, 0)
+		w := internal.NewDedupWriter(&buf, 30*time.Second)
+		w.Now = func() time.Time { return now }
+
+		// First occurrence is written immediately; repeats are suppressed.
+		for i := 0; i < 3; i++ {
+			if _, err := w.Write([]byte("cannot connect\n")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := w.Write([]byte("other\n")); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := buf.String(), "cannot connect\nother\n"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+
+		// Summary is written once the window ends.
+		now = now.Add(30 * time.Second)
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		} else if got, want := buf.String(), "cannot connect\nother\ncannot connect (repeated 2 times in the last 30s)\n"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+
+		// Message is written immediately again in the next window.
+		if _, err := w.Write([]byte("cannot connect\n")); err != nil {
+			t.Fatal(err)
+		} else if got, want := buf.String(), "cannot connect\nother\ncannot connect (repeated 2 times in the last 30s)\ncannot connect\n"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+
+	// Ensure messages differing only by connection ID are collapsed.
+	t.Run("ConnID", func(t *testing.T) {
+		var buf bytes.Buffer
+		now := time.Unix(0, 0)
+		w := internal.NewDedupWriter(&buf, 30*time.Second)
+		w.Now = func() time.Time { return now }
+
+		for _, msg := range []string{
+			"invalid response: code=503 conn=aaa\n",
+			"invalid response: code=503 conn=bbb\n",
+			"invalid response: code=500 conn=ccc\n",
+		} {
+			if _, err := w.Write([]byte(msg)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		now = now.Add(30 * time.Second)
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		} else if got, want := buf.String(), "invalid response: code=503 conn=aaa\ninvalid response: code=500 conn=ccc\ninvalid response: code=503 conn=aaa (repeated 1 times in the last 30s)\n"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := internal.NewDedupWriter(&buf, time.Minute)
+		w.Open()
+		for i := 0; i < 2; i++ {
+			if _, err := w.Write([]byte("x\n")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Pending summaries are written on close.
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		} else if got, want := buf.String(), "x\nx (repeated 1 times in the last 1m0s)\n"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+}
@@ -2,7 +2,10 @@ package litefs
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"net/url"
+	"sync"
 	"time"
 )
 
@@ -33,6 +36,19 @@ type Lease interface {
 	Close() error
 }
 
+// LeaseRevoker is implemented by leases that can be revoked before they
+// expire without a renewal, such as a demoted static lease.
+type LeaseRevoker interface {
+	Revoked() <-chan struct{}
+}
+
+// AcquireDelayer is implemented by leasers which ask to wait before the lease
+// is acquired, such as to dampen election churn. The store waits before it
+// starts promoting so writes are not rejected as retryable in the meantime.
+type AcquireDelayer interface {
+	AcquireDelay() time.Duration
+}
+
 // PrimaryInfo is the JSON object stored in the Consul lease value.
 type PrimaryInfo struct {
 	Hostname     string `json:"hostname"`
@@ -48,11 +64,45 @@ func (info *PrimaryInfo) Clone() *PrimaryInfo {
 	return &other
 }
 
+// Limits on primary info fields read from a leaser.
+const (
+	MaxPrimaryHostnameLen     = 255
+	MaxPrimaryAdvertiseURLLen = 2048
+)
+
+// Validate returns an error if info cannot describe a reachable primary, such
+// as when it was read from a corrupted lease value.
+func (info *PrimaryInfo) Validate() error {
+	if info.Hostname == "" {
+		return fmt.Errorf("hostname required")
+	} else if len(info.Hostname) > MaxPrimaryHostnameLen {
+		return fmt.Errorf("hostname exceeds %d bytes", MaxPrimaryHostnameLen)
+	} else if info.AdvertiseURL == "" {
+		return fmt.Errorf("advertise url required")
+	} else if len(info.AdvertiseURL) > MaxPrimaryAdvertiseURLLen {
+		return fmt.Errorf("advertise url exceeds %d bytes", MaxPrimaryAdvertiseURLLen)
+	}
+
+	u, err := url.Parse(info.AdvertiseURL)
+	if err != nil {
+		return fmt.Errorf("invalid advertise url %q", info.AdvertiseURL)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("advertise url %q must use http or https", info.AdvertiseURL)
+	} else if u.Host == "" {
+		return fmt.Errorf("advertise url %q has no host", info.AdvertiseURL)
+	}
+	return nil
+}
+
 // StaticLeaser always returns a lease to a static primary.
 type StaticLeaser struct {
+	mu           sync.Mutex
 	isPrimary    bool
 	hostname     string
 	advertiseURL string
+	conflict     *StaticConflict
+	demoted      bool
+	demoteCh     chan struct{} // closed on demotion
 }
 
 // NewStaticLeaser returns a new instance of StaticLeaser.
@@ -61,6 +111,7 @@ func NewStaticLeaser(isPrimary bool, hostname, advertiseURL string) *StaticLease
 		isPrimary:    isPrimary,
 		hostname:     hostname,
 		advertiseURL: advertiseURL,
+		demoteCh:     make(chan struct{}),
 	}
 }
 
@@ -70,25 +121,34 @@ func (l *StaticLeaser) Close() (err error) { return nil }
 // AdvertiseURL returns the primary URL if this is the primary.
 // Otherwise returns blank.
 func (l *StaticLeaser) AdvertiseURL() string {
-	if l.isPrimary {
+	if l.IsPrimary() {
 		return l.advertiseURL
 	}
 	return ""
 }
 
 // Acquire returns a lease if this node is the static primary.
-// Otherwise returns ErrPrimaryExists.
+// Otherwise returns ErrPrimaryExists, or ErrStaticPrimaryConflict if this
+// node was demoted.
 func (l *StaticLeaser) Acquire(ctx context.Context) (Lease, error) {
-	if !l.isPrimary {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.demoted {
+		return nil, ErrStaticPrimaryConflict
+	} else if !l.isPrimary {
 		return nil, ErrPrimaryExists
 	}
 	return &StaticLease{leaser: l}, nil
 }
 
 // PrimaryInfo returns the primary's info.
-// Returns ErrNoPrimary if the node is the primary.
+// Returns ErrNoPrimary if the node is the primary or was demoted.
 func (l *StaticLeaser) PrimaryInfo(ctx context.Context) (PrimaryInfo, error) {
-	if l.isPrimary {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.isPrimary || l.demoted {
 		return PrimaryInfo{}, ErrNoPrimary
 	}
 	return PrimaryInfo{
@@ -99,10 +159,63 @@ func (l *StaticLeaser) PrimaryInfo(ctx context.Context) (PrimaryInfo, error) {
 
 // IsPrimary returns true if the current node is the primary.
 func (l *StaticLeaser) IsPrimary() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	return l.isPrimary
 }
 
+// StaticConflict describes another node found claiming to be the primary
+// while this node is the static primary.
+type StaticConflict struct {
+	PeerURL    string
+	PeerID     string
+	DetectedAt time.Time
+	Demoted    bool
+}
+
+// Conflict returns the current primary conflict, if any.
+func (l *StaticLeaser) Conflict() *StaticConflict {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conflict == nil {
+		return nil
+	}
+	other := *l.conflict
+	return &other
+}
+
+// SetConflict records a conflict with another primary. A nil conflict clears
+// it unless this node was demoted, which lasts until restart.
+func (l *StaticLeaser) SetConflict(conflict *StaticConflict) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if conflict == nil && l.demoted {
+		return
+	}
+	l.conflict = conflict
+}
+
+// Demote stops this node from acting as the primary. The current lease is
+// revoked and no lease is acquired again. The node does not replicate from
+// the conflicting primary so its own transactions are kept for recovery.
+func (l *StaticLeaser) Demote() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.demoted {
+		return
+	}
+	l.isPrimary, l.demoted = false, true
+	if l.conflict != nil {
+		l.conflict.Demoted = true
+	}
+	close(l.demoteCh)
+}
+
 var _ Lease = (*StaticLease)(nil)
+var _ LeaseRevoker = (*StaticLease)(nil)
 
 // StaticLease represents a lease for a fixed primary.
 type StaticLease struct {
@@ -115,8 +228,18 @@ func (l *StaticLease) RenewedAt() time.Time { return time.Unix(0, 0).UTC() }
 // TTL returns the duration until the lease expires which is a time well into the future.
 func (l *StaticLease) TTL() time.Duration { return staticLeaseExpiresAt.Sub(l.RenewedAt()) }
 
-// Renew is a no-op.
-func (l *StaticLease) Renew(ctx context.Context) error { return nil }
+// Renew returns ErrLeaseExpired if the leaser was demoted. Otherwise a no-op.
+func (l *StaticLease) Renew(ctx context.Context) error {
+	select {
+	case <-l.leaser.demoteCh:
+		return ErrLeaseExpired
+	default:
+		return nil
+	}
+}
+
+// Revoked returns a channel that is closed when the leaser is demoted.
+func (l *StaticLease) Revoked() <-chan struct{} { return l.leaser.demoteCh }
 
 func (l *StaticLease) Close() error { return nil }
 
@@ -2,7 +2,9 @@ package litefs_test
 
 import (
 	"context"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/superfly/litefs"
 )
@@ -52,6 +54,36 @@ func TestStaticLeaser(t *testing.T) {
 			t.Fatal("expected no lease")
 		}
 	})
+	t.Run("Demote", func(t *testing.T) {
+		l := newPrimaryStaticLeaser()
+		store := newOpenStore(t, l, nil)
+		if !store.IsPrimary() {
+			t.Fatal("expected primary")
+		}
+
+		l.SetConflict(&litefs.StaticConflict{PeerURL: "http://other:20202"})
+		l.Demote()
+
+		// Store steps down once the lease is revoked.
+		for i := 0; store.IsPrimary(); i++ {
+			if i > 100 {
+				t.Fatal("expected store to step down")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if _, err := l.Acquire(context.Background()); err != litefs.ErrStaticPrimaryConflict {
+			t.Fatalf("unexpected error: %v", err)
+		} else if _, err := l.PrimaryInfo(context.Background()); err != litefs.ErrNoPrimary {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// Conflict remains after demotion.
+		l.SetConflict(nil)
+		if c := l.Conflict(); c == nil || !c.Demoted {
+			t.Fatalf("unexpected conflict: %#v", c)
+		}
+	})
 }
 
 func TestStaticLease(t *testing.T) {
@@ -80,3 +112,26 @@ func TestStaticLease(t *testing.T) {
 func newPrimaryStaticLeaser() *litefs.StaticLeaser {
 	return litefs.NewStaticLeaser(true, "localhost", "http://localhost:20202")
 }
+
+func TestPrimaryInfo_Validate(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		info litefs.PrimaryInfo
+		err  string
+	}{
+		{"OK", litefs.PrimaryInfo{Hostname: "node1", AdvertiseURL: "http://node1:20202"}, ""},
+		{"NoHostname", litefs.PrimaryInfo{AdvertiseURL: "http://node1:20202"}, "hostname required"},
+		{"NoAdvertiseURL", litefs.PrimaryInfo{Hostname: "node1"}, "advertise url required"},
+		{"LongAdvertiseURL", litefs.PrimaryInfo{Hostname: "node1", AdvertiseURL: "http://" + strings.Repeat("x", litefs.MaxPrimaryAdvertiseURLLen)}, "advertise url exceeds 2048 bytes"},
+		{"InvalidScheme", litefs.PrimaryInfo{Hostname: "node1", AdvertiseURL: "ftp://node1"}, `advertise url "ftp://node1" must use http or https`},
+		{"NoHost", litefs.PrimaryInfo{Hostname: "node1", AdvertiseURL: "http://"}, `advertise url "http://" has no host`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.info.Validate(); tt.err == "" && err != nil {
+				t.Fatal(err)
+			} else if tt.err != "" && (err == nil || err.Error() != tt.err) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
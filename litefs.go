@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"time"
 )
 
 // LiteFS errors
@@ -13,11 +15,34 @@ var (
 	ErrDatabaseNotFound = fmt.Errorf("database not found")
 	ErrDatabaseExists   = fmt.Errorf("database already exists")
 
+	ErrDatabaseNameCollision = errors.New("database name differs only by case from an existing database")
+	ErrDatabaseNotManaged    = errors.New("database not managed by litefs")
+	ErrNonDBFileRejected     = errors.New("non-database file rejected")
+
 	ErrNoPrimary     = errors.New("no primary")
 	ErrPrimaryExists = errors.New("primary exists")
 	ErrLeaseExpired  = errors.New("lease expired")
+	ErrNotPrimary    = errors.New("not primary")
+
+	ErrInvalidPrimaryInfo = errors.New("invalid primary info")
+
+	ErrStaticPrimaryConflict = errors.New("demoted after conflict with another static primary")
 
 	ErrReadOnlyReplica = fmt.Errorf("read only replica")
+	ErrPromoting       = errors.New("promotion to primary in progress")
+	ErrQuorumTimeout   = errors.New("replication quorum timeout, transaction committed locally")
+
+	ErrUnsupportedJournalMode = errors.New("unsupported journal mode")
+
+	ErrNoSpace        = errors.New("no space left on device, writes paused")
+	ErrWritesDisabled = errors.New("writes disabled, store is shutting down")
+
+	ErrClusterMismatch = errors.New("cluster id mismatch")
+
+	ErrTXNotApplied   = errors.New("transaction not yet applied")
+	ErrTXNotAvailable = errors.New("transaction not available")
+
+	ErrLTXChainIncomplete = errors.New("ltx chain incomplete")
 )
 
 // SQLite constants
@@ -35,6 +60,13 @@ const (
 	SHARED_SIZE   = 510
 )
 
+// LockPgno returns the page number of the lock page for a given page size.
+// The lock page holds the byte range used for file locks so SQLite never
+// writes to it & its contents are undefined.
+func LockPgno(pageSize uint32) uint32 {
+	return uint32(PENDING_BYTE/int64(pageSize)) + 1
+}
+
 // SQLite WAL lock constants.
 const (
 	WAL_WRITE_LOCK   = 120
@@ -62,8 +94,112 @@ const (
 	JournalModeTruncate = "TRUNCATE"
 	JournalModePersist  = "PERSIST"
 	JournalModeWAL      = "WAL"
+	JournalModeOff      = "OFF" // also reported for MEMORY as they cannot be distinguished
+)
+
+// AckMode represents how the primary waits for replicas to acknowledge transactions.
+type AckMode string
+
+const (
+	AckModeAsync  = AckMode("async")
+	AckModeQuorum = AckMode("quorum")
+)
+
+// SyncMode represents how aggressively LTX files are fsync'd on commit.
+type SyncMode string
+
+const (
+	// SyncModeFull fsyncs each LTX file & its parent directory per commit.
+	SyncModeFull = SyncMode("full")
+
+	// SyncModeNormal fsyncs each LTX file per commit but batches directory
+	// syncs. A crash may lose the most recent LTX file names.
+	SyncModeNormal = SyncMode("normal")
+
+	// SyncModeOff relies on the OS to flush LTX files.
+	SyncModeOff = SyncMode("off")
+)
+
+// LeaseLossMode represents how a transaction in progress is handled when the
+// primary loses its lease before the transaction commits.
+type LeaseLossMode string
+
+const (
+	// LeaseLossAbort returns an error to the application on the next write or
+	// commit of the transaction.
+	LeaseLossAbort = LeaseLossMode("abort")
+
+	// LeaseLossComplete allows the transaction to commit locally. It is not
+	// replicated and is discarded once the node syncs with the new primary.
+	LeaseLossComplete = LeaseLossMode("complete")
+)
+
+// ReadConsistency represents the guarantee given to reads on a replica.
+type ReadConsistency string
+
+const (
+	// ReadConsistencyLocal reads whatever the replica has applied.
+	ReadConsistencyLocal = ReadConsistency("local")
+
+	// ReadConsistencyLinearizable waits at the start of each read transaction
+	// until the replica has applied the primary's current transaction.
+	ReadConsistencyLinearizable = ReadConsistency("linearizable")
+)
+
+// CaseSensitivity represents how database names that differ only by case are
+// handled. These collide on case-insensitive file systems, such as a data
+// directory copied from Linux to macOS.
+type CaseSensitivity string
+
+const (
+	// CaseSensitivityStrict keeps database names distinct but rejects a name
+	// colliding with an existing database if the data directory is
+	// case-insensitive.
+	CaseSensitivityStrict = CaseSensitivity("strict")
+
+	// CaseSensitivityFold treats database names case-insensitively on every
+	// file system. A name resolves to an existing database of any case.
+	CaseSensitivityFold = CaseSensitivity("fold")
+)
+
+// ClusterMismatchMode represents how a node handles a data directory stamped
+// with a different cluster ID than the one expected.
+type ClusterMismatchMode string
+
+const (
+	// ClusterMismatchFail refuses to open the store or to replicate from the
+	// primary until the data directory is fixed manually.
+	ClusterMismatchFail = ClusterMismatchMode("fail")
+
+	// ClusterMismatchReset removes all local databases & restamps the data
+	// directory so the databases are resynced from the primary.
+	ClusterMismatchReset = ClusterMismatchMode("reset")
+)
+
+// LocalWriteMode represents how a replica handles databases created locally
+// instead of being replicated from the primary.
+type LocalWriteMode string
+
+const (
+	// LocalWriteReject returns a read-only error when creating a database.
+	LocalWriteReject = LocalWriteMode("reject")
+
+	// LocalWriteShadow allows the database to be created & written but marks
+	// it as local-only so it is never advertised to other nodes.
+	LocalWriteShadow = LocalWriteMode("shadow")
+
+	// LocalWriteAllow creates the database file but rejects writes to it.
+	LocalWriteAllow = LocalWriteMode("allow")
 )
 
+// ChecksumAlgorithm represents the algorithm used for the LTX checksum chain.
+// All nodes in a cluster must use the same algorithm.
+type ChecksumAlgorithm string
+
+// ChecksumAlgorithmCRC64 is the CRC-64 (ISO) checksum defined by the LTX
+// format. It is currently the only supported algorithm.
+const ChecksumAlgorithmCRC64 = ChecksumAlgorithm("crc64")
+
 // FileType represents a type of SQLite file.
 type FileType int
 
@@ -109,12 +245,45 @@ type Client interface {
 	Stream(ctx context.Context, rawurl string, id string, posMap map[string]Pos) (io.ReadCloser, error)
 }
 
+// StreamAcker is implemented by streams which can report the position of
+// applied transactions back to the primary.
+type StreamAcker interface {
+	Ack(name string, pos Pos) error
+}
+
+// ProtocolVersioner is implemented by streams which negotiated a replication
+// protocol version with the primary.
+type ProtocolVersioner interface {
+	ProtocolVersion() int
+}
+
+// PosFetcher is implemented by clients which can fetch the current position
+// of a database from another node.
+type PosFetcher interface {
+	FetchPos(ctx context.Context, rawurl string, name string) (Pos, error)
+}
+
+// RetryAfterError is returned when the primary rejects a replica stream and
+// asks the replica to wait before reconnecting.
+type RetryAfterError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryAfterError) Error() string { return e.Err.Error() }
+func (e *RetryAfterError) Unwrap() error { return e.Err }
+
 type StreamFrameType uint32
 
 const (
-	StreamFrameTypeLTX   = StreamFrameType(1)
-	StreamFrameTypeReady = StreamFrameType(2)
-	StreamFrameTypeEnd   = StreamFrameType(3)
+	StreamFrameTypeLTX    = StreamFrameType(1)
+	StreamFrameTypeReady  = StreamFrameType(2)
+	StreamFrameTypeEnd    = StreamFrameType(3)
+	StreamFrameTypeConfig = StreamFrameType(4)
+	StreamFrameTypeDropDB = StreamFrameType(5)
+
+	StreamFrameTypeClusterID    = StreamFrameType(6)
+	StreamFrameTypeSpecialFiles = StreamFrameType(7)
 )
 
 type StreamFrame interface {
@@ -138,6 +307,14 @@ func ReadStreamFrame(r io.Reader) (StreamFrame, error) {
 		f = &ReadyStreamFrame{}
 	case StreamFrameTypeEnd:
 		f = &EndStreamFrame{}
+	case StreamFrameTypeConfig:
+		f = &ConfigStreamFrame{}
+	case StreamFrameTypeDropDB:
+		f = &DropDBStreamFrame{}
+	case StreamFrameTypeClusterID:
+		f = &ClusterIDStreamFrame{}
+	case StreamFrameTypeSpecialFiles:
+		f = &SpecialFilesStreamFrame{}
 	default:
 		return nil, fmt.Errorf("invalid stream frame type: 0x%02x", typ)
 	}
@@ -194,6 +371,152 @@ func (f *LTXStreamFrame) WriteTo(w io.Writer) (int64, error) {
 	return 0, nil
 }
 
+// DropDBStreamFrame notifies a replica that a database no longer exists on
+// the primary so the replica removes its local copy.
+type DropDBStreamFrame struct {
+	Name string // database name
+}
+
+// Type returns the type of stream frame.
+func (*DropDBStreamFrame) Type() StreamFrameType { return StreamFrameTypeDropDB }
+
+func (f *DropDBStreamFrame) ReadFrom(r io.Reader) (int64, error) {
+	name, err := readStreamString(r)
+	if err != nil {
+		return 0, err
+	}
+	f.Name = name
+
+	return 0, nil
+}
+
+func (f *DropDBStreamFrame) WriteTo(w io.Writer) (int64, error) {
+	if err := writeStreamString(w, f.Name); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// ClusterIDStreamFrame identifies the cluster of the primary so a replica can
+// verify its data directory belongs to the same cluster before applying data.
+type ClusterIDStreamFrame struct {
+	ClusterID string
+}
+
+// Type returns the type of stream frame.
+func (*ClusterIDStreamFrame) Type() StreamFrameType { return StreamFrameTypeClusterID }
+
+func (f *ClusterIDStreamFrame) ReadFrom(r io.Reader) (int64, error) {
+	id, err := readStreamString(r)
+	if err != nil {
+		return 0, err
+	}
+	f.ClusterID = id
+
+	return 0, nil
+}
+
+func (f *ClusterIDStreamFrame) WriteTo(w io.Writer) (int64, error) {
+	if err := writeStreamString(w, f.ClusterID); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// SpecialFilesStreamFrame holds the full set of special files on the primary.
+// It is sent whenever the set changes so replicas can mirror it exactly.
+type SpecialFilesStreamFrame struct {
+	Files []SpecialFile
+}
+
+// Type returns the type of stream frame.
+func (*SpecialFilesStreamFrame) Type() StreamFrameType { return StreamFrameTypeSpecialFiles }
+
+func (f *SpecialFilesStreamFrame) ReadFrom(r io.Reader) (int64, error) {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	}
+
+	f.Files = nil
+	for i := uint32(0); i < n; i++ {
+		var file SpecialFile
+		name, err := readStreamString(r)
+		if err != nil {
+			return 0, err
+		}
+		file.Name = name
+
+		var mode uint32
+		if err := binary.Read(r, binary.BigEndian, &mode); err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return 0, err
+		}
+		file.Mode = os.FileMode(mode)
+
+		if file.Target, err = readStreamString(r); err != nil {
+			return 0, err
+		}
+		f.Files = append(f.Files, file)
+	}
+
+	return 0, nil
+}
+
+func (f *SpecialFilesStreamFrame) WriteTo(w io.Writer) (int64, error) {
+	if err := binary.Write(w, binary.BigEndian, uint32(len(f.Files))); err != nil {
+		return 0, err
+	}
+	for _, file := range f.Files {
+		if err := writeStreamString(w, file.Name); err != nil {
+			return 0, err
+		} else if err := binary.Write(w, binary.BigEndian, uint32(file.Mode)); err != nil {
+			return 0, err
+		} else if err := writeStreamString(w, file.Target); err != nil {
+			return 0, err
+		}
+	}
+	return 0, nil
+}
+
+// MaxStreamStringLen is the maximum length of a string in a stream frame,
+// such as a special file name or symlink target. Matches PATH_MAX on Linux.
+const MaxStreamStringLen = 4096
+
+// readStreamString reads a length-prefixed string. Returns an error if the
+// length exceeds MaxStreamStringLen so a corrupt frame cannot force a large
+// allocation.
+func readStreamString(r io.Reader) (string, error) {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err == io.EOF {
+		return "", io.ErrUnexpectedEOF
+	} else if err != nil {
+		return "", err
+	} else if n > MaxStreamStringLen {
+		return "", fmt.Errorf("stream string too long: %d bytes", n)
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err == io.EOF {
+		return "", io.ErrUnexpectedEOF
+	} else if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// writeStreamString writes a length-prefixed string.
+func writeStreamString(w io.Writer, s string) error {
+	if err := binary.Write(w, binary.BigEndian, uint32(len(s))); err != nil {
+		return err
+	}
+	_, err := w.Write([]byte(s))
+	return err
+}
+
 type ReadyStreamFrame struct{}
 
 func (f *ReadyStreamFrame) Type() StreamFrameType               { return StreamFrameTypeReady }
@@ -206,11 +529,52 @@ func (f *EndStreamFrame) Type() StreamFrameType               { return StreamFra
 func (f *EndStreamFrame) ReadFrom(r io.Reader) (int64, error) { return 0, nil }
 func (f *EndStreamFrame) WriteTo(w io.Writer) (int64, error)  { return 0, nil }
 
+// ConfigStreamFrame distributes the primary's cluster-wide settings to replicas.
+type ConfigStreamFrame struct {
+	RetentionDuration time.Duration
+}
+
+// Type returns the type of stream frame.
+func (*ConfigStreamFrame) Type() StreamFrameType { return StreamFrameTypeConfig }
+
+func (f *ConfigStreamFrame) ReadFrom(r io.Reader) (int64, error) {
+	var retention int64
+	if err := binary.Read(r, binary.BigEndian, &retention); err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	}
+	f.RetentionDuration = time.Duration(retention)
+
+	return 0, nil
+}
+
+func (f *ConfigStreamFrame) WriteTo(w io.Writer) (int64, error) {
+	if err := binary.Write(w, binary.BigEndian, int64(f.RetentionDuration)); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// FileSystem represents a backend that presents the store's databases to the
+// application. The FUSE file system is the default implementation. Backends
+// may also implement Invalidator to be notified of changes to databases.
+type FileSystem interface {
+	Mount() error
+	Unmount() error
+	Path() string
+}
+
 // Invalidator is a callback for the store to use to invalidate the kernel page cache.
 type Invalidator interface {
 	InvalidateDB(db *DB, offset, size int64) error
 	InvalidateSHM(db *DB) error
+	InvalidateWAL(db *DB) error
 	InvalidatePos(db *DB) error
+
+	// InvalidateEntry removes cached entries for all files of the named
+	// database. Called after a database is dropped or renamed.
+	InvalidateEntry(name string) error
 }
 
 func assert(condition bool, msg string) {
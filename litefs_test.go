@@ -9,6 +9,7 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/superfly/litefs"
 )
@@ -66,6 +67,81 @@ func TestReadWriteStreamFrame(t *testing.T) {
 			t.Fatalf("got %#v, want %#v", frame, other)
 		}
 	})
+	t.Run("DropDBStreamFrame", func(t *testing.T) {
+		frame := &litefs.DropDBStreamFrame{Name: "test.db"}
+
+		var buf bytes.Buffer
+		if err := litefs.WriteStreamFrame(&buf, frame); err != nil {
+			t.Fatal(err)
+		}
+		if other, err := litefs.ReadStreamFrame(&buf); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(frame, other) {
+			t.Fatalf("got %#v, want %#v", frame, other)
+		}
+	})
+	t.Run("ErrDropDBStreamFrameNameTooLong", func(t *testing.T) {
+		buf := []byte{0, 0, 0, 5, 0xFF, 0xFF, 0xFF, 0xFF}
+		if _, err := litefs.ReadStreamFrame(bytes.NewReader(buf)); err == nil || err.Error() != `stream string too long: 4294967295 bytes` {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+	})
+	t.Run("ClusterIDStreamFrame", func(t *testing.T) {
+		frame := &litefs.ClusterIDStreamFrame{ClusterID: "0123456789ABCDEF"}
+
+		var buf bytes.Buffer
+		if err := litefs.WriteStreamFrame(&buf, frame); err != nil {
+			t.Fatal(err)
+		}
+		if other, err := litefs.ReadStreamFrame(&buf); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(frame, other) {
+			t.Fatalf("got %#v, want %#v", frame, other)
+		}
+	})
+	t.Run("ErrClusterIDStreamFrameTooLong", func(t *testing.T) {
+		buf := []byte{0, 0, 0, 6, 0xFF, 0xFF, 0xFF, 0xFF}
+		if _, err := litefs.ReadStreamFrame(bytes.NewReader(buf)); err == nil || err.Error() != `stream string too long: 4294967295 bytes` {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+	})
+	t.Run("ConfigStreamFrame", func(t *testing.T) {
+		frame := &litefs.ConfigStreamFrame{RetentionDuration: 10 * time.Minute}
+
+		var buf bytes.Buffer
+		if err := litefs.WriteStreamFrame(&buf, frame); err != nil {
+			t.Fatal(err)
+		} else if got, want := buf.Len(), 4+8; got != want {
+			t.Fatalf("len=%d, want %d", got, want) // frame type & retention only
+		}
+		if other, err := litefs.ReadStreamFrame(&buf); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(frame, other) {
+			t.Fatalf("got %#v, want %#v", frame, other)
+		}
+	})
+	t.Run("SpecialFilesStreamFrame", func(t *testing.T) {
+		frame := &litefs.SpecialFilesStreamFrame{Files: []litefs.SpecialFile{
+			{Name: "app.sock", Mode: os.ModeSocket | 0755},
+			{Name: "current.db", Mode: os.ModeSymlink | 0777, Target: "v2.db"},
+		}}
+
+		var buf bytes.Buffer
+		if err := litefs.WriteStreamFrame(&buf, frame); err != nil {
+			t.Fatal(err)
+		}
+		if other, err := litefs.ReadStreamFrame(&buf); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(frame, other) {
+			t.Fatalf("got %#v, want %#v", frame, other)
+		}
+	})
+	t.Run("ErrSpecialFilesStreamFrameStringTooLong", func(t *testing.T) {
+		buf := []byte{0, 0, 0, 7, 0, 0, 0, 1, 0xFF, 0xFF, 0xFF, 0xFF}
+		if _, err := litefs.ReadStreamFrame(bytes.NewReader(buf)); err == nil || err.Error() != `stream string too long: 4294967295 bytes` {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+	})
 	t.Run("ReadyStreamFrame", func(t *testing.T) {
 		frame := &litefs.ReadyStreamFrame{}
 
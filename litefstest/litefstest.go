@@ -0,0 +1,258 @@
+// Package litefstest provides utilities for embedding a LiteFS store in tests.
+// It should not be used in production binaries.
+package litefstest
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/superfly/litefs"
+)
+
+// DefaultTTL is the default lease TTL for test leasers. It is short so that
+// handoffs propagate quickly.
+const DefaultTTL = 100 * time.Millisecond
+
+// Cluster coordinates a single in-memory lease between a set of leasers.
+type Cluster struct {
+	mu      sync.Mutex
+	primary *Leaser // current lease holder, if any
+	next    *Leaser // if set, only this leaser can acquire the lease
+}
+
+// NewCluster returns a new instance of Cluster.
+func NewCluster() *Cluster {
+	return &Cluster{}
+}
+
+// NewLeaser returns a new leaser attached to the cluster.
+func (c *Cluster) NewLeaser(hostname, advertiseURL string) *Leaser {
+	return &Leaser{
+		cluster:      c,
+		hostname:     hostname,
+		advertiseURL: advertiseURL,
+		TTL:          DefaultTTL,
+	}
+}
+
+// Primary returns the leaser currently holding the lease, if any.
+func (c *Cluster) Primary() *Leaser {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.primary
+}
+
+// Handoff revokes the current lease and reserves it for l. The current
+// primary loses its lease on its next renewal and l acquires it next.
+func (c *Cluster) Handoff(l *Leaser) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.primary, c.next = nil, l
+}
+
+var _ litefs.Leaser = (*Leaser)(nil)
+
+// Leaser is an in-memory implementation of litefs.Leaser.
+type Leaser struct {
+	cluster      *Cluster
+	hostname     string
+	advertiseURL string
+
+	// Time-to-live of acquired leases.
+	TTL time.Duration
+}
+
+// NewLeaser returns a leaser on its own cluster so it always becomes primary.
+func NewLeaser() *Leaser {
+	return NewCluster().NewLeaser("localhost", "http://localhost:20202")
+}
+
+// Close is a no-op.
+func (l *Leaser) Close() error { return nil }
+
+// AdvertiseURL returns the URL that other nodes use to connect to this node.
+func (l *Leaser) AdvertiseURL() string { return l.advertiseURL }
+
+// Acquire returns a lease if no other leaser holds it and it is not reserved
+// for another leaser by a handoff. Otherwise returns ErrPrimaryExists.
+func (l *Leaser) Acquire(ctx context.Context) (litefs.Lease, error) {
+	c := l.cluster
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.primary != nil && c.primary != l {
+		return nil, litefs.ErrPrimaryExists
+	} else if c.next != nil && c.next != l {
+		return nil, litefs.ErrPrimaryExists
+	}
+
+	c.primary, c.next = l, nil
+	return &Lease{leaser: l, renewedAt: time.Now()}, nil
+}
+
+// PrimaryInfo returns info about the leaser holding the lease. Returns
+// ErrNoPrimary if there is no primary or if l is the primary.
+func (l *Leaser) PrimaryInfo(ctx context.Context) (litefs.PrimaryInfo, error) {
+	c := l.cluster
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.primary == nil || c.primary == l {
+		return litefs.PrimaryInfo{}, litefs.ErrNoPrimary
+	}
+	return litefs.PrimaryInfo{
+		Hostname:     c.primary.hostname,
+		AdvertiseURL: c.primary.advertiseURL,
+	}, nil
+}
+
+var _ litefs.Lease = (*Lease)(nil)
+
+// Lease represents a lease acquired from a Leaser.
+type Lease struct {
+	mu        sync.Mutex
+	leaser    *Leaser
+	renewedAt time.Time
+}
+
+// RenewedAt returns the time of the last successful renewal.
+func (l *Lease) RenewedAt() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.renewedAt
+}
+
+// TTL returns the time-to-live of the lease.
+func (l *Lease) TTL() time.Duration { return l.leaser.TTL }
+
+// Renew resets the lease. Returns ErrLeaseExpired if the lease was revoked.
+func (l *Lease) Renew(ctx context.Context) error {
+	c := l.leaser.cluster
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.primary != l.leaser {
+		return litefs.ErrLeaseExpired
+	}
+
+	l.mu.Lock()
+	l.renewedAt = time.Now()
+	l.mu.Unlock()
+	return nil
+}
+
+// Close releases the lease, if it is still held.
+func (l *Lease) Close() error {
+	c := l.leaser.cluster
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.primary == l.leaser {
+		c.primary = nil
+	}
+	return nil
+}
+
+// NewStore returns an opened store in a temporary data directory. It waits
+// until the store is ready and closes the store when the test ends.
+func NewStore(tb testing.TB, leaser litefs.Leaser, client litefs.Client) *litefs.Store {
+	tb.Helper()
+
+	store := litefs.NewStore(tb.TempDir(), true)
+	store.Leaser = leaser
+	store.Client = client
+	if err := store.Open(); err != nil {
+		tb.Fatalf("cannot open store: %s", err)
+	}
+	tb.Cleanup(func() {
+		if err := store.Close(); err != nil {
+			tb.Errorf("cannot close store: %s", err)
+		}
+	})
+
+	select {
+	case <-time.After(5 * time.Second):
+		tb.Fatal("timeout waiting for store ready")
+	case <-store.ReadyCh():
+	}
+	return store
+}
+
+// NewDatabase returns the contents of a rollback journal mode database with
+// pageN pages of pageSize bytes. Pages are filled with b after the header so
+// that databases written with different values have different checksums.
+func NewDatabase(pageSize, pageN int, b byte) []byte {
+	data := bytes.Repeat([]byte{b}, pageSize*pageN)
+	copy(data, litefs.SQLITE_DATABASE_HEADER_STRING)
+	if pageSize == 65536 {
+		binary.BigEndian.PutUint16(data[16:], 1)
+	} else {
+		binary.BigEndian.PutUint16(data[16:], uint16(pageSize))
+	}
+	data[18], data[19] = 1, 1 // legacy read & write versions
+	binary.BigEndian.PutUint32(data[litefs.SQLITE_DATABASE_SIZE_OFFSET:], uint32(pageN))
+	return data
+}
+
+// WriteTx commits a transaction to db which replaces its contents with data,
+// as returned by NewDatabase. The database cannot shrink. Existing pages are
+// copied to the rollback journal first, as SQLite does, so that the database
+// checksum remains valid across transactions.
+func WriteTx(tb testing.TB, db *litefs.DB, data []byte) {
+	tb.Helper()
+
+	pageSize := int(binary.BigEndian.Uint16(data[16:]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+
+	prev, err := os.ReadFile(db.DatabasePath())
+	if err != nil {
+		tb.Fatal(err)
+	} else if len(prev) > len(data) {
+		tb.Fatalf("cannot shrink database from %d to %d bytes", len(prev), len(data))
+	}
+
+	// Write a single journal segment holding every existing page.
+	const sectorSize = 512
+	journal := make([]byte, sectorSize)
+	copy(journal, litefs.SQLITE_JOURNAL_HEADER_STRING)
+	binary.BigEndian.PutUint32(journal[8:], uint32(len(prev)/pageSize))
+	binary.BigEndian.PutUint32(journal[16:], uint32(len(prev)/pageSize))
+	binary.BigEndian.PutUint32(journal[20:], sectorSize)
+	binary.BigEndian.PutUint32(journal[24:], uint32(pageSize))
+	for i := 0; i < len(prev)/pageSize; i++ {
+		journal = binary.BigEndian.AppendUint32(journal, uint32(i+1))
+		journal = append(journal, prev[i*pageSize:(i+1)*pageSize]...)
+		journal = binary.BigEndian.AppendUint32(journal, 0) // checksum, unverified
+	}
+
+	jf, err := db.CreateJournal()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer func() { _ = jf.Close() }()
+	if err := db.WriteJournal(jf, journal, 0); err != nil {
+		tb.Fatal(err)
+	}
+
+	f, err := os.OpenFile(db.DatabasePath(), os.O_RDWR, 0666)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	for offset := 0; offset < len(data); offset += pageSize {
+		if err := db.WriteDatabase(f, data[offset:offset+pageSize], int64(offset)); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	if err := db.CommitJournal(litefs.JournalModeDelete); err != nil {
+		tb.Fatal(err)
+	}
+}
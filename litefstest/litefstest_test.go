@@ -0,0 +1,75 @@
+package litefstest_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/superfly/litefs"
+	"github.com/superfly/litefs/litefstest"
+)
+
+func TestNewStore(t *testing.T) {
+	store := litefstest.NewStore(t, litefstest.NewLeaser(), nil)
+	if !store.IsPrimary() {
+		t.Fatal("expected primary")
+	}
+}
+
+func TestCluster_Handoff(t *testing.T) {
+	c := litefstest.NewCluster()
+	l0 := c.NewLeaser("node0", "http://node0:20202")
+	l1 := c.NewLeaser("node1", "http://node1:20202")
+
+	lease, err := l0.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := l1.Acquire(context.Background()); err != litefs.ErrPrimaryExists {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if info, err := l1.PrimaryInfo(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if got, want := info.Hostname, "node0"; got != want {
+		t.Fatalf("Hostname=%s, want %s", got, want)
+	}
+
+	// Hand off to the second leaser so the first lease can no longer renew.
+	c.Handoff(l1)
+	if err := lease.Renew(context.Background()); err != litefs.ErrLeaseExpired {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := l0.Acquire(context.Background()); err != litefs.ErrPrimaryExists {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := l1.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if got, want := c.Primary(), l1; got != want {
+		t.Fatal("expected second leaser to be primary")
+	}
+}
+
+func TestWriteTx(t *testing.T) {
+	store := litefstest.NewStore(t, litefstest.NewLeaser(), nil)
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 2, 1))
+	litefstest.WriteTx(t, db, litefstest.NewDatabase(4096, 3, 2))
+	if got, want := db.TXID(), uint64(2); got != want {
+		t.Fatalf("TXID=%d, want %d", got, want)
+	}
+
+	// The checksum tracked across transactions should match the pages on disk.
+	pos, chksums, err := db.PageChecksums(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if got, want := len(chksums), 3; got != want {
+		t.Fatalf("len=%d, want %d", got, want)
+	} else if got, want := pos.PostApplyChecksum, db.Pos().PostApplyChecksum; got != want {
+		t.Fatalf("checksum=%016x, want %016x", got, want)
+	}
+}
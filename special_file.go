@@ -0,0 +1,236 @@
+package litefs
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// NonDBFileMode determines how files that are not databases, such as symlinks,
+// sockets & FIFOs created alongside a database, are handled in the mount.
+type NonDBFileMode string
+
+const (
+	// NonDBFilesReject rejects the creation of special files.
+	NonDBFilesReject = NonDBFileMode("reject")
+
+	// NonDBFilesLocal stores special files on the local node only.
+	NonDBFilesLocal = NonDBFileMode("local")
+
+	// NonDBFilesReplicate mirrors the primary's special files on replicas.
+	// Replicas cannot create or remove special files.
+	NonDBFilesReplicate = NonDBFileMode("replicate")
+)
+
+// SpecialFile represents a symlink, socket or FIFO in the mount. Regular files
+// are always treated as databases so they are never special files.
+type SpecialFile struct {
+	Name   string
+	Mode   os.FileMode // file type & permission bits
+	Target string      // link target, if a symlink
+}
+
+// FilesDir returns the directory that special files are stored in.
+func (s *Store) FilesDir() string {
+	return filepath.Join(s.path, "files")
+}
+
+// SpecialFile returns the named special file. Returns nil if not found.
+func (s *Store) SpecialFile(name string) (*SpecialFile, error) {
+	return readSpecialFile(s.FilesDir(), name)
+}
+
+// SpecialFiles returns all special files, sorted by name.
+func (s *Store) SpecialFiles() ([]SpecialFile, error) {
+	return readSpecialFiles(s.FilesDir())
+}
+
+// CreateSpecialFile creates a symlink, socket or FIFO. Special files are never
+// part of a database's LTX files but are sent to replicas as a whole set in
+// NonDBFilesReplicate mode.
+func (s *Store) CreateSpecialFile(f SpecialFile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkSpecialFileWritable(); err != nil {
+		return err
+	} else if s.lookupDB(f.Name) != nil {
+		return ErrDatabaseExists
+	}
+
+	if err := os.MkdirAll(s.FilesDir(), 0777); err != nil {
+		return err
+	} else if err := createSpecialFile(s.FilesDir(), f); err != nil {
+		return err
+	}
+	s.markSpecialFilesDirty()
+	return nil
+}
+
+// RemoveSpecialFile removes the named special file.
+func (s *Store) RemoveSpecialFile(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkSpecialFileWritable(); err != nil {
+		return err
+	} else if err := os.Remove(filepath.Join(s.FilesDir(), name)); err != nil {
+		return err
+	}
+	s.markSpecialFilesDirty()
+	return nil
+}
+
+// checkSpecialFileWritable returns an error if special files cannot be
+// changed on this node. Must hold s.mu.
+func (s *Store) checkSpecialFileWritable() error {
+	switch s.NonDBFiles {
+	case NonDBFilesLocal:
+		return nil
+	case NonDBFilesReplicate:
+		if !s.isPrimary {
+			return ErrReadOnlyReplica
+		}
+		return nil
+	default:
+		return ErrNonDBFileRejected
+	}
+}
+
+// markSpecialFilesDirty notifies replica streams of a change. Must hold s.mu.
+func (s *Store) markSpecialFilesDirty() {
+	s.specialFilesGen++
+	for sub := range s.subscribers {
+		sub.notify()
+	}
+}
+
+// SpecialFilesStreamFrame returns a frame of all special files & a generation
+// that changes whenever the set of files does. Returns a nil frame unless the
+// store replicates special files.
+func (s *Store) SpecialFilesStreamFrame() (*SpecialFilesStreamFrame, uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.NonDBFiles != NonDBFilesReplicate {
+		return nil, 0, nil
+	}
+
+	files, err := s.SpecialFiles()
+	if err != nil {
+		return nil, 0, err
+	}
+	return &SpecialFilesStreamFrame{Files: files}, s.specialFilesGen, nil
+}
+
+// processSpecialFilesStreamFrame mirrors the primary's special files locally.
+// The frame is ignored unless this node also replicates special files.
+func (s *Store) processSpecialFilesStreamFrame(frame *SpecialFilesStreamFrame) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.NonDBFiles != NonDBFilesReplicate {
+		return nil
+	}
+
+	dir := s.FilesDir()
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+
+	existing, err := readSpecialFiles(dir)
+	if err != nil {
+		return err
+	}
+	m := make(map[string]SpecialFile, len(existing))
+	for _, f := range existing {
+		m[f.Name] = f
+	}
+
+	// Recreate files that were added or changed on the primary. Any files
+	// remaining in the map afterward were removed on the primary.
+	for _, f := range frame.Files {
+		other, ok := m[f.Name]
+		delete(m, f.Name)
+		if ok && other == f {
+			continue
+		} else if ok {
+			if err := os.Remove(filepath.Join(dir, f.Name)); err != nil {
+				return err
+			}
+		}
+		if err := createSpecialFile(dir, f); err != nil {
+			return fmt.Errorf("create special file %q: %w", f.Name, err)
+		}
+	}
+	for name := range m {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+
+	if s.Debug() {
+		log.Printf("special files updated from primary: n=%d", len(frame.Files))
+	}
+	return nil
+}
+
+func readSpecialFile(dir, name string) (*SpecialFile, error) {
+	path := filepath.Join(dir, name)
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	f := &SpecialFile{Name: name, Mode: fi.Mode()}
+	if fi.Mode().Type() == os.ModeSymlink {
+		if f.Target, err = os.Readlink(path); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func readSpecialFiles(dir string) ([]SpecialFile, error) {
+	ents, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	a := make([]SpecialFile, 0, len(ents))
+	for _, ent := range ents {
+		f, err := readSpecialFile(dir, ent.Name())
+		if err != nil {
+			return nil, err
+		} else if f != nil {
+			a = append(a, *f)
+		}
+	}
+	return a, nil
+}
+
+// createSpecialFile creates a symlink, socket or FIFO in dir. Device nodes &
+// other file types are rejected.
+func createSpecialFile(dir string, f SpecialFile) error {
+	if f.Name == "" || f.Name == "." || f.Name == ".." || f.Name != filepath.Base(f.Name) {
+		return fmt.Errorf("invalid special file name: %q", f.Name)
+	}
+
+	path := filepath.Join(dir, f.Name)
+	switch f.Mode.Type() {
+	case os.ModeSymlink:
+		return os.Symlink(f.Target, path)
+	case os.ModeNamedPipe:
+		return syscall.Mkfifo(path, uint32(f.Mode.Perm()))
+	case os.ModeSocket:
+		return syscall.Mknod(path, syscall.S_IFSOCK|uint32(f.Mode.Perm()), 0)
+	default:
+		return fmt.Errorf("%w: unsupported file type %s", ErrNonDBFileRejected, f.Mode.Type())
+	}
+}
@@ -5,18 +5,23 @@ import (
 	"context"
 	crand "crypto/rand"
 	"encoding/json"
+	"errors"
 	"expvar"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
-	"github.com/superfly/litefs/internal"
 	"github.com/superfly/ltx"
 	"golang.org/x/sync/errgroup"
 )
@@ -28,22 +33,89 @@ const IDLength = 24
 const (
 	DefaultRetentionDuration        = 1 * time.Minute
 	DefaultRetentionMonitorInterval = 1 * time.Minute
+
+	DefaultQuorumMinReplicas = 1
+	DefaultQuorumTimeout     = 5 * time.Second
+
+	DefaultMinFreeSpace             = 16 << 20 // 16MB
+	DefaultFreeSpaceMonitorInterval = 1 * time.Second
+
+	DefaultWriteRateInterval = 10 * time.Second
+
+	DefaultSyncInterval = 1 * time.Second
+
+	DefaultReadTimeout = 5 * time.Second
+
+	// CheckpointTimeout is the time a forced checkpoint waits for SQLite
+	// connections to release their WAL locks before retrying on a later commit.
+	CheckpointTimeout = 10 * time.Second
+
+	CatchupLogInterval = 5 * time.Second
+
+	LeaseMetricInterval = 1 * time.Second
+
+	// Candidates with a lower priority wait longer before acquiring the lease
+	// so that higher priority candidates are preferred during an election.
+	MaxCandidatePriority     = 100
+	CandidatePriorityDelay   = 50 * time.Millisecond
+	DefaultCandidatePriority = MaxCandidatePriority
 )
 
 // Store represents a collection of databases.
 type Store struct {
-	mu   sync.Mutex
-	path string
+	mu          sync.Mutex
+	retentionMu sync.Mutex // serializes retention passes
+	path        string
 
 	id          string // unique node id
+	clusterID   string // id stamped in the data directory, if any
 	dbs         map[string]*DB
 	subscribers map[*Subscriber]struct{}
 
-	isPrimary   bool          // if true, store is current primary
-	primaryCh   chan struct{} // closed when primary loses leadership
-	primaryInfo *PrimaryInfo  // contains info about the current primary
-	candidate   bool          // if true, we are eligible to become the primary
-	readyCh     chan struct{} // closed when primary found or acquired
+	eventMu          sync.Mutex
+	eventSubscribers map[*EventSubscriber]struct{}
+	eventHistory     []Event // recent events, oldest first
+
+	isPrimary      bool          // if true, store is current primary
+	primaryCh      chan struct{} // closed when primary loses leadership
+	primaryInfo    *PrimaryInfo  // contains info about the current primary
+	primaryKnownCh chan struct{} // closed & replaced when the primary changes
+	protocolVer    int           // replication protocol negotiated with the primary
+	candidate      atomic.Bool   // if true, we are eligible to become the primary
+	drained        bool          // if true, node is read-only & not a candidate until restart
+	drainCh        chan struct{} // closed to release the lease once drained
+	readyCh        chan struct{} // closed when primary found or acquired
+	pinnedUntil    time.Time     // primary holds lease until this time, if set
+	pinCh          chan struct{} // signaled to end the election delay of a candidate being pinned
+	lease          Lease         // lease held while primary
+	promoting      bool          // if true, node is acquiring the lease & writes are retryable
+	noSpace        bool          // if true, writes are paused until disk space is freed
+	writesDisabled bool          // if true, new writes are rejected during shutdown
+
+	specialFilesGen uint64 // incremented whenever special files change
+
+	// Transactions & bytes received from the primary, for catch-up progress.
+	catchupTXN   atomic.Int64
+	catchupBytes atomic.Int64
+
+	// Attempts to acquire the lease or connect to the primary & the last
+	// error encountered, for reporting progress while joining the cluster.
+	leaseAttemptN  atomic.Int64
+	lastLeaseError atomic.Value // string
+
+	applyNext time.Time // earliest time the next LTX file can be applied
+
+	caseInsensitive bool // true if the data directory ignores case in file names
+
+	replicaAcks map[string]*replicaAck // acknowledged positions, by stream ID
+	ackCh       chan struct{}          // closed & replaced on each acknowledgement
+
+	// Settings that can change at runtime from Consul, the primary's config
+	// frame or the API. Guarded by their own lock as they are read while
+	// holding s.mu.
+	settingsMu        sync.RWMutex
+	retentionDuration time.Duration // length of time to retain LTX files
+	debug             bool          // if true, enables debug logging
 
 	ctx    context.Context
 	cancel func()
@@ -55,19 +127,143 @@ type Store struct {
 	// Leaser manages the lease that controls leader election.
 	Leaser Leaser
 
-	// Length of time to retain LTX files.
-	RetentionDuration        time.Duration
+	// Directory where LTX files are assembled before being renamed into the
+	// data directory. Must be on the same file system as the data directory
+	// so the rename is atomic. Defaults to alongside the final LTX file.
+	TmpDir string
+
+	// Frequency that retention is enforced. The retention duration itself
+	// can change at runtime so it is set with SetRetentionDuration().
 	RetentionMonitorInterval time.Duration
 
+	// If non-zero, LTX files older than the retention duration are still retained
+	// until at least this many newer files, or bytes of newer files, exist.
+	RetentionMaxCount int
+	RetentionMaxBytes int64
+
+	// Interval over which each database's rolling write rate is computed.
+	WriteRateInterval time.Duration
+
+	// If non-zero, a write on a node that knows of no primary, such as during
+	// an election, waits up to this long for a primary to be established
+	// instead of failing immediately.
+	WriteBusyTimeout time.Duration
+
+	// Determines how LTX files & directories are fsync'd on commit. In
+	// SyncModeNormal, directories are synced every SyncInterval.
+	SyncMode     SyncMode
+	SyncInterval time.Duration
+
+	// Determines how database names that differ only by case are handled.
+	CaseSensitivity CaseSensitivity
+
+	// If IncludeDBs is set, only databases matching one of its patterns are
+	// managed. Databases matching an ExcludeDBs pattern are never managed.
+	// Patterns use path.Match syntax. Unmanaged databases are left untouched
+	// in the data directory & are not presented or replicated.
+	IncludeDBs []string
+	ExcludeDBs []string
+
+	// If non-zero, a WAL that grows past this many bytes is checkpointed into
+	// the database file & truncated after the commit that crossed the limit.
+	MaxWALSize int64
+
+	// Determines whether a transaction in progress when the lease is lost is
+	// aborted or completed locally.
+	OnLeaseLoss LeaseLossMode
+
+	// Maximum time to wait for the initial replication set from the primary
+	// before the store is marked ready anyway. Waits indefinitely if zero.
+	CatchupDeadline time.Duration
+
+	// Determines whether read transactions on a replica wait for the primary's
+	// current position. ReadTimeout bounds each wait.
+	ReadConsistency ReadConsistency
+	ReadTimeout     time.Duration
+
+	// Identifies the cluster this node belongs to. If set, the store refuses
+	// to open a data directory stamped with a different ID. If blank, the ID
+	// is adopted from the primary, if it has one. IDs are never generated as
+	// each node would generate its own before it first connects to a primary.
+	ExpectedClusterID string
+
+	// Determines whether a data directory from a different cluster fails the
+	// store or is reset & resynced from the primary.
+	OnClusterMismatch ClusterMismatchMode
+
+	// Maximum number of transactions applied per second on a replica so that
+	// catching up does not starve the queries it serves. The limit is lifted
+	// while applying transactions committed more than ApplyRateBypassLag ago.
+	// Unlimited if zero.
+	MaxApplyRate       int
+	ApplyRateBypassLag time.Duration
+
+	// Number of workers writing pages while applying an LTX file on a replica.
+	// Pages are written sequentially if one or less.
+	ApplyConcurrency int
+
+	// Determines how databases created while this node is a replica are
+	// handled. Shadowed databases are local-only & never replicated.
+	LocalWrite LocalWriteMode
+
+	// Election priority of this candidate, from 0 to MaxCandidatePriority.
+	// Higher priority candidates attempt to acquire the lease first.
+	CandidatePriority int
+
+	// Number of recent events retained for EventHistory(). Disabled if zero.
+	EventHistorySize int
+
+	// Determines how symlinks, sockets & FIFOs created in the mount are
+	// handled. Only databases are replicated through LTX files.
+	NonDBFiles NonDBFileMode
+
+	// Determines if commits on the primary wait for replica acknowledgement.
+	// In quorum mode, a commit waits for QuorumMinReplicas replicas to apply
+	// the transaction. If QuorumTimeout elapses first then the commit returns
+	// ErrQuorumTimeout unless QuorumFallback is set, in which case the commit
+	// is treated as asynchronous. The wait happens after the transaction is
+	// committed locally so a timeout never rolls it back; it is still sent
+	// to replicas once they catch up.
+	AckMode           AckMode
+	QuorumMinReplicas int
+	QuorumTimeout     time.Duration
+	QuorumFallback    bool
+
+	// Algorithm used for the LTX checksum chain. Replicas using a different
+	// algorithm are rejected when they connect.
+	ChecksumAlgorithm ChecksumAlgorithm
+
+	// Free space required on the data directory before writes resume after
+	// the disk has filled up. Checked every FreeSpaceMonitorInterval.
+	//
+	// If EnforceMinFreeSpace is true, free space is monitored continuously and
+	// writes are paused proactively when it drops below MinFreeSpace.
+	MinFreeSpace             int64
+	FreeSpaceMonitorInterval time.Duration
+	EnforceMinFreeSpace      bool
+
 	// Callback to notify kernel of file changes.
 	Invalidator Invalidator
 
-	// If true, enables debug logging.
-	Debug bool
+	// If true, rejects writes to databases that are not using a journal as
+	// they cannot be replicated. Otherwise a warning is logged.
+	StrictJournalMode bool
 
 	// If true, computes and verifies the checksum of the entire database
 	// after every transaction. Should only be used during testing.
 	StrictVerify bool
+
+	// Human-readable name of the node, reported by the API & metrics.
+	// Primaries advertising the same name are logged as a likely mistake.
+	NodeName string
+
+	// If true, the node only receives & persists the replication stream for
+	// archival. It is never a candidate and its LTX files are not removed.
+	Observer bool
+
+	// If true, replicas adopt the retention duration sent by the primary
+	// instead of using their local configuration.
+	AdoptPrimaryConfig bool
 }
 
 // NewStore returns a new instance of Store.
@@ -81,13 +277,48 @@ func NewStore(path string, candidate bool) *Store {
 		dbs: make(map[string]*DB),
 
 		subscribers: make(map[*Subscriber]struct{}),
-		candidate:   candidate,
 		primaryCh:   primaryCh,
 		readyCh:     make(chan struct{}),
+		drainCh:     make(chan struct{}),
+		pinCh:       make(chan struct{}, 1),
+
+		primaryKnownCh: make(chan struct{}),
+
+		eventSubscribers: make(map[*EventSubscriber]struct{}),
 
-		RetentionDuration:        DefaultRetentionDuration,
+		replicaAcks: make(map[string]*replicaAck),
+		ackCh:       make(chan struct{}),
+
+		retentionDuration:        DefaultRetentionDuration,
 		RetentionMonitorInterval: DefaultRetentionMonitorInterval,
+
+		AckMode:           AckModeAsync,
+		QuorumMinReplicas: DefaultQuorumMinReplicas,
+		QuorumTimeout:     DefaultQuorumTimeout,
+
+		ChecksumAlgorithm: ChecksumAlgorithmCRC64,
+
+		MinFreeSpace:             DefaultMinFreeSpace,
+		FreeSpaceMonitorInterval: DefaultFreeSpaceMonitorInterval,
+
+		WriteRateInterval: DefaultWriteRateInterval,
+		SyncMode:          SyncModeFull,
+		SyncInterval:      DefaultSyncInterval,
+		LocalWrite:        LocalWriteAllow,
+		OnLeaseLoss:       LeaseLossAbort,
+		CaseSensitivity:   CaseSensitivityStrict,
+		OnClusterMismatch: ClusterMismatchFail,
+		ReadConsistency:   ReadConsistencyLocal,
+		ReadTimeout:       DefaultReadTimeout,
+
+		CandidatePriority: DefaultCandidatePriority,
+
+		EventHistorySize: DefaultEventHistorySize,
+		NonDBFiles:       NonDBFilesLocal,
+
+		specialFilesGen: 1,
 	}
+	s.candidate.Store(candidate)
 	s.ctx, s.cancel = context.WithCancel(context.Background())
 
 	return s
@@ -122,25 +353,99 @@ func (s *Store) Open() error {
 		return err
 	}
 
+	if s.TmpDir != "" {
+		if err := s.initTmpDir(); err != nil {
+			return fmt.Errorf("init tmp dir: %w", err)
+		}
+	}
+
 	if err := s.initID(); err != nil {
 		return fmt.Errorf("init node id: %w", err)
 	}
 
+	if err := s.initClusterID(); err != nil {
+		return fmt.Errorf("init cluster id: %w", err)
+	}
+
 	if err := s.openDatabases(); err != nil {
 		return fmt.Errorf("open databases: %w", err)
 	}
 
+	if s.NodeName != "" {
+		storeNodeInfoMetricVec.WithLabelValues(s.NodeName).Set(1)
+	}
+
 	// Begin background replication monitor.
 	s.g.Go(func() error { return s.monitorLease(s.ctx) })
+	s.g.Go(func() error { return s.monitorCatchup(s.ctx) })
 
-	// Begin retention monitor.
-	if s.RetentionMonitorInterval > 0 {
+	// Begin retention monitor. Observers keep all LTX files for archival.
+	if s.RetentionMonitorInterval > 0 && !s.Observer {
 		s.g.Go(func() error { return s.monitorRetention(s.ctx) })
 	}
 
+	// Begin write rate monitor.
+	if s.WriteRateInterval > 0 {
+		s.g.Go(func() error { return s.monitorWriteRate(s.ctx) })
+	}
+
+	// Begin batched directory sync monitor.
+	if s.SyncMode == SyncModeNormal && s.SyncInterval > 0 {
+		s.g.Go(func() error { return s.monitorSync(s.ctx) })
+	}
+
+	// Begin free space monitor, if enabled.
+	if s.EnforceMinFreeSpace {
+		storeMinFreeSpaceMetric.Set(float64(s.MinFreeSpace))
+		s.g.Go(func() error { return s.monitorFreeSpace(s.ctx) })
+	}
+
+	return nil
+}
+
+// initTmpDir creates the temp directory and warns if it is on a different
+// device than the data directory as LTX files cannot be renamed atomically.
+func (s *Store) initTmpDir() error {
+	if err := os.MkdirAll(s.TmpDir, 0777); err != nil {
+		return err
+	}
+
+	var dataStat, tmpStat syscall.Stat_t
+	if err := syscall.Stat(s.path, &dataStat); err != nil {
+		return err
+	} else if err := syscall.Stat(s.TmpDir, &tmpStat); err != nil {
+		return err
+	}
+
+	if dataStat.Dev != tmpStat.Dev {
+		log.Printf("WARNING: tmp dir (%s) is on a different device than the data dir (%s), LTX files cannot be renamed atomically and writes will fail", s.TmpDir, s.path)
+	}
 	return nil
 }
 
+// isCaseInsensitiveDir returns true if file names in dir ignore case. This is
+// detected by creating a lowercase temporary file & checking for it in uppercase.
+func isCaseInsensitiveDir(dir string) (bool, error) {
+	f, err := os.CreateTemp(dir, ".case-check-")
+	if err != nil {
+		return false, err
+	}
+	filename := f.Name()
+	defer func() { _ = os.Remove(filename) }()
+
+	if err := f.Close(); err != nil {
+		return false, err
+	}
+
+	upper := filepath.Join(dir, strings.ToUpper(filepath.Base(filename)))
+	if _, err := os.Stat(upper); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // initID initializes an identifier that is unique to this node.
 func (s *Store) initID() error {
 	filename := filepath.Join(s.path, "id")
@@ -154,11 +459,10 @@ func (s *Store) initID() error {
 	}
 
 	// Generate a new node ID if file doesn't exist.
-	b := make([]byte, IDLength/2)
-	if _, err := io.ReadFull(crand.Reader, b); err != nil {
-		return fmt.Errorf("generate id: %w", err)
+	id, err := generateID()
+	if err != nil {
+		return err
 	}
-	id := fmt.Sprintf("%X", b)
 
 	f, err := os.Create(filename)
 	if err != nil {
@@ -179,16 +483,124 @@ func (s *Store) initID() error {
 	return nil
 }
 
+// generateID returns a random hex identifier of IDLength characters.
+func generateID() (string, error) {
+	b := make([]byte, IDLength/2)
+	if _, err := io.ReadFull(crand.Reader, b); err != nil {
+		return "", fmt.Errorf("generate id: %w", err)
+	}
+	return fmt.Sprintf("%X", b), nil
+}
+
+// ClusterID returns the cluster ID stamped in the data directory. Returns
+// blank if the node has not yet become primary or connected to a primary.
+func (s *Store) ClusterID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clusterID
+}
+
+// clusterIDPath returns the path to the file that stores the cluster ID.
+func (s *Store) clusterIDPath() string {
+	return filepath.Join(s.path, "clusterid")
+}
+
+// initClusterID reads the cluster ID stamped in the data directory and
+// verifies that it matches the configured cluster ID, if set.
+func (s *Store) initClusterID() error {
+	buf, err := os.ReadFile(s.clusterIDPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	detected := string(bytes.TrimSpace(buf))
+
+	switch {
+	case s.ExpectedClusterID == "":
+		s.clusterID = detected
+		return nil
+	case detected == "" || detected == s.ExpectedClusterID:
+		return s.writeClusterID(s.ExpectedClusterID)
+	}
+
+	log.Printf("cluster id mismatch in data directory: detected=%s expected=%s", detected, s.ExpectedClusterID)
+	if s.OnClusterMismatch != ClusterMismatchReset {
+		return fmt.Errorf("%w: detected=%s expected=%s", ErrClusterMismatch, detected, s.ExpectedClusterID)
+	}
+
+	log.Printf("WARNING: removing all databases from data directory, databases will be resynced from the primary")
+	if err := os.RemoveAll(s.DBDir()); err != nil {
+		return fmt.Errorf("remove databases: %w", err)
+	}
+	return s.writeClusterID(s.ExpectedClusterID)
+}
+
+// writeClusterID atomically stamps id into the data directory.
+func (s *Store) writeClusterID(id string) error {
+	filename := s.clusterIDPath()
+	tmpFilename := filename + ".tmp"
+
+	f, err := os.Create(tmpFilename)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	if _, err := f.Write([]byte(id + "\n")); err != nil {
+		return err
+	} else if err := f.Sync(); err != nil {
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	} else if err := os.Rename(tmpFilename, filename); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.clusterID = id
+	s.mu.Unlock()
+	return nil
+}
+
 func (s *Store) openDatabases() error {
 	if err := os.MkdirAll(s.DBDir(), 0777); err != nil {
 		return err
 	}
 
+	caseInsensitive, err := isCaseInsensitiveDir(s.path)
+	if err != nil {
+		return fmt.Errorf("detect case sensitivity: %w", err)
+	} else if caseInsensitive {
+		log.Printf("data directory is case-insensitive, database names differing only by case will collide")
+	}
+	s.caseInsensitive = caseInsensitive
+
 	fis, err := os.ReadDir(s.DBDir())
 	if err != nil {
 		return fmt.Errorf("readdir: %w", err)
 	}
+
+	// Names folded to the same database cannot be opened side by side. This
+	// can happen if a data directory is copied from a case-sensitive system.
+	if s.CaseSensitivity == CaseSensitivityFold {
+		names := make(map[string]string)
+		for _, fi := range fis {
+			key := strings.ToLower(fi.Name())
+			if other, ok := names[key]; ok {
+				return fmt.Errorf("database names %q and %q differ only by case", other, fi.Name())
+			}
+			names[key] = fi.Name()
+		}
+	}
+
+	filtered := len(s.IncludeDBs) > 0 || len(s.ExcludeDBs) > 0
 	for _, fi := range fis {
+		if !s.IsManaged(fi.Name()) {
+			log.Printf("ignoring database %q, not matched by databases include/exclude patterns", fi.Name())
+			continue
+		} else if filtered {
+			log.Printf("managing database %q", fi.Name())
+		}
+
 		if err := s.openDatabase(fi.Name()); err != nil {
 			return fmt.Errorf("open database(%q): %w", fi.Name(), err)
 		}
@@ -213,6 +625,25 @@ func (s *Store) openDatabase(name string) error {
 	return nil
 }
 
+// IsManaged returns true if the database name matches the store's include &
+// exclude patterns. Invalid patterns never match.
+func (s *Store) IsManaged(name string) bool {
+	for _, pattern := range s.ExcludeDBs {
+		if ok, _ := path.Match(pattern, name); ok {
+			return false
+		}
+	}
+	if len(s.IncludeDBs) == 0 {
+		return true
+	}
+	for _, pattern := range s.IncludeDBs {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
 // Close signals for the store to shut down.
 func (s *Store) Close() error {
 	s.cancel()
@@ -225,12 +656,47 @@ func (s *Store) ReadyCh() chan struct{} {
 	return s.readyCh
 }
 
+// LeaseAttempts returns the number of attempts made to acquire the lease or
+// connect to the primary & the last error encountered, if any.
+func (s *Store) LeaseAttempts() (n int, lastErr string) {
+	lastErr, _ = s.lastLeaseError.Load().(string)
+	return int(s.leaseAttemptN.Load()), lastErr
+}
+
+// LeaseRenewal describes the renewal state of the lease held by the primary.
+type LeaseRenewal struct {
+	// Static leases never expire so they are never renewed.
+	Static bool
+
+	RenewedAt time.Time
+	TTL       time.Duration
+}
+
+// LeaseRenewal returns the renewal state of the lease held by this node.
+// Returns nil if this node is not the primary.
+func (s *Store) LeaseRenewal() *LeaseRenewal {
+	s.mu.Lock()
+	lease := s.lease
+	s.mu.Unlock()
+
+	if lease == nil {
+		return nil
+	} else if _, ok := lease.(*StaticLease); ok {
+		return &LeaseRenewal{Static: true}
+	}
+	return &LeaseRenewal{RenewedAt: lease.RenewedAt(), TTL: lease.TTL()}
+}
+
 // markReady closes the ready channel if it hasn't already been closed.
 func (s *Store) markReady() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	select {
 	case <-s.readyCh:
 		return
 	default:
+		s.publishEvent(Event{Type: EventTypeReady})
 		close(s.readyCh)
 	}
 }
@@ -248,12 +714,22 @@ func (s *Store) setIsPrimary(v bool) {
 	if s.isPrimary != v {
 		if v {
 			s.primaryCh = make(chan struct{})
+			s.publishEvent(Event{Type: EventTypePrimaryAcquire})
 		} else {
 			close(s.primaryCh)
+			s.publishEvent(Event{Type: EventTypePrimaryLost})
 		}
 	}
 
-	// Update state.
+	// A pin only applies while we continue to hold the lease.
+	if !v {
+		s.pinnedUntil = time.Time{}
+	}
+
+	// Update state & wake writers waiting for a primary.
+	if s.isPrimary != v {
+		s.notifyPrimaryChange()
+	}
 	s.isPrimary = v
 
 	// Update metrics.
@@ -264,6 +740,33 @@ func (s *Store) setIsPrimary(v bool) {
 	}
 }
 
+// Promoting returns true while the node is acquiring the primary lease. Writes
+// during this window fail with ErrPromoting & should be retried.
+func (s *Store) Promoting() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.promoting
+}
+
+func (s *Store) setPromoting(v bool) {
+	s.promoting = v
+
+	if v {
+		storePromotingMetric.Set(1)
+	} else {
+		storePromotingMetric.Set(0)
+	}
+}
+
+// readOnlyError returns the error for a write rejected because this node is
+// not the primary. Writes during a promotion are reported as retryable.
+func (s *Store) readOnlyError() error {
+	if s.Promoting() {
+		return ErrPromoting
+	}
+	return ErrReadOnlyReplica
+}
+
 // PrimaryCtx wraps ctx with another context that will cancel when no longer primary.
 func (s *Store) PrimaryCtx(ctx context.Context) context.Context {
 	s.mu.Lock()
@@ -278,9 +781,159 @@ func (s *Store) PrimaryInfo() *PrimaryInfo {
 	return s.primaryInfo.Clone()
 }
 
+// ProtocolVersion returns the replication protocol version negotiated with
+// the primary. Returns zero if not connected or if the client does not report it.
+func (s *Store) ProtocolVersion() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.protocolVer
+}
+
+// PinAcquireTimeout is the maximum time Pin() waits for a candidate to
+// acquire the lease before pinning it.
+const PinAcquireTimeout = 10 * time.Second
+
+// Pin marks the primary as pinned for the given duration. While pinned, the
+// primary continues to hold its lease and will not voluntarily hand it off
+// so MarkDrained() waits for the pin to expire or be removed before releasing
+// the lease.
+//
+// A candidate that is not primary while no other node holds the lease skips
+// its election delay, acquires the lease & is then pinned. Returns
+// ErrNotPrimary if the store is not a candidate or is connected to another
+// primary.
+func (s *Store) Pin(ctx context.Context, d time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, PinAcquireTimeout)
+	defer cancel()
+
+	// Clear any unused wake up so a later election is not cut short.
+	defer func() {
+		select {
+		case <-s.pinCh:
+		default:
+		}
+	}()
+
+	for {
+		s.mu.Lock()
+		if s.isPrimary {
+			s.pinnedUntil = time.Now().Add(d)
+			log.Printf("primary pinned until %s", s.pinnedUntil.UTC().Format(time.RFC3339))
+			s.mu.Unlock()
+			return nil
+		} else if !s.Candidate() || s.primaryInfo != nil {
+			s.mu.Unlock()
+			return ErrNotPrimary
+		}
+		ch := s.primaryKnownCh
+		s.mu.Unlock()
+
+		// Wake the lease monitor if it is waiting out its election delay.
+		select {
+		case s.pinCh <- struct{}{}:
+		default:
+		}
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return fmt.Errorf("acquire lease: %w", ctx.Err())
+		}
+	}
+}
+
+// Unpin removes the pin from the primary, if any.
+func (s *Store) Unpin() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.pinnedUntil.IsZero() {
+		log.Printf("primary unpinned")
+	}
+	s.pinnedUntil = time.Time{}
+}
+
+// PinnedUntil returns the time that the primary pin expires. Returns the zero
+// time if the primary is not pinned or the pin has expired.
+func (s *Store) PinnedUntil() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Now().After(s.pinnedUntil) {
+		return time.Time{}
+	}
+	return s.pinnedUntil
+}
+
 // Candidate returns true if store is eligible to be the primary.
 func (s *Store) Candidate() bool {
-	return s.candidate
+	return s.candidate.Load()
+}
+
+// Drained returns true if the node has been drained by MarkDrained().
+func (s *Store) Drained() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.drained
+}
+
+// MarkDrained transitions the node into a read-only, non-candidate state for
+// a rolling deploy. New writes are rejected and, once in-progress write
+// transactions complete, a primary releases its lease so another candidate
+// takes over. The node continues serving reads & replicating from the new
+// primary until it is stopped. A static lease cannot be handed off so it is
+// kept. A pinned primary holds its lease until the pin expires or is removed.
+// This is safe to call again if ctx is done before the handoff.
+func (s *Store) MarkDrained(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.drained {
+		log.Printf("draining node: rejecting writes & no longer a candidate")
+	}
+	s.drained = true
+	s.writesDisabled = true
+	s.candidate.Store(false)
+	_, isStatic := s.lease.(*StaticLease)
+	s.mu.Unlock()
+
+	if err := s.Drain(ctx); err != nil {
+		return err
+	} else if isStatic {
+		log.Printf("WARNING: static lease cannot be handed off, remaining primary")
+		return nil
+	}
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	// Hold the lease while the primary is pinned.
+	if t := s.PinnedUntil(); !t.IsZero() {
+		log.Printf("primary pinned until %s, delaying lease handoff", t.UTC().Format(time.RFC3339))
+	}
+	for !s.PinnedUntil().IsZero() {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("primary lease not released, primary is pinned: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+
+	// Signal the primary to release its lease & wait for it to step down.
+	s.mu.Lock()
+	select {
+	case <-s.drainCh:
+	default:
+		close(s.drainCh)
+	}
+	s.mu.Unlock()
+
+	for s.IsPrimary() {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("primary lease not released: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
 }
 
 // DBByName returns a database by name.
@@ -288,7 +941,37 @@ func (s *Store) Candidate() bool {
 func (s *Store) DB(name string) *DB {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.dbs[name]
+	return s.lookupDB(name)
+}
+
+// lookupDB returns the named database. In CaseSensitivityFold, a database with
+// a name differing only by case is returned. Must hold s.mu.
+func (s *Store) lookupDB(name string) *DB {
+	if db := s.dbs[name]; db != nil || s.CaseSensitivity != CaseSensitivityFold {
+		return db
+	}
+	for k, db := range s.dbs {
+		if strings.EqualFold(k, name) {
+			return db
+		}
+	}
+	return nil
+}
+
+// checkCaseCollision returns ErrDatabaseNameCollision if the data directory is
+// case-insensitive and name differs only by case from a database other than
+// self, as both would share the same directory. Must hold s.mu.
+func (s *Store) checkCaseCollision(name string, self *DB) error {
+	if !s.caseInsensitive {
+		return nil
+	}
+	for k, db := range s.dbs {
+		if db != self && k != name && strings.EqualFold(k, name) {
+			log.Printf("WARNING: database %q collides with existing database %q on case-insensitive data directory", name, k)
+			return ErrDatabaseNameCollision
+		}
+	}
+	return nil
 }
 
 // DBs returns a list of databases.
@@ -307,14 +990,37 @@ func (s *Store) DBs() []*DB {
 // must be closed by the caller. Returns an error if a database with the same
 // name already exists.
 func (s *Store) CreateDB(name string) (*DB, *os.File, error) {
+	isPrimary := s.IsPrimary()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	// Verify database doesn't already exist.
-	if _, ok := s.dbs[name]; ok {
+	if !s.IsManaged(name) {
+		return nil, nil, ErrDatabaseNotManaged
+	} else if s.lookupDB(name) != nil {
+		return nil, nil, ErrDatabaseExists
+	} else if err := s.checkCaseCollision(name, nil); err != nil {
+		return nil, nil, err
+	}
+
+	// Special files share the mount's namespace with databases.
+	if f, err := readSpecialFile(s.FilesDir(), name); err != nil {
+		return nil, nil, err
+	} else if f != nil {
 		return nil, nil, ErrDatabaseExists
 	}
 
+	// Databases created on a replica are never received by the primary so
+	// creation waits for the promotion to complete instead of shadowing.
+	if !isPrimary && s.promoting {
+		return nil, nil, ErrPromoting
+	}
+	localOnly := !isPrimary && s.LocalWrite == LocalWriteShadow
+	if !isPrimary && s.LocalWrite == LocalWriteReject {
+		return nil, nil, ErrReadOnlyReplica
+	}
+
 	// Generate database directory with name file & empty database file.
 	dbPath := s.DBPath(name)
 	if err := os.MkdirAll(dbPath, 0777); err != nil {
@@ -326,6 +1032,14 @@ func (s *Store) CreateDB(name string) (*DB, *os.File, error) {
 		return nil, nil, err
 	}
 
+	if localOnly {
+		if err := os.WriteFile(filepath.Join(dbPath, "local-only"), nil, 0666); err != nil {
+			_ = f.Close()
+			return nil, nil, fmt.Errorf("write local-only marker: %w", err)
+		}
+		log.Printf("WARNING: database %q created on replica, shadowing as local-only; it will not be replicated", name)
+	}
+
 	// Create new database instance and add to maps.
 	db := NewDB(s, name, dbPath)
 	if err := db.Open(); err != nil {
@@ -349,8 +1063,10 @@ func (s *Store) CreateDBIfNotExists(name string) (*DB, error) {
 	defer s.mu.Unlock()
 
 	// Exit if database with same name already exists.
-	if db := s.dbs[name]; db != nil {
+	if db := s.lookupDB(name); db != nil {
 		return db, nil
+	} else if err := s.checkCaseCollision(name, nil); err != nil {
+		return nil, err
 	}
 
 	// Generate database directory with name file & empty database file.
@@ -379,19 +1095,165 @@ func (s *Store) CreateDBIfNotExists(name string) (*DB, error) {
 	return db, nil
 }
 
-// PosMap returns a map of databases and their transactional position.
-func (s *Store) PosMap() map[string]Pos {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	m := make(map[string]Pos, len(s.dbs))
-	for _, db := range s.dbs {
-		m[db.Name()] = db.Pos()
+// DropLockTimeout is the maximum time to wait for in-progress transactions
+// before a database is dropped or renamed.
+const DropLockTimeout = 5 * time.Second
+
+// DropDB removes a database along with all of its retained LTX files. The
+// drop is streamed to connected replicas & to replicas which reconnect later,
+// which then remove their local copy. A database created afterward with the
+// same name starts from an empty state. Only available on the primary.
+func (s *Store) DropDB(ctx context.Context, name string) error {
+	if !s.IsPrimary() {
+		return ErrReadOnlyReplica
 	}
-	return m
+	return s.dropDB(ctx, name)
 }
 
-// Subscribe creates a new subscriber for store changes.
+func (s *Store) dropDB(ctx context.Context, name string) error {
+	db := s.DB(name)
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+
+	// Wait for in-progress transactions so files are not removed mid-write.
+	ctx, cancel := context.WithTimeout(ctx, DropLockTimeout)
+	defer cancel()
+
+	guard, err := db.AcquireWriteLock(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire write lock: %w", err)
+	}
+	defer guard.Unlock()
+
+	s.mu.Lock()
+	if s.dbs[db.Name()] != db {
+		s.mu.Unlock()
+		return ErrDatabaseNotFound
+	}
+	delete(s.dbs, db.Name())
+	s.markDirty(db.Name())
+	storeDBCountMetric.Set(float64(len(s.dbs)))
+	s.mu.Unlock()
+
+	if err := os.RemoveAll(db.Path()); err != nil {
+		return fmt.Errorf("remove database directory: %w", err)
+	}
+
+	log.Printf("database dropped: %s", name)
+	return nil
+}
+
+// RenameDB atomically renames a database on the primary. Returns
+// ErrDatabaseExists if a database with the new name already exists.
+//
+// Replicas receive the rename as a drop of the old name followed by a
+// snapshot of the database under the new name.
+func (s *Store) RenameDB(ctx context.Context, oldName, newName string) error {
+	if !s.IsPrimary() {
+		return ErrReadOnlyReplica
+	}
+
+	db := s.DB(oldName)
+	if db == nil {
+		return ErrDatabaseNotFound
+	} else if !s.IsManaged(newName) {
+		return ErrDatabaseNotManaged
+	}
+
+	// Wait for in-progress transactions so the rename is not mid-write.
+	ctx, cancel := context.WithTimeout(ctx, DropLockTimeout)
+	defer cancel()
+
+	guard, err := db.AcquireWriteLock(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire write lock: %w", err)
+	}
+	defer guard.Unlock()
+
+	if err := s.renameDB(db, newName); err != nil {
+		return err
+	}
+
+	log.Printf("database renamed: %s -> %s", oldName, newName)
+	return nil
+}
+
+func (s *Store) renameDB(db *DB, newName string) error {
+	newPath := s.DBPath(newName)
+	if err := func() error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.dbs[db.Name()] != db {
+			return ErrDatabaseNotFound
+		} else if other := s.lookupDB(newName); other != nil && other != db {
+			return ErrDatabaseExists
+		} else if err := s.checkCaseCollision(newName, db); err != nil {
+			return err
+		} else if f, err := readSpecialFile(s.FilesDir(), newName); err != nil {
+			return err
+		} else if f != nil {
+			return ErrDatabaseExists
+		}
+		if err := os.Rename(db.Path(), newPath); err != nil {
+			return fmt.Errorf("rename database directory: %w", err)
+		}
+		return nil
+	}(); err != nil {
+		return err
+	}
+
+	// Open outside the store lock as recovery may notify subscribers.
+	other := NewDB(s, newName, newPath)
+	if err := other.Open(); err != nil {
+		return fmt.Errorf("open renamed database: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.dbs, db.Name())
+	s.dbs[newName] = other
+
+	s.markDirty(db.Name())
+	s.markDirty(newName)
+
+	return nil
+}
+
+// PosMap returns a map of databases and their transactional position.
+func (s *Store) PosMap() map[string]Pos {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := make(map[string]Pos, len(s.dbs))
+	for _, db := range s.dbs {
+		if db.LocalOnly() {
+			continue // never advertised
+		}
+		m[db.Name()] = db.Pos()
+	}
+	return m
+}
+
+// LocalOnlyDBs returns the names of databases which were created locally on
+// a replica & are not replicated.
+func (s *Store) LocalOnlyDBs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var a []string
+	for _, db := range s.dbs {
+		if db.LocalOnly() {
+			a = append(a, db.Name())
+		}
+	}
+	sort.Strings(a)
+	return a
+}
+
+// Subscribe creates a new subscriber for store changes.
 func (s *Store) Subscribe() *Subscriber {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -425,8 +1287,187 @@ func (s *Store) markDirty(name string) {
 	}
 }
 
+// Ack records the position of a database that has been applied by a replica
+// on a given stream. Acknowledgements are kept per stream so that a replica
+// which reconnects before its old stream closes keeps the new stream's acks.
+func (s *Store) Ack(streamID, nodeID, name string, pos Pos) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ack := s.replicaAcks[streamID]
+	if ack == nil {
+		ack = &replicaAck{nodeID: nodeID, posMap: make(map[string]Pos)}
+		s.replicaAcks[streamID] = ack
+	}
+	ack.posMap[name] = pos
+
+	// Notify any commits waiting on acknowledgement.
+	close(s.ackCh)
+	s.ackCh = make(chan struct{})
+}
+
+// RemoveReplica clears acknowledged positions for a closed replica stream.
+// Positions acknowledged on other streams from the same node are kept.
+func (s *Store) RemoveReplica(streamID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.replicaAcks, streamID)
+}
+
+// WaitLinearizable waits until db has applied the primary's current position
+// if the store uses linearizable reads. Returns immediately for local reads.
+func (s *Store) WaitLinearizable(ctx context.Context, db *DB) error {
+	if s.ReadConsistency != ReadConsistencyLinearizable {
+		return nil
+	}
+	return s.WaitPrimaryPos(ctx, db)
+}
+
+// WaitPrimaryPos fetches the primary's current position for db and waits
+// until it has been applied locally. Returns immediately on the primary.
+// Returns an error wrapping context.DeadlineExceeded after ReadTimeout.
+func (s *Store) WaitPrimaryPos(ctx context.Context, db *DB) error {
+	if s.IsPrimary() {
+		return nil
+	}
+
+	t := time.Now()
+	defer func() { storeReadWaitDurationMetric.Observe(time.Since(t).Seconds()) }()
+
+	ctx, cancel := context.WithTimeout(ctx, s.ReadTimeout)
+	defer cancel()
+
+	info := s.PrimaryInfo()
+	if info == nil {
+		return ErrNoPrimary
+	}
+	fetcher, ok := s.Client.(PosFetcher)
+	if !ok {
+		return fmt.Errorf("client cannot fetch primary position")
+	}
+
+	pos, err := fetcher.FetchPos(ctx, info.AdvertiseURL, db.Name())
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			storeReadWaitTimeoutCountMetric.Inc()
+		}
+		return fmt.Errorf("fetch primary position: %w", err)
+	}
+	return s.WaitPos(ctx, db, pos.TXID)
+}
+
+// WaitPos waits until db has applied txID or until ctx is done. If ctx has no
+// deadline, the wait is bounded by ReadTimeout.
+func (s *Store) WaitPos(ctx context.Context, db *DB, txID uint64) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.ReadTimeout)
+		defer cancel()
+	}
+
+	// Subscribe before checking the position so no changes are missed.
+	sub := s.Subscribe()
+	defer func() { _ = sub.Close() }()
+
+	for db.TXID() < txID {
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				storeReadWaitTimeoutCountMetric.Inc()
+			}
+			return fmt.Errorf("wait for txid %s: %w", ltx.FormatTXID(txID), ctx.Err())
+		case <-sub.NotifyCh():
+		}
+	}
+	return nil
+}
+
+// ReplicaPosMaps returns a copy of the acknowledged positions of each
+// connected replica, keyed by node ID.
+func (s *Store) ReplicaPosMaps() map[string]map[string]Pos {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := make(map[string]map[string]Pos, len(s.replicaAcks))
+	for _, ack := range s.replicaAcks {
+		other := m[ack.nodeID]
+		if other == nil {
+			other = make(map[string]Pos, len(ack.posMap))
+			m[ack.nodeID] = other
+		}
+
+		// Report the furthest position if a node has overlapping streams.
+		for name, pos := range ack.posMap {
+			if pos.TXID >= other[name].TXID {
+				other[name] = pos
+			}
+		}
+	}
+	return m
+}
+
+// ackCount returns the number of replicas that have applied txID on a database.
+func (s *Store) ackCount(name string, txID uint64) (n int) {
+	nodeIDs := make(map[string]struct{}, len(s.replicaAcks))
+	for _, ack := range s.replicaAcks {
+		if _, ok := nodeIDs[ack.nodeID]; ok {
+			continue
+		} else if ack.posMap[name].TXID >= txID {
+			nodeIDs[ack.nodeID] = struct{}{}
+			n++
+		}
+	}
+	return n
+}
+
+// replicaAck holds the positions acknowledged on a single replica stream.
+type replicaAck struct {
+	nodeID string
+	posMap map[string]Pos
+}
+
+// waitForQuorum blocks until enough replicas have acknowledged txID for the
+// given database. Returns immediately if the store is not in quorum mode.
+func (s *Store) waitForQuorum(name string, txID uint64) error {
+	if s.AckMode != AckModeQuorum {
+		return nil
+	} else if db := s.DB(name); db != nil && db.LocalOnly() {
+		return nil // never replicated
+	}
+
+	ctx, cancel := s.ctx, func() {}
+	if s.QuorumTimeout > 0 {
+		ctx, cancel = context.WithTimeout(s.ctx, s.QuorumTimeout)
+	}
+	defer cancel()
+
+	t := time.Now()
+	for {
+		s.mu.Lock()
+		n, ackCh := s.ackCount(name, txID), s.ackCh
+		s.mu.Unlock()
+
+		if n >= s.QuorumMinReplicas {
+			storeAckLatencyMetric.Observe(time.Since(t).Seconds())
+			return nil
+		}
+
+		select {
+		case <-ackCh:
+		case <-ctx.Done():
+			storeAckTimeoutCountMetric.Inc()
+			if s.QuorumFallback {
+				log.Printf("replication quorum not reached for %q at tx %s, continuing asynchronously", name, ltx.FormatTXID(txID))
+				return nil
+			}
+			return ErrQuorumTimeout
+		}
+	}
+}
+
 // monitorLease continuously handles either the leader lease or replicates from the primary.
 func (s *Store) monitorLease(ctx context.Context) error {
+	var lastInvalidErr string
 	for {
 		// Exit if store is closed.
 		if err := ctx.Err(); err != nil {
@@ -434,16 +1475,37 @@ func (s *Store) monitorLease(ctx context.Context) error {
 		}
 
 		// Attempt to either obtain a primary lock or read the current primary.
+		s.leaseAttemptN.Add(1)
 		lease, info, err := s.acquireLeaseOrPrimaryInfo(ctx)
-		if err == ErrNoPrimary && !s.candidate {
+		if err != nil {
+			s.lastLeaseError.Store(err.Error())
+		}
+
+		if err == ErrNoPrimary && !s.Candidate() {
 			log.Printf("cannot find primary & ineligible to become primary, retrying: %s", err)
 			sleepWithContext(ctx, 1*time.Second)
 			continue
+		} else if errors.Is(err, ErrInvalidPrimaryInfo) {
+			// Only publish when the problem changes so subscribers are not
+			// flooded while the lease value remains corrupted.
+			log.Printf("ERROR: lease holds invalid primary info, not connecting, retrying: %s", err)
+			storeInvalidPrimaryInfoCountMetric.Inc()
+			if msg := err.Error(); msg != lastInvalidErr {
+				s.publishEvent(Event{Type: EventTypeInvalidPrimaryInfo, Error: msg})
+				lastInvalidErr = msg
+			}
+			sleepWithContext(ctx, 1*time.Second)
+			continue
+		} else if errors.Is(err, ErrStaticPrimaryConflict) {
+			log.Printf("not acting as primary: %s", err)
+			sleepWithContext(ctx, 1*time.Second)
+			continue
 		} else if err != nil {
 			log.Printf("cannot acquire lease or find primary, retrying: %s", err)
 			sleepWithContext(ctx, 1*time.Second)
 			continue
 		}
+		lastInvalidErr = ""
 
 		// Monitor as primary if we have obtained a lease.
 		if lease != nil {
@@ -454,176 +1516,688 @@ func (s *Store) monitorLease(ctx context.Context) error {
 			continue
 		}
 
-		// Monitor as replica if another primary already exists.
-		log.Printf("existing primary found (%s), connecting as replica", info.Hostname)
-		if err := s.monitorLeaseAsReplica(ctx, info); err == nil {
-			log.Printf("replica disconnected, retrying")
-		} else {
-			log.Printf("replica disconnected with error, retrying: %s", err)
+		// Monitor as replica if another primary already exists.
+		log.Printf("existing primary found (%s), connecting as replica", info.Hostname)
+		if s.NodeName != "" && info.Hostname == s.NodeName {
+			log.Printf("WARNING: primary advertises the same node name as this node (%q), node names should be unique", s.NodeName)
+		}
+		delay := 1 * time.Second
+		if err := s.monitorLeaseAsReplica(ctx, info); err == nil {
+			log.Printf("replica disconnected, retrying")
+		} else {
+			s.lastLeaseError.Store(err.Error())
+			s.publishEvent(Event{Type: EventTypeReplicaDisconnect, Error: err.Error()})
+			var retryErr *RetryAfterError
+			if errors.As(err, &retryErr) {
+				delay = retryErr.RetryAfter
+			}
+			log.Printf("replica disconnected with error, retrying in %s: %s", delay, err)
+		}
+		sleepWithContext(ctx, delay)
+	}
+}
+
+func (s *Store) acquireLeaseOrPrimaryInfo(ctx context.Context) (Lease, *PrimaryInfo, error) {
+	// Attempt to find an existing primary first.
+	info, err := s.readPrimaryInfo(ctx)
+	if err == ErrNoPrimary && !s.Candidate() {
+		return nil, nil, err // no primary, not eligible to become primary
+	} else if err != nil && err != ErrNoPrimary {
+		return nil, nil, fmt.Errorf("fetch primary url: %w", err)
+	} else if err == nil {
+		return nil, &info, nil
+	}
+
+	// Give higher priority candidates a chance to acquire the lease first.
+	// The leaser may also ask to wait, such as while the lease is churning.
+	delay := s.electionDelay()
+	if d, ok := s.Leaser.(AcquireDelayer); ok {
+		if v := d.AcquireDelay(); v > delay {
+			delay = v
+		}
+	}
+	if delay > 0 {
+		// A candidate being pinned claims the lease without waiting.
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		case <-s.pinCh:
+		}
+		if info, err := s.readPrimaryInfo(ctx); err == nil {
+			return nil, &info, nil
+		} else if err != ErrNoPrimary {
+			return nil, nil, fmt.Errorf("fetch primary url: %w", err)
+		}
+	}
+
+	// If no primary, attempt to become primary. Writes are rejected as
+	// retryable until the promotion completes or another node wins.
+	s.mu.Lock()
+	s.setPromoting(true)
+	s.mu.Unlock()
+
+	lease, err := s.Leaser.Acquire(ctx)
+	if lease != nil && err == nil {
+		return lease, nil, nil // promotion completed by monitorLeaseAsPrimary()
+	}
+
+	s.mu.Lock()
+	s.setPromoting(false)
+	s.mu.Unlock()
+
+	if err != nil && err != ErrPrimaryExists {
+		return nil, nil, fmt.Errorf("acquire lease: %w", err)
+	}
+
+	// If we raced to become primary and another node beat us, retry the fetch.
+	info, err = s.readPrimaryInfo(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, &info, nil
+}
+
+// readPrimaryInfo reads the primary info from the leaser. Returns an error
+// wrapping ErrInvalidPrimaryInfo if the info is unusable so that replicas do
+// not attempt to connect to a corrupted advertise URL.
+func (s *Store) readPrimaryInfo(ctx context.Context) (PrimaryInfo, error) {
+	info, err := s.Leaser.PrimaryInfo(ctx)
+	if err != nil {
+		return info, err
+	} else if err := info.Validate(); err != nil {
+		return info, fmt.Errorf("%w: %s", ErrInvalidPrimaryInfo, err)
+	}
+	return info, nil
+}
+
+// electionDelay returns how long the candidate waits before acquiring the lease.
+func (s *Store) electionDelay() time.Duration {
+	return time.Duration(MaxCandidatePriority-s.CandidatePriority) * CandidatePriorityDelay
+}
+
+// monitorLeaseAsPrimary monitors & renews the current lease.
+// NOTE: This code is borrowed from the consul/api's RenewPeriodic() implementation.
+func (s *Store) monitorLeaseAsPrimary(ctx context.Context, lease Lease) error {
+	const timeout = 1 * time.Second
+
+	// Attempt to destroy lease when we exit this function.
+	defer func() {
+		log.Printf("exiting primary, destroying lease")
+		if err := lease.Close(); err != nil {
+			log.Printf("cannot remove lease: %s", err)
+		}
+	}()
+
+	// Mark as the primary node while we're in this function.
+	s.mu.Lock()
+	s.setIsPrimary(true)
+	s.setPromoting(false)
+	s.lease = lease
+	s.mu.Unlock()
+
+	// Mark store as ready if we've obtained primary status.
+	s.markReady()
+
+	// Ensure that we are no longer marked as primary once we exit this function.
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.setIsPrimary(false)
+		s.lease = nil
+		storeLeaseRenewalAgeMetric.Set(0)
+		storeLeaseTTLMetric.Set(0)
+	}()
+
+	// Report time since the last renewal so an impending expiration can be
+	// alerted on. Static leases are never renewed so they are not reported.
+	_, isStatic := lease.(*StaticLease)
+	metricTicker := time.NewTicker(LeaseMetricInterval)
+	defer metricTicker.Stop()
+
+	renewTimer := time.NewTimer(lease.TTL() / 2)
+	defer renewTimer.Stop()
+
+	var revokedCh <-chan struct{}
+	if v, ok := lease.(LeaseRevoker); ok {
+		revokedCh = v.Revoked()
+	}
+
+	for {
+		select {
+		case <-revokedCh:
+			return ErrLeaseExpired
+
+		case <-metricTicker.C:
+			if !isStatic {
+				storeLeaseRenewalAgeMetric.Set(time.Since(lease.RenewedAt()).Seconds())
+				storeLeaseTTLMetric.Set(lease.TTL().Seconds())
+			}
+
+		case <-renewTimer.C:
+			// Attempt to renew the lease. If the lease is gone then we need to
+			// just exit and we can start over or connect to the new primary.
+			//
+			// If we just have a connection error then we'll try to more
+			// aggressively retry the renewal until we exceed TTL.
+			if err := lease.Renew(ctx); err == ErrLeaseExpired {
+				return err
+			} else if err != nil {
+				// If our next renewal will exceed TTL, exit now.
+				if time.Since(lease.RenewedAt())+timeout > lease.TTL() {
+					time.Sleep(timeout)
+					return ErrLeaseExpired
+				}
+
+				// Otherwise log error and try again after a shorter period.
+				log.Printf("lease renewal error, retrying: %s", err)
+				renewTimer.Reset(time.Second)
+				continue
+			}
+
+			// Renewal was successful, restart with low frequency.
+			renewTimer.Reset(lease.TTL() / 2)
+
+		case <-s.drainCh:
+			log.Printf("node drained, releasing primary lease")
+			return nil
+
+		case <-ctx.Done():
+			return nil // release lease when we shut down
+		}
+	}
+}
+
+// monitorLeaseAsReplica tries to connect to the primary node and stream down changes.
+func (s *Store) monitorLeaseAsReplica(ctx context.Context, info *PrimaryInfo) error {
+	if s.Client == nil {
+		return fmt.Errorf("no client set, skipping replica monitor")
+	}
+
+	// Store the URL of the primary while we're in this function.
+	s.mu.Lock()
+	s.primaryInfo = info
+	s.notifyPrimaryChange()
+	s.mu.Unlock()
+
+	// Clear the primary URL once we leave this function since we can no longer connect.
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.primaryInfo = nil
+		s.protocolVer = 0
+		s.notifyPrimaryChange()
+	}()
+
+	posMap := s.PosMap()
+	st, err := s.Client.Stream(ctx, info.AdvertiseURL, s.id, posMap)
+	if err != nil {
+		return fmt.Errorf("connect to primary: %w ('%s')", err, info.AdvertiseURL)
+	}
+	defer func() { _ = st.Close() }()
+
+	if v, ok := st.(ProtocolVersioner); ok {
+		s.mu.Lock()
+		s.protocolVer = v.ProtocolVersion()
+		s.mu.Unlock()
+	}
+
+	for {
+		frame, err := ReadStreamFrame(st)
+		if err == io.EOF {
+			return nil // clean disconnect
+		} else if err != nil {
+			return fmt.Errorf("next frame: %w", err)
+		}
+
+		switch frame := frame.(type) {
+		case *LTXStreamFrame:
+			if err := s.processLTXStreamFrame(ctx, frame, st); err != nil {
+				return fmt.Errorf("process ltx stream frame: %w", err)
+			}
+
+			// Report the applied position back to the primary, if supported.
+			if acker, ok := st.(StreamAcker); ok {
+				if err := acker.Ack(frame.Name, s.DB(frame.Name).Pos()); err != nil {
+					return fmt.Errorf("ack: %w", err)
+				}
+			}
+		case *ClusterIDStreamFrame:
+			if err := s.processClusterIDStreamFrame(ctx, frame); err != nil {
+				return err
+			}
+		case *ConfigStreamFrame:
+			s.processConfigStreamFrame(frame)
+		case *DropDBStreamFrame:
+			if err := s.processDropDBStreamFrame(ctx, frame); err != nil {
+				return fmt.Errorf("process drop db stream frame: %w", err)
+			}
+		case *SpecialFilesStreamFrame:
+			if err := s.processSpecialFilesStreamFrame(frame); err != nil {
+				return fmt.Errorf("process special files stream frame: %w", err)
+			}
+		case *ReadyStreamFrame:
+			// Mark store as ready once we've received an initial replication set.
+			s.markReady()
+		case *EndStreamFrame:
+			// Server cleanly disconnected
+			return nil
+		default:
+			return fmt.Errorf("invalid stream frame type: 0x%02x", frame.Type())
+		}
+	}
+}
+
+// throttleApply waits until the next LTX file can be applied under
+// MaxApplyRate. The wait is skipped if the transaction was committed more
+// than ApplyRateBypassLag ago so a replica far behind catches up at full
+// speed.
+func (s *Store) throttleApply(ctx context.Context, lag time.Duration) {
+	if s.MaxApplyRate <= 0 {
+		return
+	}
+
+	now := time.Now()
+	if s.ApplyRateBypassLag > 0 && lag > s.ApplyRateBypassLag {
+		s.applyNext = now
+		return
+	}
+
+	if d := s.applyNext.Sub(now); d > 0 {
+		replicaApplyThrottleMetric.Add(d.Seconds())
+		sleepWithContext(ctx, d)
+		now = s.applyNext
+	}
+	s.applyNext = now.Add(time.Second / time.Duration(s.MaxApplyRate))
+}
+
+// ConfigStreamFrame returns the settings this node distributes to replicas.
+func (s *Store) ConfigStreamFrame() *ConfigStreamFrame {
+	return &ConfigStreamFrame{
+		RetentionDuration: s.RetentionDuration(),
+	}
+}
+
+// processClusterIDStreamFrame verifies that the local data directory belongs
+// to the primary's cluster. A node without a cluster ID adopts the primary's.
+// On mismatch, either an error is returned so no data is applied or, if reset
+// is enabled, all local databases are removed and the stream must reconnect
+// so they are resynced from scratch.
+func (s *Store) processClusterIDStreamFrame(ctx context.Context, frame *ClusterIDStreamFrame) error {
+	detected := s.ClusterID()
+	if detected == frame.ClusterID {
+		return nil
+	} else if detected == "" {
+		log.Printf("adopting cluster id from primary: %s", frame.ClusterID)
+		return s.writeClusterID(frame.ClusterID)
+	}
+
+	log.Printf("cluster id mismatch with primary: detected=%s expected=%s", detected, frame.ClusterID)
+
+	// A configured cluster ID cannot be reset to a different primary's ID.
+	if s.OnClusterMismatch != ClusterMismatchReset || s.ExpectedClusterID != "" {
+		return fmt.Errorf("%w: detected=%s expected=%s", ErrClusterMismatch, detected, frame.ClusterID)
+	}
+
+	log.Printf("WARNING: removing all local databases to resync from primary")
+	for _, db := range s.DBs() {
+		if err := s.processDropDBStreamFrame(ctx, &DropDBStreamFrame{Name: db.Name()}); err != nil {
+			return fmt.Errorf("remove database %q: %w", db.Name(), err)
+		}
+	}
+	if err := s.writeClusterID(frame.ClusterID); err != nil {
+		return err
+	}
+	return fmt.Errorf("cluster id reset, reconnecting to resync from primary")
+}
+
+// processDropDBStreamFrame removes the local copy of a database that was
+// dropped on the primary.
+func (s *Store) processDropDBStreamFrame(ctx context.Context, frame *DropDBStreamFrame) error {
+	if db := s.DB(frame.Name); db != nil && db.LocalOnly() {
+		return nil
+	}
+
+	if err := s.dropDB(ctx, frame.Name); err == ErrDatabaseNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// Remove the database's files from the kernel cache.
+	if invalidator := s.Invalidator; invalidator != nil {
+		if err := invalidator.InvalidateEntry(frame.Name); err != nil {
+			log.Printf("cannot invalidate dropped database %q: %s", frame.Name, err)
+		}
+	}
+	return nil
+}
+
+// processConfigStreamFrame adopts the primary's settings, if enabled.
+// Otherwise the local configuration is kept.
+func (s *Store) processConfigStreamFrame(frame *ConfigStreamFrame) {
+	if *frame == *s.ConfigStreamFrame() {
+		return
+	}
+
+	if !s.AdoptPrimaryConfig {
+		log.Printf("using local config, ignoring config from primary: retention=%s", frame.RetentionDuration)
+		return
+	}
+
+	log.Printf("adopting config from primary: retention=%s", frame.RetentionDuration)
+	if frame.RetentionDuration > 0 {
+		s.SetRetentionDuration(frame.RetentionDuration)
+	}
+}
+
+// checkWritable returns an error if new write transactions are not accepted.
+// Returns ErrNoSpace if writes are paused because the disk is full.
+func (s *Store) checkWritable() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.writesDisabled {
+		return ErrWritesDisabled
+	} else if s.noSpace {
+		return fmt.Errorf("%w (free=%d bytes)", ErrNoSpace, s.freeSpace())
+	}
+	return nil
+}
+
+// notifyPrimaryChange wakes writers waiting in waitPrimary(). Must hold s.mu.
+func (s *Store) notifyPrimaryChange() {
+	close(s.primaryKnownCh)
+	s.primaryKnownCh = make(chan struct{})
+}
+
+// waitPrimary waits up to WriteBusyTimeout for a primary to be established
+// if the node currently knows of none, such as between primaries during an
+// election. Returns immediately if this node or another node is primary.
+// The caller must recheck whether it is writable afterward.
+func (s *Store) waitPrimary() {
+	if s.WriteBusyTimeout <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(s.WriteBusyTimeout)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		known, ch := s.isPrimary || s.primaryInfo != nil, s.primaryKnownCh
+		s.mu.Unlock()
+		if known {
+			return
+		}
+
+		select {
+		case <-ch:
+		case <-timer.C:
+			storeWriteBusyTimeoutCountMetric.Inc()
+			return
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// DisableWrites rejects new write transactions. This is used during shutdown
+// so that in-progress transactions can be drained.
+func (s *Store) DisableWrites() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writesDisabled = true
+}
+
+// Drain waits until no database has a write transaction in progress. Returns
+// an error if ctx is done before all transactions complete.
+func (s *Store) Drain(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		var names []string
+		for _, db := range s.DBs() {
+			if db.InWriteTx() || db.writeLock.State() == RWMutexStateExclusive {
+				names = append(names, db.Name())
+			}
+		}
+		if len(names) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("write transactions still in progress: %s", strings.Join(names, ", "))
+		case <-ticker.C:
 		}
-		sleepWithContext(ctx, 1*time.Second)
 	}
 }
 
-func (s *Store) acquireLeaseOrPrimaryInfo(ctx context.Context) (Lease, *PrimaryInfo, error) {
-	// Attempt to find an existing primary first.
-	info, err := s.Leaser.PrimaryInfo(ctx)
-	if err == ErrNoPrimary && !s.candidate {
-		return nil, nil, err // no primary, not eligible to become primary
-	} else if err != nil && err != ErrNoPrimary {
-		return nil, nil, fmt.Errorf("fetch primary url: %w", err)
-	} else if err == nil {
-		return nil, &info, nil
+// handleNoSpace pauses writes if err was caused by the disk being full. The
+// store resumes writes once enough space is available. Otherwise returns err.
+func (s *Store) handleNoSpace(err error) error {
+	if !errors.Is(err, syscall.ENOSPC) {
+		return err
 	}
 
-	// If no primary, attempt to become primary.
-	lease, err := s.Leaser.Acquire(ctx)
-	if err != nil && err != ErrPrimaryExists {
-		return nil, nil, fmt.Errorf("acquire lease: %w", err)
-	} else if lease != nil {
-		return lease, nil, nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	free := s.freeSpace()
+	if s.pauseWrites(free, err.Error()) && !s.EnforceMinFreeSpace {
+		s.g.Go(func() error { return s.monitorNoSpace(s.ctx) })
 	}
+	return fmt.Errorf("%w (free=%d bytes): %s", ErrNoSpace, free, err)
+}
 
-	// If we raced to become primary and another node beat us, retry the fetch.
-	info, err = s.Leaser.PrimaryInfo(ctx)
-	if err != nil {
-		return nil, nil, err
+// pauseWrites marks the store as out of space. Returns true if writes were
+// not already paused. Must be called while holding s.mu.
+func (s *Store) pauseWrites(free int64, reason string) bool {
+	if s.noSpace {
+		return false
 	}
-	return nil, &info, nil
+
+	log.Printf("CRITICAL: pausing writes until %d bytes are free (free=%d bytes): %s", s.MinFreeSpace, free, reason)
+	s.noSpace = true
+	storeNoSpaceMetric.Set(1)
+	storeNoSpaceCountMetric.Inc()
+	return true
 }
 
-// monitorLeaseAsPrimary monitors & renews the current lease.
-// NOTE: This code is borrowed from the consul/api's RenewPeriodic() implementation.
-func (s *Store) monitorLeaseAsPrimary(ctx context.Context, lease Lease) error {
-	const timeout = 1 * time.Second
+// resumeWrites clears the out of space state if enough space is free. Returns
+// true if writes are resumed. Must be called while holding s.mu.
+func (s *Store) resumeWrites(free int64) bool {
+	if !s.noSpace || free < s.MinFreeSpace {
+		return false
+	}
 
-	// Attempt to destroy lease when we exit this function.
-	defer func() {
-		log.Printf("exiting primary, destroying lease")
-		if err := lease.Close(); err != nil {
-			log.Printf("cannot remove lease: %s", err)
-		}
-	}()
+	log.Printf("disk space available, resuming writes (free=%d bytes)", free)
+	s.noSpace = false
+	storeNoSpaceMetric.Set(0)
+	return true
+}
 
-	// Mark as the primary node while we're in this function.
-	s.mu.Lock()
-	s.setIsPrimary(true)
-	s.mu.Unlock()
+// monitorNoSpace waits until free space is available and resumes writes.
+func (s *Store) monitorNoSpace(ctx context.Context) error {
+	ticker := time.NewTicker(s.FreeSpaceMonitorInterval)
+	defer ticker.Stop()
 
-	// Mark store as ready if we've obtained primary status.
-	s.markReady()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
 
-	// Ensure that we are no longer marked as primary once we exit this function.
-	defer func() {
 		s.mu.Lock()
-		defer s.mu.Unlock()
-		s.setIsPrimary(false)
-	}()
+		resumed := s.resumeWrites(s.freeSpace())
+		s.mu.Unlock()
+
+		if resumed {
+			return nil
+		}
+	}
+}
 
-	waitDur := lease.TTL() / 2
+// monitorFreeSpace continuously checks free space and pauses writes before the
+// disk fills up. A retention sweep is run when writes are paused to reclaim
+// space from LTX files.
+func (s *Store) monitorFreeSpace(ctx context.Context) error {
+	ticker := time.NewTicker(s.FreeSpaceMonitorInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
-		case <-time.After(waitDur):
-			// Attempt to renew the lease. If the lease is gone then we need to
-			// just exit and we can start over or connect to the new primary.
-			//
-			// If we just have a connection error then we'll try to more
-			// aggressively retry the renewal until we exceed TTL.
-			if err := lease.Renew(ctx); err == ErrLeaseExpired {
-				return err
-			} else if err != nil {
-				// If our next renewal will exceed TTL, exit now.
-				if time.Since(lease.RenewedAt())+timeout > lease.TTL() {
-					time.Sleep(timeout)
-					return ErrLeaseExpired
-				}
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
 
-				// Otherwise log error and try again after a shorter period.
-				log.Printf("lease renewal error, retrying: %s", err)
-				waitDur = time.Second
-				continue
-			}
+		free := s.freeSpace()
+		if free < 0 {
+			continue // cannot determine free space
+		}
+		storeFreeSpaceMetric.Set(float64(free))
 
-			// Renewal was successful, restart with low frequency.
-			waitDur = lease.TTL() / 2
+		s.mu.Lock()
+		var paused bool
+		if free < s.MinFreeSpace {
+			paused = s.pauseWrites(free, "free space below minimum threshold")
+		} else {
+			s.resumeWrites(free)
+		}
+		s.mu.Unlock()
 
-		case <-ctx.Done():
-			return nil // release lease when we shut down
+		if paused {
+			s.sweepRetention(ctx)
 		}
 	}
 }
 
-// monitorLeaseAsReplica tries to connect to the primary node and stream down changes.
-func (s *Store) monitorLeaseAsReplica(ctx context.Context, info *PrimaryInfo) error {
-	if s.Client == nil {
-		return fmt.Errorf("no client set, skipping replica monitor")
+// sweepRetention removes all LTX files except the latest file for each database.
+func (s *Store) sweepRetention(ctx context.Context) {
+	s.retentionMu.Lock()
+	defer s.retentionMu.Unlock()
+
+	log.Printf("running retention sweep to reclaim disk space")
+	for _, db := range s.DBs() {
+		if _, err := db.enforceRetention(ctx, time.Now(), 0, 0); err != nil {
+			log.Printf("cannot sweep retention on db %q: %s", db.Name(), err)
+		}
 	}
+}
 
-	// Store the URL of the primary while we're in this function.
-	s.mu.Lock()
-	s.primaryInfo = info
-	s.mu.Unlock()
+// freeSpace returns the bytes available on the data directory's file system.
+// Returns -1 if it cannot be determined.
+func (s *Store) freeSpace() int64 {
+	var statfs syscall.Statfs_t
+	if err := syscall.Statfs(s.path, &statfs); err != nil {
+		return -1
+	}
+	return int64(statfs.Bavail) * int64(statfs.Bsize)
+}
 
-	// Clear the primary URL once we leave this function since we can no longer connect.
-	defer func() {
-		s.mu.Lock()
-		defer s.mu.Unlock()
-		s.primaryInfo = nil
-	}()
+// monitorRetention periodically enforces retention of LTX files on the databases.
+func (s *Store) monitorRetention(ctx context.Context) error {
+	ticker := time.NewTicker(s.RetentionMonitorInterval)
+	defer ticker.Stop()
 
-	posMap := s.PosMap()
-	st, err := s.Client.Stream(ctx, info.AdvertiseURL, s.id, posMap)
-	if err != nil {
-		return fmt.Errorf("connect to primary: %s ('%s')", err, info.AdvertiseURL)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := s.EnforceRetention(ctx); err != nil {
+				return err
+			}
+		}
 	}
-	defer func() { _ = st.Close() }()
+}
+
+// monitorWriteRate periodically updates the rolling write rate of each database.
+func (s *Store) monitorWriteRate(ctx context.Context) error {
+	ticker := time.NewTicker(s.WriteRateInterval)
+	defer ticker.Stop()
 
 	for {
-		frame, err := ReadStreamFrame(st)
-		if err == io.EOF {
-			return nil // clean disconnect
-		} else if err != nil {
-			return fmt.Errorf("next frame: %w", err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			for _, db := range s.DBs() {
+				db.updateWriteRate(s.WriteRateInterval)
+			}
 		}
+	}
+}
 
-		switch frame := frame.(type) {
-		case *LTXStreamFrame:
-			if err := s.processLTXStreamFrame(ctx, frame, st); err != nil {
-				return fmt.Errorf("process ltx stream frame: %w", err)
-			}
-		case *ReadyStreamFrame:
-			// Mark store as ready once we've received an initial replication set.
+// monitorCatchup logs replication progress until the store is ready. If a
+// catch-up deadline is set, the store is marked ready once it passes even if
+// the primary has not finished sending its initial replication set.
+func (s *Store) monitorCatchup(ctx context.Context) error {
+	ticker := time.NewTicker(CatchupLogInterval)
+	defer ticker.Stop()
+
+	var deadlineCh <-chan time.Time
+	if s.CatchupDeadline > 0 {
+		timer := time.NewTimer(s.CatchupDeadline)
+		defer timer.Stop()
+		deadlineCh = timer.C
+	}
+
+	start := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.readyCh:
+			return nil
+		case <-deadlineCh:
+			log.Printf("WARNING: catch-up deadline (%s) exceeded, marking ready before caught up with primary: txs=%d bytes=%d", s.CatchupDeadline, s.catchupTXN.Load(), s.catchupBytes.Load())
 			s.markReady()
-		case *EndStreamFrame:
-			// Server cleanly disconnected
 			return nil
-		default:
-			return fmt.Errorf("invalid stream frame type: 0x%02x", frame.Type())
+		case <-ticker.C:
+			if s.PrimaryInfo() == nil {
+				log.Printf("waiting for primary: elapsed=%s", time.Since(start).Round(time.Second))
+				continue
+			}
+			log.Printf("catching up with primary: txs=%d bytes=%d elapsed=%s", s.catchupTXN.Load(), s.catchupBytes.Load(), time.Since(start).Round(time.Second))
 		}
 	}
 }
 
-// monitorRetention periodically enforces retention of LTX files on the databases.
-func (s *Store) monitorRetention(ctx context.Context) error {
-	ticker := time.NewTicker(s.RetentionMonitorInterval)
+// monitorSync periodically syncs LTX directories with pending renames. The
+// directories are synced a final time when the store closes.
+func (s *Store) monitorSync(ctx context.Context) error {
+	ticker := time.NewTicker(s.SyncInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
+			s.flushLTXDirs()
 			return nil
 		case <-ticker.C:
-			if err := s.EnforceRetention(ctx); err != nil {
-				return err
-			}
+			s.flushLTXDirs()
+		}
+	}
+}
+
+// flushLTXDirs syncs the LTX directory of every database marked dirty.
+func (s *Store) flushLTXDirs() {
+	for _, db := range s.DBs() {
+		if err := db.flushLTXDir(); err != nil {
+			log.Printf("cannot sync ltx dir on db %q: %s", db.Name(), err)
 		}
 	}
 }
 
 // EnforceRetention enforces retention of LTX files on all databases.
 func (s *Store) EnforceRetention(ctx context.Context) (err error) {
-	minTime := time.Now().Add(-s.RetentionDuration).UTC()
+	s.retentionMu.Lock()
+	defer s.retentionMu.Unlock()
+
+	minTime := time.Now().Add(-s.RetentionDuration()).UTC()
 
 	for _, db := range s.DBs() {
 		if e := db.EnforceRetention(ctx, minTime); err == nil {
@@ -633,17 +2207,86 @@ func (s *Store) EnforceRetention(ctx context.Context) (err error) {
 	return nil
 }
 
+// SweepRetention immediately runs a retention pass on all databases outside
+// of the regular interval and returns the total files & bytes reclaimed. It
+// is serialized with scheduled passes so files are not removed twice.
+func (s *Store) SweepRetention(ctx context.Context) (ret RetentionResult, err error) {
+	s.retentionMu.Lock()
+	defer s.retentionMu.Unlock()
+
+	minTime := time.Now().Add(-s.RetentionDuration()).UTC()
+	for _, db := range s.DBs() {
+		r, e := db.enforceRetention(ctx, minTime, s.RetentionMaxCount, s.RetentionMaxBytes)
+		ret.FileN += r.FileN
+		ret.Size += r.Size
+		if e != nil && err == nil {
+			err = fmt.Errorf("cannot enforce retention on db %q: %w", db.Name(), e)
+		}
+	}
+	return ret, err
+}
+
+// PreviewRetention reports the LTX files that a retention pass would remove
+// from each database under the given policy, without removing any files.
+func (s *Store) PreviewRetention(duration time.Duration, maxCount int, maxBytes int64) ([]*RetentionPreview, error) {
+	s.retentionMu.Lock()
+	defer s.retentionMu.Unlock()
+
+	minTime := time.Now().Add(-duration).UTC()
+
+	dbs := s.DBs()
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i].Name() < dbs[j].Name() })
+
+	a := make([]*RetentionPreview, 0, len(dbs))
+	for _, db := range dbs {
+		preview, err := db.PreviewRetention(minTime, maxCount, maxBytes)
+		if err != nil {
+			return nil, fmt.Errorf("cannot preview retention on db %q: %w", db.Name(), err)
+		}
+		a = append(a, preview)
+	}
+	return a, nil
+}
+
 func (s *Store) processLTXStreamFrame(ctx context.Context, frame *LTXStreamFrame, src io.Reader) error {
+	// Discard changes to databases this node does not manage.
+	if !s.IsManaged(frame.Name) {
+		if s.Debug() {
+			log.Printf("skipping ltx file for unmanaged database %q", frame.Name)
+		}
+		if _, err := io.Copy(io.Discard, src); err != nil {
+			return fmt.Errorf("discard ltx file: %w", err)
+		}
+		return nil
+	}
+
 	db, err := s.CreateDBIfNotExists(frame.Name)
 	if err != nil {
 		return fmt.Errorf("create database: %w", err)
 	}
 
+	// Keep the local-only database & discard the primary's changes.
+	if db.LocalOnly() {
+		log.Printf("WARNING: primary database %q conflicts with local-only database, skipping", frame.Name)
+		if _, err := io.Copy(io.Discard, src); err != nil {
+			return fmt.Errorf("discard ltx file: %w", err)
+		}
+		return nil
+	}
+
 	r := ltx.NewReader(src)
 	if err := r.PeekHeader(); err != nil {
 		return fmt.Errorf("peek ltx header: %w", err)
 	}
 
+	// Throttle how fast the backlog is applied, unless far behind.
+	lag := time.Since(time.UnixMilli(int64(r.Header().Timestamp)))
+	if lag < 0 {
+		lag = 0
+	}
+	replicaApplyLagMetricVec.WithLabelValues(db.Name()).Set(lag.Seconds())
+	s.throttleApply(ctx, lag)
+
 	// Verify LTX file pre-apply checksum matches the current database position
 	// unless this is a snapshot, which will overwrite all data.
 	if hdr := r.Header(); !hdr.IsSnapshot() {
@@ -658,7 +2301,7 @@ func (s *Store) processLTXStreamFrame(ctx context.Context, frame *LTXStreamFrame
 
 	// Write LTX file to a temporary file and we'll atomically rename later.
 	path := db.LTXPath(r.Header().MinTXID, r.Header().MaxTXID)
-	tmpPath := path + ".tmp"
+	tmpPath := db.LTXTempPath(path)
 	defer func() { _ = os.Remove(tmpPath) }()
 
 	f, err := os.Create(tmpPath)
@@ -670,25 +2313,29 @@ func (s *Store) processLTXStreamFrame(ctx context.Context, frame *LTXStreamFrame
 	n, err := io.Copy(f, r)
 	if err != nil {
 		return fmt.Errorf("write ltx file: %w", err)
-	} else if err := f.Sync(); err != nil {
+	} else if err := db.syncLTXFile(f); err != nil {
 		return fmt.Errorf("fsync ltx file: %w", err)
 	}
 
 	// Atomically rename file.
 	if err := os.Rename(tmpPath, path); err != nil {
 		return fmt.Errorf("rename ltx file: %w", err)
-	} else if err := internal.Sync(filepath.Dir(path)); err != nil {
+	} else if err := db.syncLTXDir(); err != nil {
 		return fmt.Errorf("sync ltx dir: %w", err)
 	}
 
 	// Update metrics
+	replicaApplyCountMetric.Inc()
 	dbLTXCountMetricVec.WithLabelValues(db.Name()).Inc()
 	dbLTXBytesMetricVec.WithLabelValues(db.Name()).Set(float64(n))
+	s.catchupTXN.Add(1)
+	s.catchupBytes.Add(n)
 
 	// Remove other LTX files after a snapshot.
 	if hdr := r.Header(); hdr.IsSnapshot() {
 		dir, file := filepath.Split(path)
 		log.Printf("snapshot received for %q, removing other ltx files: %s", db.Name(), file)
+		s.publishEvent(Event{Type: EventTypeResync, DB: db.Name(), TXID: ltx.FormatTXID(hdr.MaxTXID)})
 		if err := removeFilesExcept(dir, file); err != nil {
 			return fmt.Errorf("remove ltx after snapshot: %w", err)
 		}
@@ -702,9 +2349,39 @@ func (s *Store) processLTXStreamFrame(ctx context.Context, frame *LTXStreamFrame
 	return nil
 }
 
+// RetentionDuration returns the length of time LTX files are retained.
+func (s *Store) RetentionDuration() time.Duration {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.retentionDuration
+}
+
+// SetRetentionDuration sets the length of time LTX files are retained. Safe to
+// call while the store is running.
+func (s *Store) SetRetentionDuration(d time.Duration) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	s.retentionDuration = d
+}
+
+// Debug returns true if debug logging is enabled.
+func (s *Store) Debug() bool {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.debug
+}
+
+// SetDebug enables or disables debug logging. Safe to call while the store
+// is running.
+func (s *Store) SetDebug(v bool) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	s.debug = v
+}
+
 // DebugFn is called by FUSE when debug logging is enabled.
 func (s *Store) DebugFn(msg any) {
-	if !s.Debug {
+	if !s.Debug() {
 		return
 	}
 
@@ -723,7 +2400,7 @@ func (v *StoreVar) String() string {
 	s := (*Store)(v)
 	m := &storeVarJSON{
 		IsPrimary: s.IsPrimary(),
-		Candidate: s.candidate,
+		Candidate: s.Candidate(),
 		DBs:       make(map[string]*dbVarJSON),
 	}
 
@@ -735,6 +2412,8 @@ func (v *StoreVar) String() string {
 			PageSize: db.PageSize(),
 			TXID:     ltx.FormatTXID(pos.TXID),
 			Checksum: fmt.Sprintf("%016x", pos.PostApplyChecksum),
+
+			JournalMode: string(db.JournalMode()),
 		}
 
 		dbJSON.Locks.Pending = db.pendingLock.State().String()
@@ -812,6 +2491,14 @@ func (s *Subscriber) MarkDirty(name string) {
 	}
 }
 
+// notify wakes the subscriber without marking a database dirty.
+func (s *Subscriber) notify() {
+	select {
+	case s.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
 // DirtySet returns a set of database IDs that have changed since the last call
 // to DirtySet(). This call clears the set.
 func (s *Subscriber) DirtySet() map[string]struct{} {
@@ -917,13 +2604,108 @@ var (
 		Help: "Number of managed databases.",
 	})
 
+	storeNodeInfoMetricVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "litefs_node_info",
+		Help: "Always 1. Labeled with the node's name.",
+	}, []string{"name"})
+
 	storeIsPrimaryMetric = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "litefs_is_primary",
 		Help: "Primary status of the node.",
 	})
 
+	storePromotingMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_promoting",
+		Help: "Set to 1 while the node is being promoted to primary.",
+	})
+
+	replicaApplyCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_replica_apply_count",
+		Help: "Number of LTX files received & applied from the primary.",
+	})
+
+	replicaApplyLagMetricVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "litefs_replica_apply_lag_seconds",
+		Help: "Time since the most recently received transaction was committed on the primary.",
+	}, []string{"db"})
+
+	replicaApplyThrottleMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_replica_apply_throttle_seconds",
+		Help: "Total time spent waiting to apply transactions due to the max apply rate.",
+	})
+
+	storeLeaseRenewalAgeMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_lease_renewal_age_seconds",
+		Help: "Time since the primary last renewed its lease.",
+	})
+
+	storeInvalidPrimaryInfoCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_invalid_primary_info_count",
+		Help: "Number of times the leaser returned invalid primary info.",
+	})
+
+	storeLeaseTTLMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_lease_ttl_seconds",
+		Help: "Time-to-live of the lease held by the primary.",
+	})
+
+	storeNoSpaceMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_disk_full",
+		Help: "Set to 1 while writes are paused because the disk is full.",
+	})
+
+	storeNoSpaceCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_disk_full_count",
+		Help: "Number of times writes were paused because the disk is full.",
+	})
+
+	storeFreeSpaceMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_disk_free_bytes",
+		Help: "Free space available on the data directory, in bytes.",
+	})
+
+	storeMinFreeSpaceMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_disk_min_free_bytes",
+		Help: "Free space required on the data directory before writes are paused.",
+	})
+
 	storeSubscriberCountMetric = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "litefs_subscriber_count",
 		Help: "Number of connected subscribers",
 	})
+
+	storeWriteBusyTimeoutCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_write_busy_timeout_count",
+		Help: "Number of writes that timed out waiting for a primary to be established",
+	})
+
+	storeEventSubscriberCountMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_event_subscriber_count",
+		Help: "Number of connected event subscribers",
+	})
+
+	storeEventDroppedCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_event_dropped_count",
+		Help: "Number of events dropped because a subscriber's buffer was full",
+	})
+
+	storeAckLatencyMetric = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "litefs_replication_ack_latency_seconds",
+		Help: "Time spent waiting for replicas to acknowledge a transaction.",
+	})
+
+	storeAckTimeoutCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_replication_ack_timeout_count",
+		Help: "Number of transactions that did not reach quorum before timing out.",
+	})
+
+	storeReadWaitDurationMetric = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "litefs_read_wait_seconds",
+		Help: "Time read transactions spent waiting for the primary's position.",
+	})
+
+	storeReadWaitTimeoutCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_read_wait_timeout_count",
+		Help: "Number of reads that timed out waiting for the primary's position.",
+	})
 )